@@ -1,5 +1,7 @@
 package ast
 
+import "codeberg.org/anaseto/gofrundis/token"
+
 // Block represents a macro line or a text block.
 type Block interface {
 	ImplementsBlock()
@@ -11,24 +13,47 @@ type Inline interface {
 	ImplementsInline()
 }
 
+// Range represents a range of source, from Pos to End (excluded). Ranges are
+// only valid for nodes produced by the parser.
+type Range struct {
+	Pos token.Pos
+	End token.Pos
+}
+
 // Macro represents data associated with a macro line.
 type Macro struct {
 	Name string
 	Args [][]Inline
 	Line int
+	Range
+	ArgRanges []Range // source range of each argument, including quotes
 }
 
 // TextBlock represents data associated with a text block.
 type TextBlock struct {
 	Text []Inline
 	Line int
+	Range
+	TextRanges []Range // source range of each inline element
+}
+
+// Comment represents a \" comment. Comments are only kept when the parser is
+// asked to. A comment taking a whole line or ending a macro line is a block,
+// and a comment ending a line of text is an inline element of the text block.
+type Comment struct {
+	Text     string // comment text, after \"
+	Line     int
+	Trailing bool // comment block ending a macro line
+	Range
 }
 
 func (m *Macro) ImplementsBlock()     {}
 func (t *TextBlock) ImplementsBlock() {}
+func (c *Comment) ImplementsBlock()   {}
 
 func (m *Macro) GetLine() int     { return m.Line }
 func (t *TextBlock) GetLine() int { return t.Line }
+func (c *Comment) GetLine() int   { return c.Line }
 
 type (
 	// Escape represents a regular escape sequence
@@ -51,6 +76,7 @@ func (e NamedArgEscape) ImplementsInline()  {}
 func (e NamedFlagEscape) ImplementsInline() {}
 func (e VarEscape) ImplementsInline()       {}
 func (t Text) ImplementsInline()            {}
+func (c *Comment) ImplementsInline()        {}
 
 func (e Escape) ToText() string {
 	switch e {
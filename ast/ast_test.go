@@ -0,0 +1,103 @@
+package ast_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/parser"
+)
+
+func parse(t *testing.T, src []byte) []ast.Block {
+	p := &parser.Parser{Comments: true, Werror: &bytes.Buffer{}}
+	blocks, err := p.ParseWithReader(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blocks
+}
+
+// stripPositions returns a copy of blocks without source positions.
+func stripPositions(blocks []ast.Block) []ast.Block {
+	var res []ast.Block
+	for _, b := range blocks {
+		switch b := b.(type) {
+		case *ast.Macro:
+			res = append(res, &ast.Macro{Name: b.Name, Args: b.Args})
+		case *ast.TextBlock:
+			var text []ast.Inline
+			for _, in := range b.Text {
+				if c, ok := in.(*ast.Comment); ok {
+					in = &ast.Comment{Text: c.Text}
+				}
+				text = append(text, in)
+			}
+			res = append(res, &ast.TextBlock{Text: text})
+		case *ast.Comment:
+			res = append(res, &ast.Comment{Text: b.Text, Trailing: b.Trailing})
+		}
+	}
+	return res
+}
+
+func TestFprint(t *testing.T) {
+	files, err := filepath.Glob("../testdata/data/*.frundis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, "testdata/print.frundis")
+	for _, f := range files {
+		src, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := stripPositions(parse(t, src))
+		var buf bytes.Buffer
+		err = ast.Fprint(&buf, want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := stripPositions(parse(t, buf.Bytes()))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: printed source does not parse identically:\n%s", f, buf.String())
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	blocks := parse(t, []byte(".Sm -t x \"a b\" \\*[v]\ntext \\e \\\" comment\n.\\\" line\n"))
+	counts := map[string]int{}
+	ast.Inspect(blocks, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.Macro:
+			counts["macro"]++
+		case *ast.TextBlock:
+			counts["text block"]++
+		case *ast.Comment:
+			counts["comment"]++
+		case ast.Text:
+			counts["text"]++
+		case ast.Escape, ast.VarEscape:
+			counts["escape"]++
+		}
+		return true
+	})
+	want := map[string]int{"macro": 1, "text block": 1, "comment": 2, "text": 5, "escape": 2}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("got %v, want %v", counts, want)
+	}
+	n := 0
+	ast.Inspect(blocks, func(node ast.Node) bool {
+		if node != nil {
+			n++
+		}
+		_, isBlocks := node.([]ast.Block)
+		return isBlocks
+	})
+	if n != 4 {
+		t.Errorf("got %d nodes without descending into blocks, want 4", n)
+	}
+}
@@ -0,0 +1,135 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Fprint writes blocks to w as frundis source. The result parses into the
+// same blocks, apart from source positions, as long as the blocks could have
+// been produced by the parser (with comments kept or not). Macro arguments
+// are quoted only when needed.
+func Fprint(w io.Writer, blocks []Block) error {
+	var buf bytes.Buffer
+	for i, b := range blocks {
+		switch b := b.(type) {
+		case *Macro:
+			buf.WriteByte('.')
+			buf.WriteString(b.Name)
+			for _, arg := range b.Args {
+				buf.WriteByte(' ')
+				fprintArg(&buf, arg)
+			}
+			if i+1 < len(blocks) {
+				if c, ok := blocks[i+1].(*Comment); ok && c.Trailing {
+					buf.WriteString(` \"`)
+					buf.WriteString(c.Text)
+				}
+			}
+			buf.WriteByte('\n')
+		case *Comment:
+			if b.Trailing && i > 0 {
+				if _, ok := blocks[i-1].(*Macro); ok {
+					// already written with the macro
+					continue
+				}
+			}
+			buf.WriteString(`.\"`)
+			buf.WriteString(b.Text)
+			buf.WriteByte('\n')
+		case *TextBlock:
+			fprintText(&buf, b.Text)
+			buf.WriteByte('\n')
+		default:
+			return fmt.Errorf("ast.Fprint: unexpected block type %T", b)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// fprintArg writes a macro argument, quoting it only when needed.
+func fprintArg(buf *bytes.Buffer, arg []Inline) {
+	if !needsQuotes(arg) {
+		fprintInlines(buf, arg, false)
+		return
+	}
+	buf.WriteByte('"')
+	fprintInlines(buf, arg, true)
+	buf.WriteByte('"')
+}
+
+// ArgString returns a macro argument as frundis source, quoted only when
+// needed.
+func ArgString(arg []Inline) string {
+	var buf bytes.Buffer
+	fprintArg(&buf, arg)
+	return buf.String()
+}
+
+func needsQuotes(arg []Inline) bool {
+	if len(arg) == 0 {
+		return true
+	}
+	if t, ok := arg[0].(Text); ok && strings.HasPrefix(string(t), `"`) {
+		return true
+	}
+	for _, in := range arg {
+		if t, ok := in.(Text); ok && strings.IndexFunc(string(t), unicode.IsSpace) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// fprintText writes the text of a text block. A dot at the start of a line
+// is escaped with \&, so that the line is not read as a macro line.
+func fprintText(buf *bytes.Buffer, ins []Inline) {
+	lineStart := true
+	for _, in := range ins {
+		t, ok := in.(Text)
+		if !ok {
+			fprintInlines(buf, []Inline{in}, false)
+			lineStart = false
+			continue
+		}
+		for _, line := range strings.SplitAfter(string(t), "\n") {
+			if line == "" {
+				continue
+			}
+			if lineStart && line[0] == '.' {
+				buf.WriteString(`\&`)
+			}
+			buf.WriteString(line)
+			lineStart = strings.HasSuffix(line, "\n")
+		}
+	}
+}
+
+func fprintInlines(buf *bytes.Buffer, ins []Inline, quoted bool) {
+	for _, in := range ins {
+		switch in := in.(type) {
+		case Text:
+			if quoted {
+				buf.WriteString(strings.Replace(string(in), `"`, `""`, -1))
+			} else {
+				buf.WriteString(string(in))
+			}
+		case Escape:
+			buf.WriteString(`\` + string(in))
+		case VarEscape:
+			buf.WriteString(`\*[` + string(in) + "]")
+		case ArgEscape:
+			fmt.Fprintf(buf, `\$%d`, int(in))
+		case NamedArgEscape:
+			buf.WriteString(`\$[` + string(in) + "]")
+		case NamedFlagEscape:
+			buf.WriteString(`\$?[` + string(in) + "]")
+		case *Comment:
+			buf.WriteString(`\"` + in.Text)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package ast
+
+import "fmt"
+
+// Node represents a node of the AST: a slice of blocks, a Block or an
+// Inline.
+type Node interface{}
+
+// Visitor's Visit method is invoked for each node encountered by Walk. If the
+// result visitor w is not nil, Walk visits each of the children of node with
+// the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses an AST in depth-first order: it starts by calling
+// v.Visit(node); node must be a []Block, a Block or an Inline. If the visitor
+// w returned by v.Visit(node) is not nil, Walk is invoked recursively with
+// visitor w for each of the children of node, followed by a call of
+// w.Visit(nil). The children of a slice of blocks are its blocks, the
+// children of a macro are the inline elements of its arguments, and the
+// children of a text block are its inline elements.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	switch n := node.(type) {
+	case []Block:
+		for _, b := range n {
+			Walk(v, b)
+		}
+	case *Macro:
+		for _, arg := range n.Args {
+			for _, in := range arg {
+				Walk(v, in)
+			}
+		}
+	case *TextBlock:
+		for _, in := range n.Text {
+			Walk(v, in)
+		}
+	case *Comment, Escape, ArgEscape, NamedArgEscape, NamedFlagEscape, VarEscape, Text:
+		// no children
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses an AST in depth-first order: it starts by calling
+// f(node); node must not be nil. If f returns true, Inspect invokes f
+// recursively for each of the children of node, followed by a call of
+// f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"codeberg.org/anaseto/gofrundis/format"
+)
+
+// fmtMain handles the "fmt" subcommand, which rewrites frundis source in
+// canonical form.
+func fmtMain(args []string) {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	optList := fs.Bool("l", false, "list files whose formatting differs")
+	optSentences := fs.Bool("s", false, "put each sentence on its own line")
+	optWrite := fs.Bool("w", false, "write result to source file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s fmt [-l] [-s] [-w] [path ...]\n", os.Args[0])
+		fs.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "See man page frundis(1) for details.")
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		if *optWrite {
+			fmt.Fprintln(os.Stderr, "frundis: cannot use -w with standard input")
+			os.Exit(1)
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err == nil {
+			err = formatFile("<stdin>", src, *optList, false, *optSentences)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	status := 0
+	for _, path := range fs.Args() {
+		src, err := os.ReadFile(path)
+		if err == nil {
+			err = formatFile(path, src, *optList, *optWrite, *optSentences)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+// formatFile formats source src of file path, and either lists the file if
+// its formatting differs, writes the result back to it, or prints it.
+func formatFile(path string, src []byte, list, write, sentences bool) error {
+	res, err := format.Source(src, &format.Options{Source: path, SentenceLines: sentences})
+	if err != nil {
+		return err
+	}
+	if list || write {
+		if bytes.Equal(src, res) {
+			return nil
+		}
+		if list {
+			fmt.Println(path)
+		}
+		if write {
+			return os.WriteFile(path, res, 0644)
+		}
+		return nil
+	}
+	_, err = os.Stdout.Write(res)
+	return err
+}
@@ -1,23 +1,90 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path"
 	"strings"
+	"sync"
 	"syscall"
 	"testing"
+	"time"
 
+	"codeberg.org/anaseto/gofrundis/exporter/docbook"
+	"codeberg.org/anaseto/gofrundis/exporter/events"
+	"codeberg.org/anaseto/gofrundis/exporter/fb2"
 	"codeberg.org/anaseto/gofrundis/exporter/latex"
 	"codeberg.org/anaseto/gofrundis/exporter/markdown"
 	"codeberg.org/anaseto/gofrundis/exporter/mom"
+	"codeberg.org/anaseto/gofrundis/exporter/pandoc"
+	"codeberg.org/anaseto/gofrundis/exporter/tei"
 	"codeberg.org/anaseto/gofrundis/exporter/tpl"
+	"codeberg.org/anaseto/gofrundis/exporter/typst"
 	"codeberg.org/anaseto/gofrundis/exporter/xhtml"
 	"codeberg.org/anaseto/gofrundis/frundis"
 )
 
+func init() {
+	frundis.RegisterMacro("Epigraph", macroEpigraph)
+	frundis.RegisterMacro("Ee", macroEpigraphEnd)
+	frundis.RegisterMacro("Ded", macroDed)
+	frundis.RegisterFormat("gemini", "text", func(opts *frundis.FormatOptions) frundis.Exporter {
+		return geminiExporter{markdown.NewExporter(&markdown.Options{OutputFile: opts.OutputFile})}
+	})
+	frundis.SetFormatExtension("gemini", ".gmi")
+}
+
+// geminiExporter is a test exporter for a registered format.
+type geminiExporter struct {
+	frundis.Exporter
+}
+
+func (exp geminiExporter) Init() {
+	exp.Exporter.Init()
+	exp.Context().Format = "gemini"
+}
+
+func macroEpigraph(mc *frundis.MacroContext) {
+	opts, _, _ := mc.ParseOptions(map[string]frundis.Option{"id": frundis.ArgOption})
+	id := mc.Text(opts["id"])
+	if mc.InfoPass() {
+		if id != "" {
+			mc.RegisterID(id, frundis.BdID, "")
+		}
+		return
+	}
+	mc.BeginBlock("Ee", "", id)
+	if _, ok := mc.LookupID(id); ok {
+		fmt.Fprintf(mc.W(), "<div id=\"%s\" class=\"epigraph\">\n", id)
+	} else {
+		fmt.Fprint(mc.W(), "<div class=\"epigraph\">\n")
+	}
+}
+
+func macroEpigraphEnd(mc *frundis.MacroContext) {
+	if _, _, ok := mc.EndBlock(); ok {
+		fmt.Fprint(mc.W(), "</div>\n")
+	}
+}
+
+func macroDed(mc *frundis.MacroContext) {
+	if mc.InfoPass() {
+		return
+	}
+	args, punct := mc.ClosePunct(mc.Args())
+	if len(args) == 0 {
+		mc.Error("argument required")
+		return
+	}
+	mc.BeginPhrasing(false)
+	fmt.Fprintf(mc.W(), "<span class=\"dedicatee\">%s</span>%s", mc.RenderInline(args), punct)
+	mc.EndPhrasing()
+}
+
 func TestMain(m *testing.M) {
 	err := os.Setenv("FRUNDIS", "ok")
 	if err != nil {
@@ -85,7 +152,7 @@ func TestFragments(t *testing.T) {
 			continue
 		}
 		fullPath := path.Join("data", f)
-		for _, format := range []string{"latex", "mom", "xhtml", "markdown"} {
+		for _, format := range []string{"latex", "mom", "xhtml", "markdown", "fb2", "typst", "docbook", "tei", "pandoc-json", "events"} {
 			t.Run(fullPath+"-"+format, func(t *testing.T) {
 				doFile(t, fullPath, format, false)
 			})
@@ -175,6 +242,18 @@ func doFile(t *testing.T, file string, format string, tplmode bool) {
 		exp = markdown.NewExporter(&markdown.Options{OutputFile: outputFile})
 	case "mom":
 		exp = mom.NewExporter(&mom.Options{OutputFile: outputFile})
+	case "fb2":
+		exp = fb2.NewExporter(&fb2.Options{OutputFile: outputFile})
+	case "typst":
+		exp = typst.NewExporter(&typst.Options{OutputFile: outputFile})
+	case "docbook":
+		exp = docbook.NewExporter(&docbook.Options{OutputFile: outputFile})
+	case "tei":
+		exp = tei.NewExporter(&tei.Options{OutputFile: outputFile})
+	case "pandoc-json":
+		exp = pandoc.NewExporter(&pandoc.Options{OutputFile: outputFile})
+	case "events":
+		exp = events.NewExporter(&events.Options{OutputFile: outputFile})
 	}
 	err := frundis.ProcessFrundisSource(exp, file, true)
 	ref := name + "." + suffix
@@ -290,3 +369,526 @@ func doStandalone(t *testing.T, file string, format string, toFile bool) {
 		t.Error(string(diff))
 	}
 }
+
+func TestSourceMap(t *testing.T) {
+	const file = "srcmap/srcmap.frundis"
+	exp := xhtml.NewExporter(&xhtml.Options{
+		Format:       "xhtml",
+		OutputFile:   outputFile,
+		SourceLines:  true,
+		AllInOneFile: true})
+	err := frundis.ProcessFrundisSource(exp, file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareFiles(t, "srcmap/srcmap.html", outputFile)
+	for _, format := range []string{"latex", "markdown"} {
+		var exp frundis.Exporter
+		var ref string
+		switch format {
+		case "latex":
+			exp = latex.NewExporter(&latex.Options{OutputFile: outputFile, SourceMap: outputFile + ".map"})
+			ref = "srcmap/srcmap.tex.map"
+		case "markdown":
+			exp = markdown.NewExporter(&markdown.Options{OutputFile: outputFile, SourceMap: outputFile + ".map"})
+			ref = "srcmap/srcmap.markdown.map"
+		}
+		err := frundis.ProcessFrundisSource(exp, file, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareFiles(t, ref, outputFile+".map")
+	}
+}
+
+func TestMultiFormat(t *testing.T) {
+	formats := []string{"xhtml", "latex", "markdown", "typst", "events"}
+	newExporters := func() []frundis.Exporter {
+		var exps []frundis.Exporter
+		for _, format := range formats {
+			exp, err := frundis.NewFormatExporter(&frundis.FormatOptions{
+				Format:       format,
+				OutputFile:   multiOutputFile(outputFile, format, true),
+				AllInOneFile: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			exps = append(exps, exp)
+		}
+		return exps
+	}
+	var buf strings.Builder
+	err := frundis.ProcessFrundisSourceConcurrently(context.Background(), newExporters()[:3], "multi/diagnostics.frundis", &frundis.ProcessOptions{}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("multi/diagnostics.err")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(want) {
+		t.Errorf("multi/diagnostics.err: got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	err = frundis.ProcessFrundisSourceConcurrently(context.Background(), newExporters(), "data/lists.frundis", &frundis.ProcessOptions{Unrestricted: true}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range formats {
+		suffix := strings.Replace(format, "xhtml", "html", -1)
+		suffix = strings.Replace(suffix, "latex", "tex", -1)
+		compareFiles(t, "data/lists."+suffix, multiOutputFile(outputFile, format, true))
+	}
+}
+
+func TestCompile(t *testing.T) {
+	prog, err := frundis.CompileFile("data/lists.frundis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	formats := []string{"markdown", "xhtml", "latex"}
+	var wg sync.WaitGroup
+	errs := make([]error, 4*len(formats))
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			exp, err := frundis.NewFormatExporter(&frundis.FormatOptions{
+				Format:       formats[i%len(formats)],
+				OutputFile:   fmt.Sprintf("%s.%d", outputFile, i),
+				AllInOneFile: true})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = prog.Execute(exp, true)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+		suffix := strings.Replace(formats[i%len(formats)], "xhtml", "html", -1)
+		suffix = strings.Replace(suffix, "latex", "tex", -1)
+		compareFiles(t, "data/lists."+suffix, fmt.Sprintf("%s.%d", outputFile, i))
+	}
+}
+
+// TestCompileIncludes checks that changes to included files are taken into
+// account by later executions of a program.
+func TestCompileIncludes(t *testing.T) {
+	dir := t.TempDir()
+	inc := path.Join(dir, "inc.frundis")
+	prog, err := frundis.Compile("main.frundis", []byte(".If "+inc+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"First version.", "Second version."} {
+		err := os.WriteFile(inc, []byte(text+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := path.Join(dir, "out.md")
+		err = prog.Execute(markdown.NewExporter(&markdown.Options{OutputFile: out}), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(string(got)) != text {
+			t.Errorf("got %q, want %q", got, text)
+		}
+	}
+}
+
+func TestExecLimits(t *testing.T) {
+	var buf strings.Builder
+	exp := xhtml.NewExporter(&xhtml.Options{
+		Format:       "xhtml",
+		OutputFile:   outputFile,
+		Werror:       &buf,
+		AllInOneFile: true})
+	err := frundis.ProcessFrundisSourceContext(context.Background(), exp, "exec/limits.frundis", &frundis.ProcessOptions{
+		Unrestricted:  true,
+		ExecTimeout:   200 * time.Millisecond,
+		ExecMaxOutput: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("exec/limits.err")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(want) {
+		t.Errorf("exec/limits.err: got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	compareFiles(t, "exec/limits.html", outputFile)
+	cctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = frundis.ProcessFrundisSourceContext(cctx, exp, "exec/limits.frundis", &frundis.ProcessOptions{Unrestricted: true})
+	if err != context.Canceled {
+		t.Errorf("canceled processing: got error %v", err)
+	}
+}
+
+func TestExecCancel(t *testing.T) {
+	var buf strings.Builder
+	exp := xhtml.NewExporter(&xhtml.Options{
+		Format:       "xhtml",
+		OutputFile:   outputFile,
+		Werror:       &buf,
+		AllInOneFile: true})
+	cctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	err := frundis.ProcessFrundisSourceContext(cctx, exp, "exec/cancel.frundis", &frundis.ProcessOptions{Unrestricted: true})
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("command not killed on cancellation: processing took %v", elapsed)
+	}
+	if err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	want, err := os.ReadFile("exec/cancel.err")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(want) {
+		t.Errorf("exec/cancel.err: got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+// TestCancelOutput checks that canceled processing closes the output file and
+// removes it.
+func TestCancelOutput(t *testing.T) {
+	fds := func() int {
+		entries, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			return -1
+		}
+		return len(entries)
+	}
+	for _, format := range []string{"latex", "markdown", "xhtml"} {
+		exp, err := frundis.NewFormatExporter(&frundis.FormatOptions{
+			Format:       format,
+			OutputFile:   outputFile,
+			AllInOneFile: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := fds()
+		cctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+		}()
+		err = frundis.ProcessFrundisSourceContext(cctx, exp, "exec/cancel.frundis", &frundis.ProcessOptions{Unrestricted: true})
+		cancel()
+		if err != context.Canceled {
+			t.Errorf("%s: got error %v, want %v", format, err, context.Canceled)
+		}
+		if _, err := os.Stat(outputFile); !os.IsNotExist(err) {
+			t.Errorf("%s: partial output file not removed", format)
+		}
+		if m := fds(); m != n {
+			t.Errorf("%s: %d file descriptors open after cancellation, want %d", format, m, n)
+		}
+	}
+}
+
+func compareFiles(t *testing.T, ref, file string) {
+	t.Helper()
+	want, err := os.ReadFile(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s: got:\n%s\nwant:\n%s", ref, got, want)
+	}
+}
+
+func TestPreprocess(t *testing.T) {
+	for _, format := range []string{"latex", "xhtml"} {
+		exp, _ := checkExporter(format)
+		f, err := os.Create(outputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = frundis.Preprocess(exp, "preprocess/preprocess.frundis", f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareFiles(t, "preprocess/preprocess-"+format+".frundis", outputFile)
+	}
+}
+
+// TestPreprocessRoundTrip checks that processing preprocessed source gives
+// the same output as processing the original source.
+func TestPreprocessRoundTrip(t *testing.T) {
+	preprocessed := outputFile + ".frundis"
+	defer os.Remove(preprocessed)
+	for _, file := range []string{"data/nbsp.frundis", "data/if-expr.frundis"} {
+		for _, format := range []string{"latex", "xhtml", "markdown"} {
+			exp, _ := checkExporter(format)
+			f, err := os.Create(preprocessed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = frundis.Preprocess(exp, file, f)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			output := func(src string) string {
+				exp, err := frundis.NewFormatExporter(&frundis.FormatOptions{
+					Format:       format,
+					OutputFile:   outputFile,
+					AllInOneFile: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = frundis.ProcessFrundisSource(exp, src, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				out, err := os.ReadFile(outputFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return string(out)
+			}
+			want := output(file)
+			if got := output(preprocessed); got != want {
+				t.Errorf("%s: %s: got:\n%s\nwant:\n%s", file, format, got, want)
+			}
+		}
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	doErrors(t, "suggest/suggest.frundis", "suggest/suggest.err")
+}
+
+func TestConditionalErrors(t *testing.T) {
+	doErrors(t, "cond/errors.frundis", "cond/errors.err")
+}
+
+func TestLoopErrors(t *testing.T) {
+	doErrors(t, "for/errors.frundis", "for/errors.err")
+}
+
+func TestCounterErrors(t *testing.T) {
+	doErrors(t, "counters/errors.frundis", "counters/errors.err")
+}
+
+func TestMacroErrors(t *testing.T) {
+	doErrors(t, "macros/errors.frundis", "macros/errors.err")
+}
+
+func TestListMacros(t *testing.T) {
+	var buf strings.Builder
+	listMacros(&buf, "xhtml", "data/macro_defaults.frundis")
+	want, err := os.ReadFile("macros/list.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestRegisteredMacros(t *testing.T) {
+	doErrors(t, "plugin/epigraph.frundis", "plugin/epigraph.err")
+	compareFiles(t, "plugin/epigraph.html", outputFile)
+}
+
+func TestMacrosNotShared(t *testing.T) {
+	exp1 := &textExporter{}
+	exp1.Init()
+	delete(exp1.Ctx.Macros, "P")
+	exp1.Ctx.Macros["Zz"] = func(frundis.Exporter) {}
+	exp2 := &textExporter{}
+	exp2.Init()
+	if _, ok := exp2.Ctx.Macros["P"]; !ok {
+		t.Error("macro P removed from another context")
+	}
+	if _, ok := exp2.Ctx.Macros["Zz"]; ok {
+		t.Error("macro Zz added to another context")
+	}
+}
+
+func TestRegisteredFormats(t *testing.T) {
+	doErrors(t, "formats/formats.frundis", "formats/formats.err")
+	compareFiles(t, "formats/formats.html", outputFile)
+	exp, err := frundis.NewFormatExporter(&frundis.FormatOptions{
+		Format:     "gemini",
+		OutputFile: outputFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = frundis.ProcessFrundisSource(exp, "formats/formats.frundis", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareFiles(t, "formats/formats.gemini", outputFile)
+}
+
+// textExporter is a test exporter relying on default rendering.
+type textExporter struct {
+	frundis.BaseRenderer
+	w strings.Builder
+}
+
+func (exp *textExporter) Init() {
+	exp.Ctx = &frundis.Context{Format: "text"}
+	exp.Ctx.Init()
+}
+
+func (exp *textExporter) Reset() error {
+	exp.Ctx.Reset()
+	exp.w.Reset()
+	exp.Ctx.Wout = bufio.NewWriter(&exp.w)
+	return nil
+}
+
+func (exp *textExporter) PostProcessing() {
+	exp.Ctx.Wout.Flush()
+}
+
+func (exp *textExporter) Xdtag(cmd string, pairs []string) frundis.Dtag {
+	return frundis.Dtag{Cmd: cmd}
+}
+
+func (exp *textExporter) Xmtag(cmd *string, begin string, end string, pairs []string) frundis.Mtag {
+	return frundis.Mtag{Begin: begin, End: end}
+}
+
+func TestBaseRenderer(t *testing.T) {
+	exp := &textExporter{}
+	err := frundis.ProcessFrundisSource(exp, "renderer/text.frundis", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("renderer/text.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp.w.String() != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", exp.w.String(), want)
+	}
+}
+
+// tplExporter is a template mode exporter with the default set of macros,
+// so that all the Renderer methods are used.
+type tplExporter struct {
+	frundis.Exporter
+}
+
+func (exp tplExporter) Init() {
+	exp.Exporter.Init()
+	exp.Context().Macros = frundis.DefaultExporterMacros()
+}
+
+// TestTplRenderer checks that template mode only writes text and markup
+// tags, and not the text written by the default Renderer methods.
+func TestTplRenderer(t *testing.T) {
+	exp := tplExporter{tpl.NewExporter(&tpl.Options{OutputFile: outputFile, Format: "xhtml"})}
+	err := frundis.ProcessFrundisSource(exp, "renderer/text.frundis", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("renderer/tpl.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestCapabilities checks the rendering of notes and formulas by exporters
+// satisfying the NoteRenderer and MathRenderer interfaces.
+func TestCapabilities(t *testing.T) {
+	tests := []struct {
+		format string
+		note   string
+		math   string
+	}{
+		{"latex", `\footnote{a note}.`, `$x^2$.`},
+		{"markdown", "", `$x^2$.`},
+		{"typst", `#footnote[a note].`, ""},
+		{"docbook", `<footnote><para>a note</para></footnote>.`, ""},
+		{"tei", `<note place="foot">a note</note>.`, `<formula notation="TeX">x^2</formula>.`},
+		{"mom", "", ""},
+	}
+	for _, test := range tests {
+		exp, err := frundis.NewFormatExporter(&frundis.FormatOptions{Format: test.format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp.Init()
+		ctx := exp.Context()
+		var w strings.Builder
+		ctx.Wout = bufio.NewWriter(&w)
+		nr, ok := exp.(frundis.NoteRenderer)
+		if ok != (test.note != "") {
+			t.Errorf("%s: NoteRenderer: got %v", test.format, ok)
+		}
+		if ok {
+			nr.Note("a note", ".")
+			ctx.Wout.Flush()
+			if w.String() != test.note {
+				t.Errorf("%s: note: got %q, want %q", test.format, w.String(), test.note)
+			}
+			w.Reset()
+		}
+		mr, ok := exp.(frundis.MathRenderer)
+		if ok != (test.math != "") {
+			t.Errorf("%s: MathRenderer: got %v", test.format, ok)
+		}
+		if ok {
+			mr.Math("x^2", ".")
+			ctx.Wout.Flush()
+			if w.String() != test.math {
+				t.Errorf("%s: math: got %q, want %q", test.format, w.String(), test.math)
+			}
+		}
+	}
+}
+
+// doErrors checks that errors produced when processing file match the
+// content of ref.
+func doErrors(t *testing.T, file, ref string) {
+	t.Helper()
+	var buf strings.Builder
+	exp := xhtml.NewExporter(&xhtml.Options{
+		Format:       "xhtml",
+		OutputFile:   outputFile,
+		Werror:       &buf,
+		AllInOneFile: true})
+	err := frundis.ProcessFrundisSource(exp, file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(want) {
+		t.Errorf("%s: got:\n%s\nwant:\n%s", ref, buf.String(), want)
+	}
+}
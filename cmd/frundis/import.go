@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"codeberg.org/anaseto/gofrundis/importer"
+)
+
+// importMain handles the "import" subcommand, which converts documents in
+// other markup languages into frundis source.
+func importMain(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	optFrom := fs.String("from", "", "input `format` (required)")
+	optOutputFile := fs.String("o", "", "`output-file`")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s import -from format [-o output-file] [path]\n", os.Args[0])
+		fs.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "See man page frundis(1) for details.")
+	}
+	fs.Parse(args)
+
+	importError := func(usage bool, msgs ...interface{}) {
+		fmt.Fprintln(os.Stderr, "frundis: "+fmt.Sprint(msgs...))
+		if usage {
+			fs.Usage()
+		}
+		os.Exit(1)
+	}
+
+	var convert func(io.Reader, io.Writer) error
+	switch *optFrom {
+	case "markdown":
+		convert = importer.Markdown
+	case "html":
+		convert = importer.HTML
+	case "":
+		importError(true, "-from option required")
+	default:
+		importError(true, "invalid format argument to -from option")
+	}
+
+	in := os.Stdin
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			importError(false, err)
+		}
+		defer f.Close()
+		in = f
+	default:
+		importError(true, "too many arguments")
+	}
+	out := os.Stdout
+	if *optOutputFile != "" {
+		f, err := os.Create(*optOutputFile)
+		if err != nil {
+			importError(false, err)
+		}
+		out = f
+	}
+	err := convert(in, out)
+	if err == nil && *optOutputFile != "" {
+		err = out.Close()
+	}
+	if err != nil {
+		importError(false, err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"codeberg.org/anaseto/gofrundis/frundis"
+	"codeberg.org/anaseto/gofrundis/lsp"
+)
+
+// lspMain handles the "lsp" subcommand, which runs a language server on
+// standard input and output.
+func lspMain(args []string) {
+	fs := flag.NewFlagSet("lsp", flag.ExitOnError)
+	optFormat := fs.String("T", "xhtml", "export `format` used for diagnostics")
+	optDelay := fs.Duration("delay", 300*time.Millisecond, "`delay` before updating diagnostics")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s lsp [-T format] [-delay duration]\n", os.Args[0])
+		fs.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "See man page frundis(1) for details.")
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "frundis: too many arguments")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if _, err := checkExporter(*optFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "frundis: %v\n", err)
+		os.Exit(1)
+	}
+
+	s := lsp.NewServer(&lsp.Options{
+		NewExporter: checkExporter,
+		Format:      *optFormat,
+		Delay:       *optDelay})
+	if err := s.Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "frundis: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// checkExporter returns an exporter for format that discards its output.
+// EPUB documents are checked as single-file XHTML.
+func checkExporter(format string) (frundis.Exporter, error) {
+	if format == "epub" {
+		format = "xhtml"
+	}
+	return frundis.NewFormatExporter(&frundis.FormatOptions{
+		Format:       format,
+		OutputFile:   os.DevNull,
+		AllInOneFile: true})
+}
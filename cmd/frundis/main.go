@@ -2,31 +2,65 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"runtime/pprof"
+	"strings"
 
-	"codeberg.org/anaseto/gofrundis/exporter/latex"
-	"codeberg.org/anaseto/gofrundis/exporter/markdown"
-	"codeberg.org/anaseto/gofrundis/exporter/mom"
+	// exporters register their formats
+	_ "codeberg.org/anaseto/gofrundis/exporter/docbook"
+	_ "codeberg.org/anaseto/gofrundis/exporter/events"
+	_ "codeberg.org/anaseto/gofrundis/exporter/fb2"
+	_ "codeberg.org/anaseto/gofrundis/exporter/latex"
+	_ "codeberg.org/anaseto/gofrundis/exporter/markdown"
+	_ "codeberg.org/anaseto/gofrundis/exporter/mom"
+	_ "codeberg.org/anaseto/gofrundis/exporter/pandoc"
+	_ "codeberg.org/anaseto/gofrundis/exporter/tei"
 	"codeberg.org/anaseto/gofrundis/exporter/tpl"
+	_ "codeberg.org/anaseto/gofrundis/exporter/typst"
 	"codeberg.org/anaseto/gofrundis/exporter/xhtml"
 	"codeberg.org/anaseto/gofrundis/frundis"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			importMain(os.Args[2:])
+			return
+		case "fmt":
+			fmtMain(os.Args[2:])
+			return
+		case "lsp":
+			lspMain(os.Args[2:])
+			return
+		}
+	}
 
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to `file`")
-	optFormat := flag.String("T", "", "export `format` (required)")
+	optFormat := flag.String("T", "", "export `format` or comma-separated formats (required)")
 	optAllInOneFile := flag.Bool("a", false, "all in one file (for xhtml only)")
 	optStandalone := flag.Bool("s", false, "standalone document (default for xhtml and epub)")
 	optOutputFile := flag.String("o", "", "`output-file`")
 	optCompress := flag.Bool("z", false, "produce a finalized compressed EPUB (zipped)")
 	optTemplate := flag.Bool("t", false, "template operation mode")
 	optExec := flag.Bool("x", false, "unrestricted mode (#run and shell filters allowed)")
+	optExecTimeout := flag.Duration("exec-timeout", 0, "kill external commands running longer than `duration` (no limit if zero)")
+	optExecMaxOutput := flag.Int64("exec-max-output", 0, "kill external commands producing more than `bytes` of output (no limit if zero)")
+	optPreprocess := flag.Bool("E", false, "preprocess only (expand user macros, conditionals and inclusions)")
+	optListMacros := flag.Bool("list-macros", false, "list user macros with their arguments and options")
+	optSourceMap := flag.Bool("m", false, "source mapping (data-src attributes for xhtml and epub, output-file.map for latex and markdown)")
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s -T format [-a] [-s] [-t] [-x] [-o output-file] path\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s -T format[,format...] [-a] [-m] [-s] [-t] [-x] [-exec-timeout duration] [-exec-max-output bytes] [-o output-file] path\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s -E -T format [-o output-file] path\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s -list-macros [-T format] path\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s import -from format [-o output-file] [path]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s fmt [-l] [-s] [-w] [path ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s lsp [-T format] [-delay duration]\n", os.Args[0])
 		flag.PrintDefaults()
 		fmt.Fprintln(os.Stderr, "See man page frundis(1) for details.")
 	}
@@ -53,18 +87,69 @@ func main() {
 		Error(true, "too many arguments")
 	}
 
-	switch *optFormat {
-	case "epub", "xhtml", "latex", "markdown", "mom":
-	case "":
+	if *optListMacros {
+		if *optFormat == "" {
+			*optFormat = "xhtml"
+		}
+	}
+	if *optFormat == "" {
 		Error(true, "-T option required")
-	default:
-		Error(true, "invalid format argument to -T option")
+	}
+	formats := splitFormats(*optFormat)
+	for _, format := range formats {
+		if !frundis.IsFormat(format) {
+			Error(true, "invalid format argument to -T option: ", format,
+				" (valid formats: ", strings.Join(frundis.Formats(), ", "), ")")
+		}
+	}
+	if len(formats) > 1 {
+		switch {
+		case *optListMacros || *optPreprocess || *optTemplate:
+			Error(true, "options -E, -list-macros and -t require a single format")
+		case *optOutputFile == "":
+			Error(true, "-o option required with several formats")
+		}
+	}
+	*optFormat = formats[0]
+	if *optListMacros {
+		listMacros(os.Stdout, *optFormat, filename)
+		os.Exit(0)
+	}
+	if *optPreprocess {
+		preprocess(*optFormat, filename, *optOutputFile)
+		os.Exit(0)
 	}
 	if *optOutputFile == "" {
-		if *optFormat == "epub" || *optFormat == "xhtml" && !*optAllInOneFile {
+		if outputIsDir(*optFormat, *optAllInOneFile) {
 			Error(true, "-o option required with formats epub and xhtml (without -a)")
 		}
 	}
+	if *optSourceMap {
+		for _, format := range formats {
+			switch format {
+			case "epub", "xhtml":
+			case "latex", "markdown":
+				if *optOutputFile == "" {
+					Error(true, "-o option required with -m for formats latex and markdown")
+				}
+			default:
+				Error(true, "-m option only supported with formats epub, xhtml, latex and markdown")
+			}
+		}
+	}
+	popts := &frundis.ProcessOptions{
+		Unrestricted:  *optExec,
+		ExecTimeout:   *optExecTimeout,
+		ExecMaxOutput: *optExecMaxOutput}
+	if len(formats) > 1 {
+		exportFormats(formats, filename, &frundis.FormatOptions{
+			OutputFile:   *optOutputFile,
+			Standalone:   *optStandalone,
+			AllInOneFile: *optAllInOneFile,
+			SourceMap:    *optSourceMap},
+			popts, *optCompress)
+		os.Exit(0)
+	}
 
 	if *optTemplate {
 		export(
@@ -72,56 +157,108 @@ func main() {
 				OutputFile: *optOutputFile,
 				Format:     *optFormat}),
 			filename,
-			*optExec)
+			popts)
 		os.Exit(0)
 	}
 
-	switch *optFormat {
-	case "epub", "xhtml":
-		export(
-			xhtml.NewExporter(&xhtml.Options{
-				Format:       *optFormat,
-				OutputFile:   *optOutputFile,
-				Standalone:   *optStandalone,
-				AllInOneFile: *optAllInOneFile}),
-			filename,
-			*optExec)
-		if *optFormat == "epub" && *optCompress {
-			err := writeEpub(*optOutputFile, *optOutputFile+".epub")
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "frundis: %v", err)
-				os.Exit(1)
-			}
+	exp, err := frundis.NewFormatExporter(&frundis.FormatOptions{
+		Format:       *optFormat,
+		OutputFile:   *optOutputFile,
+		Standalone:   *optStandalone,
+		AllInOneFile: *optAllInOneFile,
+		SourceMap:    *optSourceMap})
+	if err != nil {
+		Error(false, err)
+	}
+	export(exp, filename, popts)
+	if *optFormat == "epub" && *optCompress {
+		err := writeEpub(*optOutputFile, *optOutputFile+".epub")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "frundis: %v", err)
+			os.Exit(1)
 		}
-	case "latex":
-		export(
-			latex.NewExporter(&latex.Options{
-				OutputFile: *optOutputFile,
-				Standalone: *optStandalone}),
-			filename,
-			*optExec)
-	case "markdown":
-		export(
-			markdown.NewExporter(&markdown.Options{OutputFile: *optOutputFile}),
-			filename,
-			*optExec)
-	case "mom":
-		export(
-			mom.NewExporter(&mom.Options{
-				OutputFile: *optOutputFile,
-				Standalone: *optStandalone}),
-			filename,
-			*optExec)
 	}
 }
 
-func export(exp frundis.Exporter, filename string, unrestricted bool) {
-	err := frundis.ProcessFrundisSource(exp, filename, unrestricted)
+func export(exp frundis.Exporter, filename string, opts *frundis.ProcessOptions) {
+	cctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := frundis.ProcessFrundisSourceContext(cctx, exp, filename, opts)
 	if err != nil {
 		Error(false, err)
 	}
 }
 
+// preprocess writes the preprocessed source of file filename for the given
+// export format.
+func preprocess(format string, filename string, outputFile string) {
+	exp := nullExporter(format)
+	w := os.Stdout
+	if outputFile != "" {
+		var err error
+		w, err = os.Create(outputFile)
+		if err != nil {
+			Error(false, err)
+		}
+	}
+	err := frundis.Preprocess(exp, filename, w)
+	if err != nil {
+		Error(false, err)
+	}
+	if outputFile != "" {
+		err = w.Close()
+		if err != nil {
+			Error(false, err)
+		}
+	}
+}
+
+// nullExporter returns an exporter for format that produces no output.
+func nullExporter(format string) frundis.Exporter {
+	if format == "epub" {
+		// no output is produced: we can use the real format
+		return xhtml.NewExporter(&xhtml.Options{Format: format})
+	}
+	exp, _ := checkExporter(format)
+	return exp
+}
+
+// listMacros writes to w a description of the user macros defined in file
+// filename for the given export format.
+func listMacros(w io.Writer, format string, filename string) {
+	macros, err := frundis.ListMacros(nullExporter(format), filename)
+	if err != nil {
+		Error(false, err)
+	}
+	for _, m := range macros {
+		fmt.Fprintf(w, "%s:%d: .%s", m.File, m.Line, m.Name)
+		for i := 1; i <= m.Arity; i++ {
+			fmt.Fprintf(w, " $%d", i)
+		}
+		if m.Variadic {
+			fmt.Fprint(w, " ...")
+		}
+		fmt.Fprintln(w)
+		if m.Description != "" {
+			fmt.Fprintf(w, "\t%s\n", m.Description)
+		}
+		for _, opt := range m.Options {
+			fmt.Fprintf(w, "\t-%s", opt.Name)
+			switch {
+			case opt.Flag:
+				fmt.Fprint(w, " (flag)")
+			case opt.Required:
+				fmt.Fprint(w, " value (required)")
+			case opt.Default != "":
+				fmt.Fprintf(w, " value (default: %s)", opt.Default)
+			default:
+				fmt.Fprint(w, " value")
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
 func Error(usage bool, msgs ...interface{}) {
 	s := "frundis: "
 	s += fmt.Sprint(msgs...)
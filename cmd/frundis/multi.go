@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// splitFormats returns the comma-separated formats of a -T option argument,
+// without duplicates.
+func splitFormats(arg string) []string {
+	var formats []string
+	seen := make(map[string]bool)
+	for _, format := range strings.Split(arg, ",") {
+		if !seen[format] {
+			seen[format] = true
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// outputIsDir reports whether the output of a format is a directory.
+func outputIsDir(format string, allInOneFile bool) bool {
+	return format == "epub" || format == "xhtml" && !allInOneFile
+}
+
+// multiOutputFile returns the output file or directory for a format when
+// exporting to several formats, using base as base name. Output files get
+// the extension registered for the format.
+func multiOutputFile(base string, format string, allInOneFile bool) string {
+	if outputIsDir(format, allInOneFile) {
+		return base + "-" + format
+	}
+	if ext := frundis.FormatExtension(format); ext != "" {
+		return base + ext
+	}
+	return base + "." + format
+}
+
+// exportFormats exports file filename to several formats concurrently. The
+// output file of opts is used as a base name for the output files of each
+// format.
+func exportFormats(formats []string, filename string, opts *frundis.FormatOptions, popts *frundis.ProcessOptions, compress bool) {
+	var exps []frundis.Exporter
+	for _, format := range formats {
+		fopts := *opts
+		fopts.Format = format
+		fopts.OutputFile = multiOutputFile(opts.OutputFile, format, opts.AllInOneFile)
+		exp, err := frundis.NewFormatExporter(&fopts)
+		if err != nil {
+			Error(false, err)
+		}
+		exps = append(exps, exp)
+	}
+	cctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := frundis.ProcessFrundisSourceConcurrently(cctx, exps, filename, popts, os.Stderr)
+	if err != nil {
+		Error(false, err)
+	}
+	for _, format := range formats {
+		if format == "epub" && compress {
+			err := writeEpub(multiOutputFile(opts.OutputFile, format, false), opts.OutputFile+".epub")
+			if err != nil {
+				Error(false, err)
+			}
+		}
+	}
+}
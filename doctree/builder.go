@@ -0,0 +1,449 @@
+package doctree
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/frundis"
+	"codeberg.org/anaseto/gofrundis/internal/stream"
+)
+
+// Options gathers configuration for the document tree builder.
+type Options struct {
+	// Format is the export format name used for conditionals and -f
+	// options of macros ("xhtml" by default). As-is text given with Ft or
+	// Bf for other formats ends up in Raw nodes, while text for this format
+	// is kept as Text.
+	Format string
+	// Unrestricted enables #run and shell filters (used by Build).
+	Unrestricted bool
+}
+
+// Builder is a frundis.Exporter that builds a document tree instead of
+// producing output. It writes a stream of text and node markers during the
+// process pass, which is then converted into a tree at post-processing.
+type Builder struct {
+	frundis.BaseRenderer
+	Format string
+	stream bytes.Buffer // marked up document stream
+	stanza bool         // whether a stanza is open
+	table  bool         // whether inside a table
+	verse  bool         // whether inside a poem
+	decls  [][]string   // arguments of X mtag, X dtag and X set declarations
+	doc    *Document
+}
+
+// NewBuilder returns a new document tree builder. See type Options for
+// options.
+func NewBuilder(opts *Options) *Builder {
+	format := opts.Format
+	if format == "" {
+		format = "xhtml"
+	}
+	return &Builder{Format: format}
+}
+
+// Build processes a frundis source file and returns its document tree.
+// Processing errors are reported like with any exporter.
+func Build(filename string, opts *Options) (*Document, error) {
+	b := NewBuilder(opts)
+	err := frundis.ProcessFrundisSource(b, filename, opts.Unrestricted)
+	if err != nil {
+		return nil, err
+	}
+	return b.Document(), nil
+}
+
+// Document returns the document tree built after processing, or nil if
+// processing did not complete.
+func (b *Builder) Document() *Document {
+	return b.doc
+}
+
+// Init initializes the builder. It is called by frundis.ProcessFrundisSource.
+func (b *Builder) Init() {
+	ctx := &frundis.Context{Wout: bufio.NewWriter(&b.stream), Format: b.Format}
+	b.Ctx = ctx
+	ctx.Init()
+	ctx.Filters["escape"] = stream.Sanitize
+	x := ctx.Macros["X"]
+	ctx.Macros["X"] = func(exp frundis.Exporter) {
+		b.recordDecl()
+		x(exp)
+	}
+}
+
+// recordDecl records the arguments of an X mtag, X dtag or X set
+// declaration, whatever the format it is intended for, so that it can be made
+// again by Render.
+func (b *Builder) recordDecl() {
+	ctx := b.Context()
+	if ctx.Process || len(ctx.Args) == 0 {
+		return
+	}
+	switch ctx.InlinesToText(ctx.Args[0]) {
+	case "mtag", "dtag", "set":
+	default:
+		return
+	}
+	args := make([]string, 0, len(ctx.Args))
+	for _, arg := range ctx.Args {
+		args = append(args, ctx.InlinesToText(arg))
+	}
+	b.decls = append(b.decls, args)
+}
+
+// Reset prepares the builder for the process pass.
+func (b *Builder) Reset() error {
+	ctx := b.Context()
+	ctx.Reset()
+	b.stream.Reset()
+	ctx.Wout = bufio.NewWriter(&b.stream)
+	return nil
+}
+
+// PostProcessing builds the document tree from the document stream.
+func (b *Builder) PostProcessing() {
+	ctx := b.Context()
+	ctx.Wout.Flush()
+	b.doc = document(stream.Parse(b.stream.String()))
+	b.doc.Params = make(map[string]string, len(ctx.Params))
+	for k, v := range ctx.Params {
+		b.doc.Params[k] = v
+	}
+	b.doc.Decls = b.decls
+}
+
+// BeginDescList implements frundis.Renderer.
+func (b *Builder) BeginDescList(id string) {
+	fmt.Fprint(b.Context().W(), open(nodeDescList, id))
+}
+
+// BeginDescValue implements frundis.Renderer.
+func (b *Builder) BeginDescValue() {
+	fmt.Fprint(b.Context().W(), open(nodeDescValue))
+}
+
+// BeginDialogue implements frundis.Renderer.
+func (b *Builder) BeginDialogue() {
+	fmt.Fprint(b.Context().W(), open(nodeDialogue), stream.Close)
+}
+
+// BeginDisplayBlock implements frundis.Renderer.
+func (b *Builder) BeginDisplayBlock(tag string, id string) {
+	fmt.Fprint(b.Context().W(), open(nodeDisplay, tag, id))
+}
+
+// BeginEnumItem implements frundis.Renderer.
+func (b *Builder) BeginEnumItem() {
+	b.BeginItem()
+}
+
+// BeginEnumList implements frundis.Renderer.
+func (b *Builder) BeginEnumList(id string) {
+	fmt.Fprint(b.Context().W(), open(nodeEnumList, id))
+}
+
+// BeginHeader implements frundis.Renderer.
+func (b *Builder) BeginHeader(macro string, numbered bool, title string) {
+	ctx := b.Context()
+	level := ctx.Toc.HeaderLevel(macro)
+	toc := ctx.LoXstack["toc"]
+	entry := toc[ctx.Toc.HeaderCount-1] // headers count is updated before
+	var nonum string
+	if !numbered {
+		nonum = "nonum"
+	}
+	fmt.Fprint(ctx.W(), open(nodeHeader, macro, strconv.Itoa(level), nonum, entry.Ref, ctx.IDX))
+}
+
+// BeginItem implements frundis.Renderer.
+func (b *Builder) BeginItem() {
+	fmt.Fprint(b.Context().W(), open(nodeItem))
+}
+
+// BeginItemList implements frundis.Renderer.
+func (b *Builder) BeginItemList(id string) {
+	fmt.Fprint(b.Context().W(), open(nodeItemList, id))
+}
+
+// BeginMarkupBlock implements frundis.Renderer.
+func (b *Builder) BeginMarkupBlock(tag string, id string) {
+	fmt.Fprint(b.Context().W(), open(nodeMarkup, tag, id))
+}
+
+// BeginParagraph implements frundis.Renderer.
+func (b *Builder) BeginParagraph() {
+	w := b.Context().W()
+	switch {
+	case b.table:
+	case b.verse:
+		if !b.stanza {
+			fmt.Fprint(w, open(nodeStanza))
+			b.stanza = true
+		}
+	default:
+		fmt.Fprint(w, open(nodePara))
+	}
+}
+
+// BeginTable implements frundis.Renderer.
+func (b *Builder) BeginTable(tableinfo *frundis.TableData) {
+	w := b.Context().W()
+	id := tableinfo.ID
+	if tableinfo.Title != "" {
+		id = b.loXID("lot", b.Context().Table.TitCount)
+	}
+	fmt.Fprint(w, open(nodeTable, id, strconv.Itoa(tableinfo.Cols)))
+	if tableinfo.Title != "" {
+		fmt.Fprint(w, open(nodeCaption), tableinfo.Title, stream.Close)
+	}
+	b.table = true
+}
+
+// BeginTableCell implements frundis.Renderer.
+func (b *Builder) BeginTableCell() {
+	fmt.Fprint(b.Context().W(), open(nodeCell))
+}
+
+// BeginTableRow implements frundis.Renderer.
+func (b *Builder) BeginTableRow() {
+	fmt.Fprint(b.Context().W(), open(nodeRow))
+}
+
+// BeginVerse implements frundis.Renderer.
+func (b *Builder) BeginVerse(title string, id string) {
+	w := b.Context().W()
+	if title != "" {
+		// id is the poem number
+		n, _ := strconv.Atoi(id)
+		id = b.loXID("lop", n)
+	}
+	fmt.Fprint(w, open(nodeVerse, id))
+	if title != "" {
+		fmt.Fprint(w, open(nodeCaption), title, stream.Close)
+	}
+	b.verse = true
+}
+
+// BeginVerseLine implements frundis.Renderer.
+func (b *Builder) BeginVerseLine() {
+	fmt.Fprint(b.Context().W(), open(nodeLine))
+}
+
+// CrossReference implements frundis.Renderer.
+func (b *Builder) CrossReference(idf frundis.IDInfo, punct string) {
+	fmt.Fprint(b.Context().W(), open(nodeRef, idf.Ref, idf.ID, strconv.Itoa(int(idf.Type))), idf.Name, stream.Close, punct)
+}
+
+// DescName implements frundis.Renderer.
+func (b *Builder) DescName(name string) {
+	fmt.Fprint(b.Context().W(), open(nodeDescName), name, stream.Close)
+}
+
+// EndDescList implements frundis.Renderer.
+func (b *Builder) EndDescList() {
+	b.EndItemList()
+}
+
+// EndDescValue implements frundis.Renderer.
+func (b *Builder) EndDescValue() {
+	b.EndItemList()
+}
+
+// EndDisplayBlock implements frundis.Renderer.
+func (b *Builder) EndDisplayBlock(tag string) {
+	fmt.Fprint(b.Context().W(), stream.Close)
+}
+
+// EndEnumItem implements frundis.Renderer.
+func (b *Builder) EndEnumItem() {
+	b.EndItemList()
+}
+
+// EndEnumList implements frundis.Renderer.
+func (b *Builder) EndEnumList() {
+	b.EndItemList()
+}
+
+// EndHeader implements frundis.Renderer.
+func (b *Builder) EndHeader(macro string, numbered bool, title string) {
+	fmt.Fprint(b.Context().W(), stream.Close)
+}
+
+// EndItem implements frundis.Renderer.
+func (b *Builder) EndItem() {
+	b.EndItemList()
+}
+
+// EndItemList implements frundis.Renderer.
+func (b *Builder) EndItemList() {
+	fmt.Fprint(b.Context().W(), stream.Close)
+}
+
+// EndMarkupBlock implements frundis.Renderer.
+func (b *Builder) EndMarkupBlock(tag string, id string, punct string) {
+	fmt.Fprint(b.Context().W(), stream.Close, punct)
+}
+
+// EndParagraph implements frundis.Renderer.
+func (b *Builder) EndParagraph(pbreak frundis.ParagraphBreak) {
+	switch {
+	case pbreak == frundis.ParBreakForced:
+	case b.table:
+	case b.verse:
+		b.EndStanza()
+	default:
+		fmt.Fprint(b.Context().W(), stream.Close)
+	}
+}
+
+// EndStanza implements frundis.Renderer. It closes the last line too.
+func (b *Builder) EndStanza() {
+	if !b.stanza {
+		return
+	}
+	fmt.Fprint(b.Context().W(), stream.Close, stream.Close)
+	b.stanza = false
+}
+
+// EndTable implements frundis.Renderer.
+func (b *Builder) EndTable(tableinfo *frundis.TableData) {
+	fmt.Fprint(b.Context().W(), stream.Close)
+	b.table = false
+}
+
+// EndTableCell implements frundis.Renderer.
+func (b *Builder) EndTableCell() {
+	b.EndItemList()
+}
+
+// EndTableRow implements frundis.Renderer.
+func (b *Builder) EndTableRow() {
+	b.EndItemList()
+}
+
+// EndVerse implements frundis.Renderer.
+func (b *Builder) EndVerse() {
+	fmt.Fprint(b.Context().W(), stream.Close)
+	b.verse = false
+}
+
+// EndVerseLine implements frundis.Renderer.
+func (b *Builder) EndVerseLine() {
+	fmt.Fprint(b.Context().W(), stream.Close)
+}
+
+// FigureImage implements frundis.Renderer.
+func (b *Builder) FigureImage(image string, caption string, link string, alt string) {
+	ctx := b.Context()
+	fmt.Fprint(ctx.W(), open(nodeFigure, image, link, alt, b.loXID("lof", ctx.FigCount)), caption, stream.Close)
+}
+
+// GenRef implements frundis.Renderer.
+func (b *Builder) GenRef(prefix string, id string, hasfile bool) string {
+	if prefix != "" {
+		return fmt.Sprintf("%s:%s", prefix, id)
+	}
+	return id
+}
+
+// loXID returns the explicit identifier of the i-th entry, starting from 1,
+// of a given class of elements (lof, lot or lop), if any.
+func (b *Builder) loXID(class string, i int) string {
+	entries := b.Context().LoXstack[class]
+	if i < 1 || i > len(entries) {
+		return ""
+	}
+	return entries[i-1].ID
+}
+
+// HeaderReference implements frundis.Renderer.
+func (b *Builder) HeaderReference(macro string) string {
+	ctx := b.Context()
+	if ctx.IDX != "" {
+		return b.GenRef("", ctx.IDX, false)
+	}
+	return b.GenRef("s", strconv.Itoa(ctx.Toc.HeaderCount), false)
+}
+
+// InlineImage implements frundis.Renderer.
+func (b *Builder) InlineImage(image string, link string, id string, punct string, alt string) {
+	fmt.Fprint(b.Context().W(), open(nodeImage, image, link, id, alt), stream.Close, punct)
+}
+
+// LkWithLabel implements frundis.Renderer.
+func (b *Builder) LkWithLabel(url string, label string, punct string) {
+	fmt.Fprint(b.Context().W(), open(nodeLink, url), label, stream.Close, punct)
+}
+
+// LkWithoutLabel implements frundis.Renderer.
+func (b *Builder) LkWithoutLabel(url string, punct string) {
+	fmt.Fprint(b.Context().W(), open(nodeLink, url), stream.Close, punct)
+}
+
+// Math implements frundis.MathRenderer.
+func (b *Builder) Math(tex string, punct string) {
+	fmt.Fprint(b.Context().W(), open(nodeMath, tex), stream.Close, punct)
+}
+
+// Note implements frundis.NoteRenderer.
+func (b *Builder) Note(text string, punct string) {
+	fmt.Fprint(b.Context().W(), open(nodeNote), text, stream.Close, punct)
+}
+
+// ParagraphTitle implements frundis.Renderer.
+func (b *Builder) ParagraphTitle(title string) {
+	fmt.Fprint(b.Context().W(), open(nodePara), open(nodeParaTitle), title, stream.Close)
+}
+
+// RawFormat implements frundis.RawRenderer. As-is text for any format is
+// kept in Raw nodes.
+func (b *Builder) RawFormat(format string) (string, bool) {
+	return format, true
+}
+
+// RawText implements frundis.RawRenderer.
+func (b *Builder) RawText(format string, text string) {
+	fmt.Fprint(b.Context().W(), open(nodeRaw, format, text), stream.Close)
+}
+
+// RenderText implements frundis.Renderer. Text is not escaped.
+func (b *Builder) RenderText(text []ast.Inline) string {
+	return stream.Sanitize(b.Context().InlinesToText(text))
+}
+
+// TableOfContents implements frundis.Renderer.
+func (b *Builder) TableOfContents(opts map[string][]ast.Inline, flags map[string]bool) {
+	kind := "toc"
+	for _, k := range []string{"lof", "lot", "lop"} {
+		if flags[k] {
+			kind = k
+		}
+	}
+	ctx := b.Context()
+	args := []string{kind, ctx.InlinesToText(opts["title"])}
+	for _, k := range []string{"mini", "nonum", "summary"} {
+		if flags[k] {
+			args = append(args, k)
+		}
+	}
+	fmt.Fprint(ctx.W(), open(nodeToc, args...), stream.Close)
+}
+
+// Xdtag implements frundis.Exporter.
+func (b *Builder) Xdtag(cmd string, pairs []string) frundis.Dtag {
+	return frundis.Dtag{Cmd: cmd, Pairs: pairs}
+}
+
+// Xmtag implements frundis.Exporter.
+func (b *Builder) Xmtag(cmd *string, begin string, end string, pairs []string) frundis.Mtag {
+	var c string
+	if cmd != nil {
+		c = *cmd
+	}
+	return frundis.Mtag{Begin: begin, End: end, Cmd: c, Pairs: pairs}
+}
@@ -0,0 +1,388 @@
+// Package doctree provides a typed document tree for frundis documents,
+// built by processing source files like an exporter would. The tree can be
+// inspected and transformed by Go programs before producing any output:
+// Render renders a tree with any exporter. Exporters may also embed a Builder
+// and produce their output from the tree, as the pandoc exporter does.
+package doctree
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// Node is the interface satisfied by all the nodes of a document tree. Block
+// nodes are Document, Section, Paragraph, Display, List, Item, Table, Row,
+// Cell, Verse, Stanza, Line, Figure, TableOfContents and Raw. Inline nodes
+// are Text, Markup, Link, Reference, Image, Dialogue, Note, Math and Raw.
+type Node interface {
+	// Nodes returns the children of the node (nil for leaf nodes).
+	Nodes() []Node
+}
+
+// Document is the root of a document tree.
+type Document struct {
+	Params   map[string]string // parameters set with X set
+	Decls    [][]string        // arguments of X mtag, X dtag and X set declarations
+	Children []Node
+}
+
+// Section represents a header and the content that follows it, up to the
+// next header of same or higher level. Parts, chapters, sections and
+// subsections are distinguished by their macro.
+type Section struct {
+	Macro    string // "Pt", "Ch", "Sh" or "Ss"
+	Level    int    // header level: 1 for the highest level used
+	Numbered bool   // whether the header is numbered
+	ID       string // explicit identifier, if any
+	Ref      string // reference for cross-references
+	Title    []Node // inline nodes
+	Children []Node
+}
+
+// Paragraph represents a paragraph, with an optional title.
+type Paragraph struct {
+	Title    []Node // inline nodes
+	Children []Node // inline nodes
+}
+
+// Display represents a display block started with Bd.
+type Display struct {
+	Tag      string
+	ID       string
+	Children []Node
+}
+
+// ListKind represents a kind of list.
+type ListKind int
+
+// Kinds of lists.
+const (
+	ItemList ListKind = iota
+	EnumList
+	DescList
+)
+
+// List represents an item, enumeration or description list, whose children
+// are Item nodes.
+type List struct {
+	Kind     ListKind
+	ID       string
+	Children []Node
+}
+
+// Item represents a list item.
+type Item struct {
+	Name     []Node // description list item name (inline nodes)
+	Children []Node
+}
+
+// Table represents a table, whose children are Row nodes.
+type Table struct {
+	Title    []Node // inline nodes
+	ID       string
+	Cols     int // number of columns
+	Children []Node
+}
+
+// Row represents a table row, whose children are Cell nodes.
+type Row struct {
+	Children []Node
+}
+
+// Cell represents a table cell.
+type Cell struct {
+	Children []Node
+}
+
+// Verse represents a poem, whose children are Stanza nodes.
+type Verse struct {
+	Title    []Node // inline nodes
+	ID       string
+	Children []Node
+}
+
+// Stanza represents a poem stanza, whose children are Line nodes.
+type Stanza struct {
+	Children []Node
+}
+
+// Line represents a poem line.
+type Line struct {
+	Children []Node // inline nodes
+}
+
+// Figure represents an image with a caption.
+type Figure struct {
+	Image   string
+	Link    string
+	Alt     string
+	ID      string
+	Caption []Node // inline nodes
+}
+
+// TableOfContents represents a table of contents, or another list of
+// elements, as requested with the Tc macro.
+type TableOfContents struct {
+	Kind    string // "toc", "lof", "lot" or "lop"
+	Mini    bool   // -mini flag
+	Nonum   bool   // -nonum flag
+	Summary bool   // -summary flag
+	Title   string // -title option
+}
+
+// Text represents text, without any escaping.
+type Text struct {
+	Text string
+}
+
+// Markup represents inline markup started with Bm or Sm.
+type Markup struct {
+	Tag      string
+	ID       string
+	Children []Node
+}
+
+// Link represents a link.
+type Link struct {
+	URL   string
+	Label []Node // inline nodes (empty for links without label)
+}
+
+// Reference represents a cross-reference.
+type Reference struct {
+	Ref  string // reference, as in Section.Ref
+	ID   string // referenced identifier, if any
+	Type frundis.IDType
+	Name []Node // inline nodes
+}
+
+// Image represents an inline image.
+type Image struct {
+	Image string
+	Link  string
+	ID    string
+	Alt   string
+}
+
+// Dialogue represents the start of a dialogue.
+type Dialogue struct{}
+
+// Note represents a footnote, as provided by frundis.NoteRenderer.
+type Note struct {
+	Children []Node // inline nodes
+}
+
+// Math represents a TeX formula, as provided by frundis.MathRenderer.
+type Math struct {
+	TeX string
+}
+
+// Raw represents text targeted at another format, as provided by Ft or Bf.
+type Raw struct {
+	Format string
+	Text   string
+}
+
+// Nodes returns the children of the node.
+func (n *Document) Nodes() []Node { return n.Children }
+
+// Nodes returns the children of the node.
+func (n *Section) Nodes() []Node { return n.Children }
+
+// Nodes returns the children of the node.
+func (n *Paragraph) Nodes() []Node { return n.Children }
+
+// Nodes returns the children of the node.
+func (n *Display) Nodes() []Node { return n.Children }
+
+// Nodes returns the children of the node.
+func (n *List) Nodes() []Node { return n.Children }
+
+// Nodes returns the children of the node.
+func (n *Item) Nodes() []Node { return n.Children }
+
+// Nodes returns the children of the node.
+func (n *Table) Nodes() []Node { return n.Children }
+
+// Nodes returns the children of the node.
+func (n *Row) Nodes() []Node { return n.Children }
+
+// Nodes returns the children of the node.
+func (n *Cell) Nodes() []Node { return n.Children }
+
+// Nodes returns the children of the node.
+func (n *Verse) Nodes() []Node { return n.Children }
+
+// Nodes returns the children of the node.
+func (n *Stanza) Nodes() []Node { return n.Children }
+
+// Nodes returns the children of the node.
+func (n *Line) Nodes() []Node { return n.Children }
+
+// Nodes returns nil.
+func (n *Figure) Nodes() []Node { return nil }
+
+// Nodes returns nil.
+func (n *TableOfContents) Nodes() []Node { return nil }
+
+// Nodes returns nil.
+func (n *Text) Nodes() []Node { return nil }
+
+// Nodes returns the children of the node.
+func (n *Markup) Nodes() []Node { return n.Children }
+
+// Nodes returns nil.
+func (n *Link) Nodes() []Node { return nil }
+
+// Nodes returns nil.
+func (n *Reference) Nodes() []Node { return nil }
+
+// Nodes returns nil.
+func (n *Image) Nodes() []Node { return nil }
+
+// Nodes returns nil.
+func (n *Dialogue) Nodes() []Node { return nil }
+
+// Nodes returns the children of the node.
+func (n *Note) Nodes() []Node { return n.Children }
+
+// Nodes returns nil.
+func (n *Math) Nodes() []Node { return nil }
+
+// Nodes returns nil.
+func (n *Raw) Nodes() []Node { return nil }
+
+// Walk traverses a tree in depth-first order, starting with n. It calls fn
+// for each node, and visits the children of the node if fn returns true.
+// Inline nodes in titles, names, captions and labels are not visited.
+func Walk(n Node, fn func(Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for _, c := range n.Nodes() {
+		Walk(c, fn)
+	}
+}
+
+// TextContent returns the concatenated text of inline nodes, without markup.
+func TextContent(nodes []Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		Walk(n, func(n Node) bool {
+			switch n := n.(type) {
+			case *Text:
+				b.WriteString(n.Text)
+			case *Link:
+				if len(n.Label) > 0 {
+					b.WriteString(TextContent(n.Label))
+				} else {
+					b.WriteString(n.URL)
+				}
+			case *Reference:
+				b.WriteString(TextContent(n.Name))
+			case *Image:
+				b.WriteString(n.Alt)
+			case *Dialogue:
+				b.WriteString("—")
+			case *Math:
+				b.WriteString(n.TeX)
+			case *Note:
+				return false
+			}
+			return true
+		})
+	}
+	return b.String()
+}
+
+// Fprint writes to w an indented textual representation of a tree, for
+// debugging purposes.
+func Fprint(w io.Writer, n Node) {
+	fprint(w, n, 0)
+}
+
+func fprint(w io.Writer, n Node, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch n := n.(type) {
+	case *Document:
+		fmt.Fprintf(w, "%sDocument\n", indent)
+	case *Section:
+		fmt.Fprintf(w, "%sSection %s level=%d ref=%q title=%q", indent, n.Macro, n.Level, n.Ref, TextContent(n.Title))
+		if n.ID != "" {
+			fmt.Fprintf(w, " id=%q", n.ID)
+		}
+		if !n.Numbered {
+			fmt.Fprint(w, " nonum")
+		}
+		fmt.Fprintln(w)
+	case *Paragraph:
+		fmt.Fprintf(w, "%sParagraph", indent)
+		if len(n.Title) > 0 {
+			fmt.Fprintf(w, " title=%q", TextContent(n.Title))
+		}
+		fmt.Fprintln(w)
+	case *Display:
+		fmt.Fprintf(w, "%sDisplay tag=%q id=%q\n", indent, n.Tag, n.ID)
+	case *List:
+		kind := [...]string{"item", "enum", "desc"}[n.Kind]
+		fmt.Fprintf(w, "%sList %s id=%q\n", indent, kind, n.ID)
+	case *Item:
+		fmt.Fprintf(w, "%sItem", indent)
+		if len(n.Name) > 0 {
+			fmt.Fprintf(w, " name=%q", TextContent(n.Name))
+		}
+		fmt.Fprintln(w)
+	case *Table:
+		fmt.Fprintf(w, "%sTable title=%q id=%q\n", indent, TextContent(n.Title), n.ID)
+	case *Row:
+		fmt.Fprintf(w, "%sRow\n", indent)
+	case *Cell:
+		fmt.Fprintf(w, "%sCell\n", indent)
+	case *Verse:
+		fmt.Fprintf(w, "%sVerse title=%q id=%q\n", indent, TextContent(n.Title), n.ID)
+	case *Stanza:
+		fmt.Fprintf(w, "%sStanza\n", indent)
+	case *Line:
+		fmt.Fprintf(w, "%sLine\n", indent)
+	case *Figure:
+		fmt.Fprintf(w, "%sFigure image=%q caption=%q\n", indent, n.Image, TextContent(n.Caption))
+	case *TableOfContents:
+		fmt.Fprintf(w, "%sTableOfContents %s", indent, n.Kind)
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{{"mini", n.Mini}, {"nonum", n.Nonum}, {"summary", n.Summary}} {
+			if f.set {
+				fmt.Fprint(w, " ", f.name)
+			}
+		}
+		if n.Title != "" {
+			fmt.Fprintf(w, " title=%q", n.Title)
+		}
+		fmt.Fprintln(w)
+	case *Text:
+		fmt.Fprintf(w, "%sText %q\n", indent, n.Text)
+	case *Markup:
+		fmt.Fprintf(w, "%sMarkup tag=%q\n", indent, n.Tag)
+	case *Link:
+		fmt.Fprintf(w, "%sLink url=%q label=%q\n", indent, n.URL, TextContent(n.Label))
+	case *Reference:
+		fmt.Fprintf(w, "%sReference ref=%q name=%q\n", indent, n.Ref, TextContent(n.Name))
+	case *Image:
+		fmt.Fprintf(w, "%sImage image=%q\n", indent, n.Image)
+	case *Dialogue:
+		fmt.Fprintf(w, "%sDialogue\n", indent)
+	case *Note:
+		fmt.Fprintf(w, "%sNote\n", indent)
+	case *Math:
+		fmt.Fprintf(w, "%sMath tex=%q\n", indent, n.TeX)
+	case *Raw:
+		fmt.Fprintf(w, "%sRaw format=%q text=%q\n", indent, n.Format, n.Text)
+	}
+	for _, c := range n.Nodes() {
+		fprint(w, c, depth+1)
+	}
+}
@@ -0,0 +1,133 @@
+package doctree
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "codeberg.org/anaseto/gofrundis/exporter/docbook"
+	_ "codeberg.org/anaseto/gofrundis/exporter/events"
+	_ "codeberg.org/anaseto/gofrundis/exporter/fb2"
+	_ "codeberg.org/anaseto/gofrundis/exporter/latex"
+	_ "codeberg.org/anaseto/gofrundis/exporter/markdown"
+	_ "codeberg.org/anaseto/gofrundis/exporter/mom"
+	_ "codeberg.org/anaseto/gofrundis/exporter/tei"
+	_ "codeberg.org/anaseto/gofrundis/exporter/typst"
+	_ "codeberg.org/anaseto/gofrundis/exporter/xhtml"
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// TestBuild compares the dump of the tree built for each source file with
+// the file of same base name and suffix .tree.
+func TestBuild(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.frundis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		doc, err := Build(f, &Options{})
+		if err != nil {
+			t.Errorf("%s: %v", f, err)
+			continue
+		}
+		var out bytes.Buffer
+		Fprint(&out, doc)
+		golden := strings.TrimSuffix(f, filepath.Ext(f)) + ".tree"
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != string(want) {
+			t.Errorf("%s: tree differs from %s:\n%s", f, golden, out.String())
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	doc, err := Build("testdata/tree.frundis", &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	Walk(doc, func(n Node) bool {
+		switch n := n.(type) {
+		case *Section:
+			titles = append(titles, TextContent(n.Title))
+		case *Markup:
+			// transformation pass: drop markup around text
+			n.Tag = "none"
+		case *Verse:
+			return false
+		case *Line:
+			t.Error("visited poem line")
+		}
+		return true
+	})
+	if got, want := strings.Join(titles, "|"), "Introduction|Section|Subsection|Poems"; got != want {
+		t.Errorf("section titles: got %q, want %q", got, want)
+	}
+	Walk(doc, func(n Node) bool {
+		if n, ok := n.(*Markup); ok && n.Tag != "none" {
+			t.Errorf("markup tag not transformed: %q", n.Tag)
+		}
+		return true
+	})
+}
+
+// TestFormats checks that the names and families of formats registered by
+// exporter packages are valid in -f options.
+func TestFormats(t *testing.T) {
+	b := NewBuilder(&Options{})
+	var diags bytes.Buffer
+	err := frundis.ProcessFrundisSourceConcurrently(context.Background(), []frundis.Exporter{b},
+		"testdata/formats/formats.frundis", &frundis.ProcessOptions{}, &diags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diags.Len() > 0 {
+		t.Errorf("diagnostics: %s", diags.String())
+	}
+	var out bytes.Buffer
+	Fprint(&out, b.Document())
+	if !strings.Contains(out.String(), "Some text.") {
+		t.Errorf("missing conditional text:\n%s", out.String())
+	}
+}
+
+// TestRender checks that rendering a document tree with a tree builder gives
+// back the same tree, and that tags declared for other formats are declared
+// again.
+func TestRender(t *testing.T) {
+	doc, err := Build("testdata/tree.frundis", &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nodes without builtin macro
+	p := doc.Children[0].(*Section).Children[0].(*Paragraph)
+	p.Children = append(p.Children,
+		&Note{Children: []Node{&Text{Text: "A note."}}},
+		&Math{TeX: `x^2`},
+		&Reference{Ref: "elsewhere", Type: frundis.NoID, Name: []Node{&Text{Text: "there"}}})
+	b := NewBuilder(&Options{})
+	err = Render(b, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want, got bytes.Buffer
+	Fprint(&want, doc)
+	Fprint(&got, b.Document())
+	if got.String() != want.String() {
+		t.Errorf("rendered tree differs:\n%s", got.String())
+	}
+	b = NewBuilder(&Options{Format: "latex"})
+	err = Render(b, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.Context().Mtags["tex"]; !ok {
+		t.Errorf("tag declared for latex not declared again")
+	}
+}
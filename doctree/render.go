@@ -0,0 +1,413 @@
+package doctree
+
+import (
+	"fmt"
+	"strconv"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// Render renders a document tree with a given exporter, as
+// frundis.ProcessFrundisSource does for a source file: the tree is walked
+// once to collect identifiers and table of contents entries, and once more
+// to produce output. The declarations of the tree, as made in the source file
+// with X mtag, X dtag or X set, are made again for the exporter, whatever the
+// format they were intended for: parameters are changed by adding
+// declarations, not by changing Params. Notes and formulas are written inline
+// with exporters that do not satisfy frundis.NoteRenderer or
+// frundis.MathRenderer.
+func Render(exp frundis.Exporter, doc *Document) error {
+	exp.Init()
+	r := &renderer{exp: exp, mc: frundis.NewMacroContext(exp)}
+	r.declare(doc)
+	r.blocks(doc.Children)
+	err := exp.Reset()
+	if err != nil {
+		return err
+	}
+	r.poems = 0
+	r.blocks(doc.Children)
+	r.mc.EndParagraph(frundis.ParBreakNormal)
+	exp.PostProcessing()
+	return nil
+}
+
+// renderer walks a document tree, calling exporter methods. During the info
+// pass, it only registers identifiers and list of elements entries.
+type renderer struct {
+	exp   frundis.Exporter
+	mc    *frundis.MacroContext
+	poems int // number of titled poems so far
+}
+
+// info reports whether rendering is in the info pass.
+func (r *renderer) info() bool {
+	return r.mc.InfoPass()
+}
+
+// declare makes the declarations of the tree with X.
+func (r *renderer) declare(doc *Document) {
+	ctx := r.exp.Context()
+	for _, decl := range doc.Decls {
+		args := make([][]ast.Inline, 0, len(decl))
+		for _, a := range decl {
+			args = append(args, []ast.Inline{ast.Text(a)})
+		}
+		ctx.Macro = "X"
+		ctx.Args = args
+		ctx.Macros["X"](r.exp)
+	}
+	ctx.Macro = ""
+	ctx.Args = nil
+}
+
+// capture returns the rendering of inline nodes, as for the title of a
+// header.
+func (r *renderer) capture(nodes []Node) string {
+	return r.mc.Capture(func() {
+		for _, n := range nodes {
+			r.inline(n)
+		}
+	})
+}
+
+func (r *renderer) blocks(nodes []Node) {
+	for _, n := range nodes {
+		r.block(n)
+	}
+}
+
+func (r *renderer) block(n Node) {
+	exp, ctx, mc := r.exp, r.exp.Context(), r.mc
+	switch n := n.(type) {
+	case *Section:
+		mc.Header(n.Macro, n.Numbered, n.ID, r.capture(n.Title))
+		r.blocks(n.Children)
+	case *Paragraph:
+		if !r.info() {
+			mc.EndParagraph(frundis.ParBreakNormal)
+			if len(n.Title) > 0 {
+				mc.ParagraphTitle(r.capture(n.Title))
+			}
+		}
+		r.inlines(n.Children)
+	case *Display:
+		if r.info() {
+			if n.ID != "" {
+				mc.RegisterID(n.ID, frundis.BdID, "")
+			}
+			r.blocks(n.Children)
+			return
+		}
+		var pbreak frundis.ParagraphBreak
+		if ctx.Dtags[n.Tag].Cmd != "" {
+			pbreak = frundis.ParBreakBlock
+		}
+		mc.EndParagraph(pbreak)
+		exp.BeginDisplayBlock(n.Tag, n.ID)
+		r.blocks(n.Children)
+		mc.EndParagraph(pbreak)
+		exp.EndDisplayBlock(n.Tag)
+		ctx.WantsSpace = false
+	case *List:
+		r.list(n)
+	case *Table:
+		r.table(n)
+	case *Verse:
+		r.verse(n)
+	case *Figure:
+		ctx.FigCount++
+		if r.info() {
+			ctx.Images = append(ctx.Images, n.Image)
+			mc.AddLoXEntry("lof", &frundis.LoXinfo{
+				ID:        n.ID,
+				Title:     r.capture(n.Caption),
+				Count:     ctx.FigCount,
+				RefPrefix: "fig"},
+				strconv.Itoa(ctx.FigCount))
+			return
+		}
+		mc.EndParagraph(frundis.ParBreakNormal)
+		exp.FigureImage(n.Image, r.capture(n.Caption), n.Link, n.Alt)
+	case *TableOfContents:
+		flags := map[string]bool{n.Kind: true, "mini": n.Mini, "nonum": n.Nonum, "summary": n.Summary}
+		if r.info() {
+			exp.TableOfContentsInfos(flags)
+			return
+		}
+		var opts map[string][]ast.Inline
+		if n.Title != "" {
+			opts = map[string][]ast.Inline{"title": {ast.Text(n.Title)}}
+		}
+		mc.EndParagraph(frundis.ParBreakNormal)
+		exp.TableOfContents(opts, flags)
+	case *Raw:
+		if r.info() {
+			return
+		}
+		mc.EndParagraph(frundis.ParBreakNormal)
+		if r.raw(n) {
+			fmt.Fprint(mc.W(), "\n")
+		}
+	default:
+		// inline node at block level
+		r.inline(n)
+	}
+}
+
+func (r *renderer) list(n *List) {
+	exp, mc := r.exp, r.mc
+	if r.info() {
+		if n.ID != "" {
+			mc.RegisterID(n.ID, frundis.UntitledList, "")
+		}
+		for _, it := range n.Children {
+			r.blocks(it.Nodes())
+		}
+		return
+	}
+	mc.EndParagraph(frundis.ParBreakBlock)
+	switch n.Kind {
+	case DescList:
+		exp.BeginDescList(n.ID)
+	case EnumList:
+		exp.BeginEnumList(n.ID)
+	default:
+		exp.BeginItemList(n.ID)
+	}
+	for _, it := range n.Children {
+		switch n.Kind {
+		case DescList:
+			var name []Node
+			if it, ok := it.(*Item); ok {
+				name = it.Name
+			}
+			exp.DescName(r.capture(name))
+			exp.BeginDescValue()
+		case EnumList:
+			exp.BeginEnumItem()
+		default:
+			exp.BeginItem()
+		}
+		r.blocks(it.Nodes())
+		mc.EndParagraph(frundis.ParBreakItem)
+		switch n.Kind {
+		case DescList:
+			exp.EndDescValue()
+		case EnumList:
+			exp.EndEnumItem()
+		default:
+			exp.EndItem()
+		}
+	}
+	switch n.Kind {
+	case DescList:
+		exp.EndDescList()
+	case EnumList:
+		exp.EndEnumList()
+	default:
+		exp.EndItemList()
+	}
+	exp.Context().WantsSpace = false
+}
+
+func (r *renderer) table(n *Table) {
+	exp, ctx, mc := r.exp, r.exp.Context(), r.mc
+	var title string
+	if len(n.Title) > 0 {
+		title = r.capture(n.Title)
+	}
+	if title != "" {
+		ctx.Table.TitCount++
+	}
+	if r.info() {
+		if title != "" {
+			mc.AddLoXEntry("lot", &frundis.LoXinfo{
+				ID:        n.ID,
+				Title:     title,
+				Count:     ctx.Table.TitCount,
+				RefPrefix: "tbl"},
+				strconv.Itoa(ctx.Table.TitCount))
+		} else if n.ID != "" {
+			mc.RegisterID(n.ID, frundis.UntitledList, "")
+		}
+		for _, row := range n.Children {
+			for _, cell := range row.Nodes() {
+				r.inlines(cell.Nodes())
+			}
+		}
+		return
+	}
+	mc.EndParagraph(frundis.ParBreakBlock)
+	data := &frundis.TableData{Cols: n.Cols, Title: title}
+	if title == "" {
+		data.ID = n.ID
+	}
+	exp.BeginTable(data)
+	for _, row := range n.Children {
+		ctx.Table.Cell = 1
+		exp.BeginTableRow()
+		for i, cell := range row.Nodes() {
+			if i > 0 {
+				mc.EndParagraph(frundis.ParBreakItem)
+				exp.EndTableCell()
+				ctx.Table.Cell++
+			}
+			exp.BeginTableCell()
+			r.inlines(cell.Nodes())
+		}
+		mc.EndParagraph(frundis.ParBreakItem)
+		exp.EndTableCell()
+		exp.EndTableRow()
+	}
+	exp.EndTable(data)
+	ctx.Table.Cell = 0
+	ctx.Table.Count++
+	ctx.WantsSpace = false
+}
+
+func (r *renderer) verse(n *Verse) {
+	exp, ctx, mc := r.exp, r.exp.Context(), r.mc
+	var title string
+	if len(n.Title) > 0 {
+		title = r.capture(n.Title)
+	}
+	id := n.ID
+	if title != "" {
+		r.poems++
+		id = strconv.Itoa(r.poems)
+	}
+	if r.info() {
+		ctx.Verse.Used = true
+		if title != "" {
+			mc.AddLoXEntry("lop", &frundis.LoXinfo{
+				ID:        n.ID,
+				Title:     title,
+				Count:     r.poems,
+				RefPrefix: "poem"},
+				id)
+		} else if n.ID != "" {
+			mc.RegisterID(n.ID, frundis.UntitledList, "")
+		}
+		for _, stanza := range n.Children {
+			for _, l := range stanza.Nodes() {
+				r.inlines(l.Nodes())
+			}
+		}
+		return
+	}
+	mc.EndParagraph(frundis.ParBreakBlock)
+	exp.BeginVerse(title, id)
+	for _, stanza := range n.Children {
+		for i, l := range stanza.Nodes() {
+			if i == 0 {
+				mc.BeginPhrasing(true)
+			} else {
+				exp.EndVerseLine()
+			}
+			exp.BeginVerseLine()
+			r.inlines(l.Nodes())
+		}
+		mc.EndStanza()
+	}
+	exp.EndVerse()
+	ctx.WantsSpace = false
+}
+
+func (r *renderer) inlines(nodes []Node) {
+	for _, n := range nodes {
+		r.inline(n)
+	}
+}
+
+func (r *renderer) inline(n Node) {
+	exp, ctx, mc := r.exp, r.exp.Context(), r.mc
+	if r.info() {
+		switch n := n.(type) {
+		case *Markup:
+			if n.ID != "" {
+				mc.RegisterID(n.ID, frundis.SmID, "")
+			}
+			r.inlines(n.Children)
+		case *Image:
+			ctx.Images = append(ctx.Images, n.Image)
+			if n.ID != "" {
+				mc.RegisterID(n.ID, frundis.InlineImID, "")
+			}
+		}
+		return
+	}
+	switch n := n.(type) {
+	case *Text:
+		mc.BeginPhrasing(true)
+		fmt.Fprint(mc.W(), exp.RenderText([]ast.Inline{ast.Text(n.Text)}))
+	case *Markup:
+		mc.BeginPhrasing(true)
+		exp.BeginMarkupBlock(n.Tag, n.ID)
+		r.inlines(n.Children)
+		exp.EndMarkupBlock(n.Tag, n.ID, "")
+	case *Link:
+		mc.BeginPhrasing(true)
+		if len(n.Label) > 0 {
+			exp.LkWithLabel(n.URL, r.capture(n.Label), "")
+		} else {
+			exp.LkWithoutLabel(n.URL, "")
+		}
+	case *Reference:
+		mc.BeginPhrasing(true)
+		idinfo := frundis.IDInfo{ID: n.ID, Ref: n.Ref, Type: n.Type}
+		if info, ok := mc.LookupID(n.ID); ok {
+			// reference as generated by the exporter
+			idinfo = info
+		}
+		if len(n.Name) > 0 {
+			idinfo.Name = r.capture(n.Name)
+		} else if idinfo.Name == "" {
+			idinfo.Name = n.ID
+		}
+		exp.CrossReference(idinfo, "")
+	case *Image:
+		mc.BeginPhrasing(true)
+		exp.InlineImage(n.Image, n.Link, n.ID, "", n.Alt)
+	case *Dialogue:
+		mc.BeginPhrasing(true)
+		exp.BeginDialogue()
+	case *Note:
+		mc.BeginPhrasing(true)
+		if nr, ok := exp.(frundis.NoteRenderer); ok {
+			nr.Note(r.capture(n.Children), "")
+			break
+		}
+		fmt.Fprint(mc.W(), exp.RenderText([]ast.Inline{ast.Text("(")}))
+		r.inlines(n.Children)
+		fmt.Fprint(mc.W(), exp.RenderText([]ast.Inline{ast.Text(")")}))
+	case *Math:
+		mc.BeginPhrasing(true)
+		if mr, ok := exp.(frundis.MathRenderer); ok {
+			mr.Math(n.TeX, "")
+			break
+		}
+		fmt.Fprint(mc.W(), exp.RenderText([]ast.Inline{ast.Text(n.TeX)}))
+	case *Raw:
+		mc.BeginPhrasing(true)
+		r.raw(n)
+	}
+}
+
+// raw writes as-is text for its format, if it is the export format or its
+// family, or embeds it if the exporter is a frundis.RawRenderer accepting
+// it. It reports whether the text was written as-is.
+func (r *renderer) raw(n *Raw) bool {
+	ctx := r.exp.Context()
+	if n.Format == ctx.Format || n.Format != "" && n.Format == frundis.FormatFamily(ctx.Format) {
+		fmt.Fprint(r.mc.W(), n.Text)
+		return true
+	}
+	if rr, ok := r.exp.(frundis.RawRenderer); ok {
+		if format, ok := rr.RawFormat(n.Format); ok {
+			rr.RawText(format, n.Text)
+		}
+	}
+	return false
+}
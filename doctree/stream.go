@@ -0,0 +1,309 @@
+package doctree
+
+import (
+	"strconv"
+	"strings"
+
+	"codeberg.org/anaseto/gofrundis/frundis"
+	"codeberg.org/anaseto/gofrundis/internal/stream"
+)
+
+// The document stream is made of text and node markers, as described in
+// package stream. A node is opened by a marker whose kind is one of the
+// following, and closed by stream.Close.
+const (
+	nodeCaption   = "caption"
+	nodeCell      = "cell"
+	nodeDescList  = "dl"
+	nodeDescName  = "dt"
+	nodeDescValue = "dd"
+	nodeDialogue  = "dialogue"
+	nodeDisplay   = "display"
+	nodeEnumList  = "ol"
+	nodeFigure    = "figure"
+	nodeHeader    = "header"
+	nodeImage     = "image"
+	nodeItem      = "item"
+	nodeItemList  = "ul"
+	nodeLine      = "line"
+	nodeLink      = "link"
+	nodeMarkup    = "markup"
+	nodeMath      = "math"
+	nodeNote      = "note"
+	nodePara      = "para"
+	nodeParaTitle = "ptitle"
+	nodeRaw       = "raw"
+	nodeRef       = "ref"
+	nodeRow       = "row"
+	nodeStanza    = "stanza"
+	nodeTable     = "table"
+	nodeToc       = "toc"
+	nodeVerse     = "verse"
+)
+
+// open returns a marker opening a node of a given kind.
+func open(kind string, args ...string) string {
+	return stream.Marker(kind, args...)
+}
+
+// document converts a parsed document stream into a document tree, nesting
+// content into sections according to header levels.
+func document(root *stream.Node) *Document {
+	doc := &Document{}
+	var sections []*Section
+	add := func(n Node) {
+		if len(sections) > 0 {
+			s := sections[len(sections)-1]
+			s.Children = append(s.Children, n)
+		} else {
+			doc.Children = append(doc.Children, n)
+		}
+	}
+	for _, n := range blocks(root.Children) {
+		s, ok := n.(*Section)
+		if !ok {
+			add(n)
+			continue
+		}
+		for len(sections) > 0 && sections[len(sections)-1].Level >= s.Level {
+			sections = sections[:len(sections)-1]
+		}
+		add(s)
+		sections = append(sections, s)
+	}
+	return doc
+}
+
+func isBlock(n *stream.Node) bool {
+	switch n.Kind {
+	case nodePara, nodeHeader, nodeItemList, nodeEnumList, nodeDescList,
+		nodeDisplay, nodeTable, nodeVerse, nodeFigure, nodeToc:
+		return true
+	}
+	return false
+}
+
+// blocks converts nodes to block nodes. Inline nodes at block level are
+// gathered into paragraphs.
+func blocks(nodes []*stream.Node) []Node {
+	var bs []Node
+	var pending []*stream.Node
+	flush := func() {
+		if is := inlines(pending); len(is) > 0 {
+			bs = append(bs, &Paragraph{Children: is})
+		}
+		pending = nil
+	}
+	for _, n := range nodes {
+		switch {
+		case n.Kind == nodeRaw && len(inlines(pending)) == 0:
+			pending = nil
+			bs = append(bs, &Raw{Format: n.Arg(0), Text: n.Arg(1)})
+		case isBlock(n):
+			flush()
+			if b := block(n); b != nil {
+				bs = append(bs, b)
+			}
+		default:
+			pending = append(pending, n)
+		}
+	}
+	flush()
+	return bs
+}
+
+func block(n *stream.Node) Node {
+	switch n.Kind {
+	case nodePara:
+		p := &Paragraph{}
+		children := n.Children
+		if len(children) > 0 && children[0].Kind == nodeParaTitle {
+			p.Title = inlines(children[0].Children)
+			children = children[1:]
+		}
+		p.Children = inlines(children)
+		if len(p.Title) == 0 && len(p.Children) == 0 {
+			return nil
+		}
+		return p
+	case nodeHeader:
+		level, _ := strconv.Atoi(n.Arg(1))
+		return &Section{
+			Macro:    n.Arg(0),
+			Level:    level,
+			Numbered: n.Arg(2) == "",
+			Ref:      n.Arg(3),
+			ID:       n.Arg(4),
+			Title:    inlines(n.Children)}
+	case nodeItemList:
+		return &List{Kind: ItemList, ID: n.Arg(0), Children: items(n.Children)}
+	case nodeEnumList:
+		return &List{Kind: EnumList, ID: n.Arg(0), Children: items(n.Children)}
+	case nodeDescList:
+		return &List{Kind: DescList, ID: n.Arg(0), Children: definitions(n.Children)}
+	case nodeDisplay:
+		return &Display{Tag: n.Arg(0), ID: n.Arg(1), Children: blocks(n.Children)}
+	case nodeTable:
+		return table(n)
+	case nodeVerse:
+		v := &Verse{ID: n.Arg(0)}
+		for _, c := range n.Children {
+			switch c.Kind {
+			case nodeCaption:
+				v.Title = inlines(c.Children)
+			case nodeStanza:
+				v.Children = append(v.Children, &Stanza{Children: lines(c.Children)})
+			}
+		}
+		return v
+	case nodeFigure:
+		return &Figure{
+			Image:   n.Arg(0),
+			Link:    n.Arg(1),
+			Alt:     n.Arg(2),
+			ID:      n.Arg(3),
+			Caption: inlines(n.Children)}
+	case nodeToc:
+		toc := &TableOfContents{Kind: n.Arg(0), Title: n.Arg(1)}
+		for i := 2; i < len(n.Args); i++ {
+			switch n.Args[i] {
+			case "mini":
+				toc.Mini = true
+			case "nonum":
+				toc.Nonum = true
+			case "summary":
+				toc.Summary = true
+			}
+		}
+		return toc
+	}
+	return nil
+}
+
+func items(nodes []*stream.Node) []Node {
+	var its []Node
+	for _, n := range nodes {
+		if n.Kind == nodeItem {
+			its = append(its, &Item{Children: blocks(n.Children)})
+		}
+	}
+	return its
+}
+
+func definitions(nodes []*stream.Node) []Node {
+	var its []Node
+	var name []Node
+	for _, n := range nodes {
+		switch n.Kind {
+		case nodeDescName:
+			name = inlines(n.Children)
+		case nodeDescValue:
+			its = append(its, &Item{Name: name, Children: blocks(n.Children)})
+			name = nil
+		}
+	}
+	return its
+}
+
+func lines(nodes []*stream.Node) []Node {
+	var ls []Node
+	for _, n := range nodes {
+		if n.Kind == nodeLine {
+			ls = append(ls, &Line{Children: inlines(n.Children)})
+		}
+	}
+	return ls
+}
+
+func table(n *stream.Node) Node {
+	cols, _ := strconv.Atoi(n.Arg(1))
+	t := &Table{ID: n.Arg(0), Cols: cols}
+	for _, c := range n.Children {
+		switch c.Kind {
+		case nodeCaption:
+			t.Title = inlines(c.Children)
+		case nodeRow:
+			row := &Row{}
+			for _, cell := range c.Children {
+				if cell.Kind == nodeCell {
+					row.Children = append(row.Children, &Cell{Children: inlines(cell.Children)})
+				}
+			}
+			t.Children = append(t.Children, row)
+		}
+	}
+	return t
+}
+
+// inlines converts nodes to inline nodes, merging adjacent text and trimming
+// leading and trailing spaces.
+func inlines(nodes []*stream.Node) []Node {
+	var is []Node
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			is = append(is, &Text{Text: text.String()})
+			text.Reset()
+		}
+	}
+	for _, n := range nodes {
+		if n.Kind == "" {
+			text.WriteString(n.Text)
+			continue
+		}
+		if n.Kind == nodePara {
+			// paragraph in inline context (should not happen): keep content
+			for _, c := range n.Children {
+				if c.Kind == "" {
+					text.WriteString(c.Text)
+				}
+			}
+			continue
+		}
+		flush()
+		if i := inline(n); i != nil {
+			is = append(is, i)
+		}
+	}
+	flush()
+	if len(is) > 0 {
+		if t, ok := is[0].(*Text); ok {
+			t.Text = strings.TrimLeft(t.Text, " \t\n")
+			if t.Text == "" {
+				is = is[1:]
+			}
+		}
+	}
+	if len(is) > 0 {
+		if t, ok := is[len(is)-1].(*Text); ok {
+			t.Text = strings.TrimRight(t.Text, " \t\n")
+			if t.Text == "" {
+				is = is[:len(is)-1]
+			}
+		}
+	}
+	return is
+}
+
+func inline(n *stream.Node) Node {
+	switch n.Kind {
+	case nodeMarkup:
+		return &Markup{Tag: n.Arg(0), ID: n.Arg(1), Children: inlines(n.Children)}
+	case nodeLink:
+		return &Link{URL: n.Arg(0), Label: inlines(n.Children)}
+	case nodeRef:
+		typ, _ := strconv.Atoi(n.Arg(2))
+		return &Reference{Ref: n.Arg(0), ID: n.Arg(1), Type: frundis.IDType(typ), Name: inlines(n.Children)}
+	case nodeImage:
+		return &Image{Image: n.Arg(0), Link: n.Arg(1), ID: n.Arg(2), Alt: n.Arg(3)}
+	case nodeDialogue:
+		return &Dialogue{}
+	case nodeNote:
+		return &Note{Children: inlines(n.Children)}
+	case nodeMath:
+		return &Math{TeX: n.Arg(0)}
+	case nodeRaw:
+		return &Raw{Format: n.Arg(0), Text: n.Arg(1)}
+	}
+	return nil
+}
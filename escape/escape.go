@@ -64,3 +64,58 @@ var roffEscaper = strings.NewReplacer(roffEscapes...)
 func Roff(text string) string {
 	return roffEscaper.Replace(text)
 }
+
+var typstEscapes = []string{
+	"\\", "\\\\",
+	"*", "\\*",
+	"_", "\\_",
+	"`", "\\`",
+	"#", "\\#",
+	"$", "\\$",
+	"@", "\\@",
+	"<", "\\<",
+	">", "\\>",
+	"[", "\\[",
+	"]", "\\]",
+	"~", "\\~",
+	"=", "\\=",
+	"+", "\\+",
+	"//", "/\\/",
+	string('\xa0'), "~"}
+
+var typstEscaper = strings.NewReplacer(typstEscapes...)
+
+// Typst escapes special Typst markup characters, as well as slashes starting
+// a comment. A hyphen or a number followed by a period starting a line are
+// escaped too, so that they do not start a list item.
+func Typst(text string) string {
+	lines := strings.Split(typstEscaper.Replace(text), "\n")
+	for i, line := range lines {
+		lines[i] = typstEscapeLineStart(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// typstEscapeLineStart escapes a list marker at the start of a line.
+func typstEscapeLineStart(line string) string {
+	s := strings.TrimLeft(line, " \t")
+	indent := line[:len(line)-len(s)]
+	if strings.HasPrefix(s, "-") {
+		return indent + "\\" + s
+	}
+	digits := len(s) - len(strings.TrimLeft(s, "0123456789"))
+	if digits == 0 || !strings.HasPrefix(s[digits:], ".") {
+		return line
+	}
+	if rest := s[digits+1:]; rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return line
+	}
+	return indent + s[:digits] + "\\" + s[digits:]
+}
+
+var typstStringEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+
+// TypstString escapes text for use in a Typst string literal.
+func TypstString(text string) string {
+	return typstStringEscaper.Replace(text)
+}
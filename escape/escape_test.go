@@ -0,0 +1,25 @@
+package escape
+
+import "testing"
+
+func TestTypst(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"a/b and c / d", "a/b and c / d"},
+		{"see https://example.org", "see https:/\\/example.org"},
+		{"a // b /* c */", "a /\\/ b /\\* c \\*/"},
+		{"///", "/\\//"},
+		{"1. not a list", "1\\. not a list"},
+		{"text\n12. not a list\n  3.", "text\n12\\. not a list\n  3\\."},
+		{"1.5 or 2.b", "1.5 or 2.b"},
+		{"version 1. here", "version 1. here"},
+		{"- not a list\n-also", "\\- not a list\n\\-also"},
+		{"*a* + b = c", "\\*a\\* \\+ b \\= c"},
+	}
+	for _, test := range tests {
+		if got := Typst(test.text); got != test.want {
+			t.Errorf("Typst(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
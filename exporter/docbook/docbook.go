@@ -0,0 +1,524 @@
+package docbook
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"strconv"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// Options gathers configuration for DocBook exporter.
+type Options struct {
+	OutputFile string // name of output file
+}
+
+// NewExporter returns a frundis.Exporter suitable to produce DocBook 5.
+// See type Options for options.
+func NewExporter(opts *Options) frundis.Exporter {
+	return &exporter{OutputFile: opts.OutputFile}
+}
+
+func init() {
+	frundis.RegisterFormat("docbook", "", func(opts *frundis.FormatOptions) frundis.Exporter {
+		return NewExporter(&Options{OutputFile: opts.OutputFile})
+	})
+	frundis.SetFormatExtension("docbook", ".docbook.xml")
+}
+
+type exporter struct {
+	frundis.BaseRenderer
+	OutputFile    string
+	curOutputFile *os.File
+	book          bool       // whether producing a book instead of an article
+	divisions     []division // currently open divisions
+	formalpara    bool       // whether a titled paragraph is open
+	stanza        bool       // whether a stanza is open
+	table         bool       // whether inside a table
+	verse         bool       // whether inside a poem
+}
+
+// division represents an open sectioning element.
+type division struct {
+	elem  string // element name (e.g. "chapter")
+	level int    // header level
+}
+
+func (exp *exporter) Init() {
+	ctx := &frundis.Context{Wout: bufio.NewWriter(os.Stdout), Format: "docbook"}
+	exp.Ctx = ctx
+	ctx.Init()
+	ctx.Filters["escape"] = html.EscapeString
+}
+
+func (exp *exporter) Reset() error {
+	ctx := exp.Context()
+	ctx.Reset()
+	if exp.OutputFile != "" {
+		var err error
+		exp.curOutputFile, err = os.Create(exp.OutputFile)
+		if err != nil {
+			return fmt.Errorf("%v\n", err)
+		}
+	}
+	if exp.curOutputFile == nil {
+		exp.curOutputFile = os.Stdout
+	}
+	ctx.Wout = bufio.NewWriter(exp.curOutputFile)
+	exp.book = ctx.Toc.HasPart || ctx.Toc.HasChapter
+	exp.beginDocBookDocument()
+	return nil
+}
+
+func (exp *exporter) PostProcessing() {
+	ctx := exp.Context()
+	exp.closeDivisions(0)
+	exp.endDocBookDocument()
+	ctx.Wout.Flush()
+	if exp.curOutputFile != nil {
+		err := exp.curOutputFile.Close()
+		if err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// Abort implements frundis.Aborter.
+func (exp *exporter) Abort() {
+	frundis.AbortOutputFile(exp.curOutputFile)
+}
+
+// openDivision ensures that block content can be written, as books and parts
+// do not allow text directly: a preface or part introduction is opened as
+// necessary.
+func (exp *exporter) openDivision() {
+	if !exp.book {
+		return
+	}
+	w := exp.Context().Wout
+	switch {
+	case len(exp.divisions) == 0:
+		fmt.Fprint(w, "<preface>\n<title></title>\n")
+		exp.divisions = append(exp.divisions, division{elem: "preface", level: 1})
+	case exp.divisions[len(exp.divisions)-1].elem == "part":
+		level := exp.divisions[len(exp.divisions)-1].level + 1
+		fmt.Fprint(w, "<partintro>\n")
+		exp.divisions = append(exp.divisions, division{elem: "partintro", level: level})
+	}
+}
+
+// closeDivisions closes divisions with header level greater than level.
+func (exp *exporter) closeDivisions(level int) {
+	w := exp.Context().Wout
+	for len(exp.divisions) > 0 {
+		div := exp.divisions[len(exp.divisions)-1]
+		if div.level <= level {
+			break
+		}
+		fmt.Fprintf(w, "</%s>\n", div.elem)
+		exp.divisions = exp.divisions[:len(exp.divisions)-1]
+	}
+}
+
+func (exp *exporter) BeginDescList(id string) {
+	exp.openDivision()
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<variablelist%s>\n", xmlID(id))
+}
+
+func (exp *exporter) BeginDescValue() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "<listitem>\n")
+}
+
+func (exp *exporter) BeginDialogue() {
+	ctx := exp.Context()
+	dmark, ok := ctx.Params["dmark"]
+	if !ok {
+		dmark = "—"
+	} else {
+		dmark = html.EscapeString(dmark)
+	}
+	w := ctx.W()
+	fmt.Fprint(w, dmark)
+}
+
+func (exp *exporter) BeginDisplayBlock(tag string, id string) {
+	ctx := exp.Context()
+	exp.openDivision()
+	w := ctx.W()
+	dtag, ok := ctx.Dtags[tag]
+	if !ok {
+		if id != "" {
+			fmt.Fprintf(w, "<anchor%s/>\n", xmlID(id))
+		}
+		return
+	}
+	fmt.Fprintf(w, "<%s role=\"%s\"", dtag.Cmd, html.EscapeString(tag))
+	writePairs(w, dtag.Pairs)
+	fmt.Fprintf(w, "%s>\n", xmlID(id))
+}
+
+func (exp *exporter) BeginEnumList(id string) {
+	exp.openDivision()
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<orderedlist%s>\n", xmlID(id))
+}
+
+func (exp *exporter) BeginHeader(macro string, numbered bool, title string) {
+	ctx := exp.Context()
+	level := ctx.Toc.HeaderLevel(macro)
+	exp.closeDivisions(level - 1)
+	w := ctx.W()
+	elem := divisionName(macro)
+	toc := ctx.LoXstack["toc"]
+	entry := toc[ctx.Toc.HeaderCount-1] // headers count is updated before
+	fmt.Fprintf(w, "<%s%s", elem, xmlID(entry.Ref))
+	if !numbered {
+		fmt.Fprint(w, " label=\"\"")
+	}
+	fmt.Fprint(w, ">\n<title>")
+	exp.divisions = append(exp.divisions, division{elem: elem, level: level})
+}
+
+func (exp *exporter) BeginItem() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "<listitem>\n")
+}
+
+func (exp *exporter) BeginEnumItem() {
+	exp.BeginItem()
+}
+
+func (exp *exporter) BeginItemList(id string) {
+	exp.openDivision()
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<itemizedlist%s>\n", xmlID(id))
+}
+
+func (exp *exporter) BeginMarkupBlock(tag string, id string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	mtag, ok := ctx.Mtags[tag]
+	if !ok {
+		fmt.Fprintf(w, "<emphasis%s>", xmlID(id))
+		return
+	}
+	fmt.Fprintf(w, "<%s role=\"%s\"", mtag.Cmd, html.EscapeString(tag))
+	writePairs(w, mtag.Pairs)
+	fmt.Fprintf(w, "%s>", xmlID(id))
+	fmt.Fprint(w, mtag.Begin)
+}
+
+func (exp *exporter) BeginParagraph() {
+	ctx := exp.Context()
+	w := ctx.W()
+	switch {
+	case exp.table:
+	case exp.verse:
+		if !exp.stanza {
+			fmt.Fprint(w, "<linegroup>\n")
+			exp.stanza = true
+		}
+	default:
+		exp.openDivision()
+		fmt.Fprint(w, "<para>")
+	}
+}
+
+func (exp *exporter) BeginTable(tableinfo *frundis.TableData) {
+	ctx := exp.Context()
+	exp.openDivision()
+	w := ctx.W()
+	if tableinfo.Title != "" {
+		fmt.Fprintf(w, "<table xml:id=\"tbl%d\">\n", ctx.Table.TitCount)
+		fmt.Fprintf(w, "<caption>%s</caption>\n", tableinfo.Title)
+	} else {
+		fmt.Fprintf(w, "<informaltable%s>\n", xmlID(tableinfo.ID))
+	}
+	exp.table = true
+}
+
+func (exp *exporter) BeginTableCell() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "<td>")
+}
+
+func (exp *exporter) BeginTableRow() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "<tr>\n")
+}
+
+func (exp *exporter) BeginVerse(title string, id string) {
+	ctx := exp.Context()
+	exp.openDivision()
+	w := ctx.W()
+	if title != "" {
+		fmt.Fprintf(w, "<poetry xml:id=\"poem%s\">\n", id)
+		fmt.Fprintf(w, "<title>%s</title>\n", title)
+	} else {
+		fmt.Fprintf(w, "<poetry%s>\n", xmlID(id))
+	}
+	exp.verse = true
+}
+
+func (exp *exporter) BeginVerseLine() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "<line>")
+}
+
+func (exp *exporter) CrossReference(idf frundis.IDInfo, punct string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	switch idf.Type {
+	case frundis.NoID:
+		fmt.Fprintf(w, "%s%s", idf.Name, punct)
+		return
+	case frundis.HeaderID, frundis.FigureID, frundis.TableID:
+		for _, info := range ctx.IDs {
+			if info.Ref == idf.Ref && info.Name == idf.Name {
+				// generated text is enough
+				fmt.Fprintf(w, "<xref linkend=\"%s\"/>%s", idf.Ref, punct)
+				return
+			}
+		}
+	}
+	fmt.Fprintf(w, "<link linkend=\"%s\">%s</link>%s", idf.Ref, idf.Name, punct)
+}
+
+func (exp *exporter) DescName(name string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<varlistentry>\n<term>%s</term>\n", name)
+}
+
+func (exp *exporter) EndDescList() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</variablelist>\n")
+}
+
+func (exp *exporter) EndDescValue() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</listitem>\n</varlistentry>\n")
+}
+
+func (exp *exporter) EndDisplayBlock(tag string) {
+	ctx := exp.Context()
+	dtag, ok := ctx.Dtags[tag]
+	if !ok {
+		return
+	}
+	w := ctx.W()
+	fmt.Fprintf(w, "</%s>\n", dtag.Cmd)
+}
+
+func (exp *exporter) EndEnumList() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</orderedlist>\n")
+}
+
+func (exp *exporter) EndEnumItem() {
+	exp.EndItem()
+}
+
+func (exp *exporter) EndHeader(macro string, numbered bool, title string) {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</title>\n")
+}
+
+func (exp *exporter) EndItemList() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</itemizedlist>\n")
+}
+
+func (exp *exporter) EndItem() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</listitem>\n")
+}
+
+func (exp *exporter) EndMarkupBlock(tag string, id string, punct string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	mtag, ok := ctx.Mtags[tag]
+	if !ok {
+		fmt.Fprint(w, "</emphasis>")
+	} else {
+		fmt.Fprint(w, mtag.End)
+		fmt.Fprintf(w, "</%s>", mtag.Cmd)
+	}
+	fmt.Fprint(w, punct)
+}
+
+func (exp *exporter) EndParagraph(pbreak frundis.ParagraphBreak) {
+	w := exp.Context().W()
+	switch {
+	case pbreak == frundis.ParBreakForced:
+	case exp.table:
+	case exp.verse:
+		exp.EndStanza()
+	default:
+		fmt.Fprint(w, "</para>\n")
+		if exp.formalpara {
+			fmt.Fprint(w, "</formalpara>\n")
+			exp.formalpara = false
+		}
+	}
+}
+
+func (exp *exporter) EndStanza() {
+	w := exp.Context().W()
+	if !exp.stanza {
+		return
+	}
+	fmt.Fprint(w, "</line>\n</linegroup>\n")
+	exp.stanza = false
+}
+
+func (exp *exporter) EndTable(tableinfo *frundis.TableData) {
+	w := exp.Context().W()
+	if tableinfo.Title != "" {
+		fmt.Fprint(w, "</table>\n")
+	} else {
+		fmt.Fprint(w, "</informaltable>\n")
+	}
+	exp.table = false
+}
+
+func (exp *exporter) EndTableCell() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</td>\n")
+}
+
+func (exp *exporter) EndTableRow() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</tr>\n")
+}
+
+func (exp *exporter) EndVerse() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</poetry>\n")
+	exp.verse = false
+}
+
+func (exp *exporter) EndVerseLine() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</line>\n")
+}
+
+func (exp *exporter) FigureImage(image string, caption string, link string, alt string) {
+	ctx := exp.Context()
+	exp.openDivision()
+	w := ctx.W()
+	if alt != "" {
+		alt = html.EscapeString(alt)
+	} else {
+		// caption is already escaped
+		alt = caption
+	}
+	if caption != "" {
+		fmt.Fprintf(w, "<figure xml:id=\"fig%d\">\n", ctx.FigCount)
+		fmt.Fprintf(w, "<title>%s</title>\n", caption)
+	}
+	fmt.Fprint(w, "<mediaobject>\n")
+	fmt.Fprintf(w, "<imageobject><imagedata fileref=\"%s\"/></imageobject>\n", exp.fileRef(image))
+	if alt != "" {
+		fmt.Fprintf(w, "<textobject><phrase>%s</phrase></textobject>\n", alt)
+	}
+	fmt.Fprint(w, "</mediaobject>\n")
+	if caption != "" {
+		fmt.Fprint(w, "</figure>\n")
+	}
+}
+
+func (exp *exporter) GenRef(prefix string, id string, hasfile bool) string {
+	return prefix + id
+}
+
+func (exp *exporter) HeaderReference(macro string) string {
+	return exp.GenRef("s", strconv.Itoa(exp.Context().Toc.HeaderCount), false)
+}
+
+func (exp *exporter) InlineImage(image string, link string, id string, punct string, alt string) {
+	w := exp.Context().W()
+	if link != "" {
+		fmt.Fprintf(w, "<link xlink:href=\"%s\">", exp.fileRef(link))
+	}
+	fmt.Fprintf(w, "<inlinemediaobject%s>", xmlID(id))
+	fmt.Fprintf(w, "<imageobject><imagedata fileref=\"%s\"/></imageobject>", exp.fileRef(image))
+	if alt != "" {
+		fmt.Fprintf(w, "<textobject><phrase>%s</phrase></textobject>", html.EscapeString(alt))
+	}
+	fmt.Fprint(w, "</inlinemediaobject>")
+	if link != "" {
+		fmt.Fprint(w, "</link>")
+	}
+	fmt.Fprint(w, punct)
+}
+
+func (exp *exporter) LkWithLabel(uri string, label string, punct string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<link xlink:href=\"%s\">%s</link>%s", exp.fileRef(uri), label, punct)
+}
+
+func (exp *exporter) LkWithoutLabel(uri string, punct string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<link xlink:href=\"%s\"/>%s", exp.fileRef(uri), punct)
+}
+
+func (exp *exporter) Note(text string, punct string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<footnote><para>%s</para></footnote>%s", text, punct)
+}
+
+func (exp *exporter) ParagraphTitle(title string) {
+	exp.openDivision()
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<formalpara>\n<title>%s</title>\n<para>", title)
+	exp.formalpara = true
+}
+
+func (exp *exporter) RenderText(text []ast.Inline) string {
+	ctx := exp.Context()
+	switch ctx.Params["lang"] {
+	case "fr":
+		text = frundis.FrenchTypography(exp, text)
+	case "en":
+		text = frundis.EnglishTypography(exp, text)
+	}
+	return html.EscapeString(ctx.InlinesToText(text))
+}
+
+func (exp *exporter) TableOfContents(opts map[string][]ast.Inline, flags map[string]bool) {
+	// DocBook processors generate tables of contents and lists of
+	// figures and tables themselves.
+}
+
+func (exp *exporter) Xdtag(cmd string, pairs []string) frundis.Dtag {
+	if cmd == "" {
+		cmd = "blockquote"
+	}
+	return frundis.Dtag{Cmd: cmd, Pairs: pairs}
+}
+
+func (exp *exporter) Xmtag(cmd *string, begin string, end string, pairs []string) frundis.Mtag {
+	var c string
+	if cmd == nil || *cmd == "" {
+		c = "emphasis"
+	} else {
+		c = *cmd
+	}
+	return frundis.Mtag{Begin: begin, End: end, Cmd: c, Pairs: pairs}
+}
+
+// fileRef returns an escaped URI reference.
+func (exp *exporter) fileRef(uri string) string {
+	parsedURL, err := url.Parse(uri)
+	if err != nil {
+		exp.Context().Error("invalid url or path:", uri)
+		return ""
+	}
+	return html.EscapeString(parsedURL.String())
+}
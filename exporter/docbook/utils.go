@@ -0,0 +1,68 @@
+package docbook
+
+import (
+	"fmt"
+	"html"
+	"io"
+)
+
+func divisionName(macro string) string {
+	var elem string
+	switch macro {
+	case "Pt":
+		elem = "part"
+	case "Ch":
+		elem = "chapter"
+	default:
+		elem = "section"
+	}
+	return elem
+}
+
+// xmlID returns an xml:id attribute for id, or an empty string if there is
+// no id.
+func xmlID(id string) string {
+	if id == "" {
+		return ""
+	}
+	return " xml:id=\"" + id + "\""
+}
+
+// writePairs writes "-a" key/value pairs as attributes.
+func writePairs(w io.Writer, pairs []string) {
+	for i := 0; i < len(pairs)-1; i += 2 {
+		fmt.Fprintf(w, " %s=\"%s\"", html.EscapeString(pairs[i]), html.EscapeString(pairs[i+1]))
+	}
+}
+
+func (exp *exporter) beginDocBookDocument() {
+	ctx := exp.Context()
+	w := ctx.Wout
+	elem := "article"
+	if exp.book {
+		elem = "book"
+	}
+	fmt.Fprint(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	fmt.Fprintf(w, "<%s xmlns=\"http://docbook.org/ns/docbook\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" version=\"5.2\"", elem)
+	if lang := ctx.Params["lang"]; lang != "" {
+		fmt.Fprintf(w, " xml:lang=\"%s\"", html.EscapeString(lang))
+	}
+	fmt.Fprint(w, ">\n<info>\n")
+	fmt.Fprintf(w, "<title>%s</title>\n", ctx.Params["document-title"])
+	if author := ctx.Params["document-author"]; author != "" {
+		fmt.Fprintf(w, "<author><personname>%s</personname></author>\n", author)
+	}
+	if date := ctx.Params["document-date"]; date != "" {
+		fmt.Fprintf(w, "<date>%s</date>\n", date)
+	}
+	fmt.Fprint(w, "</info>\n")
+}
+
+func (exp *exporter) endDocBookDocument() {
+	w := exp.Context().Wout
+	if exp.book {
+		fmt.Fprint(w, "</book>\n")
+	} else {
+		fmt.Fprint(w, "</article>\n")
+	}
+}
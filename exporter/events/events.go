@@ -0,0 +1,355 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/frundis"
+	"codeberg.org/anaseto/gofrundis/internal/stream"
+)
+
+// Options gathers configuration for event stream exporter.
+type Options struct {
+	OutputFile string // name of output file
+}
+
+// NewExporter returns a frundis.Exporter that produces a stream of rendering
+// events in JSON lines format, for use by renderers written in other
+// languages. See type Options for options.
+func NewExporter(opts *Options) frundis.Exporter {
+	return &exporter{OutputFile: opts.OutputFile}
+}
+
+func init() {
+	frundis.RegisterFormat("events", "", func(opts *frundis.FormatOptions) frundis.Exporter {
+		return NewExporter(&Options{OutputFile: opts.OutputFile})
+	})
+	frundis.SetFormatExtension("events", ".events.jsonl")
+}
+
+// The exporter writes a stream of text and event markers (see stream.go),
+// which is converted into JSON lines at post-processing. Events are not
+// reset between passes, because rendered names and titles collected during
+// the info pass may refer to them.
+type exporter struct {
+	frundis.BaseRenderer
+	OutputFile string
+	events     []*event     // recorded events
+	info       *infoRecord  // information collected during info pass
+	stream     bytes.Buffer // marked up document stream
+}
+
+func (exp *exporter) Init() {
+	exp.events = nil
+	exp.info = nil
+	exp.stream.Reset()
+	ctx := &frundis.Context{Wout: bufio.NewWriter(&exp.stream), Format: "events"}
+	exp.Ctx = ctx
+	ctx.Init()
+	ctx.Filters["escape"] = stream.Sanitize
+}
+
+func (exp *exporter) Reset() error {
+	ctx := exp.Context()
+	exp.info = exp.infoRecord()
+	ctx.Reset()
+	exp.stream.Reset()
+	ctx.Wout = bufio.NewWriter(&exp.stream)
+	return nil
+}
+
+func (exp *exporter) PostProcessing() {
+	ctx := exp.Context()
+	ctx.Wout.Flush()
+	out := os.Stdout
+	if exp.OutputFile != "" {
+		var err error
+		out, err = os.Create(exp.OutputFile)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+	}
+	err := exp.writeEvents(out)
+	if err != nil {
+		ctx.Error(err)
+	}
+	if exp.OutputFile != "" {
+		err = out.Close()
+		if err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// Abort implements frundis.Aborter. Nothing is done, as output is written
+// only by PostProcessing.
+func (exp *exporter) Abort() {}
+
+// emit records an event of a given name with arguments given as a list of
+// key/value pairs, and writes its marker to the current output.
+func (exp *exporter) emit(name string, kvs ...interface{}) {
+	ctx := exp.Context()
+	file, line := ctx.SourceLocation()
+	ev := &event{Event: name, File: file, Line: line}
+	if len(kvs) > 0 {
+		ev.Args = make(map[string]interface{}, len(kvs)/2)
+		for i := 0; i < len(kvs)-1; i += 2 {
+			ev.Args[kvs[i].(string)] = kvs[i+1]
+		}
+	}
+	exp.events = append(exp.events, ev)
+	fmt.Fprint(ctx.W(), marker(len(exp.events)-1))
+}
+
+func (exp *exporter) BeginDescList(id string) {
+	exp.emit("BeginDescList", "id", id)
+}
+
+func (exp *exporter) BeginDescValue() {
+	exp.emit("BeginDescValue")
+}
+
+func (exp *exporter) BeginDialogue() {
+	exp.emit("BeginDialogue")
+}
+
+func (exp *exporter) BeginDisplayBlock(tag string, id string) {
+	exp.emit("BeginDisplayBlock", "tag", tag, "id", id)
+}
+
+func (exp *exporter) BeginEnumItem() {
+	exp.emit("BeginEnumItem")
+}
+
+func (exp *exporter) BeginEnumList(id string) {
+	exp.emit("BeginEnumList", "id", id)
+}
+
+func (exp *exporter) BeginHeader(macro string, numbered bool, title string) {
+	ctx := exp.Context()
+	exp.emit("BeginHeader", "macro", macro, "numbered", numbered, "title", title,
+		"level", ctx.Toc.HeaderLevel(macro), "id", ctx.IDX)
+}
+
+func (exp *exporter) BeginItem() {
+	exp.emit("BeginItem")
+}
+
+func (exp *exporter) BeginItemList(id string) {
+	exp.emit("BeginItemList", "id", id)
+}
+
+func (exp *exporter) BeginMarkupBlock(tag string, id string) {
+	exp.emit("BeginMarkupBlock", "tag", tag, "id", id)
+}
+
+func (exp *exporter) BeginParagraph() {
+	exp.emit("BeginParagraph")
+}
+
+func (exp *exporter) BeginPhrasingMacroInParagraph(nospace bool) {
+	frundis.BeginPhrasingMacroInParagraph(exp, nospace)
+}
+
+func (exp *exporter) BeginTable(tableinfo *frundis.TableData) {
+	exp.emit("BeginTable", "title", tableinfo.Title, "cols", tableinfo.Cols, "id", tableinfo.ID)
+}
+
+func (exp *exporter) BeginTableCell() {
+	exp.emit("BeginTableCell")
+}
+
+func (exp *exporter) BeginTableRow() {
+	exp.emit("BeginTableRow")
+}
+
+func (exp *exporter) BeginVerse(title string, id string) {
+	exp.emit("BeginVerse", "title", title, "id", id)
+}
+
+func (exp *exporter) BeginVerseLine() {
+	exp.emit("BeginVerseLine")
+}
+
+func (exp *exporter) CheckParamAssignement(param string, value string) bool {
+	return true
+}
+
+func (exp *exporter) CrossReference(idf frundis.IDInfo, punct string) {
+	exp.emit("CrossReference", "ref", idf.Ref, "name", idf.Name, "type", idTypeName(idf.Type), "punct", punct)
+}
+
+func (exp *exporter) DescName(name string) {
+	exp.emit("DescName", "name", name)
+}
+
+func (exp *exporter) EndDescList() {
+	exp.emit("EndDescList")
+}
+
+func (exp *exporter) EndDescValue() {
+	exp.emit("EndDescValue")
+}
+
+func (exp *exporter) EndDisplayBlock(tag string) {
+	exp.emit("EndDisplayBlock", "tag", tag)
+}
+
+func (exp *exporter) EndEnumItem() {
+	exp.emit("EndEnumItem")
+}
+
+func (exp *exporter) EndEnumList() {
+	exp.emit("EndEnumList")
+}
+
+func (exp *exporter) EndHeader(macro string, numbered bool, title string) {
+	exp.emit("EndHeader", "macro", macro, "numbered", numbered, "title", title)
+}
+
+func (exp *exporter) EndItem() {
+	exp.emit("EndItem")
+}
+
+func (exp *exporter) EndItemList() {
+	exp.emit("EndItemList")
+}
+
+func (exp *exporter) EndMarkupBlock(tag string, id string, punct string) {
+	exp.emit("EndMarkupBlock", "tag", tag, "id", id, "punct", punct)
+}
+
+func (exp *exporter) EndParagraph(pbreak frundis.ParagraphBreak) {
+	exp.emit("EndParagraph", "break", parBreakName(pbreak))
+}
+
+func (exp *exporter) EndStanza() {
+	exp.emit("EndStanza")
+}
+
+func (exp *exporter) EndTable(tableinfo *frundis.TableData) {
+	exp.emit("EndTable", "title", tableinfo.Title, "cols", tableinfo.Cols, "id", tableinfo.ID)
+}
+
+func (exp *exporter) EndTableCell() {
+	exp.emit("EndTableCell")
+}
+
+func (exp *exporter) EndTableRow() {
+	exp.emit("EndTableRow")
+}
+
+func (exp *exporter) EndVerse() {
+	exp.emit("EndVerse")
+}
+
+func (exp *exporter) EndVerseLine() {
+	exp.emit("EndVerseLine")
+}
+
+func (exp *exporter) FormatParagraph(text []byte) []byte {
+	return text
+}
+
+func (exp *exporter) FigureImage(image string, caption string, link string, alt string) {
+	exp.emit("FigureImage", "image", image, "caption", caption, "link", link, "alt", alt,
+		"count", exp.Context().FigCount)
+}
+
+func (exp *exporter) GenRef(prefix string, id string, hasfile bool) string {
+	if prefix != "" {
+		return fmt.Sprintf("%s:%s", prefix, id)
+	}
+	return id
+}
+
+func (exp *exporter) HeaderReference(macro string) string {
+	ctx := exp.Context()
+	if ctx.IDX != "" {
+		return exp.GenRef("", ctx.IDX, false)
+	}
+	return exp.GenRef("s", strconv.Itoa(ctx.Toc.HeaderCount), false)
+}
+
+func (exp *exporter) InlineImage(image string, link string, id string, punct string, alt string) {
+	exp.emit("InlineImage", "image", image, "link", link, "id", id, "punct", punct, "alt", alt)
+}
+
+func (exp *exporter) LkWithLabel(url string, label string, punct string) {
+	exp.emit("LkWithLabel", "url", url, "label", label, "punct", punct)
+}
+
+func (exp *exporter) LkWithoutLabel(url string, punct string) {
+	exp.emit("LkWithoutLabel", "url", url, "punct", punct)
+}
+
+func (exp *exporter) ParagraphTitle(title string) {
+	exp.emit("ParagraphTitle", "title", title)
+}
+
+// RawFormat keeps as-is text for any format, so that consumers can decide
+// what to do with it.
+func (exp *exporter) RawFormat(format string) (string, bool) {
+	return format, true
+}
+
+func (exp *exporter) RawText(format string, text string) {
+	exp.emit("RawText", "format", format, "text", text)
+}
+
+// LocateText implements frundis.TextLocator, recording the location of the
+// following text.
+func (exp *exporter) LocateText() {
+	exp.emit(locationEvent)
+}
+
+func (exp *exporter) RenderText(text []ast.Inline) string {
+	return stream.Sanitize(exp.Context().InlinesToText(text))
+}
+
+func (exp *exporter) TableOfContents(opts map[string][]ast.Inline, flags map[string]bool) {
+	ctx := exp.Context()
+	options := make(map[string]string, len(opts))
+	for k, v := range opts {
+		options[k] = ctx.InlinesToText(v)
+	}
+	fs := []string{}
+	for k, v := range flags {
+		if v {
+			fs = append(fs, k)
+		}
+	}
+	sort.Strings(fs)
+	exp.emit("TableOfContents", "options", options, "flags", fs)
+}
+
+func (exp *exporter) Xdtag(cmd string, pairs []string) frundis.Dtag {
+	return frundis.Dtag{Cmd: cmd, Pairs: pairs}
+}
+
+func (exp *exporter) Xmtag(cmd *string, begin string, end string, pairs []string) frundis.Mtag {
+	var c string
+	if cmd != nil {
+		c = *cmd
+	}
+	return frundis.Mtag{Begin: begin, End: end, Cmd: c, Pairs: pairs}
+}
+
+// parBreakName returns the name of a paragraph break kind.
+func parBreakName(pbreak frundis.ParagraphBreak) string {
+	switch pbreak {
+	case frundis.ParBreakBlock:
+		return "block"
+	case frundis.ParBreakItem:
+		return "item"
+	case frundis.ParBreakForced:
+		return "forced"
+	}
+	return "normal"
+}
@@ -0,0 +1,263 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"codeberg.org/anaseto/gofrundis/frundis"
+	"codeberg.org/anaseto/gofrundis/internal/stream"
+)
+
+// The document stream is made of text and event markers, as described in
+// package stream. The kind of an event marker is the index of a recorded
+// event. Rendered arguments of events, such as titles, may contain event
+// markers too.
+
+// marker returns the marker of the i-th event.
+func marker(i int) string {
+	return stream.Marker(strconv.Itoa(i))
+}
+
+// event represents a Renderer callback invocation, or text in between. Text
+// events starting with the text of a text block have its source location.
+type event struct {
+	Event  string                 `json:"event"`
+	File   string                 `json:"file,omitempty"`
+	Line   int                    `json:"line,omitempty"`
+	Text   string                 `json:"text,omitempty"`
+	Args   map[string]interface{} `json:"args,omitempty"`
+	Inline map[string][]*event    `json:"inline,omitempty"`
+}
+
+// locationEvent is the name of recorded events giving the source location of
+// the text that follows them. They are not part of the output.
+const locationEvent = "Location"
+
+// infoRecord is the first record of the output, with information collected
+// during the info pass.
+type infoRecord struct {
+	Event    string                `json:"event"`
+	Format   string                `json:"format"`
+	Params   map[string]string     `json:"params"`
+	Mtags    map[string]mtag       `json:"mtags"`
+	Dtags    map[string]dtag       `json:"dtags"`
+	IDs      map[string]idInfo     `json:"ids"`
+	LoXstack map[string][]*loXinfo `json:"loxstack"`
+}
+
+type mtag struct {
+	Begin string   `json:"begin"`
+	Cmd   string   `json:"cmd"`
+	End   string   `json:"end"`
+	Pairs []string `json:"pairs"`
+}
+
+type dtag struct {
+	Cmd   string   `json:"cmd"`
+	Pairs []string `json:"pairs"`
+}
+
+type idInfo struct {
+	Ref  string `json:"ref"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type loXinfo struct {
+	Count     int    `json:"count"`
+	Macro     string `json:"macro"`
+	Nonum     bool   `json:"nonum"`
+	Num       string `json:"num"`
+	Ref       string `json:"ref"`
+	RefPrefix string `json:"refPrefix"`
+	Title     string `json:"title"`
+	ID        string `json:"id"`
+}
+
+// infoRecord returns information collected during the info pass. Rendered
+// names and titles are given as plain text.
+func (exp *exporter) infoRecord() *infoRecord {
+	ctx := exp.Context()
+	info := &infoRecord{
+		Event:    "Info",
+		Format:   ctx.Format,
+		Params:   make(map[string]string, len(ctx.Params)),
+		Mtags:    make(map[string]mtag, len(ctx.Mtags)),
+		Dtags:    make(map[string]dtag, len(ctx.Dtags)),
+		IDs:      make(map[string]idInfo, len(ctx.IDs)),
+		LoXstack: make(map[string][]*loXinfo, len(ctx.LoXstack))}
+	for k, v := range ctx.Params {
+		info.Params[k] = exp.plainText(v)
+	}
+	for k, v := range ctx.Mtags {
+		info.Mtags[k] = mtag{Begin: v.Begin, Cmd: v.Cmd, End: v.End, Pairs: nonNil(v.Pairs)}
+	}
+	for k, v := range ctx.Dtags {
+		info.Dtags[k] = dtag{Cmd: v.Cmd, Pairs: nonNil(v.Pairs)}
+	}
+	for k, v := range ctx.IDs {
+		info.IDs[k] = idInfo{Ref: v.Ref, Name: exp.plainText(v.Name), Type: idTypeName(v.Type)}
+	}
+	for k, entries := range ctx.LoXstack {
+		l := []*loXinfo{}
+		for _, e := range entries {
+			l = append(l, &loXinfo{
+				Count:     e.Count,
+				Macro:     e.Macro,
+				Nonum:     e.Nonum,
+				Num:       e.Num,
+				Ref:       e.Ref,
+				RefPrefix: e.RefPrefix,
+				Title:     exp.plainText(e.Title),
+				ID:        e.ID})
+		}
+		info.LoXstack[k] = l
+	}
+	return info
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// writeEvents writes the info record followed by the events of the document
+// stream, one JSON object per line.
+func (exp *exporter) writeEvents(out io.Writer) error {
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	info := exp.info
+	if info == nil {
+		info = exp.infoRecord()
+	}
+	err := enc.Encode(info)
+	if err != nil {
+		return err
+	}
+	for _, ev := range exp.expand(exp.stream.String()) {
+		err := enc.Encode(ev)
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// expand returns the list of events corresponding to a stream, with Text
+// events for text between markers.
+func (exp *exporter) expand(s string) []*event {
+	evs := []*event{}
+	var loc *event // location of the following text, if any
+	for _, n := range stream.Split(s) {
+		if n.Kind == "" {
+			ev := &event{Event: "Text", Text: n.Text}
+			if loc != nil {
+				ev.File, ev.Line = loc.File, loc.Line
+			}
+			evs = append(evs, ev)
+			loc = nil
+			continue
+		}
+		idx, err := strconv.Atoi(n.Kind)
+		if err != nil || idx >= len(exp.events) {
+			// should not happen
+			continue
+		}
+		ev := exp.events[idx]
+		if ev.Event == locationEvent {
+			loc = ev
+			continue
+		}
+		loc = nil
+		evs = append(evs, exp.resolve(ev))
+	}
+	return evs
+}
+
+// resolve returns a copy of a recorded event, whose string arguments
+// containing event markers are replaced by their plain text, with the
+// corresponding events in the Inline field.
+func (exp *exporter) resolve(ev *event) *event {
+	rev := &event{Event: ev.Event, File: ev.File, Line: ev.Line}
+	if ev.Args == nil {
+		return rev
+	}
+	rev.Args = make(map[string]interface{}, len(ev.Args))
+	for k, v := range ev.Args {
+		s, ok := v.(string)
+		if !ok || !strings.Contains(s, stream.Open) {
+			rev.Args[k] = v
+			continue
+		}
+		rev.Args[k] = exp.plainText(s)
+		if rev.Inline == nil {
+			rev.Inline = make(map[string][]*event)
+		}
+		rev.Inline[k] = exp.expand(s)
+	}
+	return rev
+}
+
+// plainText returns the text of a stream, without events, except for the
+// text found in their arguments, as with link labels.
+func (exp *exporter) plainText(s string) string {
+	if !strings.Contains(s, stream.Open) {
+		return s
+	}
+	var sb strings.Builder
+	for _, ev := range exp.expand(s) {
+		switch ev.Event {
+		case "Text":
+			sb.WriteString(ev.Text)
+		case "CrossReference":
+			sb.WriteString(argString(ev, "name"))
+			sb.WriteString(argString(ev, "punct"))
+		case "LkWithLabel":
+			sb.WriteString(argString(ev, "label"))
+			sb.WriteString(argString(ev, "punct"))
+		case "LkWithoutLabel":
+			sb.WriteString(argString(ev, "url"))
+			sb.WriteString(argString(ev, "punct"))
+		case "InlineImage":
+			sb.WriteString(argString(ev, "alt"))
+			sb.WriteString(argString(ev, "punct"))
+		case "EndMarkupBlock":
+			sb.WriteString(argString(ev, "punct"))
+		}
+	}
+	return sb.String()
+}
+
+func argString(ev *event, key string) string {
+	s, _ := ev.Args[key].(string)
+	return s
+}
+
+// idTypeName returns the name of an identifier type.
+func idTypeName(t frundis.IDType) string {
+	switch t {
+	case frundis.SmID:
+		return "markup"
+	case frundis.BdID:
+		return "display"
+	case frundis.InlineImID:
+		return "image"
+	case frundis.FigureID:
+		return "figure"
+	case frundis.HeaderID:
+		return "header"
+	case frundis.PoemID:
+		return "poem"
+	case frundis.TableID:
+		return "table"
+	case frundis.UntitledList:
+		return "list"
+	}
+	return ""
+}
@@ -0,0 +1,470 @@
+package fb2
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// Options gathers configuration for FictionBook 2 exporter.
+type Options struct {
+	OutputFile string // name of output file
+}
+
+// NewExporter returns a frundis.Exporter suitable to produce FictionBook 2.
+// See type Options for options.
+func NewExporter(opts *Options) frundis.Exporter {
+	return &exporter{OutputFile: opts.OutputFile}
+}
+
+func init() {
+	frundis.RegisterFormat("fb2", "", func(opts *frundis.FormatOptions) frundis.Exporter {
+		return NewExporter(&Options{OutputFile: opts.OutputFile})
+	})
+	frundis.SetFormatExtension("fb2", ".fb2")
+}
+
+type exporter struct {
+	frundis.BaseRenderer
+	OutputFile    string
+	curOutputFile *os.File
+	depth         int    // current section nesting
+	itemPrefix    string // prefix for next paragraph in a list item
+	enumCount     []int  // enumeration counters for nested lists
+	stanza        bool   // whether a stanza is open
+	table         bool   // whether inside a table
+	verse         bool   // whether inside a poem
+}
+
+func (exp *exporter) Init() {
+	ctx := &frundis.Context{Wout: bufio.NewWriter(os.Stdout), Format: "fb2"}
+	exp.Ctx = ctx
+	ctx.Init()
+	ctx.Filters["escape"] = html.EscapeString
+}
+
+func (exp *exporter) Reset() error {
+	ctx := exp.Context()
+	ctx.Reset()
+	if exp.OutputFile != "" {
+		var err error
+		exp.curOutputFile, err = os.Create(exp.OutputFile)
+		if err != nil {
+			return fmt.Errorf("%v\n", err)
+		}
+	}
+	if exp.curOutputFile == nil {
+		exp.curOutputFile = os.Stdout
+	}
+	ctx.Wout = bufio.NewWriter(exp.curOutputFile)
+	exp.beginFB2Document()
+	return nil
+}
+
+func (exp *exporter) PostProcessing() {
+	ctx := exp.Context()
+	exp.closeSections(0)
+	exp.endFB2Document()
+	ctx.Wout.Flush()
+	if exp.curOutputFile != nil {
+		err := exp.curOutputFile.Close()
+		if err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// Abort implements frundis.Aborter.
+func (exp *exporter) Abort() {
+	frundis.AbortOutputFile(exp.curOutputFile)
+}
+
+// openSection ensures that there is an open section, as FictionBook does not
+// allow text directly in the body.
+func (exp *exporter) openSection() {
+	if exp.depth > 0 {
+		return
+	}
+	w := exp.Context().Wout
+	fmt.Fprint(w, "<section>\n")
+	exp.depth = 1
+}
+
+// closeSections closes sections until nesting is level.
+func (exp *exporter) closeSections(level int) {
+	w := exp.Context().Wout
+	for exp.depth > level {
+		fmt.Fprint(w, "</section>\n")
+		exp.depth--
+	}
+}
+
+func (exp *exporter) BeginDescList(id string) {
+	exp.openSection()
+}
+
+func (exp *exporter) BeginDialogue() {
+	ctx := exp.Context()
+	dmark, ok := ctx.Params["dmark"]
+	if !ok {
+		dmark = "—"
+	} else {
+		dmark = html.EscapeString(dmark)
+	}
+	w := ctx.W()
+	fmt.Fprint(w, dmark)
+}
+
+func (exp *exporter) BeginDisplayBlock(tag string, id string) {
+	ctx := exp.Context()
+	exp.openSection()
+	cmd := ctx.Dtags[tag].Cmd
+	if cmd == "" {
+		return
+	}
+	w := ctx.W()
+	if id != "" {
+		id = " id=\"" + id + "\""
+	}
+	fmt.Fprintf(w, "<%s%s>\n", cmd, id)
+}
+
+func (exp *exporter) BeginEnumList(id string) {
+	exp.openSection()
+	exp.enumCount = append(exp.enumCount, 0)
+}
+
+func (exp *exporter) BeginHeader(macro string, numbered bool, title string) {
+	ctx := exp.Context()
+	level := ctx.Toc.HeaderLevel(macro)
+	exp.closeSections(level - 1)
+	w := ctx.W()
+	for exp.depth < level-1 {
+		fmt.Fprint(w, "<section>\n")
+		exp.depth++
+	}
+	toc := ctx.LoXstack["toc"]
+	entry := toc[ctx.Toc.HeaderCount-1] // headers count is updated before
+	fmt.Fprintf(w, "<section id=\"%s\">\n", exp.sectionID(entry))
+	exp.depth++
+	fmt.Fprint(w, "<title><p>")
+	if numbered {
+		fmt.Fprintf(w, "%s. ", entry.Num)
+	}
+}
+
+func (exp *exporter) BeginItem() {
+	exp.itemPrefix = "• "
+}
+
+func (exp *exporter) BeginEnumItem() {
+	if len(exp.enumCount) == 0 {
+		return
+	}
+	exp.enumCount[len(exp.enumCount)-1]++
+	exp.itemPrefix = strconv.Itoa(exp.enumCount[len(exp.enumCount)-1]) + ". "
+}
+
+func (exp *exporter) BeginItemList(id string) {
+	exp.openSection()
+}
+
+func (exp *exporter) BeginMarkupBlock(tag string, id string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	mtag, ok := ctx.Mtags[tag]
+	switch {
+	case !ok:
+		fmt.Fprint(w, "<emphasis>")
+	case mtag.Cmd == "style":
+		fmt.Fprintf(w, "<style name=\"%s\">", html.EscapeString(tag))
+	default:
+		fmt.Fprintf(w, "<%s>", mtag.Cmd)
+	}
+	if ok {
+		fmt.Fprint(w, mtag.Begin)
+	}
+}
+
+func (exp *exporter) BeginParagraph() {
+	ctx := exp.Context()
+	w := ctx.W()
+	switch {
+	case exp.table:
+	case exp.verse:
+		if !exp.stanza {
+			fmt.Fprint(w, "<stanza>\n")
+			exp.stanza = true
+		}
+	default:
+		exp.openSection()
+		fmt.Fprint(w, "<p>")
+		if exp.itemPrefix != "" {
+			fmt.Fprint(w, exp.itemPrefix)
+			exp.itemPrefix = ""
+		}
+	}
+}
+
+func (exp *exporter) BeginTable(tableinfo *frundis.TableData) {
+	ctx := exp.Context()
+	exp.openSection()
+	w := ctx.W()
+	var id string
+	if tableinfo.Title != "" {
+		id = fmt.Sprintf(" id=\"tbl%d\"", ctx.Table.TitCount)
+	} else if tableinfo.ID != "" {
+		id = " id=\"" + tableinfo.ID + "\""
+	}
+	fmt.Fprintf(w, "<table%s>\n", id)
+	exp.table = true
+}
+
+func (exp *exporter) BeginTableCell() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "<td>")
+}
+
+func (exp *exporter) BeginTableRow() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "<tr>\n")
+}
+
+func (exp *exporter) BeginVerse(title string, id string) {
+	ctx := exp.Context()
+	exp.openSection()
+	w := ctx.W()
+	if title != "" {
+		fmt.Fprintf(w, "<poem id=\"poem%s\">\n", id)
+		fmt.Fprintf(w, "<title><p>%s</p></title>\n", title)
+	} else if id != "" {
+		fmt.Fprintf(w, "<poem id=\"%s\">\n", id)
+	} else {
+		fmt.Fprint(w, "<poem>\n")
+	}
+	exp.verse = true
+}
+
+func (exp *exporter) BeginVerseLine() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "<v>")
+}
+
+func (exp *exporter) CrossReference(idf frundis.IDInfo, punct string) {
+	w := exp.Context().W()
+	switch idf.Type {
+	case frundis.NoID:
+		fmt.Fprintf(w, "%s%s", idf.Name, punct)
+	default:
+		fmt.Fprintf(w, "<a l:href=\"%s\">%s</a>%s", idf.Ref, idf.Name, punct)
+	}
+}
+
+func (exp *exporter) DescName(name string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<p><strong>%s</strong></p>\n", name)
+}
+
+func (exp *exporter) EndDescValue() {
+}
+
+func (exp *exporter) EndDisplayBlock(tag string) {
+	ctx := exp.Context()
+	cmd := ctx.Dtags[tag].Cmd
+	if cmd == "" {
+		return
+	}
+	w := ctx.W()
+	fmt.Fprintf(w, "</%s>\n", cmd)
+}
+
+func (exp *exporter) EndEnumList() {
+	if len(exp.enumCount) > 0 {
+		exp.enumCount = exp.enumCount[:len(exp.enumCount)-1]
+	}
+}
+
+func (exp *exporter) EndEnumItem() {
+	exp.itemPrefix = ""
+}
+
+func (exp *exporter) EndHeader(macro string, numbered bool, title string) {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</p></title>\n")
+}
+
+func (exp *exporter) EndItem() {
+	exp.itemPrefix = ""
+}
+
+func (exp *exporter) EndMarkupBlock(tag string, id string, punct string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	mtag, ok := ctx.Mtags[tag]
+	switch {
+	case !ok:
+		fmt.Fprint(w, "</emphasis>")
+	default:
+		fmt.Fprint(w, mtag.End)
+		fmt.Fprintf(w, "</%s>", mtag.Cmd)
+	}
+	fmt.Fprint(w, punct)
+}
+
+func (exp *exporter) EndParagraph(pbreak frundis.ParagraphBreak) {
+	w := exp.Context().W()
+	switch {
+	case pbreak == frundis.ParBreakForced:
+	case exp.table:
+	case exp.verse:
+		exp.EndStanza()
+	default:
+		fmt.Fprint(w, "</p>\n")
+	}
+}
+
+func (exp *exporter) EndStanza() {
+	w := exp.Context().W()
+	if !exp.stanza {
+		return
+	}
+	fmt.Fprint(w, "</v>\n</stanza>\n")
+	exp.stanza = false
+}
+
+func (exp *exporter) EndTable(tableinfo *frundis.TableData) {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</table>\n")
+	if tableinfo.Title != "" {
+		fmt.Fprintf(w, "<subtitle>%s</subtitle>\n", tableinfo.Title)
+	}
+	exp.table = false
+}
+
+func (exp *exporter) EndTableCell() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</td>\n")
+}
+
+func (exp *exporter) EndTableRow() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</tr>\n")
+}
+
+func (exp *exporter) EndVerse() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</poem>\n")
+	exp.verse = false
+}
+
+func (exp *exporter) EndVerseLine() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</v>\n")
+}
+
+func (exp *exporter) FigureImage(image string, caption string, link string, alt string) {
+	ctx := exp.Context()
+	exp.openSection()
+	w := ctx.W()
+	fmt.Fprintf(w, "<image l:href=\"#%s\" id=\"fig%d\"", html.EscapeString(path.Base(image)), ctx.FigCount)
+	switch {
+	case alt != "":
+		fmt.Fprintf(w, " alt=\"%s\"", html.EscapeString(alt))
+	case caption != "":
+		// caption is already escaped
+		fmt.Fprintf(w, " alt=\"%s\"", caption)
+	}
+	if caption != "" {
+		fmt.Fprintf(w, " title=\"%s\"", caption)
+	}
+	fmt.Fprint(w, " />\n")
+	if caption != "" {
+		fmt.Fprintf(w, "<p><emphasis>%s</emphasis></p>\n", caption)
+	}
+}
+
+func (exp *exporter) GenRef(prefix string, id string, hasfile bool) string {
+	return fmt.Sprintf("#%s%s", prefix, id)
+}
+
+func (exp *exporter) HeaderReference(macro string) string {
+	return exp.GenRef("s", strconv.Itoa(exp.Context().Toc.HeaderCount), false)
+}
+
+func (exp *exporter) InlineImage(image string, link string, id string, punct string, alt string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<image l:href=\"#%s\"", html.EscapeString(path.Base(image)))
+	if alt != "" {
+		fmt.Fprintf(w, " alt=\"%s\"", html.EscapeString(alt))
+	}
+	fmt.Fprintf(w, " />%s", punct)
+}
+
+func (exp *exporter) LkWithLabel(uri string, label string, punct string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	parsedURL, err := url.Parse(uri)
+	var u string
+	if err != nil {
+		ctx.Error("invalid url or path:", uri)
+	} else {
+		u = html.EscapeString(parsedURL.String())
+	}
+	fmt.Fprintf(w, "<a l:href=\"%s\">%s</a>%s", u, label, punct)
+}
+
+func (exp *exporter) LkWithoutLabel(uri string, punct string) {
+	exp.LkWithLabel(uri, html.EscapeString(uri), punct)
+}
+
+func (exp *exporter) ParagraphTitle(title string) {
+	exp.openSection()
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<p><strong>%s</strong>\n", title)
+}
+
+func (exp *exporter) RenderText(text []ast.Inline) string {
+	ctx := exp.Context()
+	switch ctx.Params["lang"] {
+	case "fr":
+		text = frundis.FrenchTypography(exp, text)
+	case "en":
+		text = frundis.EnglishTypography(exp, text)
+	}
+	return html.EscapeString(ctx.InlinesToText(text))
+}
+
+func (exp *exporter) TableOfContents(opts map[string][]ast.Inline, flags map[string]bool) {
+	// FictionBook readers build their own table of contents from sections.
+}
+
+func (exp *exporter) Xdtag(cmd string, pairs []string) frundis.Dtag {
+	switch cmd {
+	case "epigraph", "cite", "annotation", "":
+	default:
+		exp.Context().Errorf("%s: not a FictionBook block element", cmd)
+	}
+	return frundis.Dtag{Cmd: cmd}
+}
+
+func (exp *exporter) Xmtag(cmd *string, begin string, end string, pairs []string) frundis.Mtag {
+	var c string
+	if cmd == nil || *cmd == "" {
+		c = "emphasis"
+	} else {
+		c = *cmd
+	}
+	switch c {
+	case "emphasis", "strong", "style", "strikethrough", "sub", "sup", "code":
+	default:
+		exp.Context().Errorf("%s: not a FictionBook inline element", c)
+	}
+	return frundis.Mtag{Begin: begin, End: end, Cmd: c}
+}
@@ -0,0 +1,148 @@
+package fb2
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"strings"
+
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+func (exp *exporter) sectionID(entry *frundis.LoXinfo) string {
+	return fmt.Sprintf("s%d", entry.Count)
+}
+
+// writeAuthor writes an author element, splitting name into first and last
+// names as required by FictionBook. As a name is mandatory, a placeholder
+// nickname is used when no author is set.
+func (exp *exporter) writeAuthor(indent string, name string) {
+	w := exp.Context().Wout
+	fmt.Fprintf(w, "%s<author>\n", indent)
+	fields := strings.Fields(html.UnescapeString(name))
+	switch len(fields) {
+	case 0:
+		fmt.Fprintf(w, "%s  <nickname>Unknown</nickname>\n", indent)
+	case 1:
+		fmt.Fprintf(w, "%s  <nickname>%s</nickname>\n", indent, html.EscapeString(fields[0]))
+	default:
+		fmt.Fprintf(w, "%s  <first-name>%s</first-name>\n", indent, html.EscapeString(strings.Join(fields[:len(fields)-1], " ")))
+		fmt.Fprintf(w, "%s  <last-name>%s</last-name>\n", indent, html.EscapeString(fields[len(fields)-1]))
+	}
+	fmt.Fprintf(w, "%s</author>\n", indent)
+}
+
+// beginFB2Document writes the description of the document and opens its
+// body. As a book title is mandatory, a placeholder is used when no document
+// title is set.
+func (exp *exporter) beginFB2Document() {
+	ctx := exp.Context()
+	w := ctx.Wout
+	title := ctx.Params["document-title"]
+	author := ctx.Params["document-author"]
+	date := ctx.Params["document-date"]
+	fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description>
+  <title-info>
+`)
+	genre := ctx.Params["epub-subject"]
+	if genre == "" {
+		genre = "prose"
+	}
+	fmt.Fprintf(w, "    <genre>%s</genre>\n", genre)
+	exp.writeAuthor("    ", author)
+	if title != "" {
+		fmt.Fprintf(w, "    <book-title>%s</book-title>\n", title)
+	} else {
+		fmt.Fprint(w, "    <book-title>Untitled</book-title>\n")
+	}
+	if date != "" {
+		fmt.Fprintf(w, "    <date>%s</date>\n", date)
+	}
+	if cover := ctx.Params["epub-cover"]; cover != "" {
+		fmt.Fprintf(w, "    <coverpage><image l:href=\"#%s\" /></coverpage>\n", html.EscapeString(path.Base(cover)))
+	}
+	fmt.Fprintf(w, "    <lang>%s</lang>\n", ctx.Params["lang"])
+	fmt.Fprint(w, "  </title-info>\n")
+	fmt.Fprint(w, "  <document-info>\n")
+	exp.writeAuthor("    ", author)
+	fmt.Fprint(w, "    <program-used>frundis</program-used>\n")
+	if date != "" {
+		fmt.Fprintf(w, "    <date>%s</date>\n", date)
+	}
+	fmt.Fprintf(w, "    <id>%s</id>\n", exp.documentID())
+	fmt.Fprint(w, "    <version>1.0</version>\n")
+	fmt.Fprint(w, "  </document-info>\n")
+	fmt.Fprint(w, "</description>\n")
+	fmt.Fprint(w, "<body>\n")
+	if title != "" && frundis.IsTrue(ctx.Params["title-page"]) {
+		fmt.Fprintf(w, "<title><p>%s</p></title>\n", title)
+	}
+}
+
+// documentID returns the value of the epub-uuid parameter, if any, or else an
+// identifier derived from document title, author and date, so that it is
+// stable across exports.
+func (exp *exporter) documentID() string {
+	ctx := exp.Context()
+	if id := ctx.Params["epub-uuid"]; id != "" {
+		return id
+	}
+	h := sha1.Sum([]byte(ctx.Params["document-title"] + "\x00" +
+		ctx.Params["document-author"] + "\x00" + ctx.Params["document-date"]))
+	return fmt.Sprintf("frundis-%x", h[:16])
+}
+
+func (exp *exporter) endFB2Document() {
+	ctx := exp.Context()
+	w := ctx.Wout
+	fmt.Fprint(w, "</body>\n")
+	seen := map[string]bool{}
+	for _, image := range append(ctx.Images, ctx.Params["epub-cover"]) {
+		if image == "" {
+			continue
+		}
+		name := path.Base(image)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		exp.writeBinary(image, name)
+	}
+	fmt.Fprint(w, "</FictionBook>\n")
+}
+
+// writeBinary embeds an image as a base64 encoded binary element.
+func (exp *exporter) writeBinary(image string, name string) {
+	ctx := exp.Context()
+	var contentType string
+	switch {
+	case strings.HasSuffix(image, ".png"):
+		contentType = "image/png"
+	case strings.HasSuffix(image, ".jpeg") || strings.HasSuffix(image, ".jpg"):
+		contentType = "image/jpeg"
+	case strings.HasSuffix(image, ".gif"):
+		contentType = "image/gif"
+	default:
+		ctx.Error("unknown image format:", image)
+		return
+	}
+	f, ok := frundis.SearchIncFile(exp, image)
+	if !ok {
+		ctx.Errorf("image: no such file: %s", image)
+		return
+	}
+	data, err := os.ReadFile(f)
+	if err != nil {
+		ctx.Errorf("image: reading image: %s: %s", f, err)
+		return
+	}
+	w := ctx.Wout
+	fmt.Fprintf(w, "<binary id=\"%s\" content-type=\"%s\">", html.EscapeString(name), contentType)
+	fmt.Fprint(w, base64.StdEncoding.EncodeToString(data))
+	fmt.Fprint(w, "</binary>\n")
+}
@@ -16,6 +16,7 @@ import (
 // Options gathers configuration for LaTeX exporter.
 type Options struct {
 	OutputFile string // name of output file or directory
+	SourceMap  string // name of JSON source map file (if any)
 	Standalone bool   // generate complete document with headers
 }
 
@@ -24,12 +25,28 @@ type Options struct {
 func NewExporter(opts *Options) frundis.Exporter {
 	return &exporter{
 		OutputFile: opts.OutputFile,
+		SourceMap:  opts.SourceMap,
 		Standalone: opts.Standalone}
 }
 
+func init() {
+	frundis.RegisterFormat("latex", "", func(opts *frundis.FormatOptions) frundis.Exporter {
+		var sourceMap string
+		if opts.SourceMap && opts.OutputFile != "" {
+			sourceMap = opts.OutputFile + ".map"
+		}
+		return NewExporter(&Options{
+			OutputFile: opts.OutputFile,
+			SourceMap:  sourceMap,
+			Standalone: opts.Standalone})
+	})
+	frundis.SetFormatExtension("latex", ".tex")
+}
+
 type exporter struct {
 	Ctx           *frundis.Context
 	OutputFile    string
+	SourceMap     string
 	curOutputFile *os.File
 	Standalone    bool
 	dominilof     bool
@@ -58,7 +75,12 @@ func (exp *exporter) Reset() error {
 	if exp.curOutputFile == nil {
 		exp.curOutputFile = os.Stdout
 	}
-	ctx.Wout = bufio.NewWriter(exp.curOutputFile)
+	if exp.SourceMap != "" {
+		ctx.SourceMap = frundis.NewSourceMap(exp.curOutputFile)
+		ctx.Wout = bufio.NewWriter(ctx.SourceMap)
+	} else {
+		ctx.Wout = bufio.NewWriter(exp.curOutputFile)
+	}
 	if exp.Standalone {
 		exp.beginLatexDocument()
 	}
@@ -77,6 +99,17 @@ func (exp *exporter) PostProcessing() {
 			ctx.Error(err)
 		}
 	}
+	if ctx.SourceMap != nil {
+		err := ctx.SourceMap.WriteFile(exp.SourceMap)
+		if err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// Abort implements frundis.Aborter.
+func (exp *exporter) Abort() {
+	frundis.AbortOutputFile(exp.curOutputFile)
 }
 
 func (exp *exporter) BeginDescList(id string) {
@@ -396,7 +429,7 @@ func (exp *exporter) FigureImage(image string, caption string, link string, alt
 		return
 	}
 	w := ctx.W()
-	_, err := os.Stat(image)
+	_, err := os.Stat(ctx.Path(image))
 	if err != nil {
 		ctx.Error("image not found:", image)
 		return
@@ -429,7 +462,7 @@ func (exp *exporter) InlineImage(image string, link string, id string, punct str
 		return
 	}
 	w := ctx.W()
-	_, err := os.Stat(image)
+	_, err := os.Stat(ctx.Path(image))
 	if err != nil {
 		ctx.Error("image not found:", image)
 		return
@@ -467,6 +500,16 @@ func (exp *exporter) LkWithoutLabel(uri string, punct string) {
 	fmt.Fprintf(w, "\\url{%s}%s", u, punct)
 }
 
+func (exp *exporter) Math(tex string, punct string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "$%s$%s", tex, punct)
+}
+
+func (exp *exporter) Note(text string, punct string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "\\footnote{%s}%s", text, punct)
+}
+
 func (exp *exporter) ParagraphTitle(title string) {
 	w := exp.Context().W()
 	fmt.Fprintf(w, "\\paragraph{%s}\n", title)
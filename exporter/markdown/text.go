@@ -2,6 +2,7 @@ package markdown
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"os"
 	"strings"
@@ -15,12 +16,24 @@ import (
 // Options gathers configuration for markdown exporter.
 type Options struct {
 	OutputFile string // name of output file or directory
+	SourceMap  string // name of JSON source map file (if any)
 }
 
 // NewExporter returns a frundis.Exporter suitable to produce markdown.
 // See type Options for options.
 func NewExporter(opts *Options) frundis.Exporter {
-	return &exporter{OutputFile: opts.OutputFile}
+	return &exporter{OutputFile: opts.OutputFile, SourceMap: opts.SourceMap}
+}
+
+func init() {
+	frundis.RegisterFormat("markdown", "", func(opts *frundis.FormatOptions) frundis.Exporter {
+		var sourceMap string
+		if opts.SourceMap && opts.OutputFile != "" {
+			sourceMap = opts.OutputFile + ".map"
+		}
+		return NewExporter(&Options{OutputFile: opts.OutputFile, SourceMap: sourceMap})
+	})
+	frundis.SetFormatExtension("markdown", ".md")
 }
 
 type exporter struct {
@@ -28,9 +41,12 @@ type exporter struct {
 	Ctx           *frundis.Context
 	Format        string
 	OutputFile    string
+	SourceMap     string
 	curOutputFile *os.File
 	nesting       int
+	pbuf          bytes.Buffer // paragraph buffer
 	verse         bool
+	wordbuf       bytes.Buffer // word buffer
 }
 
 func (exp *exporter) Init() {
@@ -53,7 +69,12 @@ func (exp *exporter) Reset() error {
 	if exp.curOutputFile == nil {
 		exp.curOutputFile = os.Stdout
 	}
-	ctx.Wout = bufio.NewWriter(exp.curOutputFile)
+	if exp.SourceMap != "" {
+		ctx.SourceMap = frundis.NewSourceMap(exp.curOutputFile)
+		ctx.Wout = bufio.NewWriter(ctx.SourceMap)
+	} else {
+		ctx.Wout = bufio.NewWriter(exp.curOutputFile)
+	}
 	return nil
 }
 
@@ -66,6 +87,17 @@ func (exp *exporter) PostProcessing() {
 			ctx.Error(err)
 		}
 	}
+	if ctx.SourceMap != nil {
+		err := ctx.SourceMap.WriteFile(exp.SourceMap)
+		if err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// Abort implements frundis.Aborter.
+func (exp *exporter) Abort() {
+	frundis.AbortOutputFile(exp.curOutputFile)
 }
 
 func (exp *exporter) BeginDescList(id string) {
@@ -335,7 +367,7 @@ func (exp *exporter) FormatParagraph(text []byte) []byte {
 	if exp.verse {
 		return text
 	}
-	return processText(indent, text)
+	return exp.processText(indent, text)
 }
 
 func (exp *exporter) FigureImage(image string, caption string, link string, alt string) {
@@ -368,6 +400,11 @@ func (exp *exporter) LkWithoutLabel(url string, punct string) {
 	fmt.Fprint(w, "<"+url+">"+punct)
 }
 
+func (exp *exporter) Math(tex string, punct string) {
+	w := exp.Context().W()
+	fmt.Fprint(w, "$"+tex+"$"+punct)
+}
+
 func (exp *exporter) ParagraphTitle(title string) {
 	w := exp.Context().W()
 	fmt.Fprint(w, "**"+title+"** ")
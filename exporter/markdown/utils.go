@@ -1,16 +1,14 @@
 package markdown
 
 import (
-	"bytes"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
-var pbuf bytes.Buffer    // paragraph buffer
-var wordbuf bytes.Buffer // word buffer
-
-func processText(indent int, text []byte) []byte {
+func (exp *exporter) processText(indent int, text []byte) []byte {
+	pbuf := &exp.pbuf
+	wordbuf := &exp.wordbuf
 	pbuf.Reset()
 	wordbuf.Reset()
 	indentspaces := strings.Repeat(" ", indent)
@@ -29,6 +29,15 @@ func NewExporter(opts *Options) frundis.Exporter {
 		Standalone: opts.Standalone}
 }
 
+func init() {
+	frundis.RegisterFormat("mom", "", func(opts *frundis.FormatOptions) frundis.Exporter {
+		return NewExporter(&Options{
+			OutputFile: opts.OutputFile,
+			Standalone: opts.Standalone})
+	})
+	frundis.SetFormatExtension("mom", ".mom")
+}
+
 type exporter struct {
 	Ctx           *frundis.Context
 	OutputFile    string
@@ -77,6 +86,11 @@ func (exp *exporter) PostProcessing() {
 	}
 }
 
+// Abort implements frundis.Aborter.
+func (exp *exporter) Abort() {
+	frundis.AbortOutputFile(exp.curOutputFile)
+}
+
 func (exp *exporter) BeginDescList(id string) {
 	w := exp.Context().W()
 	if id != "" {
@@ -392,7 +406,7 @@ func (exp *exporter) FormatParagraph(text []byte) []byte {
 func (exp *exporter) FigureImage(image string, caption string, link string, alt string) {
 	ctx := exp.Context()
 	w := ctx.W()
-	_, err := os.Stat(image)
+	_, err := os.Stat(ctx.Path(image))
 	if err != nil {
 		ctx.Error("image not found:", image)
 		return
@@ -427,7 +441,7 @@ func (exp *exporter) InlineImage(image string, link string, id string, punct str
 		return
 	}
 	w := ctx.W()
-	_, err := os.Stat(image)
+	_, err := os.Stat(ctx.Path(image))
 	if err != nil {
 		ctx.Error("image not found:", image)
 		return
@@ -0,0 +1,98 @@
+package pandoc
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/doctree"
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// Options gathers configuration for pandoc JSON exporter.
+type Options struct {
+	OutputFile string // name of output file
+}
+
+// NewExporter returns a frundis.Exporter suitable to produce pandoc's JSON
+// AST. See type Options for options.
+func NewExporter(opts *Options) frundis.Exporter {
+	return &exporter{
+		Builder:    doctree.NewBuilder(&doctree.Options{Format: "pandoc-json"}),
+		OutputFile: opts.OutputFile}
+}
+
+func init() {
+	frundis.RegisterFormat("pandoc-json", "", func(opts *frundis.FormatOptions) frundis.Exporter {
+		return NewExporter(&Options{OutputFile: opts.OutputFile})
+	})
+	frundis.SetFormatExtension("pandoc-json", ".json")
+}
+
+// The exporter builds a document tree (see package doctree), which is then
+// converted into pandoc's JSON AST at post-processing (see tree.go).
+type exporter struct {
+	*doctree.Builder
+	OutputFile string
+}
+
+func (exp *exporter) PostProcessing() {
+	exp.Builder.PostProcessing()
+	ctx := exp.Context()
+	out := os.Stdout
+	if exp.OutputFile != "" {
+		var err error
+		out, err = os.Create(exp.OutputFile)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+	}
+	err := exp.writeJSON(out, exp.Document())
+	if err != nil {
+		ctx.Error(err)
+	}
+	if exp.OutputFile != "" {
+		err = out.Close()
+		if err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// Abort implements frundis.Aborter. Nothing is done, as output is written
+// only by PostProcessing.
+func (exp *exporter) Abort() {}
+
+func (exp *exporter) GenRef(prefix string, id string, hasfile bool) string {
+	return fmt.Sprintf("#%s%s", prefix, id)
+}
+
+func (exp *exporter) HeaderReference(macro string) string {
+	ctx := exp.Context()
+	if ctx.IDX != "" {
+		return exp.GenRef("", ctx.IDX, false)
+	}
+	return exp.GenRef("s", strconv.Itoa(ctx.Toc.HeaderCount), false)
+}
+
+func (exp *exporter) RawFormat(format string) (string, bool) {
+	switch format {
+	case "xhtml", "epub":
+		return "html", true
+	case "mom":
+		return "ms", true
+	}
+	return format, true
+}
+
+func (exp *exporter) RenderText(text []ast.Inline) string {
+	switch exp.Context().Params["lang"] {
+	case "fr":
+		text = frundis.FrenchTypography(exp, text)
+	case "en":
+		text = frundis.EnglishTypography(exp, text)
+	}
+	return exp.Builder.RenderText(text)
+}
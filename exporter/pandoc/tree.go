@@ -0,0 +1,367 @@
+package pandoc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"codeberg.org/anaseto/gofrundis/doctree"
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// elt is a pandoc AST element. Elements without contents, like Space, have
+// a nil C.
+type elt struct {
+	T string
+	C interface{}
+}
+
+func (e elt) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	var err error
+	if e.C == nil {
+		err = enc.Encode(struct {
+			T string `json:"t"`
+		}{e.T})
+	} else {
+		err = enc.Encode(struct {
+			T string      `json:"t"`
+			C interface{} `json:"c"`
+		}{e.T, e.C})
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), err
+}
+
+type document struct {
+	APIVersion []int                  `json:"pandoc-api-version"`
+	Meta       map[string]interface{} `json:"meta"`
+	Blocks     []interface{}          `json:"blocks"`
+}
+
+// converter converts a document tree into pandoc's JSON AST. Markup and
+// display tags are looked up in the context of the exporter.
+type converter struct {
+	ctx     *frundis.Context
+	dmark   string // dialogue mark
+	figures int    // number of figures so far
+	poems   int    // number of titled poems so far
+	tables  int    // number of titled tables so far
+}
+
+func (exp *exporter) writeJSON(out io.Writer, tree *doctree.Document) error {
+	ctx := exp.Context()
+	c := &converter{ctx: ctx, dmark: "—"}
+	if dmark, ok := ctx.Params["dmark"]; ok {
+		c.dmark = dmark
+	}
+	doc := document{
+		APIVersion: []int{1, 23, 1},
+		Meta:       map[string]interface{}{},
+		Blocks:     c.blocks(tree.Children)}
+	for _, p := range []string{"title", "author", "date"} {
+		if v := tree.Params["document-"+p]; v != "" {
+			doc.Meta[p] = elt{T: "MetaInlines", C: c.inlines([]doctree.Node{&doctree.Text{Text: v}})}
+		}
+	}
+	if lang := tree.Params["lang"]; lang != "" {
+		doc.Meta["lang"] = elt{T: "MetaString", C: lang}
+	}
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(doc)
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// attr returns a pandoc attribute from an id, an optional class and
+// key/value pairs.
+func attr(id string, class string, pairs []string) []interface{} {
+	classes := []string{}
+	if class != "" {
+		classes = append(classes, class)
+	}
+	kvs := [][]string{}
+	for i := 0; i < len(pairs)-1; i += 2 {
+		kvs = append(kvs, []string{pairs[i], pairs[i+1]})
+	}
+	return []interface{}{id, classes, kvs}
+}
+
+// blocks converts block nodes to pandoc blocks. Sections are flattened into
+// a header followed by their content.
+func (c *converter) blocks(nodes []doctree.Node) []interface{} {
+	bs := []interface{}{}
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *doctree.Section:
+			var class string
+			if !n.Numbered {
+				class = "unnumbered"
+			}
+			id := strings.TrimPrefix(n.Ref, "#")
+			bs = append(bs, elt{T: "Header", C: []interface{}{n.Level, attr(id, class, nil), c.inlines(n.Title)}})
+			bs = append(bs, c.blocks(n.Children)...)
+		case *doctree.TableOfContents:
+			// Pandoc writers generate tables of contents themselves.
+		default:
+			if b := c.block(n); b != nil {
+				bs = append(bs, b)
+			}
+		}
+	}
+	return bs
+}
+
+func (c *converter) block(n doctree.Node) interface{} {
+	switch n := n.(type) {
+	case *doctree.Paragraph:
+		is := c.inlines(n.Children)
+		if len(n.Title) > 0 {
+			title := []interface{}{elt{T: "Strong", C: c.inlines(n.Title)}}
+			if len(is) > 0 {
+				title = append(title, elt{T: "Space"})
+			}
+			is = append(title, is...)
+		}
+		if len(is) == 0 {
+			return nil
+		}
+		return elt{T: "Para", C: is}
+	case *doctree.List:
+		switch n.Kind {
+		case doctree.EnumList:
+			style := []interface{}{1, elt{T: "Decimal"}, elt{T: "Period"}}
+			return withID(n.ID, elt{T: "OrderedList", C: []interface{}{style, c.items(n.Children)}})
+		case doctree.DescList:
+			return withID(n.ID, elt{T: "DefinitionList", C: c.definitions(n.Children)})
+		default:
+			return withID(n.ID, elt{T: "BulletList", C: c.items(n.Children)})
+		}
+	case *doctree.Display:
+		return elt{T: "Div", C: []interface{}{attr(n.ID, n.Tag, c.ctx.Dtags[n.Tag].Pairs), c.blocks(n.Children)}}
+	case *doctree.Table:
+		return c.table(n)
+	case *doctree.Verse:
+		bs := []interface{}{}
+		id := n.ID
+		if len(n.Title) > 0 {
+			c.poems++
+			id = fmt.Sprintf("poem%d", c.poems)
+			bs = append(bs, elt{T: "Para", C: []interface{}{elt{T: "Strong", C: c.inlines(n.Title)}}})
+		}
+		for _, s := range n.Children {
+			ls := [][]interface{}{}
+			for _, l := range s.Nodes() {
+				ls = append(ls, c.inlines(l.Nodes()))
+			}
+			bs = append(bs, elt{T: "LineBlock", C: ls})
+		}
+		return elt{T: "Div", C: []interface{}{attr(id, "verse", nil), bs}}
+	case *doctree.Figure:
+		c.figures++
+		alt := n.Alt
+		if alt == "" {
+			alt = doctree.TextContent(n.Caption)
+		}
+		image := elt{T: "Image", C: []interface{}{attr("", "", nil), textInlines(alt), []string{n.Image, ""}}}
+		caption := []interface{}{}
+		if is := c.inlines(n.Caption); len(is) > 0 {
+			caption = append(caption, elt{T: "Plain", C: is})
+		}
+		return elt{T: "Figure", C: []interface{}{
+			attr(fmt.Sprintf("fig%d", c.figures), "", nil),
+			[]interface{}{nil, caption},
+			[]interface{}{elt{T: "Plain", C: []interface{}{image}}}}}
+	case *doctree.Raw:
+		return elt{T: "RawBlock", C: []string{n.Format, n.Text}}
+	}
+	return nil
+}
+
+// withID wraps a block into a Div with a given id, if not empty.
+func withID(id string, b elt) elt {
+	if id == "" {
+		return b
+	}
+	return elt{T: "Div", C: []interface{}{attr(id, "", nil), []interface{}{b}}}
+}
+
+func (c *converter) items(nodes []doctree.Node) [][]interface{} {
+	its := [][]interface{}{}
+	for _, n := range nodes {
+		its = append(its, c.blocks(n.Nodes()))
+	}
+	return its
+}
+
+func (c *converter) definitions(nodes []doctree.Node) []interface{} {
+	defs := []interface{}{}
+	for _, n := range nodes {
+		item, ok := n.(*doctree.Item)
+		if !ok {
+			continue
+		}
+		defs = append(defs, []interface{}{c.inlines(item.Name), [][]interface{}{c.blocks(item.Children)}})
+	}
+	return defs
+}
+
+func (c *converter) table(t *doctree.Table) interface{} {
+	noattr := attr("", "", nil)
+	colspecs := []interface{}{}
+	for i := 0; i < t.Cols; i++ {
+		colspecs = append(colspecs, []interface{}{elt{T: "AlignDefault"}, elt{T: "ColWidthDefault"}})
+	}
+	id := t.ID
+	caption := []interface{}{}
+	if len(t.Title) > 0 {
+		c.tables++
+		id = fmt.Sprintf("tbl%d", c.tables)
+		caption = append(caption, elt{T: "Plain", C: c.inlines(t.Title)})
+	}
+	rows := []interface{}{}
+	for _, row := range t.Children {
+		cells := []interface{}{}
+		for _, cell := range row.Nodes() {
+			content := []interface{}{}
+			if is := c.inlines(cell.Nodes()); len(is) > 0 {
+				content = append(content, elt{T: "Plain", C: is})
+			}
+			cells = append(cells, []interface{}{noattr, elt{T: "AlignDefault"}, 1, 1, content})
+		}
+		rows = append(rows, []interface{}{noattr, cells})
+	}
+	return elt{T: "Table", C: []interface{}{
+		attr(id, "", nil),
+		[]interface{}{nil, caption},
+		colspecs,
+		[]interface{}{noattr, []interface{}{}},
+		[]interface{}{[]interface{}{noattr, 0, []interface{}{}, rows}},
+		[]interface{}{noattr, []interface{}{}}}}
+}
+
+// inlines converts inline nodes to pandoc inlines, merging adjacent text and
+// trimming leading and trailing spaces. Dialogue marks, markup delimiters
+// and names of references without target are merged with surrounding text.
+func (c *converter) inlines(nodes []doctree.Node) []interface{} {
+	is := []interface{}{}
+	var text strings.Builder
+	flush := func() {
+		is = append(is, textInlines(text.String())...)
+		text.Reset()
+	}
+	var add func(nodes []doctree.Node)
+	add = func(nodes []doctree.Node) {
+		for _, n := range nodes {
+			switch n := n.(type) {
+			case *doctree.Text:
+				text.WriteString(n.Text)
+			case *doctree.Dialogue:
+				text.WriteString(c.dmark)
+			case *doctree.Reference:
+				if n.Type == frundis.NoID {
+					add(n.Name)
+					continue
+				}
+				flush()
+				is = append(is, elt{T: "Link", C: []interface{}{attr("", "", nil), c.inlines(n.Name), []string{n.Ref, ""}}})
+			default:
+				flush()
+				if i := c.inline(n); i != nil {
+					is = append(is, i)
+				}
+			}
+		}
+	}
+	add(nodes)
+	flush()
+	for len(is) > 0 && isSpace(is[0]) {
+		is = is[1:]
+	}
+	for len(is) > 0 && isSpace(is[len(is)-1]) {
+		is = is[:len(is)-1]
+	}
+	return is
+}
+
+func isSpace(i interface{}) bool {
+	e, ok := i.(elt)
+	return ok && (e.T == "Space" || e.T == "SoftBreak")
+}
+
+func (c *converter) inline(n doctree.Node) interface{} {
+	switch n := n.(type) {
+	case *doctree.Markup:
+		mtag, ok := c.ctx.Mtags[n.Tag]
+		if !ok {
+			e := elt{T: "Emph", C: c.inlines(n.Children)}
+			if n.ID != "" {
+				return elt{T: "Span", C: []interface{}{attr(n.ID, "", nil), []interface{}{e}}}
+			}
+			return e
+		}
+		content := []doctree.Node{&doctree.Text{Text: mtag.Begin}}
+		content = append(content, n.Children...)
+		content = append(content, &doctree.Text{Text: mtag.End})
+		return elt{T: "Span", C: []interface{}{attr(n.ID, n.Tag, mtag.Pairs), c.inlines(content)}}
+	case *doctree.Link:
+		label := c.inlines(n.Label)
+		if len(n.Label) == 0 {
+			label = textInlines(n.URL)
+		}
+		return elt{T: "Link", C: []interface{}{attr("", "", nil), label, []string{n.URL, ""}}}
+	case *doctree.Image:
+		image := elt{T: "Image", C: []interface{}{attr(n.ID, "", nil), textInlines(n.Alt), []string{n.Image, ""}}}
+		if n.Link != "" {
+			return elt{T: "Link", C: []interface{}{attr("", "", nil), []interface{}{image}, []string{n.Link, ""}}}
+		}
+		return image
+	case *doctree.Note:
+		return elt{T: "Note", C: []interface{}{elt{T: "Para", C: c.inlines(n.Children)}}}
+	case *doctree.Math:
+		return elt{T: "Math", C: []interface{}{elt{T: "InlineMath"}, n.TeX}}
+	case *doctree.Raw:
+		return elt{T: "RawInline", C: []string{n.Format, n.Text}}
+	}
+	return nil
+}
+
+// textInlines splits text into pandoc Str, Space and SoftBreak elements.
+func textInlines(text string) []interface{} {
+	is := []interface{}{}
+	for len(text) > 0 {
+		i := strings.IndexFunc(text, isBreakingSpace)
+		if i < 0 {
+			i = len(text)
+		}
+		if i > 0 {
+			is = append(is, elt{T: "Str", C: text[:i]})
+			text = text[i:]
+			continue
+		}
+		j := strings.IndexFunc(text, func(r rune) bool { return !isBreakingSpace(r) })
+		if j < 0 {
+			j = len(text)
+		}
+		if strings.ContainsRune(text[:j], '\n') {
+			is = append(is, elt{T: "SoftBreak"})
+		} else {
+			is = append(is, elt{T: "Space"})
+		}
+		text = text[j:]
+	}
+	return is
+}
+
+func isBreakingSpace(r rune) bool {
+	return unicode.IsSpace(r) && r != '\u00a0' && r != '\u202f'
+}
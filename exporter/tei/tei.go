@@ -0,0 +1,472 @@
+package tei
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"strconv"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// Options gathers configuration for TEI exporter.
+type Options struct {
+	OutputFile string // name of output file
+}
+
+// NewExporter returns a frundis.Exporter suitable to produce TEI P5.
+// See type Options for options.
+func NewExporter(opts *Options) frundis.Exporter {
+	return &exporter{OutputFile: opts.OutputFile}
+}
+
+func init() {
+	frundis.RegisterFormat("tei", "", func(opts *frundis.FormatOptions) frundis.Exporter {
+		return NewExporter(&Options{OutputFile: opts.OutputFile})
+	})
+	frundis.SetFormatExtension("tei", ".tei.xml")
+}
+
+type exporter struct {
+	frundis.BaseRenderer
+	OutputFile    string
+	curOutputFile *os.File
+	levels        []int // header levels of currently open divisions
+	said          bool  // whether a dialogue is open
+	stanza        bool  // whether a stanza is open
+	table         bool  // whether inside a table
+	verse         bool  // whether inside a poem
+}
+
+func (exp *exporter) Init() {
+	ctx := &frundis.Context{Wout: bufio.NewWriter(os.Stdout), Format: "tei"}
+	exp.Ctx = ctx
+	ctx.Init()
+	ctx.Filters["escape"] = html.EscapeString
+}
+
+func (exp *exporter) Reset() error {
+	ctx := exp.Context()
+	ctx.Reset()
+	if exp.OutputFile != "" {
+		var err error
+		exp.curOutputFile, err = os.Create(exp.OutputFile)
+		if err != nil {
+			return fmt.Errorf("%v\n", err)
+		}
+	}
+	if exp.curOutputFile == nil {
+		exp.curOutputFile = os.Stdout
+	}
+	ctx.Wout = bufio.NewWriter(exp.curOutputFile)
+	exp.beginTEIDocument()
+	return nil
+}
+
+func (exp *exporter) PostProcessing() {
+	ctx := exp.Context()
+	exp.closeDivisions(0)
+	exp.endTEIDocument()
+	ctx.Wout.Flush()
+	if exp.curOutputFile != nil {
+		err := exp.curOutputFile.Close()
+		if err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// Abort implements frundis.Aborter.
+func (exp *exporter) Abort() {
+	frundis.AbortOutputFile(exp.curOutputFile)
+}
+
+// closeDivisions closes divisions with header level greater than level.
+func (exp *exporter) closeDivisions(level int) {
+	w := exp.Context().Wout
+	for len(exp.levels) > 0 && exp.levels[len(exp.levels)-1] > level {
+		fmt.Fprint(w, "</div>\n")
+		exp.levels = exp.levels[:len(exp.levels)-1]
+	}
+}
+
+func (exp *exporter) BeginDescList(id string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<list type=\"gloss\"%s>\n", xmlID(id))
+}
+
+func (exp *exporter) BeginDescValue() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "<item>")
+}
+
+func (exp *exporter) BeginDialogue() {
+	if exp.verse {
+		return
+	}
+	w := exp.Context().W()
+	fmt.Fprint(w, "<said>")
+	exp.said = true
+}
+
+func (exp *exporter) BeginDisplayBlock(tag string, id string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	dtag, ok := ctx.Dtags[tag]
+	if !ok {
+		if id != "" {
+			fmt.Fprintf(w, "<anchor%s/>\n", xmlID(id))
+		}
+		return
+	}
+	fmt.Fprintf(w, "<%s type=\"%s\"", dtag.Cmd, html.EscapeString(tag))
+	writePairs(w, dtag.Pairs)
+	fmt.Fprintf(w, "%s>\n", xmlID(id))
+}
+
+func (exp *exporter) BeginEnumList(id string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<list rend=\"numbered\"%s>\n", xmlID(id))
+}
+
+func (exp *exporter) BeginHeader(macro string, numbered bool, title string) {
+	ctx := exp.Context()
+	level := ctx.Toc.HeaderLevel(macro)
+	exp.closeDivisions(level - 1)
+	w := ctx.W()
+	toc := ctx.LoXstack["toc"]
+	entry := toc[ctx.Toc.HeaderCount-1] // headers count is updated before
+	fmt.Fprintf(w, "<div type=\"%s\"%s", divisionType(macro), xmlID(entry.Ref[1:]))
+	if numbered {
+		fmt.Fprintf(w, " n=\"%s\"", entry.Num)
+	}
+	fmt.Fprint(w, ">\n<head>")
+	exp.levels = append(exp.levels, level)
+}
+
+func (exp *exporter) BeginItem() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "<item>")
+}
+
+func (exp *exporter) BeginEnumItem() {
+	exp.BeginItem()
+}
+
+func (exp *exporter) BeginItemList(id string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<list rend=\"bulleted\"%s>\n", xmlID(id))
+}
+
+func (exp *exporter) BeginMarkupBlock(tag string, id string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	mtag, ok := ctx.Mtags[tag]
+	if !ok {
+		fmt.Fprintf(w, "<emph%s>", xmlID(id))
+		return
+	}
+	fmt.Fprintf(w, "<%s rend=\"%s\"", mtag.Cmd, html.EscapeString(tag))
+	writePairs(w, mtag.Pairs)
+	fmt.Fprintf(w, "%s>", xmlID(id))
+	fmt.Fprint(w, mtag.Begin)
+}
+
+func (exp *exporter) BeginParagraph() {
+	ctx := exp.Context()
+	w := ctx.W()
+	switch {
+	case exp.table:
+	case exp.verse:
+		if !exp.stanza {
+			fmt.Fprint(w, "<lg type=\"stanza\">\n")
+			exp.stanza = true
+		}
+	default:
+		fmt.Fprint(w, "<p>")
+	}
+}
+
+func (exp *exporter) BeginTable(tableinfo *frundis.TableData) {
+	ctx := exp.Context()
+	w := ctx.W()
+	if tableinfo.Title != "" {
+		fmt.Fprintf(w, "<table xml:id=\"tbl%d\">\n", ctx.Table.TitCount)
+		fmt.Fprintf(w, "<head>%s</head>\n", tableinfo.Title)
+	} else {
+		fmt.Fprintf(w, "<table%s>\n", xmlID(tableinfo.ID))
+	}
+	exp.table = true
+}
+
+func (exp *exporter) BeginTableCell() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "<cell>")
+}
+
+func (exp *exporter) BeginTableRow() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "<row>\n")
+}
+
+func (exp *exporter) BeginVerse(title string, id string) {
+	w := exp.Context().W()
+	if title != "" {
+		fmt.Fprintf(w, "<lg type=\"poem\" xml:id=\"poem%s\">\n", id)
+		fmt.Fprintf(w, "<head>%s</head>\n", title)
+	} else {
+		fmt.Fprintf(w, "<lg type=\"poem\"%s>\n", xmlID(id))
+	}
+	exp.verse = true
+}
+
+func (exp *exporter) BeginVerseLine() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "<l>")
+}
+
+func (exp *exporter) CrossReference(idf frundis.IDInfo, punct string) {
+	w := exp.Context().W()
+	switch idf.Type {
+	case frundis.NoID:
+		fmt.Fprintf(w, "%s%s", idf.Name, punct)
+	default:
+		fmt.Fprintf(w, "<ref target=\"%s\">%s</ref>%s", idf.Ref, idf.Name, punct)
+	}
+}
+
+func (exp *exporter) DescName(name string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<label>%s</label>\n", name)
+}
+
+func (exp *exporter) EndDescList() {
+	exp.EndItemList()
+}
+
+func (exp *exporter) EndDescValue() {
+	exp.EndItem()
+}
+
+func (exp *exporter) EndDisplayBlock(tag string) {
+	ctx := exp.Context()
+	dtag, ok := ctx.Dtags[tag]
+	if !ok {
+		return
+	}
+	w := ctx.W()
+	fmt.Fprintf(w, "</%s>\n", dtag.Cmd)
+}
+
+func (exp *exporter) EndEnumList() {
+	exp.EndItemList()
+}
+
+func (exp *exporter) EndEnumItem() {
+	exp.EndItem()
+}
+
+func (exp *exporter) EndHeader(macro string, numbered bool, title string) {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</head>\n")
+}
+
+func (exp *exporter) EndItemList() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</list>\n")
+}
+
+func (exp *exporter) EndItem() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</item>\n")
+}
+
+func (exp *exporter) EndMarkupBlock(tag string, id string, punct string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	mtag, ok := ctx.Mtags[tag]
+	if !ok {
+		fmt.Fprint(w, "</emph>")
+	} else {
+		fmt.Fprint(w, mtag.End)
+		fmt.Fprintf(w, "</%s>", mtag.Cmd)
+	}
+	fmt.Fprint(w, punct)
+}
+
+func (exp *exporter) EndParagraph(pbreak frundis.ParagraphBreak) {
+	w := exp.Context().W()
+	switch {
+	case pbreak == frundis.ParBreakForced:
+	case exp.table:
+	case exp.verse:
+		exp.EndStanza()
+	default:
+		if exp.said {
+			fmt.Fprint(w, "</said>")
+			exp.said = false
+		}
+		fmt.Fprint(w, "</p>\n")
+	}
+}
+
+func (exp *exporter) EndStanza() {
+	w := exp.Context().W()
+	if !exp.stanza {
+		return
+	}
+	fmt.Fprint(w, "</l>\n</lg>\n")
+	exp.stanza = false
+}
+
+func (exp *exporter) EndTable(tableinfo *frundis.TableData) {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</table>\n")
+	exp.table = false
+}
+
+func (exp *exporter) EndTableCell() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</cell>\n")
+}
+
+func (exp *exporter) EndTableRow() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</row>\n")
+}
+
+func (exp *exporter) EndVerse() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</lg>\n")
+	exp.verse = false
+}
+
+func (exp *exporter) EndVerseLine() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "</l>\n")
+}
+
+func (exp *exporter) FigureImage(image string, caption string, link string, alt string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	fmt.Fprintf(w, "<figure xml:id=\"fig%d\">\n", ctx.FigCount)
+	fmt.Fprintf(w, "<graphic url=\"%s\"/>\n", exp.target(image))
+	if caption != "" {
+		fmt.Fprintf(w, "<head>%s</head>\n", caption)
+	}
+	if alt != "" {
+		fmt.Fprintf(w, "<figDesc>%s</figDesc>\n", html.EscapeString(alt))
+	}
+	fmt.Fprint(w, "</figure>\n")
+}
+
+func (exp *exporter) GenRef(prefix string, id string, hasfile bool) string {
+	return fmt.Sprintf("#%s%s", prefix, id)
+}
+
+func (exp *exporter) HeaderReference(macro string) string {
+	ctx := exp.Context()
+	if ctx.IDX != "" {
+		return exp.GenRef("", ctx.IDX, false)
+	}
+	return exp.GenRef("s", strconv.Itoa(ctx.Toc.HeaderCount), false)
+}
+
+func (exp *exporter) InlineImage(image string, link string, id string, punct string, alt string) {
+	w := exp.Context().W()
+	if link != "" {
+		fmt.Fprintf(w, "<ref target=\"%s\">", exp.target(link))
+	}
+	if alt != "" {
+		fmt.Fprintf(w, "<figure%s><graphic url=\"%s\"/><figDesc>%s</figDesc></figure>",
+			xmlID(id), exp.target(image), html.EscapeString(alt))
+	} else {
+		fmt.Fprintf(w, "<graphic url=\"%s\"%s/>", exp.target(image), xmlID(id))
+	}
+	if link != "" {
+		fmt.Fprint(w, "</ref>")
+	}
+	fmt.Fprint(w, punct)
+}
+
+func (exp *exporter) LkWithLabel(uri string, label string, punct string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<ref target=\"%s\">%s</ref>%s", exp.target(uri), label, punct)
+}
+
+func (exp *exporter) LkWithoutLabel(uri string, punct string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<ptr target=\"%s\"/>%s", exp.target(uri), punct)
+}
+
+func (exp *exporter) Math(tex string, punct string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<formula notation=\"TeX\">%s</formula>%s", html.EscapeString(tex), punct)
+}
+
+func (exp *exporter) Note(text string, punct string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<note place=\"foot\">%s</note>%s", text, punct)
+}
+
+func (exp *exporter) ParagraphTitle(title string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "<p><label>%s</label>\n", title)
+}
+
+func (exp *exporter) RenderText(text []ast.Inline) string {
+	ctx := exp.Context()
+	switch ctx.Params["lang"] {
+	case "fr":
+		text = frundis.FrenchTypography(exp, text)
+	case "en":
+		text = frundis.EnglishTypography(exp, text)
+	}
+	return html.EscapeString(ctx.InlinesToText(text))
+}
+
+func (exp *exporter) TableOfContents(opts map[string][]ast.Inline, flags map[string]bool) {
+	w := exp.Context().W()
+	var typ string
+	switch {
+	case flags["lof"]:
+		typ = "figures"
+	case flags["lot"]:
+		typ = "tables"
+	case flags["lop"]:
+		typ = "poems"
+	default:
+		typ = "toc"
+	}
+	fmt.Fprintf(w, "<divGen type=\"%s\"/>\n", typ)
+}
+
+func (exp *exporter) Xdtag(cmd string, pairs []string) frundis.Dtag {
+	if cmd == "" {
+		cmd = "div"
+	}
+	return frundis.Dtag{Cmd: cmd, Pairs: pairs}
+}
+
+func (exp *exporter) Xmtag(cmd *string, begin string, end string, pairs []string) frundis.Mtag {
+	var c string
+	if cmd == nil || *cmd == "" {
+		c = "hi"
+	} else {
+		c = *cmd
+	}
+	return frundis.Mtag{Begin: begin, End: end, Cmd: c, Pairs: pairs}
+}
+
+// target returns an escaped URI reference.
+func (exp *exporter) target(uri string) string {
+	parsedURL, err := url.Parse(uri)
+	if err != nil {
+		exp.Context().Error("invalid url or path:", uri)
+		return ""
+	}
+	return html.EscapeString(parsedURL.String())
+}
@@ -0,0 +1,75 @@
+package tei
+
+import (
+	"fmt"
+	"html"
+	"io"
+)
+
+func divisionType(macro string) string {
+	var typ string
+	switch macro {
+	case "Pt":
+		typ = "part"
+	case "Ch":
+		typ = "chapter"
+	default:
+		typ = "section"
+	}
+	return typ
+}
+
+// xmlID returns an xml:id attribute for id, or an empty string if there is
+// no id.
+func xmlID(id string) string {
+	if id == "" {
+		return ""
+	}
+	return " xml:id=\"" + id + "\""
+}
+
+// writePairs writes "-a" key/value pairs as attributes.
+func writePairs(w io.Writer, pairs []string) {
+	for i := 0; i < len(pairs)-1; i += 2 {
+		fmt.Fprintf(w, " %s=\"%s\"", html.EscapeString(pairs[i]), html.EscapeString(pairs[i+1]))
+	}
+}
+
+func (exp *exporter) beginTEIDocument() {
+	ctx := exp.Context()
+	w := ctx.Wout
+	fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?>
+<TEI xmlns="http://www.tei-c.org/ns/1.0">
+<teiHeader>
+<fileDesc>
+<titleStmt>
+`)
+	fmt.Fprintf(w, "<title>%s</title>\n", ctx.Params["document-title"])
+	if author := ctx.Params["document-author"]; author != "" {
+		fmt.Fprintf(w, "<author>%s</author>\n", author)
+	}
+	fmt.Fprint(w, "</titleStmt>\n<publicationStmt>\n")
+	if date := ctx.Params["document-date"]; date != "" {
+		fmt.Fprintf(w, "<p><date>%s</date></p>\n", date)
+	} else {
+		fmt.Fprint(w, "<p>Unpublished.</p>\n")
+	}
+	fmt.Fprint(w, `</publicationStmt>
+<sourceDesc>
+<p>Born digital.</p>
+</sourceDesc>
+</fileDesc>
+</teiHeader>
+`)
+	if lang := ctx.Params["lang"]; lang != "" {
+		fmt.Fprintf(w, "<text xml:lang=\"%s\">\n", html.EscapeString(lang))
+	} else {
+		fmt.Fprint(w, "<text>\n")
+	}
+	fmt.Fprint(w, "<body>\n")
+}
+
+func (exp *exporter) endTEIDocument() {
+	w := exp.Context().Wout
+	fmt.Fprint(w, "</body>\n</text>\n</TEI>\n")
+}
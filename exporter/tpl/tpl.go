@@ -6,7 +6,6 @@ import (
 	"html"
 	"os"
 
-	"codeberg.org/anaseto/gofrundis/ast"
 	"codeberg.org/anaseto/gofrundis/escape"
 	"codeberg.org/anaseto/gofrundis/frundis"
 )
@@ -26,11 +25,10 @@ func NewExporter(opts *Options) frundis.Exporter {
 }
 
 type exporter struct {
-	Ctx           *frundis.Context
+	frundis.BaseRenderer
 	Format        string
 	OutputFile    string
 	curOutputFile *os.File
-	escape        func(string) string
 }
 
 func (exp *exporter) Init() {
@@ -44,12 +42,6 @@ func (exp *exporter) Init() {
 	case "latex":
 		ctx.Filters["escape"] = escape.LaTeX
 	}
-	f, ok := ctx.Filters["escape"]
-	if ok {
-		exp.escape = f
-	} else {
-		exp.escape = func(s string) string { return s }
-	}
 }
 
 func (exp *exporter) Reset() error {
@@ -80,31 +72,22 @@ func (exp *exporter) PostProcessing() {
 	}
 }
 
-func (exp *exporter) BeginDescList(id string) {
+// Abort implements frundis.Aborter.
+func (exp *exporter) Abort() {
+	frundis.AbortOutputFile(exp.curOutputFile)
 }
 
-func (exp *exporter) BeginDescValue() {
-}
+// Template mode only keeps text and markup tags: Renderer methods whose
+// default implementation in frundis.BaseRenderer writes some text are
+// overridden so as to write nothing.
 
 func (exp *exporter) BeginDialogue() {
 }
 
-func (exp *exporter) BeginDisplayBlock(tag string, id string) {
-}
-
-func (exp *exporter) BeginEnumList(id string) {
-}
-
-func (exp *exporter) BeginHeader(macro string, numbered bool, title string) {
-}
-
-func (exp *exporter) BeginItem() {
-}
-
 func (exp *exporter) BeginEnumItem() {
 }
 
-func (exp *exporter) BeginItemList(id string) {
+func (exp *exporter) BeginItem() {
 }
 
 func (exp *exporter) BeginMarkupBlock(tag string, id string) {
@@ -128,64 +111,27 @@ func (exp *exporter) BeginMarkupBlock(tag string, id string) {
 	}
 }
 
-func (exp *exporter) BeginParagraph() {
-}
-
-func (exp *exporter) BeginPhrasingMacroInParagraph(nospace bool) {
-	frundis.BeginPhrasingMacroInParagraph(exp, nospace)
-}
-
-func (exp *exporter) BeginTable(tableinfo *frundis.TableData) {
-}
-
 func (exp *exporter) BeginTableCell() {
 }
 
-func (exp *exporter) BeginTableRow() {
-}
-
 func (exp *exporter) BeginVerse(title string, id string) {
 }
 
-func (exp *exporter) BeginVerseLine() {
-}
-
-func (exp *exporter) CheckParamAssignement(param string, value string) bool {
-	return true
-	// XXX: nothing for now
-}
-
-func (exp *exporter) Context() *frundis.Context {
-	return exp.Ctx
-}
-
 func (exp *exporter) CrossReference(idf frundis.IDInfo, punct string) {
 }
 
 func (exp *exporter) DescName(name string) {
 }
 
-func (exp *exporter) EndDescList() {
-}
-
 func (exp *exporter) EndDescValue() {
 }
 
-func (exp *exporter) EndDisplayBlock(tag string) {
-}
-
-func (exp *exporter) EndEnumList() {
-}
-
 func (exp *exporter) EndEnumItem() {
 }
 
 func (exp *exporter) EndHeader(macro string, numbered bool, title string) {
 }
 
-func (exp *exporter) EndItemList() {
-}
-
 func (exp *exporter) EndItem() {
 }
 
@@ -224,36 +170,15 @@ func (exp *exporter) EndStanza() {
 	exp.EndParagraph(frundis.ParBreakNormal)
 }
 
-func (exp *exporter) EndTable(tableinfo *frundis.TableData) {
-}
-
-func (exp *exporter) EndTableCell() {
-}
-
 func (exp *exporter) EndTableRow() {
 }
 
-func (exp *exporter) EndVerse() {
-}
-
 func (exp *exporter) EndVerseLine() {
 }
 
-func (exp *exporter) FormatParagraph(text []byte) []byte {
-	return text
-}
-
 func (exp *exporter) FigureImage(image string, caption string, link string, alt string) {
 }
 
-func (exp *exporter) GenRef(prefix string, id string, hasfile bool) string {
-	return ""
-}
-
-func (exp *exporter) HeaderReference(macro string) string {
-	return ""
-}
-
 func (exp *exporter) InlineImage(image string, link string, id string, punct string, alt string) {
 }
 
@@ -266,16 +191,6 @@ func (exp *exporter) LkWithoutLabel(url string, punct string) {
 func (exp *exporter) ParagraphTitle(title string) {
 }
 
-func (exp *exporter) RenderText(text []ast.Inline) string {
-	return exp.escape(exp.Context().InlinesToText(text))
-}
-
-func (exp *exporter) TableOfContents(opts map[string][]ast.Inline, flags map[string]bool) {
-}
-
-func (exp *exporter) TableOfContentsInfos(flags map[string]bool) {
-}
-
 func (exp *exporter) Xdtag(cmd string, pairs []string) frundis.Dtag {
 	return frundis.Dtag{}
 }
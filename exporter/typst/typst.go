@@ -0,0 +1,487 @@
+package typst
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/escape"
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// Options gathers configuration for Typst exporter.
+type Options struct {
+	OutputFile string // name of output file
+	Standalone bool   // generate complete document with set rules
+}
+
+// NewExporter returns a frundis.Exporter suitable to produce Typst.
+// See type Options for options.
+func NewExporter(opts *Options) frundis.Exporter {
+	return &exporter{
+		OutputFile: opts.OutputFile,
+		Standalone: opts.Standalone}
+}
+
+func init() {
+	frundis.RegisterFormat("typst", "", func(opts *frundis.FormatOptions) frundis.Exporter {
+		return NewExporter(&Options{
+			OutputFile: opts.OutputFile,
+			Standalone: opts.Standalone})
+	})
+	frundis.SetFormatExtension("typst", ".typ")
+}
+
+type exporter struct {
+	frundis.BaseRenderer
+	OutputFile    string
+	Standalone    bool
+	curOutputFile *os.File
+	blockIDs      []string // ids of currently open display blocks
+	nesting       int      // list nesting indentation
+	table         bool     // whether inside a table
+	verse         bool     // whether inside a poem
+}
+
+func (exp *exporter) Init() {
+	ctx := &frundis.Context{Wout: bufio.NewWriter(os.Stdout), Format: "typst"}
+	exp.Ctx = ctx
+	ctx.Init()
+	ctx.Filters["escape"] = escape.Typst
+}
+
+func (exp *exporter) Reset() error {
+	ctx := exp.Context()
+	ctx.Reset()
+	if exp.OutputFile != "" {
+		var err error
+		exp.curOutputFile, err = os.Create(exp.OutputFile)
+		if err != nil {
+			return fmt.Errorf("%v\n", err)
+		}
+	}
+	if exp.curOutputFile == nil {
+		exp.curOutputFile = os.Stdout
+	}
+	ctx.Wout = bufio.NewWriter(exp.curOutputFile)
+	if exp.Standalone {
+		exp.beginTypstDocument()
+	}
+	return nil
+}
+
+func (exp *exporter) PostProcessing() {
+	ctx := exp.Context()
+	ctx.Wout.Flush()
+	if exp.curOutputFile != nil {
+		err := exp.curOutputFile.Close()
+		if err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// Abort implements frundis.Aborter.
+func (exp *exporter) Abort() {
+	frundis.AbortOutputFile(exp.curOutputFile)
+}
+
+// anchor writes a metadata anchor with label id, if any.
+func (exp *exporter) anchor(id string) {
+	if id == "" {
+		return
+	}
+	w := exp.Context().W()
+	fmt.Fprintf(w, "#metadata(none) <%s>\n", id)
+}
+
+func (exp *exporter) BeginDescList(id string) {
+	exp.BeginItemList(id)
+}
+
+func (exp *exporter) BeginDialogue() {
+	ctx := exp.Context()
+	dmark, ok := ctx.Params["dmark"]
+	if !ok {
+		dmark = "—"
+	} else {
+		dmark = escape.Typst(dmark)
+	}
+	w := ctx.W()
+	fmt.Fprint(w, dmark)
+}
+
+func (exp *exporter) BeginDisplayBlock(tag string, id string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	dtag, ok := ctx.Dtags[tag]
+	var cmd string
+	if ok {
+		cmd = dtag.Cmd
+	}
+	if cmd == "" && id != "" {
+		cmd = "block"
+	}
+	exp.blockIDs = append(exp.blockIDs, id)
+	if cmd == "" {
+		return
+	}
+	fmt.Fprintf(w, "#%s", cmd)
+	writePairs(w, dtag.Pairs)
+	fmt.Fprint(w, "[\n")
+}
+
+func (exp *exporter) BeginEnumList(id string) {
+	exp.BeginItemList(id)
+}
+
+func (exp *exporter) BeginHeader(macro string, numbered bool, title string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	level := ctx.Toc.HeaderLevel(macro)
+	if numbered {
+		fmt.Fprintf(w, "%s ", strings.Repeat("=", level))
+	} else {
+		fmt.Fprintf(w, "#heading(level: %d, numbering: none)[", level)
+	}
+}
+
+func (exp *exporter) BeginItem() {
+	w := exp.Context().W()
+	fmt.Fprint(w, strings.Repeat(" ", exp.nesting-2), "- ")
+}
+
+func (exp *exporter) BeginEnumItem() {
+	w := exp.Context().W()
+	fmt.Fprint(w, strings.Repeat(" ", exp.nesting-2), "+ ")
+}
+
+func (exp *exporter) BeginItemList(id string) {
+	w := exp.Context().W()
+	if exp.nesting > 0 {
+		fmt.Fprint(w, "\n")
+	} else {
+		exp.anchor(id)
+	}
+	exp.nesting += 2
+}
+
+func (exp *exporter) BeginMarkupBlock(tag string, id string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	mtag, ok := ctx.Mtags[tag]
+	if !ok {
+		fmt.Fprint(w, "#emph[")
+		return
+	}
+	fmt.Fprintf(w, "#%s", mtag.Cmd)
+	writePairs(w, mtag.Pairs)
+	fmt.Fprint(w, "[")
+	fmt.Fprint(w, mtag.Begin)
+}
+
+func (exp *exporter) BeginTable(tableinfo *frundis.TableData) {
+	w := exp.Context().W()
+	if tableinfo.Title != "" {
+		fmt.Fprintf(w, "#figure(table(columns: %d,\n", tableinfo.Cols)
+	} else {
+		fmt.Fprintf(w, "#table(columns: %d,\n", tableinfo.Cols)
+	}
+	exp.table = true
+}
+
+func (exp *exporter) BeginTableCell() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "[")
+}
+
+func (exp *exporter) BeginVerse(title string, id string) {
+	w := exp.Context().W()
+	if title != "" {
+		fmt.Fprintf(w, "#strong[%s] <poem%s>\n\n", title, id)
+	} else {
+		exp.anchor(id)
+	}
+	fmt.Fprint(w, "#block(inset: (left: 2em))[\n")
+	exp.verse = true
+}
+
+func (exp *exporter) CrossReference(idf frundis.IDInfo, punct string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	switch idf.Type {
+	case frundis.NoID:
+		fmt.Fprintf(w, "%s%s", idf.Name, punct)
+		return
+	case frundis.HeaderID, frundis.FigureID, frundis.TableID:
+		for _, info := range ctx.IDs {
+			if info.Ref == idf.Ref && info.Name == idf.Name {
+				// no explicit link text
+				fmt.Fprintf(w, "@%s%s", idf.Ref, punct)
+				return
+			}
+		}
+	}
+	fmt.Fprintf(w, "#link(<%s>)[%s]%s", idf.Ref, idf.Name, punct)
+}
+
+func (exp *exporter) DescName(name string) {
+	w := exp.Context().W()
+	fmt.Fprint(w, strings.Repeat(" ", exp.nesting-2), "/ ", name, ": ")
+}
+
+func (exp *exporter) EndDescList() {
+	exp.EndItemList()
+}
+
+func (exp *exporter) EndDescValue() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "\n")
+}
+
+func (exp *exporter) EndDisplayBlock(tag string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	var id string
+	if len(exp.blockIDs) > 0 {
+		id = exp.blockIDs[len(exp.blockIDs)-1]
+		exp.blockIDs = exp.blockIDs[:len(exp.blockIDs)-1]
+	}
+	if ctx.Dtags[tag].Cmd == "" && id == "" {
+		return
+	}
+	fmt.Fprint(w, "]")
+	if id != "" {
+		fmt.Fprintf(w, " <%s>", id)
+	}
+	fmt.Fprint(w, "\n\n")
+}
+
+func (exp *exporter) EndEnumList() {
+	exp.EndItemList()
+}
+
+func (exp *exporter) EndEnumItem() {
+	exp.EndItem()
+}
+
+func (exp *exporter) EndHeader(macro string, numbered bool, title string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	if !numbered {
+		fmt.Fprint(w, "]")
+	}
+	toc := ctx.LoXstack["toc"]
+	entry := toc[ctx.Toc.HeaderCount-1] // headers count is updated before
+	fmt.Fprintf(w, " <%s>\n\n", entry.Ref)
+}
+
+func (exp *exporter) EndItemList() {
+	w := exp.Context().W()
+	exp.nesting -= 2
+	if exp.nesting == 0 {
+		fmt.Fprint(w, "\n")
+	}
+}
+
+func (exp *exporter) EndItem() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "\n")
+}
+
+func (exp *exporter) EndMarkupBlock(tag string, id string, punct string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	mtag, ok := ctx.Mtags[tag]
+	if ok {
+		fmt.Fprint(w, mtag.End)
+	}
+	fmt.Fprint(w, "]")
+	if id != "" {
+		fmt.Fprintf(w, "<%s>", id)
+	}
+	fmt.Fprint(w, punct)
+}
+
+func (exp *exporter) EndParagraph(pbreak frundis.ParagraphBreak) {
+	w := exp.Context().W()
+	switch pbreak {
+	case frundis.ParBreakForced:
+	case frundis.ParBreakItem:
+	case frundis.ParBreakBlock:
+		if exp.table || exp.nesting > 0 {
+			return
+		}
+		fmt.Fprint(w, "\n")
+	default:
+		if exp.table {
+			return
+		}
+		if exp.nesting > 0 {
+			fmt.Fprint(w, "\n\n", strings.Repeat(" ", exp.nesting))
+			return
+		}
+		fmt.Fprint(w, "\n\n")
+	}
+}
+
+func (exp *exporter) EndStanza() {
+	exp.EndParagraph(frundis.ParBreakNormal)
+}
+
+func (exp *exporter) EndTable(tableinfo *frundis.TableData) {
+	ctx := exp.Context()
+	w := ctx.W()
+	if tableinfo.Title != "" {
+		fmt.Fprintf(w, "), caption: [%s]) <tbl%d>\n\n", tableinfo.Title, ctx.Table.TitCount)
+	} else {
+		fmt.Fprint(w, ")")
+		if tableinfo.ID != "" {
+			fmt.Fprintf(w, " <%s>", tableinfo.ID)
+		}
+		fmt.Fprint(w, "\n\n")
+	}
+	exp.table = false
+}
+
+func (exp *exporter) EndTableCell() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "], ")
+}
+
+func (exp *exporter) EndTableRow() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "\n")
+}
+
+func (exp *exporter) EndVerse() {
+	w := exp.Context().W()
+	fmt.Fprint(w, "]\n\n")
+	exp.verse = false
+}
+
+func (exp *exporter) EndVerseLine() {
+	w := exp.Context().W()
+	fmt.Fprint(w, " \\\n")
+}
+
+func (exp *exporter) FormatParagraph(text []byte) []byte {
+	if exp.nesting == 0 {
+		return text
+	}
+	// indent continuation lines of list items
+	return bytes.Replace(text, []byte("\n"), []byte("\n"+strings.Repeat(" ", exp.nesting)), -1)
+}
+
+func (exp *exporter) FigureImage(image string, caption string, link string, alt string) {
+	ctx := exp.Context()
+	w := ctx.W()
+	fmt.Fprintf(w, "#figure(image(\"%s\"", escape.TypstString(image))
+	if alt != "" {
+		fmt.Fprintf(w, ", alt: \"%s\"", escape.TypstString(alt))
+	}
+	fmt.Fprint(w, ")")
+	if caption != "" {
+		fmt.Fprintf(w, ", caption: [%s]", caption)
+	}
+	fmt.Fprintf(w, ") <fig%d>\n\n", ctx.FigCount)
+}
+
+func (exp *exporter) GenRef(prefix string, id string, hasfile bool) string {
+	return prefix + id
+}
+
+func (exp *exporter) HeaderReference(macro string) string {
+	return exp.GenRef("s", strconv.Itoa(exp.Context().Toc.HeaderCount), false)
+}
+
+func (exp *exporter) InlineImage(image string, link string, id string, punct string, alt string) {
+	w := exp.Context().W()
+	if link != "" {
+		fmt.Fprintf(w, "#link(\"%s\")[", escape.TypstString(link))
+	}
+	fmt.Fprintf(w, "#box(image(\"%s\"", escape.TypstString(image))
+	if alt != "" {
+		fmt.Fprintf(w, ", alt: \"%s\"", escape.TypstString(alt))
+	}
+	fmt.Fprint(w, "))")
+	if link != "" {
+		fmt.Fprint(w, "]")
+	}
+	if id != "" {
+		fmt.Fprintf(w, "<%s>", id)
+	}
+	fmt.Fprint(w, punct)
+}
+
+func (exp *exporter) LkWithLabel(uri string, label string, punct string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "#link(\"%s\")[%s]%s", escape.TypstString(uri), label, punct)
+}
+
+func (exp *exporter) LkWithoutLabel(uri string, punct string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "#link(\"%s\")%s", escape.TypstString(uri), punct)
+}
+
+func (exp *exporter) Note(text string, punct string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "#footnote[%s]%s", text, punct)
+}
+
+func (exp *exporter) ParagraphTitle(title string) {
+	w := exp.Context().W()
+	fmt.Fprintf(w, "#strong[%s]\n", title)
+}
+
+func (exp *exporter) RenderText(text []ast.Inline) string {
+	ctx := exp.Context()
+	switch ctx.Params["lang"] {
+	case "fr":
+		text = frundis.FrenchTypography(exp, text)
+	case "en":
+		text = frundis.EnglishTypography(exp, text)
+	}
+	return escape.Typst(ctx.InlinesToText(text))
+}
+
+func (exp *exporter) TableOfContents(opts map[string][]ast.Inline, flags map[string]bool) {
+	ctx := exp.Context()
+	w := ctx.W()
+	var title string
+	if t, ok := opts["title"]; ok {
+		title = fmt.Sprintf("title: [%s], ", exp.RenderText(t))
+	}
+	switch {
+	case flags["lof"]:
+		fmt.Fprintf(w, "#outline(%starget: figure.where(kind: image))\n\n", title)
+	case flags["lot"]:
+		fmt.Fprintf(w, "#outline(%starget: figure.where(kind: table))\n\n", title)
+	case flags["lop"]:
+		ctx.Error("list of poems not available for Typst")
+	default:
+		depth := 4
+		if flags["summary"] {
+			depth = ctx.Toc.HeaderLevel("Ch")
+		}
+		fmt.Fprintf(w, "#outline(%sdepth: %d)\n\n", title, depth)
+	}
+}
+
+func (exp *exporter) Xdtag(cmd string, pairs []string) frundis.Dtag {
+	return frundis.Dtag{Cmd: cmd, Pairs: pairs}
+}
+
+func (exp *exporter) Xmtag(cmd *string, begin string, end string, pairs []string) frundis.Mtag {
+	var c string
+	if cmd == nil || *cmd == "" {
+		c = "emph"
+	} else {
+		c = *cmd
+	}
+	return frundis.Mtag{Begin: begin, End: end, Cmd: c, Pairs: pairs}
+}
@@ -0,0 +1,71 @@
+package typst
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"codeberg.org/anaseto/gofrundis/escape"
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// writePairs writes "-a" key/value pairs as raw Typst named arguments.
+func writePairs(w io.Writer, pairs []string) {
+	if len(pairs) < 2 {
+		return
+	}
+	fmt.Fprint(w, "(")
+	for i := 0; i < len(pairs)-1; i += 2 {
+		if i > 0 {
+			fmt.Fprint(w, ", ")
+		}
+		fmt.Fprintf(w, "%s: %s", pairs[i], pairs[i+1])
+	}
+	fmt.Fprint(w, ")")
+}
+
+func (exp *exporter) beginTypstDocument() {
+	ctx := exp.Context()
+	w := ctx.Wout
+	title := ctx.Params["document-title"]
+	author := ctx.Params["document-author"]
+	date := ctx.Params["document-date"]
+	if preamble := ctx.Params["typst-preamble"]; preamble != "" {
+		p, ok := frundis.SearchIncFile(exp, preamble)
+		if !ok {
+			ctx.Errorf("typst preamble: %s: no such file", preamble)
+		} else {
+			source, err := os.ReadFile(p)
+			if err != nil {
+				ctx.Error(err)
+			} else {
+				w.Write(source)
+				exp.titlePage(title, author, date)
+				return
+			}
+		}
+	}
+	fmt.Fprintf(w, "#set document(title: [%s])\n", title)
+	if lang := ctx.Params["lang"]; lang != "" {
+		fmt.Fprintf(w, "#set text(lang: \"%s\")\n", escape.TypstString(lang))
+	}
+	fmt.Fprint(w, "#set heading(numbering: \"1.\")\n\n")
+	exp.titlePage(title, author, date)
+}
+
+func (exp *exporter) titlePage(title, author, date string) {
+	ctx := exp.Context()
+	if !frundis.IsTrue(ctx.Params["title-page"]) {
+		return
+	}
+	w := ctx.Wout
+	fmt.Fprint(w, "#align(center)[\n")
+	fmt.Fprintf(w, "  #text(size: 2em)[%s]\n\n", title)
+	if author != "" {
+		fmt.Fprintf(w, "  %s\n\n", author)
+	}
+	if date != "" {
+		fmt.Fprintf(w, "  %s\n", date)
+	}
+	fmt.Fprint(w, "]\n\n")
+}
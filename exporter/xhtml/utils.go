@@ -459,3 +459,13 @@ func (exp *exporter) xhtmlTitlePage() {
 func escapeFilter(text string) string {
 	return html.EscapeString(text)
 }
+
+// srcAttr returns a data-src attribute with the source location of the
+// current block, if requested, or the empty string.
+func (exp *exporter) srcAttr() string {
+	if !exp.SourceLines {
+		return ""
+	}
+	file, line := exp.Context().SourceLocation()
+	return fmt.Sprintf(" data-src=\"%s:%d\"", html.EscapeString(file), line)
+}
@@ -20,6 +20,7 @@ type Options struct {
 	AllInOneFile bool   // output goes only to one html file
 	Format       string // "epub" or "xhtml"
 	OutputFile   string // name of output file or directory
+	SourceLines  bool   // annotate block elements with data-src="file:line"
 	Standalone   bool   // generate complete document with headers (default unless AllInOneFile)
 	Werror       io.Writer
 }
@@ -31,16 +32,32 @@ func NewExporter(opts *Options) frundis.Exporter {
 		AllInOneFile: opts.AllInOneFile,
 		Format:       opts.Format,
 		OutputFile:   opts.OutputFile,
+		SourceLines:  opts.SourceLines,
 		Standalone:   opts.Standalone,
 		Werror:       opts.Werror}
 }
 
+func init() {
+	for format, ext := range map[string]string{"xhtml": ".html", "epub": ".epub"} {
+		frundis.RegisterFormat(format, "html", func(opts *frundis.FormatOptions) frundis.Exporter {
+			return NewExporter(&Options{
+				Format:       opts.Format,
+				OutputFile:   opts.OutputFile,
+				SourceLines:  opts.SourceMap,
+				Standalone:   opts.Standalone,
+				AllInOneFile: opts.AllInOneFile})
+		})
+		frundis.SetFormatExtension(format, ext)
+	}
+}
+
 type exporter struct {
 	Ctx                 *frundis.Context
 	Format              string // "epub" or "xhtml"
 	AllInOneFile        bool
 	Standalone          bool
 	OutputFile          string
+	SourceLines         bool
 	Werror              io.Writer
 	curOutputFile       *os.File
 	xhtmlNavigationText *bytes.Buffer
@@ -164,6 +181,12 @@ func (exp *exporter) PostProcessing() {
 	}
 }
 
+// Abort implements frundis.Aborter. Only the file being written is removed
+// when producing several files.
+func (exp *exporter) Abort() {
+	frundis.AbortOutputFile(exp.curOutputFile)
+}
+
 func (exp *exporter) BeginDescList(id string) {
 	ctx := exp.Context()
 	w := ctx.W()
@@ -237,7 +260,7 @@ func (exp *exporter) BeginHeader(macro string, numbered bool, title string) {
 	toc := ctx.LoXstack["toc"]
 	entry := toc[ctx.Toc.HeaderCount-1] // headers count is updated before
 	id := exp.getID(entry)
-	fmt.Fprintf(w, "<h%d class=\"%s\" id=\"%s\">", num, macro, id)
+	fmt.Fprintf(w, "<h%d class=\"%s\" id=\"%s\"%s>", num, macro, id, exp.srcAttr())
 	if numbered {
 		fmt.Fprintf(w, "%s ", entry.Num)
 	}
@@ -245,12 +268,12 @@ func (exp *exporter) BeginHeader(macro string, numbered bool, title string) {
 
 func (exp *exporter) BeginItem() {
 	w := exp.Context().W()
-	fmt.Fprint(w, "<li>")
+	fmt.Fprintf(w, "<li%s>", exp.srcAttr())
 }
 
 func (exp *exporter) BeginEnumItem() {
 	w := exp.Context().W()
-	fmt.Fprint(w, "<li>")
+	fmt.Fprintf(w, "<li%s>", exp.srcAttr())
 }
 
 func (exp *exporter) BeginItemList(id string) {
@@ -285,7 +308,7 @@ func (exp *exporter) BeginMarkupBlock(tag string, id string) {
 
 func (exp *exporter) BeginParagraph() {
 	w := exp.Context().W()
-	fmt.Fprint(w, "<p>")
+	fmt.Fprintf(w, "<p%s>", exp.srcAttr())
 }
 
 func (exp *exporter) BeginPhrasingMacroInParagraph(nospace bool) {
@@ -301,7 +324,7 @@ func (exp *exporter) BeginTable(tableinfo *frundis.TableData) {
 	} else if tableinfo.ID != "" {
 		id = " id=\"" + tableinfo.ID + "\""
 	}
-	fmt.Fprintf(w, "<table%s>\n", id)
+	fmt.Fprintf(w, "<table%s%s>\n", id, exp.srcAttr())
 }
 
 func (exp *exporter) BeginTableCell() {
@@ -374,7 +397,7 @@ func (exp *exporter) CrossReference(idf frundis.IDInfo, punct string) {
 
 func (exp *exporter) DescName(name string) {
 	w := exp.Context().W()
-	fmt.Fprintf(w, "<dt>%s</dt>\n", name)
+	fmt.Fprintf(w, "<dt%s>%s</dt>\n", exp.srcAttr(), name)
 }
 
 func (exp *exporter) EndDescList() {
@@ -665,7 +688,7 @@ func (exp *exporter) LkWithoutLabel(uri string, punct string) {
 func (exp *exporter) ParagraphTitle(title string) {
 	ctx := exp.Context()
 	w := ctx.W()
-	fmt.Fprintf(w, "<p class=\"paragraph\"><strong class=\"paragraph\">%s</strong>\n", title)
+	fmt.Fprintf(w, "<p class=\"paragraph\"%s><strong class=\"paragraph\">%s</strong>\n", exp.srcAttr(), title)
 }
 
 func (exp *exporter) RenderText(text []ast.Inline) string {
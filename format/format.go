@@ -0,0 +1,297 @@
+// Package format implements canonical formatting of frundis source.
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/frundis"
+	"codeberg.org/anaseto/gofrundis/parser"
+)
+
+// Options gathers formatting options.
+type Options struct {
+	Source        string // for error messages location information (e.g. filename)
+	SentenceLines bool   // put each sentence of text on its own line
+}
+
+// Source formats frundis source src in canonical form: macro arguments are
+// quoted only when needed, options of builtin macros are written in a
+// canonical order, trailing spaces and runs of blank lines in text are
+// removed, and comments are kept. Text in Bf format blocks is kept as-is.
+// Source returns an error if src does not parse cleanly.
+func Source(src []byte, opts *Options) ([]byte, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	var werror bytes.Buffer
+	p := &parser.Parser{Source: opts.Source, Werror: &werror, Comments: true}
+	blocks, err := p.ParseWithReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, fmt.Errorf("frundis:%s: %v", opts.Source, err)
+	}
+	if werror.Len() > 0 {
+		return nil, errors.New(strings.TrimRight(werror.String(), "\n"))
+	}
+	pr := &printer{opts: opts, rawMacros: make(map[string]bool)}
+	pr.blocks(blocks)
+	out := bytes.TrimLeft(pr.buf.Bytes(), "\n")
+	out = bytes.TrimRight(out, "\n")
+	if len(out) > 0 {
+		out = append(out, '\n')
+	}
+	return out, nil
+}
+
+// printer writes canonical frundis source.
+type printer struct {
+	buf       bytes.Buffer
+	opts      *Options
+	raw       bool            // within a Bf format block
+	def       string          // name of user macro being defined
+	defRaw    int             // Bf count minus Ef count in definition
+	rawMacros map[string]bool // user macros opening (true) or closing (false) format blocks
+}
+
+func (pr *printer) blocks(blocks []ast.Block) {
+	for i, b := range blocks {
+		switch b := b.(type) {
+		case *ast.Macro:
+			pr.macro(b)
+			line := []ast.Block{&ast.Macro{Name: b.Name, Args: pr.args(b)}}
+			if i+1 < len(blocks) {
+				if c, ok := blocks[i+1].(*ast.Comment); ok && c.Trailing {
+					line = append(line, &ast.Comment{Text: strings.TrimRight(c.Text, " \t"), Trailing: true})
+				}
+			}
+			ast.Fprint(&pr.buf, line)
+		case *ast.Comment:
+			if b.Trailing {
+				// already written with the macro
+				continue
+			}
+			ast.Fprint(&pr.buf, []ast.Block{&ast.Comment{Text: strings.TrimRight(b.Text, " \t")}})
+		case *ast.TextBlock:
+			pr.textBlock(b)
+		}
+	}
+}
+
+// macro updates the printer state for macro m.
+func (pr *printer) macro(m *ast.Macro) {
+	switch m.Name {
+	case "Bf":
+		pr.raw = true
+		pr.defRaw++
+	case "Ef":
+		pr.raw = false
+		pr.defRaw--
+	case "#de":
+		pr.def = ""
+		if len(m.Args) > 0 {
+			pr.def, _ = plainText(m.Args[len(m.Args)-1])
+		}
+		pr.defRaw = 0
+	case "#.":
+		if pr.def != "" && pr.defRaw != 0 {
+			pr.rawMacros[pr.def] = pr.defRaw > 0
+		}
+		pr.def = ""
+		pr.raw = false
+	default:
+		if raw, ok := pr.rawMacros[m.Name]; ok {
+			pr.raw = raw
+		}
+	}
+}
+
+// option represents an option of a macro with its eventual argument.
+type option struct {
+	name string
+	args [][]ast.Inline
+}
+
+// args returns the arguments of m, with options of builtin macros in
+// canonical order: first -f, then -t, and then the others in alphabetical
+// order.
+func (pr *printer) args(m *ast.Macro) [][]ast.Inline {
+	name := m.Name
+	args := m.Args
+	var head [][]ast.Inline
+	if name == "X" && len(args) > 0 {
+		cmd, ok := plainText(args[0])
+		if !ok {
+			return m.Args
+		}
+		name += " " + cmd
+		head, args = args[:1], args[1:]
+	}
+	spec, ok := frundis.BuiltinOptions(name)
+	if !ok {
+		return m.Args
+	}
+	var opts []option
+	for len(args) > 0 {
+		arg := args[0]
+		if len(arg) == 0 {
+			break
+		}
+		if t, ok := arg[0].(ast.Text); !ok || !strings.HasPrefix(string(t), "-") {
+			break
+		}
+		flag, ok := plainText(arg)
+		if !ok {
+			return m.Args
+		}
+		kind, ok := spec[flag[1:]]
+		if !ok {
+			return m.Args
+		}
+		n := 1
+		if kind == frundis.ArgOption {
+			if len(args) < 2 {
+				return m.Args
+			}
+			n = 2
+		}
+		opts = append(opts, option{name: flag[1:], args: args[:n]})
+		args = args[n:]
+	}
+	rank := func(name string) int {
+		switch name {
+		case "f":
+			return 0
+		case "t":
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(opts, func(i, j int) bool {
+		ri, rj := rank(opts[i].name), rank(opts[j].name)
+		if ri != rj {
+			return ri < rj
+		}
+		return ri == 2 && opts[i].name < opts[j].name
+	})
+	nargs := make([][]ast.Inline, 0, len(m.Args))
+	nargs = append(nargs, head...)
+	for _, opt := range opts {
+		nargs = append(nargs, opt.args...)
+	}
+	return append(nargs, args...)
+}
+
+// plainText returns the text of arg if it is made of text only.
+func plainText(arg []ast.Inline) (string, bool) {
+	var sb strings.Builder
+	for _, in := range arg {
+		t, ok := in.(ast.Text)
+		if !ok {
+			return "", false
+		}
+		sb.WriteString(string(t))
+	}
+	return sb.String(), true
+}
+
+func (pr *printer) textBlock(b *ast.TextBlock) {
+	if pr.raw {
+		ast.Fprint(&pr.buf, []ast.Block{b})
+		return
+	}
+	var sb strings.Builder
+	ast.Fprint(&sb, []ast.Block{b})
+	text := strings.TrimSuffix(sb.String(), "\n")
+	blank := false
+	for _, line := range strings.Split(text, "\n") {
+		body, comment := line, ""
+		if i := strings.Index(line, `\"`); i >= 0 {
+			body, comment = line[:i], strings.TrimRight(line[i:], " \t")
+		} else {
+			body = strings.TrimRight(body, " \t")
+		}
+		if body == "" && comment == "" {
+			if blank {
+				continue
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+		if pr.opts.SentenceLines {
+			body = splitSentences(body)
+		}
+		pr.buf.WriteString(body)
+		pr.buf.WriteString(comment)
+		pr.buf.WriteByte('\n')
+	}
+}
+
+// splitSentences puts each sentence of a line of text on its own line. A
+// sentence ends with a period, a question mark or an exclamation mark,
+// eventually followed by closing punctuation, and the next one starts with an
+// upper case letter, eventually preceded by opening punctuation.
+func splitSentences(line string) string {
+	var sb strings.Builder
+	i := 0
+	for j := 0; j < len(line); {
+		r, size := utf8.DecodeRuneInString(line[j:])
+		j += size
+		if r != '.' && r != '?' && r != '!' {
+			continue
+		}
+		if r == '.' && isInitial(line[:j-size]) {
+			continue
+		}
+		for j < len(line) {
+			r, size := utf8.DecodeRuneInString(line[j:])
+			if !strings.ContainsRune(`)]"'’»`, r) {
+				break
+			}
+			j += size
+		}
+		k := j
+		for k < len(line) && (line[k] == ' ' || line[k] == '\t') {
+			k++
+		}
+		if k == j || k == len(line) {
+			continue
+		}
+		if !startsSentence(line[k:]) {
+			continue
+		}
+		sb.WriteString(line[i:j])
+		sb.WriteByte('\n')
+		i, j = k, k
+	}
+	sb.WriteString(line[i:])
+	return sb.String()
+}
+
+// startsSentence reports whether s starts with an upper case letter,
+// eventually preceded by opening punctuation.
+func startsSentence(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune(`(["'«‘“¿¡`, r) {
+			return unicode.IsUpper(r)
+		}
+	}
+	return false
+}
+
+// isInitial reports whether s ends with a lone upper case letter, as in a
+// name initial.
+func isInitial(s string) bool {
+	r, size := utf8.DecodeLastRuneInString(s)
+	if !unicode.IsUpper(r) {
+		return false
+	}
+	r, _ = utf8.DecodeLastRuneInString(s[:len(s)-size])
+	return len(s) == size || unicode.IsSpace(r)
+}
@@ -0,0 +1,124 @@
+package format
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/parser"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		src, want string
+		sentences bool
+	}{
+		{".Sh   \"Title\"\n", ".Sh Title\n", false},
+		{".Sh \"Two words\" \"\" \"\"\"q\" a\"b\n", ".Sh \"Two words\" \"\" \"\"\"q\" a\"b\n", false},
+		{".Bl -id x -columns 2 -t table\n", ".Bl -t table -columns 2 -id x\n", false},
+		{".X mtag -t em -c i -f xhtml\n", ".X mtag -f xhtml -t em -c i\n", false},
+		{".Bl -unknown -t x\n", ".Bl -unknown -t x\n", false},
+		{".Sm -t x a \\\n b\n", ".Sm -t x a b\n", false},
+		{"\n\nText  \n\n\n\nmore\n\n\n.P\n\n", "Text\n\nmore\n\n.P\n", false},
+		{".\\\" comment  \n.P \\\" trailing\ntext \\\" inline\n", ".\\\" comment\n.P \\\" trailing\ntext \\\" inline\n", false},
+		{".Bf -f xhtml\n<pre>  \n\n\n</pre>\n.Ef\n", ".Bf -f xhtml\n<pre>  \n\n\n</pre>\n.Ef\n", false},
+		{"One. Two? (Three!) four. J. Smith. Five\n", "One.\nTwo?\n(Three!) four.\nJ. Smith.\nFive\n", true},
+		{"\\e\\&\\~\\*[v]\\$1\\$[a]\\$?[f]\\$@\n", "\\e\\&\\~\\*[v]\\$1\\$[a]\\$?[f]\\$@\n", false},
+	}
+	for _, test := range tests {
+		got, err := Source([]byte(test.src), &Options{SentenceLines: test.sentences})
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%q: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	_, err := Source([]byte(".Sh \"unterminated\n"), &Options{Source: "f"})
+	if err == nil || !strings.Contains(err.Error(), "unterminated quoted argument") {
+		t.Errorf("expected error, got %v", err)
+	}
+}
+
+// TestFixtures checks that formatting is idempotent and does not change
+// macros and text, apart from whitespace in text.
+func TestFixtures(t *testing.T) {
+	files, err := filepath.Glob("../testdata/data/*.frundis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		src, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sentences := range []bool{false, true} {
+			opts := &Options{Source: f, SentenceLines: sentences}
+			res, err := Source(src, opts)
+			if err != nil {
+				t.Errorf("%s: %v", f, err)
+				continue
+			}
+			again, err := Source(res, opts)
+			if err != nil {
+				t.Errorf("%s: reformatting: %v", f, err)
+				continue
+			}
+			if string(again) != string(res) {
+				t.Errorf("%s: formatting is not idempotent", f)
+			}
+			want := normalize(t, string(src))
+			got := normalize(t, string(res))
+			if len(got) != len(want) {
+				t.Errorf("%s: got %d blocks, want %d", f, len(got), len(want))
+				continue
+			}
+			for i := range want {
+				if !reflect.DeepEqual(got[i], want[i]) {
+					t.Errorf("%s: block %d: got %#v, want %#v", f, i, got[i], want[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+// normalize parses src and returns its blocks without line information and
+// with whitespace collapsed in text, sorting macro options.
+func normalize(t *testing.T, src string) []ast.Block {
+	p := &parser.Parser{}
+	blocks, err := p.ParseString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := &printer{opts: &Options{}}
+	var res []ast.Block
+	for _, b := range blocks {
+		switch b := b.(type) {
+		case *ast.Macro:
+			res = append(res, &ast.Macro{Name: b.Name, Args: pr.args(b)})
+		case *ast.TextBlock:
+			var text []ast.Inline
+			for _, in := range b.Text {
+				if s, ok := in.(ast.Text); ok {
+					in = ast.Text(strings.Join(strings.Fields(string(s)), " "))
+					if in == ast.Text("") {
+						continue
+					}
+				}
+				text = append(text, in)
+			}
+			if len(text) > 0 {
+				res = append(res, &ast.TextBlock{Text: text})
+			}
+		}
+	}
+	return res
+}
@@ -4,7 +4,6 @@ package frundis
 
 import (
 	"bytes"
-	"os"
 	"strings"
 
 	"codeberg.org/anaseto/gofrundis/ast"
@@ -20,7 +19,8 @@ func macroDefStart(exp Exporter) {
 		}
 		return
 	}
-	opts, _, args := ctx.ParseOptions(specOptDef, ctx.Args)
+	defaults, required, rest := ctx.scanDefOptions(ctx.Args)
+	opts, _, args := ctx.ParseOptions(specOptDef, rest)
 	if len(args) < 1 {
 		if ctx.Process {
 			ctx.Error("'.#de' requires name argument")
@@ -36,12 +36,79 @@ func macroDefStart(exp Exporter) {
 		}
 	}
 	name := ctx.InlinesToText(args[0])
+	var desc string
+	if d, ok := opts["desc"]; ok {
+		desc = ctx.InlinesToText(d)
+	}
 	ctx.uMacroDef = &uMacroDefInfo{
-		name:   name,
-		blocks: []ast.Block{},
-		line:   ctx.line,
-		ignore: ignore,
-		file:   ctx.loc.curFile}
+		name:     name,
+		blocks:   []ast.Block{},
+		line:     ctx.line,
+		ignore:   ignore,
+		file:     ctx.loc.curFile,
+		defaults: defaults,
+		required: required,
+		desc:     desc}
+}
+
+// scanDefOptions extracts the -default and -required options of a "#de"
+// macro, which may be repeated, and returns the remaining arguments.
+func (ctx *Context) scanDefOptions(args [][]ast.Inline) (map[string][]ast.Inline, []string, [][]ast.Inline) {
+	var defaults map[string][]ast.Inline
+	var required []string
+	rest := [][]ast.Inline{}
+	for len(args) > 0 {
+		if len(args[0]) == 0 {
+			break
+		}
+		t, ok := args[0][0].(ast.Text)
+		if !ok || len(t) == 0 || t[0] != '-' {
+			break
+		}
+		name := ctx.InlinesToText(args[0])[1:]
+		if specOptDef[name] != ArgOption || len(args) < 2 {
+			rest = append(rest, args[0])
+			args = args[1:]
+			continue
+		}
+		if name != "default" && name != "required" {
+			rest = append(rest, args[:2]...)
+			args = args[2:]
+			continue
+		}
+		arg := args[1]
+		args = args[2:]
+		if name == "required" {
+			for _, r := range strings.Split(ctx.InlinesToText(arg), ",") {
+				if r != "" {
+					required = append(required, r)
+				}
+			}
+			continue
+		}
+		t, ok = arg[0].(ast.Text)
+		var i int
+		if ok {
+			i = strings.IndexByte(string(t), '=')
+		}
+		if !ok || i <= 0 {
+			if ctx.Process {
+				ctx.Error("invalid -default argument: expected name=value")
+			}
+			continue
+		}
+		value := []ast.Inline{}
+		if i+1 < len(t) {
+			value = append(value, t[i+1:])
+		}
+		value = append(value, arg[1:]...)
+		if defaults == nil {
+			defaults = make(map[string][]ast.Inline)
+		}
+		defaults[string(t[:i])] = value
+	}
+	rest = append(rest, args...)
+	return defaults, required, rest
 }
 
 func macroDefEnd(exp Exporter) {
@@ -57,12 +124,38 @@ func macroDefEnd(exp Exporter) {
 		return
 	}
 	if !ctx.uMacroDef.ignore {
-		ctx.uMacroDef.argsc, ctx.uMacroDef.list, ctx.uMacroDef.opts = ctx.searchArgInBlocks(ctx.uMacroDef.blocks)
-		ctx.uMacros[ctx.uMacroDef.name] = ctx.uMacroDef
+		m := ctx.uMacroDef
+		m.argsc, m.list, m.opts = ctx.searchArgInBlocks(m.blocks)
+		ctx.declareDefOptions(m)
+		ctx.uMacros[m.name] = m
 	}
 	ctx.uMacroDef = nil
 }
 
+// declareDefOptions adds named arguments with a default value or required to
+// the option specification of user macro m, even if they are not used in its
+// definition.
+func (ctx *Context) declareDefOptions(m *uMacroDefInfo) {
+	declare := func(name, what string) {
+		if opt, ok := m.opts[name]; ok && opt == FlagOption {
+			if ctx.Process {
+				ctx.Errorf("%s flag: -%s", what, name)
+			}
+			return
+		}
+		m.opts[name] = ArgOption
+	}
+	for name := range m.defaults {
+		declare(name, "default value for")
+	}
+	for _, name := range m.required {
+		declare(name, "required")
+		if _, ok := m.defaults[name]; ok && ctx.Process {
+			ctx.Errorf("required option with default value: -%s", name)
+		}
+	}
+}
+
 // searchArgInBlocks returns the greatest number N of an $N argument, whether
 // $@ is used, as well as a specification of macro options found.
 func (ctx *Context) searchArgInBlocks(blocks []ast.Block) (int, bool, map[string]Option) {
@@ -146,51 +239,59 @@ func (ctx *Context) searchArgInText(text []ast.Inline, opts map[string]Option) (
 func macroIfStart(exp Exporter) {
 	// macro .#if
 	ctx := exp.Context()
-	ctx.pushScope(&scope{kind: scopeIf})
+	ctx.pushScope(&scope{kind: scopeIf, macro: "#if"})
 	if ctx.ifIgnoreDepth > 0 {
 		ctx.ifIgnoreDepth++
 		return
 	}
-	opts, flags, args := ctx.ParseOptions(specOptIf, ctx.Args)
-	if len(args) > 1 && ctx.Process {
-		ctx.Error("too many arguments")
+	ctx.enterIfBranch(ctx.ifCondition())
+}
+
+func macroElif(exp Exporter) {
+	// macro .#elif
+	ctx := exp.Context()
+	s := ctx.ifScope()
+	if s == nil {
+		return
 	}
-	ignore := false
-	fmt, okf := opts["f"]
-	if okf {
-		formats := strings.Split(ctx.InlinesToText(fmt), ",")
-		if ctx.Process {
-			ctx.checkFormats(formats)
-		}
-		ignore = ignore || ctx.notExportFormat(formats)
+	if s.elseScope != nil && ctx.Process {
+		ctx.Error("`.#elif' after `.#else'", ctx.scopeLocation(s.elseScope))
 	}
-	cmpstr, okeq := opts["eq"]
-	if okeq {
-		if len(args) == 0 {
-			if ctx.Process {
-				ctx.Error("compare string argument required")
-			}
-		} else {
-			ignore = ignore || ctx.InlinesToText(cmpstr) != ctx.InlinesToText(args[0])
-		}
+	switch {
+	case ctx.ifIgnoreDepth > 1:
+		// in an ignored enclosing conditional
+	case s.ifTaken:
+		ctx.ifIgnoreDepth = 1
+	default:
+		ctx.enterIfBranch(ctx.ifCondition())
 	}
-	if !okeq && !okf && len(args) == 0 {
-		if ctx.Process {
-			ctx.Error("boolean argument required")
-		}
+}
+
+func macroElse(exp Exporter) {
+	// macro .#else
+	ctx := exp.Context()
+	if len(ctx.Args) > 0 && ctx.Process {
+		ctx.Error("useless arguments")
 	}
-	if len(args) > 0 && !okeq {
-		switch ctx.InlinesToText(args[0]) {
-		case "0", "":
-			ignore = true
-		}
+	s := ctx.ifScope()
+	if s == nil {
+		return
 	}
-	if flags["not"] {
-		ignore = !ignore
+	if s.elseScope != nil {
+		if ctx.Process {
+			ctx.Error("`.#else' already found", ctx.scopeLocation(s.elseScope))
+		}
+	} else {
+		s.elseScope = &scope{kind: scopeIf, macro: "#else"}
+		ctx.setScopeLocation(s.elseScope)
 	}
-	ctx.ifIgnoreDepth = 0
-	if ignore {
+	switch {
+	case ctx.ifIgnoreDepth > 1:
+		// in an ignored enclosing conditional
+	case s.ifTaken:
 		ctx.ifIgnoreDepth = 1
+	default:
+		ctx.enterIfBranch(true)
 	}
 }
 
@@ -209,10 +310,76 @@ func macroIfEnd(exp Exporter) {
 	}
 }
 
+// ifScope returns the scope of the innermost "#if", reporting an error if
+// there is none.
+func (ctx *Context) ifScope() *scope {
+	st := ctx.scopes[scopeIf]
+	if len(st) == 0 {
+		if ctx.Process {
+			ctx.Error("no corresponding `.#if'")
+		}
+		return nil
+	}
+	return st[len(st)-1]
+}
+
+// enterIfBranch starts processing or ignoring a branch of the innermost
+// "#if", depending on cond.
+func (ctx *Context) enterIfBranch(cond bool) {
+	if cond {
+		ctx.ifIgnoreDepth = 0
+		ctx.ifScope().ifTaken = true
+	} else {
+		ctx.ifIgnoreDepth = 1
+	}
+}
+
+// ifCondition evaluates the condition of a "#if" or "#elif" macro.
+func (ctx *Context) ifCondition() bool {
+	opts, flags, args := ctx.ParseOptions(specOptIf, ctx.Args)
+	cond := true
+	fmt, okf := opts["f"]
+	if okf {
+		formats := strings.Split(ctx.InlinesToText(fmt), ",")
+		if ctx.Process {
+			ctx.checkFormats(formats)
+		}
+		cond = !ctx.notExportFormat(formats)
+	}
+	cmpstr, okeq := opts["eq"]
+	switch {
+	case okeq:
+		if len(args) == 0 {
+			if ctx.Process {
+				ctx.Error("compare string argument required")
+			}
+			break
+		}
+		if len(args) > 1 && ctx.Process {
+			ctx.Error("too many arguments")
+		}
+		cond = cond && ctx.InlinesToText(cmpstr) == ctx.InlinesToText(args[0])
+	case len(args) > 0:
+		v, err := ctx.evalCond(args)
+		if err != nil && ctx.Process {
+			ctx.Error("invalid condition:", err)
+		}
+		cond = cond && v
+	case !okf:
+		if ctx.Process {
+			ctx.Error("boolean argument required")
+		}
+	}
+	if flags["not"] {
+		cond = !cond
+	}
+	return cond
+}
+
 func macroDefVar(exp Exporter) {
 	// macro .#dv
 	ctx := exp.Context()
-	opts, _, args := ctx.ParseOptions(specOptDefVar, ctx.Args)
+	opts, flags, args := ctx.ParseOptions(specOptDefVar, ctx.Args)
 	if len(args) == 0 {
 		if ctx.Process {
 			ctx.Error("name argument required")
@@ -230,6 +397,9 @@ func macroDefVar(exp Exporter) {
 	}
 	name := ctx.InlinesToText(args[0])
 	args = args[1:]
+	if ctx.defCounter(name, opts, flags, args) {
+		return
+	}
 	buf := bytes.Buffer{}
 	for i, arg := range args {
 		if i > 0 {
@@ -237,7 +407,105 @@ func macroDefVar(exp Exporter) {
 		}
 		buf.WriteString(ctx.InlinesToText(arg))
 	}
-	ctx.ivars[name] = buf.String()
+	ctx.setVar(name, buf.String())
+}
+
+// Limits for "#for" loops.
+const (
+	maxForDepth      = 42    // maximum depth of nested loop expansions
+	maxForIterations = 10000 // maximum number of iterations of an outermost loop
+)
+
+func macroForStart(exp Exporter) {
+	// macro .#for
+	ctx := exp.Context()
+	opts, _, args := ctx.ParseOptions(specOptFor, ctx.Args)
+	loop := &forLoopInfo{
+		file:   ctx.loc.curFile,
+		line:   ctx.line,
+		blocks: []ast.Block{}}
+	// the body is collected even in case of error, up to the matching
+	// .#done
+	ctx.forLoop = loop
+	if len(args) < 2 || ctx.InlinesToText(args[1]) != "in" {
+		if ctx.Process {
+			ctx.Error("name and `in' arguments required")
+		}
+		return
+	}
+	loop.name = ctx.InlinesToText(args[0])
+	sep, okSep := opts["sep"]
+	for _, arg := range args[2:] {
+		value := ctx.InlinesToText(arg)
+		if !okSep {
+			loop.values = append(loop.values, value)
+			continue
+		}
+		for _, v := range strings.Split(value, ctx.InlinesToText(sep)) {
+			v = strings.TrimSpace(v)
+			if v != "" {
+				loop.values = append(loop.values, v)
+			}
+		}
+	}
+}
+
+func macroForEnd(exp Exporter) {
+	// macro .#done
+	ctx := exp.Context()
+	if len(ctx.Args) > 0 && ctx.Process {
+		ctx.Error("useless arguments")
+	}
+	loop := ctx.forLoop
+	if loop == nil {
+		if ctx.Process {
+			ctx.Error("found `.#done' without previous `.#for'")
+		}
+		return
+	}
+	ctx.forLoop = nil
+	if loop.name == "" {
+		return
+	}
+	if ctx.forDepth >= maxForDepth {
+		if ctx.Process {
+			ctx.Error("nested loops: too much depth (infinite recursive calls?)")
+		}
+		return
+	}
+	value, defined := ctx.ivars[loop.name]
+	c, isCounter := ctx.counters[loop.name]
+	oloc := ctx.loc
+	ctx.forDepth++
+	defer func() {
+		ctx.forDepth--
+		if ctx.forDepth == 0 {
+			ctx.forIterations = 0
+		}
+		ctx.loc = oloc
+		if defined {
+			ctx.ivars[loop.name] = value
+		} else {
+			delete(ctx.ivars, loop.name)
+		}
+		if isCounter {
+			ctx.counters[loop.name] = c
+		} else {
+			delete(ctx.counters, loop.name)
+		}
+	}()
+	for _, v := range loop.values {
+		if ctx.forIterations >= maxForIterations {
+			if ctx.Process {
+				ctx.Errorf("`.#for' loop at line %d of file %s: too many iterations", loop.line, loop.file)
+			}
+			return
+		}
+		ctx.forIterations++
+		ctx.setVar(loop.name, v)
+		ctx.loc = &location{curBlocks: loop.blocks, curFile: loop.file}
+		processBlocks(exp)
+	}
 }
 
 func macroRun(exp Exporter) {
@@ -263,9 +531,7 @@ func macroRun(exp Exporter) {
 		ctx.Error("not enough arguments")
 		return
 	}
-	cmd := getCommand(sargs)
-	cmd.Stderr = os.Stderr
-	bytes, err := cmd.Output()
+	bytes, err := ctx.runCommand(sargs, nil)
 	if err != nil {
 		ctx.Errorf("shell command: %v: %s", sargs, err)
 		return
@@ -0,0 +1,65 @@
+// Compiled programs for repeated processing
+
+package frundis
+
+import (
+	"bytes"
+	"context"
+
+	"codeberg.org/anaseto/gofrundis/parser"
+)
+
+// Program is a parsed frundis source, that can be processed several times,
+// possibly concurrently, with distinct exporters. Parsed blocks of the main
+// file are shared between executions, so that only per-document processing
+// state is allocated on each execution. Files included during processing are
+// read again on each execution, so that changes to them are taken into
+// account.
+type Program struct {
+	filename string
+	main     *cachedFile // parsed main file
+}
+
+// Compile parses frundis source src. The filename is used in error messages.
+// Parser warnings are reported by each execution of the program.
+func Compile(filename string, src []byte) (*Program, error) {
+	f := &cachedFile{}
+	f.once.Do(func() {
+		var buf bytes.Buffer
+		p := parser.Parser{Werror: &buf, Source: filename}
+		f.blocks, f.err = p.ParseWithReader(bytes.NewReader(src))
+		f.warns = buf.Bytes()
+	})
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &Program{filename: filename, main: f}, nil
+}
+
+// CompileFile parses frundis file filename, like Compile.
+func CompileFile(filename string) (*Program, error) {
+	fc := &fileCache{files: make(map[string]*cachedFile)}
+	var buf bytes.Buffer
+	_, err := fc.parse(filename, &buf)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{filename: filename, main: fc.files[filename]}, nil
+}
+
+// Execute processes the program with a given exporter, as
+// ProcessFrundisSource does for a file. It is safe to call Execute from
+// several goroutines, as long as each uses its own exporter. In restricted
+// mode, no #run nor shell filter are allowed.
+func (prog *Program) Execute(exp Exporter, unrestricted bool) error {
+	return prog.ExecuteContext(context.Background(), exp, &ProcessOptions{Unrestricted: unrestricted})
+}
+
+// ExecuteContext is like Execute, with cancellation and processing options,
+// as in ProcessFrundisSourceContext.
+func (prog *Program) ExecuteContext(cctx context.Context, exp Exporter, opts *ProcessOptions) error {
+	fc := &fileCache{files: map[string]*cachedFile{prog.filename: prog.main}}
+	return processFrundisSource(cctx, exp, prog.filename, opts, func(ctx *Context) {
+		ctx.fileCache = fc
+	})
+}
@@ -0,0 +1,135 @@
+// Concurrent processing with several exporters
+
+package frundis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/parser"
+)
+
+// fileCache is a cache of parsed files shared by contexts processing the same
+// sources concurrently, so that each file is parsed only once. Parsed blocks
+// are not modified during processing, so they can be shared.
+type fileCache struct {
+	mu    sync.Mutex
+	files map[string]*cachedFile
+}
+
+// cachedFile represents a parsed file in a fileCache.
+type cachedFile struct {
+	once   sync.Once
+	blocks []ast.Block
+	warns  []byte // parser warnings
+	err    error
+}
+
+// parse returns the blocks of file filename, parsing it only the first time.
+// Parser warnings are written to werr each time, so that every context reports
+// them.
+func (fc *fileCache) parse(filename string, werr io.Writer) ([]ast.Block, error) {
+	fc.mu.Lock()
+	f, ok := fc.files[filename]
+	if !ok {
+		f = &cachedFile{}
+		fc.files[filename] = f
+	}
+	fc.mu.Unlock()
+	f.once.Do(func() {
+		var buf bytes.Buffer
+		p := parser.Parser{Werror: &buf}
+		f.blocks, f.err = p.ParseFile(filename)
+		f.warns = buf.Bytes()
+	})
+	werr.Write(f.warns)
+	return f.blocks, f.err
+}
+
+// ProcessFrundisSourceConcurrently processes a frundis file with several
+// exporters concurrently, as ProcessFrundisSource would do for each exporter.
+// Exporters should be for distinct formats, with isolated state and output
+// files. Source files are parsed only once. Diagnostics of all exporters are
+// written to werr without duplicates: a diagnostic that is not produced by
+// all the exporters is followed by the formats of the exporters producing it.
+// It returns the first error encountered, in the order of exporters.
+// Cancellation and processing options are as in ProcessFrundisSourceContext.
+func ProcessFrundisSourceConcurrently(cctx context.Context, exps []Exporter, filename string, opts *ProcessOptions, werr io.Writer) error {
+	fc := &fileCache{files: make(map[string]*cachedFile)}
+	bufs := make([]bytes.Buffer, len(exps))
+	errs := make([]error, len(exps))
+	var wg sync.WaitGroup
+	for i, exp := range exps {
+		wg.Add(1)
+		go func(i int, exp Exporter) {
+			defer wg.Done()
+			errs[i] = processFrundisSource(cctx, exp, filename, opts, func(ctx *Context) {
+				ctx.Werror = &bufs[i]
+				ctx.fileCache = fc
+			})
+		}(i, exp)
+	}
+	wg.Wait()
+	diags := make([]string, len(exps))
+	formats := make([]string, len(exps))
+	for i, exp := range exps {
+		diags[i] = bufs[i].String()
+		formats[i] = exp.Context().Format
+	}
+	writeDiagnostics(werr, formats, diags)
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDiagnostics writes to w the lines of diagnostics produced for each
+// format, without duplicates. Lines are merged so that the order of lines
+// for each format is preserved as much as possible. Lines not found for all
+// formats are followed by the list of formats for which they were found.
+func writeDiagnostics(w io.Writer, formats []string, diags []string) {
+	var lines []string
+	found := make(map[string][]string)
+	for i, d := range diags {
+		pos := 0 // insertion position for new lines
+		for _, line := range strings.SplitAfter(d, "\n") {
+			if line == "" {
+				continue
+			}
+			fmts, ok := found[line]
+			if !ok {
+				lines = append(lines, "")
+				copy(lines[pos+1:], lines[pos:])
+				lines[pos] = line
+				pos++
+			} else {
+				for j := pos; j < len(lines); j++ {
+					if lines[j] == line {
+						pos = j + 1
+						break
+					}
+				}
+			}
+			if len(fmts) == 0 || fmts[len(fmts)-1] != formats[i] {
+				found[line] = append(fmts, formats[i])
+			}
+		}
+	}
+	for _, line := range lines {
+		fmts := found[line]
+		if len(fmts) == len(diags) {
+			fmt.Fprint(w, line)
+			continue
+		}
+		sort.Strings(fmts)
+		fmt.Fprintf(w, "%s (formats: %s)\n", strings.TrimSuffix(line, "\n"), strings.Join(fmts, ", "))
+	}
+}
@@ -0,0 +1,286 @@
+// Conditional expressions for "#if" and "#elif"
+
+package frundis
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+)
+
+// condToken represents a token of a conditional expression.
+type condToken struct {
+	text    string
+	op      bool // whether token is an operator or parenthesis
+	literal bool // whether token contains interpolated or escaped text
+}
+
+// lexCond splits arguments into expression tokens. Interpolated variables
+// and escapes are always part of word tokens, so that their values are never
+// interpreted as operators or keywords. Counter variables are replaced by
+// their numeric value.
+func (ctx *Context) lexCond(args [][]ast.Inline) ([]condToken, error) {
+	var toks []condToken
+	for _, arg := range args {
+		n := len(toks)
+		var word strings.Builder
+		inWord, literal := false, false
+		flush := func() {
+			if inWord {
+				toks = append(toks, condToken{text: word.String(), literal: literal})
+				word.Reset()
+				inWord, literal = false, false
+			}
+		}
+		for _, in := range arg {
+			t, ok := in.(ast.Text)
+			if !ok {
+				word.WriteString(ctx.numericText(in))
+				inWord, literal = true, true
+				continue
+			}
+			s := string(t)
+			for len(s) > 0 {
+				var op string
+				switch {
+				case strings.HasPrefix(s, "&&"), strings.HasPrefix(s, "||"),
+					strings.HasPrefix(s, "=="), strings.HasPrefix(s, "!="),
+					strings.HasPrefix(s, "<="), strings.HasPrefix(s, ">="):
+					op = s[:2]
+				case s[0] == '(' || s[0] == ')' || s[0] == '!' || s[0] == '<' || s[0] == '>':
+					op = s[:1]
+				case s[0] == '&' || s[0] == '|' || s[0] == '=':
+					return nil, fmt.Errorf("invalid operator `%c'", s[0])
+				}
+				if op == "" {
+					word.WriteByte(s[0])
+					inWord = true
+					s = s[1:]
+					continue
+				}
+				flush()
+				toks = append(toks, condToken{text: op, op: true})
+				s = s[len(op):]
+			}
+		}
+		flush()
+		if len(toks) == n {
+			// empty argument
+			toks = append(toks, condToken{literal: true})
+		}
+	}
+	return toks, nil
+}
+
+// condParser evaluates a conditional expression. The grammar is as follows:
+//
+//	expr    = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | primary
+//	primary = "(" expr ")" | operand [ cmp operand | "in" list ]
+//	operand = "format" | "param" "(" name ")" | word
+//	cmp     = "==" | "!=" | "<" | "<=" | ">" | ">="
+//
+// A lone operand is true if it is neither empty nor "0". The list for "in" is
+// a comma-separated word: for "format", it may contain format families.
+type condParser struct {
+	ctx  *Context
+	toks []condToken
+	pos  int
+}
+
+// evalCond evaluates the conditional expression formed by args.
+func (ctx *Context) evalCond(args [][]ast.Inline) (bool, error) {
+	toks, err := ctx.lexCond(args)
+	if err != nil {
+		return false, err
+	}
+	p := &condParser{ctx: ctx, toks: toks}
+	v, err := p.expr()
+	if err != nil {
+		return false, err
+	}
+	if p.pos < len(p.toks) {
+		return false, fmt.Errorf("unexpected `%s'", p.toks[p.pos].text)
+	}
+	return v, nil
+}
+
+// peek reports whether next token is the given operator or keyword.
+func (p *condParser) peek(text string) bool {
+	if p.pos >= len(p.toks) {
+		return false
+	}
+	t := p.toks[p.pos]
+	return t.text == text && !t.literal
+}
+
+func (p *condParser) expect(text string) error {
+	if !p.peek(text) {
+		if p.pos >= len(p.toks) {
+			return fmt.Errorf("expected `%s' at end of expression", text)
+		}
+		return fmt.Errorf("expected `%s' but found `%s'", text, p.toks[p.pos].text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *condParser) expr() (bool, error) {
+	v, err := p.and()
+	if err != nil {
+		return false, err
+	}
+	for p.peek("||") {
+		p.pos++
+		w, err := p.and()
+		if err != nil {
+			return false, err
+		}
+		v = v || w
+	}
+	return v, nil
+}
+
+func (p *condParser) and() (bool, error) {
+	v, err := p.unary()
+	if err != nil {
+		return false, err
+	}
+	for p.peek("&&") {
+		p.pos++
+		w, err := p.unary()
+		if err != nil {
+			return false, err
+		}
+		v = v && w
+	}
+	return v, nil
+}
+
+func (p *condParser) unary() (bool, error) {
+	if p.peek("!") {
+		p.pos++
+		v, err := p.unary()
+		return !v, err
+	}
+	return p.primary()
+}
+
+func (p *condParser) primary() (bool, error) {
+	if p.peek("(") {
+		p.pos++
+		v, err := p.expr()
+		if err != nil {
+			return false, err
+		}
+		return v, p.expect(")")
+	}
+	isFormat := p.peek("format")
+	x, err := p.operand()
+	if err != nil {
+		return false, err
+	}
+	if p.peek("in") {
+		p.pos++
+		if p.pos >= len(p.toks) || p.toks[p.pos].op {
+			return false, errors.New("list expected after `in'")
+		}
+		list := strings.Split(p.toks[p.pos].text, ",")
+		p.pos++
+		if isFormat {
+			for _, f := range list {
+				if !p.ctx.isValidFormat(f) {
+					return false, fmt.Errorf("invalid format: %s%s", f, didYouMean(f, formatNames()))
+				}
+			}
+			return !p.ctx.notExportFormat(list), nil
+		}
+		for _, s := range list {
+			if s == x {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if p.pos >= len(p.toks) || !p.toks[p.pos].op {
+		return x != "" && x != "0", nil
+	}
+	op := p.toks[p.pos].text
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return x != "" && x != "0", nil
+	}
+	p.pos++
+	y, err := p.operand()
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case "==":
+		return x == y, nil
+	case "!=":
+		return x != y, nil
+	}
+	a, errx := strconv.ParseFloat(x, 64)
+	b, erry := strconv.ParseFloat(y, 64)
+	if errx != nil || erry != nil {
+		// string comparison
+		switch op {
+		case "<":
+			return x < y, nil
+		case "<=":
+			return x <= y, nil
+		case ">":
+			return x > y, nil
+		default:
+			return x >= y, nil
+		}
+	}
+	switch op {
+	case "<":
+		return a < b, nil
+	case "<=":
+		return a <= b, nil
+	case ">":
+		return a > b, nil
+	default:
+		return a >= b, nil
+	}
+}
+
+func (p *condParser) operand() (string, error) {
+	if p.pos >= len(p.toks) {
+		return "", errors.New("unexpected end of expression")
+	}
+	t := p.toks[p.pos]
+	if t.op {
+		return "", fmt.Errorf("unexpected `%s'", t.text)
+	}
+	p.pos++
+	if t.literal {
+		return t.text, nil
+	}
+	switch t.text {
+	case "format":
+		return p.ctx.Format, nil
+	case "param":
+		if err := p.expect("("); err != nil {
+			return "", err
+		}
+		if p.pos >= len(p.toks) || p.toks[p.pos].op {
+			return "", errors.New("parameter name expected")
+		}
+		name := p.toks[p.pos].text
+		p.pos++
+		if !isParam(name) {
+			return "", fmt.Errorf("unknown parameter: %s%s", name, didYouMean(name, ParamNames))
+		}
+		return p.ctx.Params[name], p.expect(")")
+	}
+	return t.text, nil
+}
@@ -5,9 +5,12 @@ package frundis
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"codeberg.org/anaseto/gofrundis/ast"
 )
@@ -25,7 +28,7 @@ type BaseExporter interface {
 }
 
 // Renderer is an interface regrouping rendering methods common to all
-// exporters.
+// exporters. Exporters can embed BaseRenderer to get default implementations.
 type Renderer interface {
 	// BeginDescList starts a description list (e.g. prints to Context.W a "<dl>").
 	BeginDescList(id string)
@@ -158,9 +161,62 @@ type Exporter interface {
 	Xmtag(cmd *string, begin string, end string, pairs []string) Mtag
 }
 
-// Context gathers main context information for Exporter.
+// RawRenderer is an optional interface that an Exporter can satisfy to embed
+// as-is text targeted at other formats (e.g. pandoc raw blocks), instead of
+// ignoring it.
+type RawRenderer interface {
+	// RawFormat returns the name under which as-is text for format
+	// should be embedded, or false if it should be ignored.
+	RawFormat(format string) (string, bool)
+	// RawText writes as-is text for a raw format returned by RawFormat.
+	RawText(format string, text string)
+}
+
+// NoteRenderer is an optional interface that an Exporter can satisfy to
+// render notes as footnotes. No builtin macro provides notes: it is meant for
+// Go macros registered with RegisterMacro, that should check for it with a
+// type assertion, and write notes inline otherwise.
+type NoteRenderer interface {
+	// Note writes a note with already rendered text, followed by
+	// punctuation punct.
+	Note(text string, punct string)
+}
+
+// MathRenderer is an optional interface that an Exporter can satisfy to
+// render TeX formulas. No builtin macro provides formulas: it is meant for Go
+// macros registered with RegisterMacro, that should check for it with a type
+// assertion, and write the TeX source as text otherwise.
+type MathRenderer interface {
+	// Math writes an inline formula given in TeX syntax, followed by
+	// punctuation punct.
+	Math(tex string, punct string)
+}
+
+// TextLocator is an optional interface that an Exporter can satisfy to learn
+// the source location of paragraph text, which is not passed to any Renderer
+// method. LocateText is called during the process pass just before writing
+// the rendered text of a text block to the current paragraph, so that
+// SourceLocation returns the location of the text. It is not called for text
+// in macro arguments, such as titles.
+type TextLocator interface {
+	LocateText()
+}
+
+// Aborter is an optional interface that an Exporter can satisfy to release
+// its resources when processing fails or is canceled after Reset. Abort is
+// then called instead of PostProcessing, and should close the output and
+// remove partially written files. Exporters that do not satisfy it are
+// finalized with PostProcessing.
+type Aborter interface {
+	Abort()
+}
+
+// Context gathers main context information for Exporter. It holds the state
+// of one document processing, and is not safe for concurrent use: each
+// goroutine should use its own exporter.
 type Context struct {
 	Args          [][]ast.Inline                 // current macro args
+	Dir           string                         // directory for relative file names (default current directory)
 	Dtags         map[string]Dtag                // display block tags set with "X dtag"
 	FigCount      int                            // current figure number
 	Filters       map[string]func(string) string // function filters
@@ -178,6 +234,7 @@ type Context struct {
 	Params        map[string]string              // parameters set with "X set"
 	PrevMacro     string                         // previous non-user macro called, or "" for text-block
 	Process       bool                           // whether in processing or info pass
+	SourceMap     *SourceMap                     // source map of output (optional, set by exporter)
 	Table         TableInfo                      // table information
 	Toc           *TocInfo                       // Toc information
 	Unrestricted  bool                           // unrestricted mode (#run and shell filters allowed)
@@ -191,21 +248,30 @@ type Context struct {
 	bufa2t        bytes.Buffer                   // buffer to avoid allocations
 	bufi2t        bytes.Buffer                   // buffer to avoid allocations
 	bufra         bytes.Buffer                   // buffer to avoid allocations
+	cctx          context.Context                // cancellation context (optional)
+	counters      map[string]*counter            // numeric variables
+	execMaxOutput int64                          // maximum output size of external commands (if positive)
+	execTimeout   time.Duration                  // maximum duration of external commands (if positive)
+	fileCache     *fileCache                     // parsed files shared with other contexts (optional)
 	files         map[string]([]ast.Block)       // parsed files
+	forDepth      int                            // depth of nested "#for" loop expansions
+	forIterations int                            // number of "#for" iterations in current outermost loop
+	forLoop       *forLoopInfo                   // information related to "#for" loop body collection
 	frundisINC    []string                       // list of paths where to search for frundis source files
 	ifIgnoreDepth int                            // depth of "#if" blocks with false condition
 	ivars         map[string]string              // interpolation variables
 	line          int                            // current/last block source line
 	loc           *location                      // source location information
 	parScope      bool                           // whether currently inside a paragraph or not
+	preprocess    *preprocessor                  // preprocess-only mode information (if any)
 	verseScope    bool                           // whether currently inside a verse or not
 	rawText       bytes.Buffer                   // buffer for currently accumulated raw text (as-is text of Bf/Ef)
 	scopes        map[scopeKind]([]*scope)       // scopes
+	sources       map[string][]byte              // source contents by absolute file name, used instead of files on disk
 	text          []ast.Inline                   // current/last text block text
 	uMacroCall    *uMacroCallInfo                // information related to user macro call
 	uMacroDef     *uMacroDefInfo                 // information related to user macro definition
 	uMacros       map[string]*uMacroDefInfo      // user defined textual macros
-	validFormats  []string                       // list of valid export formats
 	quiet         bool                           // Do not print errors to Werror
 }
 
@@ -224,14 +290,27 @@ type uMacroCallInfo struct {
 
 // User macro definition information
 type uMacroDefInfo struct {
-	file   string // file where macro is defined
-	line   int    // .#de
-	name   string // new macro name
-	ignore bool   // whether definition has to be ignored
-	argsc  int    // argument count
-	opts   map[string]Option
-	blocks []ast.Block // list of blocks defining the new macro
-	list   bool        // whether $@ is present
+	file     string // file where macro is defined
+	line     int    // .#de
+	name     string // new macro name
+	ignore   bool   // whether definition has to be ignored
+	argsc    int    // argument count
+	opts     map[string]Option
+	blocks   []ast.Block             // list of blocks defining the new macro
+	list     bool                    // whether $@ is present
+	defaults map[string][]ast.Inline // default values of named arguments
+	required []string                // required named arguments
+	desc     string                  // one-line description
+}
+
+// Loop information
+type forLoopInfo struct {
+	file   string      // file where loop starts
+	line   int         // .#for
+	name   string      // loop variable name
+	values []string    // values taken by the loop variable
+	blocks []ast.Block // loop body
+	depth  int         // depth of nested "#for" in body
 }
 
 // VerseInfo gathers verse information.
@@ -290,6 +369,7 @@ const (
 
 // IDInfo gathers identifier information.
 type IDInfo struct {
+	ID   string // identifier, as given in the source
 	Ref  string
 	Name string
 	Type IDType
@@ -301,6 +381,7 @@ type bfInfo struct {
 	ignore      bool   // whether this format block should be ignored
 	inUserMacro bool   // whether "Bf" was invoked through user macro
 	line        int    // line where "Bf" was invoked
+	rawFormat   string // raw format for RawRenderer (if any)
 }
 
 // Mtag represents tags set with "X mtag".
@@ -331,7 +412,7 @@ func (ctx *Context) Init() {
 	ctx.scopes = make(map[scopeKind]([]*scope))
 	ctx.uMacros = make(map[string]*uMacroDefInfo)
 	ctx.ivars = make(map[string]string)
-	ctx.validFormats = []string{"markdown", "xhtml", "latex", "epub", "mom"}
+	ctx.counters = make(map[string]*counter)
 	if ctx.files == nil {
 		ctx.files = make(map[string]([]ast.Block))
 	}
@@ -343,7 +424,7 @@ func (ctx *Context) Init() {
 		ctx.Ftags = make(map[string]Ftag)
 		ctx.IDs = make(map[string]IDInfo)
 		ctx.LoXstack = make(map[string][]*LoXinfo)
-		ctx.Macros = DefaultExporterMacros()
+		ctx.Macros = defaultMacros()
 		ctx.Mtags = make(map[string]Mtag)
 		ctx.Params = make(map[string]string)
 		ctx.Toc = &TocInfo{}
@@ -363,27 +444,61 @@ func (ctx *Context) Init() {
 func (ctx *Context) Reset() {
 	tableinfo := ctx.Table.info
 	*ctx = Context{
-		Dtags:        ctx.Dtags,
-		Filters:      ctx.Filters,
-		Format:       ctx.Format,
-		Ftags:        ctx.Ftags,
-		IDs:          ctx.IDs,
-		Images:       ctx.Images,
-		LoXstack:     ctx.LoXstack,
-		Macros:       ctx.Macros,
-		Mtags:        ctx.Mtags,
-		Params:       ctx.Params,
-		Unrestricted: ctx.Unrestricted,
-		Toc:          ctx.Toc,
-		Wout:         ctx.Wout,
-		Werror:       ctx.Werror,
-		files:        ctx.files}
+		Dir:           ctx.Dir,
+		Dtags:         ctx.Dtags,
+		Filters:       ctx.Filters,
+		Format:        ctx.Format,
+		Ftags:         ctx.Ftags,
+		IDs:           ctx.IDs,
+		Images:        ctx.Images,
+		LoXstack:      ctx.LoXstack,
+		Macros:        ctx.Macros,
+		Mtags:         ctx.Mtags,
+		Params:        ctx.Params,
+		Unrestricted:  ctx.Unrestricted,
+		Toc:           ctx.Toc,
+		Wout:          ctx.Wout,
+		Werror:        ctx.Werror,
+		cctx:          ctx.cctx,
+		execMaxOutput: ctx.execMaxOutput,
+		execTimeout:   ctx.execTimeout,
+		fileCache:     ctx.fileCache,
+		files:         ctx.files,
+		sources:       ctx.sources}
 	ctx.Table.info = tableinfo
 	ctx.Toc.resetCounters()
 	ctx.Process = true
 	ctx.Init()
 }
 
+// Path returns file name filename, relative to ctx.Dir if it is a relative
+// file name. Exporters should use it for files given in the source, such as
+// images.
+func (ctx *Context) Path(filename string) string {
+	if ctx.Dir == "" || filepath.IsAbs(filename) {
+		return filename
+	}
+	return filepath.Join(ctx.Dir, filename)
+}
+
+// canceled reports whether processing has been canceled.
+func (ctx *Context) canceled() bool {
+	return ctx.cctx != nil && ctx.cctx.Err() != nil
+}
+
+// SetSource makes src the contents of file filename during processing,
+// instead of the contents of the file on disk (for example for unsaved editor
+// buffers). It should be called after exporter initialization.
+func (ctx *Context) SetSource(filename string, src []byte) {
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	if ctx.sources == nil {
+		ctx.sources = make(map[string][]byte)
+	}
+	ctx.sources[filename] = src
+}
+
 // W returns a writer to be used in place of ctx.W in macro methods.
 func (ctx *Context) W() io.Writer {
 	switch {
@@ -0,0 +1,354 @@
+// Numeric counter variables
+
+package frundis
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+)
+
+// counter represents a numeric variable defined with "#dv".
+type counter struct {
+	value  int
+	format string // arabic, roman, Roman, alpha or Alpha
+}
+
+// counterFormats lists the valid counter formats.
+var counterFormats = []string{"arabic", "roman", "Roman", "alpha", "Alpha"}
+
+// setVar sets interpolation variable name to a string value.
+func (ctx *Context) setVar(name string, value string) {
+	delete(ctx.counters, name)
+	ctx.ivars[name] = value
+}
+
+// setCounter sets the value of counter c with name name.
+func (ctx *Context) setCounter(name string, c *counter, value int) {
+	c.value = value
+	ctx.counters[name] = c
+	ctx.ivars[name] = formatCounter(value, c.format)
+}
+
+// defCounter handles "#dv" counter options for variable name with argument
+// args. It returns false if no counter option was provided.
+func (ctx *Context) defCounter(name string, opts map[string][]ast.Inline, flags map[string]bool, args [][]ast.Inline) bool {
+	var ops []string
+	for _, op := range []string{"inc", "dec", "reset"} {
+		if flags[op] {
+			ops = append(ops, "-"+op)
+		}
+	}
+	if flags["expr"] {
+		ops = append(ops, "-expr")
+	}
+	format, okFmt := opts["fmt"]
+	if len(ops) == 0 && !okFmt {
+		return false
+	}
+	if len(ops) > 1 {
+		if ctx.Process {
+			ctx.Error("incompatible options:", strings.Join(ops, " "))
+		}
+		return true
+	}
+	c, ok := ctx.counters[name]
+	if !ok {
+		c = &counter{format: "arabic"}
+		if s, ok := ctx.ivars[name]; ok && len(ops) > 0 && ops[0] != "-reset" && ops[0] != "-expr" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				if ctx.Process {
+					ctx.Errorf("variable `%s' is not numeric: %s", name, s)
+				}
+				return true
+			}
+			c.value = n
+		}
+	}
+	if okFmt {
+		f := ctx.InlinesToText(format)
+		if isCounterFormat(f) {
+			c.format = f
+		} else if ctx.Process {
+			ctx.Error("invalid counter format:", f+didYouMean(f, counterFormats))
+		}
+	}
+	value := c.value
+	switch {
+	case flags["inc"], flags["dec"]:
+		if len(args) > 0 && ctx.Process {
+			ctx.Error("too many arguments")
+		}
+		if flags["inc"] {
+			value++
+		} else {
+			value--
+		}
+	case flags["reset"], flags["expr"]:
+		if len(args) == 0 {
+			if flags["expr"] && ctx.Process {
+				ctx.Error("expression argument required")
+			}
+			value = 0
+			break
+		}
+		n, err := ctx.evalArith(args)
+		if err != nil {
+			if ctx.Process {
+				ctx.Error("invalid expression:", err)
+			}
+			return true
+		}
+		value = n
+	default:
+		// only -fmt
+		if len(args) > 0 && ctx.Process {
+			ctx.Error("too many arguments")
+		}
+	}
+	ctx.setCounter(name, c, value)
+	return true
+}
+
+func isCounterFormat(format string) bool {
+	for _, f := range counterFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// formatCounter returns the string representation of n in a given format.
+// Roman numerals are used only for 1 to 3999, and letters only for positive
+// numbers: arabic numerals are used otherwise.
+func formatCounter(n int, format string) string {
+	switch format {
+	case "roman", "Roman":
+		if n < 1 || n > 3999 {
+			break
+		}
+		s := romanNumeral(n)
+		if format == "roman" {
+			s = strings.ToLower(s)
+		}
+		return s
+	case "alpha", "Alpha":
+		if n < 1 {
+			break
+		}
+		base := 'a'
+		if format == "Alpha" {
+			base = 'A'
+		}
+		// bijective base 26: a, ..., z, aa, ab, ...
+		var rs []rune
+		for n > 0 {
+			n--
+			rs = append([]rune{base + rune(n%26)}, rs...)
+			n /= 26
+		}
+		return string(rs)
+	}
+	return strconv.Itoa(n)
+}
+
+func romanNumeral(n int) string {
+	numerals := []struct {
+		value int
+		s     string
+	}{{1000, "M"}, {900, "CM"}, {500, "D"}, {400, "CD"}, {100, "C"}, {90, "XC"},
+		{50, "L"}, {40, "XL"}, {10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"}}
+	var b strings.Builder
+	for _, num := range numerals {
+		for n >= num.value {
+			b.WriteString(num.s)
+			n -= num.value
+		}
+	}
+	return b.String()
+}
+
+// numericText returns the text of an inline element, using the numeric
+// value for counter variables.
+func (ctx *Context) numericText(in ast.Inline) string {
+	if v, ok := in.(ast.VarEscape); ok {
+		if c, ok := ctx.counters[string(v)]; ok {
+			return strconv.Itoa(c.value)
+		}
+	}
+	return ctx.inlineToText(in)
+}
+
+// arithParser evaluates an integer arithmetic expression. The grammar is as
+// follows:
+//
+//	expr   = term { ( "+" | "-" ) term }
+//	term   = factor { ( "*" | "/" | "%" ) factor }
+//	factor = "-" factor | "(" expr ")" | number
+type arithParser struct {
+	toks []string
+	pos  int
+}
+
+const (
+	maxInt = int(^uint(0) >> 1)
+	minInt = -maxInt - 1
+)
+
+var errOverflow = errors.New("integer overflow")
+
+// evalArith evaluates the arithmetic expression formed by args. Interpolated
+// variables are always numbers.
+func (ctx *Context) evalArith(args [][]ast.Inline) (int, error) {
+	var toks []string
+	for _, arg := range args {
+		var word strings.Builder
+		inWord := false
+		flush := func() {
+			if inWord {
+				toks = append(toks, word.String())
+				word.Reset()
+				inWord = false
+			}
+		}
+		for _, in := range arg {
+			t, ok := in.(ast.Text)
+			if !ok {
+				s := ctx.numericText(in)
+				if _, err := strconv.Atoi(s); err != nil {
+					return 0, fmt.Errorf("non-numeric value `%s'", s)
+				}
+				flush()
+				toks = append(toks, s)
+				continue
+			}
+			for _, r := range string(t) {
+				switch r {
+				case '+', '-', '*', '/', '%', '(', ')':
+					flush()
+					toks = append(toks, string(r))
+				default:
+					word.WriteRune(r)
+					inWord = true
+				}
+			}
+		}
+		flush()
+	}
+	p := &arithParser{toks: toks}
+	n, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+	if p.pos < len(p.toks) {
+		return 0, fmt.Errorf("unexpected `%s'", p.toks[p.pos])
+	}
+	return n, nil
+}
+
+func (p *arithParser) peek() string {
+	if p.pos >= len(p.toks) {
+		return ""
+	}
+	return p.toks[p.pos]
+}
+
+func (p *arithParser) expr() (int, error) {
+	n, err := p.term()
+	if err != nil {
+		return 0, err
+	}
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.toks[p.pos]
+		p.pos++
+		m, err := p.term()
+		if err != nil {
+			return 0, err
+		}
+		if op == "+" {
+			if m > 0 && n > maxInt-m || m < 0 && n < minInt-m {
+				return 0, errOverflow
+			}
+			n += m
+		} else {
+			if m < 0 && n > maxInt+m || m > 0 && n < minInt+m {
+				return 0, errOverflow
+			}
+			n -= m
+		}
+	}
+	return n, nil
+}
+
+func (p *arithParser) term() (int, error) {
+	n, err := p.factor()
+	if err != nil {
+		return 0, err
+	}
+	for p.peek() == "*" || p.peek() == "/" || p.peek() == "%" {
+		op := p.toks[p.pos]
+		p.pos++
+		m, err := p.factor()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "*":
+			r := n * m
+			if n != 0 && (r/n != m || n == -1 && m == minInt || m == -1 && n == minInt) {
+				return 0, errOverflow
+			}
+			n = r
+		default:
+			if m == 0 {
+				return 0, errors.New("division by zero")
+			}
+			if m == -1 && n == minInt {
+				return 0, errOverflow
+			}
+			if op == "/" {
+				n /= m
+			} else {
+				n %= m
+			}
+		}
+	}
+	return n, nil
+}
+
+func (p *arithParser) factor() (int, error) {
+	switch tok := p.peek(); tok {
+	case "":
+		return 0, errors.New("unexpected end of expression")
+	case "-":
+		p.pos++
+		n, err := p.factor()
+		if err == nil && n == minInt {
+			return 0, errOverflow
+		}
+		return -n, err
+	case "(":
+		p.pos++
+		n, err := p.expr()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ")" {
+			return 0, errors.New("missing `)'")
+		}
+		p.pos++
+		return n, nil
+	default:
+		p.pos++
+		n, err := strconv.Atoi(tok)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number `%s'", tok)
+		}
+		return n, nil
+	}
+}
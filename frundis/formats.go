@@ -0,0 +1,175 @@
+// Export format registry
+
+package frundis
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// FormatOptions gathers the options shared by exporters, as used by
+// NewFormatExporter.
+type FormatOptions struct {
+	Format       string // export format name
+	OutputFile   string // name of output file or directory
+	Standalone   bool   // generate complete document with headers
+	AllInOneFile bool   // output goes only to one file (for multi-file formats)
+	SourceMap    bool   // produce source mapping information (if supported)
+}
+
+// FormatConstructor returns a new exporter for the format with the given
+// options.
+type FormatConstructor func(opts *FormatOptions) Exporter
+
+// formatInfo gathers information about an export format.
+type formatInfo struct {
+	family string            // format family, if any
+	ext    string            // output file extension, if any
+	new    FormatConstructor // exporter constructor
+}
+
+// formatRegistry contains the export formats registered with RegisterFormat,
+// usually by the exporter packages.
+var formatRegistry = struct {
+	sync.RWMutex
+	formats map[string]*formatInfo
+}{formats: map[string]*formatInfo{}}
+
+// RegisterFormat makes an export format available under a given name, with
+// an exporter constructor. The format name can then be used in -f options of
+// macros, as well as the family name, if not empty, which refers to all the
+// formats of the family: for example, the "html" family contains formats
+// "xhtml" and "epub". RegisterFormat is intended to be called from the init
+// function of an exporter package. It panics if name is empty, conflicts
+// with a family name, or has already been registered.
+func RegisterFormat(name string, family string, fn FormatConstructor) {
+	formatRegistry.Lock()
+	defer formatRegistry.Unlock()
+	if name == "" || fn == nil {
+		panic("frundis: RegisterFormat: empty format name or nil constructor")
+	}
+	if isFormatFamily(name) || formatRegistry.formats[family] != nil || family == name {
+		panic("frundis: RegisterFormat: format and family names conflict: " + name)
+	}
+	if _, ok := formatRegistry.formats[name]; ok {
+		panic("frundis: RegisterFormat: format registered twice: " + name)
+	}
+	formatRegistry.formats[name] = &formatInfo{family: family, new: fn}
+}
+
+// SetFormatExtension sets the usual extension of output files of registered
+// format name, such as ".tex". It is used to name output files when
+// exporting to several formats at once. It panics if the format is not
+// registered.
+func SetFormatExtension(name string, ext string) {
+	formatRegistry.Lock()
+	defer formatRegistry.Unlock()
+	info, ok := formatRegistry.formats[name]
+	if !ok {
+		panic("frundis: SetFormatExtension: unknown format: " + name)
+	}
+	info.ext = ext
+}
+
+// isFormatFamily reports whether name is a format family. The caller should
+// hold the registry lock.
+func isFormatFamily(name string) bool {
+	for _, info := range formatRegistry.formats {
+		if info.family == name {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinFormats is the sorted list of export formats provided by the
+// exporter packages of this module.
+var builtinFormats = []string{"docbook", "epub", "events", "fb2", "latex", "markdown",
+	"mom", "pandoc-json", "tei", "typst", "xhtml"}
+
+// BuiltinFormats returns the sorted list of export formats provided by the
+// exporter packages of this module, whether they are registered or not. It
+// is meant for tools producing frundis source for any export format.
+func BuiltinFormats() []string {
+	return append([]string(nil), builtinFormats...)
+}
+
+// Formats returns the sorted list of known export formats.
+func Formats() []string {
+	formatRegistry.RLock()
+	defer formatRegistry.RUnlock()
+	formats := make([]string, 0, len(formatRegistry.formats))
+	for name := range formatRegistry.formats {
+		formats = append(formats, name)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// IsFormat reports whether name is a known export format.
+func IsFormat(name string) bool {
+	formatRegistry.RLock()
+	defer formatRegistry.RUnlock()
+	_, ok := formatRegistry.formats[name]
+	return ok
+}
+
+// FormatFamily returns the family of format name, or the empty string if it
+// has none.
+func FormatFamily(name string) string {
+	formatRegistry.RLock()
+	defer formatRegistry.RUnlock()
+	if info, ok := formatRegistry.formats[name]; ok {
+		return info.family
+	}
+	return ""
+}
+
+// FormatExtension returns the output file extension of format name, as
+// given to SetFormatExtension, or the empty string if there is none.
+func FormatExtension(name string) string {
+	formatRegistry.RLock()
+	defer formatRegistry.RUnlock()
+	if info, ok := formatRegistry.formats[name]; ok {
+		return info.ext
+	}
+	return ""
+}
+
+// NewFormatExporter returns a new exporter for format opts.Format, using the
+// registered constructor.
+func NewFormatExporter(opts *FormatOptions) (Exporter, error) {
+	formatRegistry.RLock()
+	info, ok := formatRegistry.formats[opts.Format]
+	formatRegistry.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("invalid format: %s", opts.Format)
+	}
+	return info.new(opts), nil
+}
+
+// formatNames returns the known export formats and format families, as valid
+// in -f options.
+func formatNames() []string {
+	formatRegistry.RLock()
+	defer formatRegistry.RUnlock()
+	names := make([]string, 0, len(formatRegistry.formats)+1)
+	families := make(map[string]bool)
+	for name, info := range formatRegistry.formats {
+		names = append(names, name)
+		if info.family != "" && !families[info.family] {
+			families[info.family] = true
+			names = append(names, info.family)
+		}
+	}
+	return names
+}
+
+// isKnownFormat reports whether name is a known format or format family.
+func isKnownFormat(name string) bool {
+	formatRegistry.RLock()
+	defer formatRegistry.RUnlock()
+	_, ok := formatRegistry.formats[name]
+	return ok || isFormatFamily(name)
+}
@@ -0,0 +1,350 @@
+// Macro authoring API
+
+package frundis
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+)
+
+// MacroFunc is the type of macro handlers written with the macro authoring
+// API.
+type MacroFunc func(mc *MacroContext)
+
+// MacroContext gives access to the current macro invocation and to the
+// helpers used by builtin macros, so that well-behaved macros can be written
+// outside of this package.
+//
+// Source files are processed twice: first during an info pass that collects
+// information such as identifiers and table of contents entries, without
+// producing output, and then during the process pass. Macros should register
+// identifiers during the info pass, and produce output only during the
+// process pass.
+//
+// A phrasing macro, producing inline text, typically looks like this:
+//
+//	func macroName(mc *frundis.MacroContext) {
+//		if mc.InfoPass() {
+//			return
+//		}
+//		args, punct := mc.ClosePunct(mc.Args())
+//		mc.BeginPhrasing(false)
+//		fmt.Fprint(mc.W(), mc.RenderInline(args), punct)
+//		mc.EndPhrasing()
+//	}
+//
+// A block macro pair uses BeginBlock and EndBlock instead.
+type MacroContext struct {
+	exp Exporter
+	ctx *Context
+}
+
+var macroRegistry = struct {
+	sync.RWMutex
+	macros   map[string]MacroFunc
+	defaults map[string]func(Exporter) // cached default macros (if any)
+	version  int                       // incremented on each registration
+}{macros: make(map[string]MacroFunc)}
+
+// RegisterMacro makes a macro available under a given name to exporters
+// initialized afterwards, with the default or minimal set of macros. A
+// registered macro takes precedence over a builtin macro with the same name.
+// It is intended to be called from the init function of a package providing
+// macros. It panics if name is empty or already registered.
+func RegisterMacro(name string, fn MacroFunc) {
+	macroRegistry.Lock()
+	defer macroRegistry.Unlock()
+	if name == "" {
+		panic("frundis: RegisterMacro: empty macro name")
+	}
+	if _, ok := macroRegistry.macros[name]; ok {
+		panic("frundis: RegisterMacro: macro registered twice: " + name)
+	}
+	macroRegistry.macros[name] = fn
+	macroRegistry.defaults = nil
+	macroRegistry.version++
+}
+
+// withRegisteredMacros adds registered macros to a mapping from macros to
+// handling functions.
+func withRegisteredMacros(macros map[string]func(Exporter)) map[string]func(Exporter) {
+	macroRegistry.RLock()
+	defer macroRegistry.RUnlock()
+	for name, fn := range macroRegistry.macros {
+		macros[name] = MacroHandler(fn)
+	}
+	return macros
+}
+
+// defaultMacros returns a copy of the mapping of DefaultExporterMacros, which
+// is built once and cached until a new macro is registered. The returned map
+// belongs to the caller and may be modified.
+func defaultMacros() map[string]func(Exporter) {
+	macroRegistry.RLock()
+	cached, version := macroRegistry.defaults, macroRegistry.version
+	macroRegistry.RUnlock()
+	if cached == nil {
+		cached = DefaultExporterMacros()
+		macroRegistry.Lock()
+		if macroRegistry.version == version {
+			macroRegistry.defaults = cached
+		}
+		macroRegistry.Unlock()
+	}
+	macros := make(map[string]func(Exporter), len(cached))
+	for name, fn := range cached {
+		macros[name] = fn
+	}
+	return macros
+}
+
+// MacroHandler returns a handling function suitable for Context.Macros from a
+// macro written with the macro authoring API.
+func MacroHandler(fn MacroFunc) func(Exporter) {
+	return func(exp Exporter) {
+		fn(&MacroContext{exp: exp, ctx: exp.Context()})
+	}
+}
+
+// NewMacroContext returns a macro context for exporter exp, for Go code that
+// drives an exporter without any source file, such as the document tree
+// renderer of package doctree. The exporter should be initialized.
+func NewMacroContext(exp Exporter) *MacroContext {
+	return &MacroContext{exp: exp, ctx: exp.Context()}
+}
+
+// Exporter returns the current exporter.
+func (mc *MacroContext) Exporter() Exporter {
+	return mc.exp
+}
+
+// Context returns the current processing context.
+func (mc *MacroContext) Context() *Context {
+	return mc.ctx
+}
+
+// Name returns the name of the current macro.
+func (mc *MacroContext) Name() string {
+	return mc.ctx.Macro
+}
+
+// Args returns the arguments of the current macro.
+func (mc *MacroContext) Args() [][]ast.Inline {
+	return mc.ctx.Args
+}
+
+// ParseOptions parses the options of the current macro following spec, and
+// returns option arguments, flags, and remaining arguments. Unknown options
+// are reported during the process pass.
+func (mc *MacroContext) ParseOptions(spec map[string]Option) (map[string][]ast.Inline, map[string]bool, [][]ast.Inline) {
+	ctx := mc.ctx
+	if !ctx.Process {
+		oquiet := ctx.quiet
+		ctx.quiet = true
+		defer func() { ctx.quiet = oquiet }()
+	}
+	return ctx.ParseOptions(spec, ctx.Args)
+}
+
+// InfoPass reports whether processing is in the info pass, which does not
+// produce output.
+func (mc *MacroContext) InfoPass() bool {
+	return !mc.ctx.Process
+}
+
+// Error reports an error at the current macro location. Errors are reported
+// only during the process pass, to avoid reporting them twice.
+func (mc *MacroContext) Error(msgs ...interface{}) {
+	if mc.ctx.Process {
+		mc.ctx.Error(msgs...)
+	}
+}
+
+// Errorf is like Error, but with a format string.
+func (mc *MacroContext) Errorf(format string, msgs ...interface{}) {
+	if mc.ctx.Process {
+		mc.ctx.Errorf(format, msgs...)
+	}
+}
+
+// Text returns the text of an argument, with escapes and variables
+// interpolated, but without any rendering.
+func (mc *MacroContext) Text(arg []ast.Inline) string {
+	return mc.ctx.InlinesToText(arg)
+}
+
+// Render returns the text of an argument, rendered (escaped) for the output
+// format.
+func (mc *MacroContext) Render(arg []ast.Inline) string {
+	return mc.exp.RenderText(arg)
+}
+
+// RenderInline returns the rendering of arguments, handling Sm-like markup
+// as in the Sx or Lk macros.
+func (mc *MacroContext) RenderInline(args [][]ast.Inline) string {
+	return processInlineMacros(mc.exp, args)
+}
+
+// ClosePunct returns args without a last argument consisting of closing
+// punctuation, if any, and the rendered punctuation, or the empty string.
+// The punctuation is only recognized if there are at least two arguments.
+func (mc *MacroContext) ClosePunct(args [][]ast.Inline) ([][]ast.Inline, string) {
+	if len(args) < 2 {
+		return args, ""
+	}
+	return getClosePunct(mc.exp, args)
+}
+
+// W returns the writer where output should go.
+func (mc *MacroContext) W() io.Writer {
+	return mc.ctx.W()
+}
+
+// BeginPhrasing should be called before producing inline output. It starts a
+// new paragraph if needed, or writes some space if the previous output
+// wants it, unless nospace is true.
+func (mc *MacroContext) BeginPhrasing(nospace bool) {
+	beginPhrasingMacro(mc.exp, nospace)
+}
+
+// EndPhrasing should be called after producing inline output, so that
+// following text is separated by some space.
+func (mc *MacroContext) EndPhrasing() {
+	mc.ctx.WantsSpace = true
+}
+
+// EndParagraph ends the current paragraph, if any.
+func (mc *MacroContext) EndParagraph(pbreak ParagraphBreak) {
+	endParagraph(mc.exp, pbreak)
+}
+
+// Capture returns the output written by fn as inline text, as for the
+// arguments of Sx or Lk, instead of writing it. Errors are not reported
+// during the info pass.
+func (mc *MacroContext) Capture(fn func()) string {
+	ctx := mc.ctx
+	buf, par, inline, ws := ctx.buf, ctx.parScope, ctx.Inline, ctx.WantsSpace
+	proc, quiet := ctx.Process, ctx.quiet
+	ctx.buf = bytes.Buffer{}
+	ctx.parScope = true
+	ctx.Inline = true
+	ctx.WantsSpace = false
+	if !ctx.Process {
+		ctx.quiet = true
+	}
+	ctx.Process = true
+	defer func() {
+		ctx.buf = buf
+		ctx.parScope = par
+		ctx.Inline = inline
+		ctx.WantsSpace = ws
+		ctx.Process = proc
+		ctx.quiet = quiet
+	}()
+	fn()
+	return ctx.buf.String()
+}
+
+// Header writes a header for a given macro ("Pt", "Ch", "Sh" or "Ss"), with
+// an already rendered title, and an optional identifier id. The current
+// paragraph is closed first. During the info pass, it registers the header
+// in the table of contents instead.
+func (mc *MacroContext) Header(macro string, numbered bool, id string, title string) {
+	if !mc.ctx.Process {
+		info := headerInfos(mc.exp, macro, !numbered, id)
+		info.Title = title
+		return
+	}
+	endParagraph(mc.exp, ParBreakNormal)
+	mc.ctx.Toc.updateHeadersCount(macro, !numbered)
+	writeHeader(mc.exp, macro, numbered, id, title)
+}
+
+// ParagraphTitle ends the current paragraph, if any, and starts a new one
+// with an already rendered title.
+func (mc *MacroContext) ParagraphTitle(title string) {
+	endParagraph(mc.exp, ParBreakNormal)
+	mc.ctx.parScope = true
+	mc.exp.ParagraphTitle(title)
+	reopenSpanningBlocks(mc.exp)
+	mc.ctx.WantsSpace = false
+}
+
+// EndStanza ends the current stanza of a poem, whose first line was started
+// with BeginPhrasing.
+func (mc *MacroContext) EndStanza() {
+	if mc.ctx.parScope {
+		processParagraph(mc.exp)
+	}
+	mc.exp.EndStanza()
+}
+
+// BeginBlock starts a block scope for the current macro, that should be
+// closed by a later call to EndBlock from a macro named end. It closes any
+// unclosed inline markup and the current paragraph. If the block is still
+// open at end of file, or when an enclosing block is closed, the handler of
+// macro end is invoked to close it, with an error reported. BeginBlock does
+// nothing during the info pass.
+func (mc *MacroContext) BeginBlock(end, tag, id string) {
+	ctx := mc.ctx
+	if !ctx.Process {
+		return
+	}
+	closeUnclosedScopes(mc.exp, scopeInline)
+	endParagraph(mc.exp, ParBreakNormal)
+	ctx.pushScope(&scope{kind: scopeBlock, macro: ctx.Macro, endMacro: end, tag: tag, id: id})
+}
+
+// EndBlock closes the innermost block scope started with BeginBlock that
+// should be closed by the current macro, and returns its tag and id. Blocks
+// opened since then, as well as the current paragraph, are closed. It
+// reports an error and returns false if there is no such block. EndBlock
+// does nothing during the info pass.
+func (mc *MacroContext) EndBlock() (tag string, id string, ok bool) {
+	ctx := mc.ctx
+	if !ctx.Process {
+		return "", "", false
+	}
+	var s *scope
+	for _, sc := range ctx.scopes[scopeBlock] {
+		if sc.endMacro == ctx.Macro {
+			s = sc
+		}
+	}
+	if s == nil {
+		ctx.Errorf("no corresponding block for `.%s'", ctx.Macro)
+		return "", "", false
+	}
+	closeUnclosedScopes(mc.exp, scopeInline)
+	closeUnclosedBlocks(mc.exp, s.macro)
+	ctx.popScope(scopeBlock)
+	endParagraph(mc.exp, ParBreakNormal)
+	ctx.WantsSpace = false
+	return s.tag, s.id, true
+}
+
+// RegisterID registers identifier id for an element of a given type and
+// name, which can then be referenced with Sx. It returns the reference
+// generated by the exporter for id. It should be called during the info
+// pass.
+func (mc *MacroContext) RegisterID(id string, idtype IDType, name string) string {
+	ref := mc.exp.GenRef("", id, false)
+	mc.ctx.storeID(id, IDInfo{Ref: ref, Name: name, Type: idtype})
+	return ref
+}
+
+// LookupID returns information about identifier id, if it was registered.
+func (mc *MacroContext) LookupID(id string) (IDInfo, bool) {
+	info, ok := mc.ctx.IDs[id]
+	return info, ok
+}
+
+// AddLoXEntry adds an entry to the list of elements of a given class, such
+// as "lof" (figures), "lot" (tables) or "lop" (poems), registering its
+// identifier id, if any. It should be called during the info pass.
+func (mc *MacroContext) AddLoXEntry(class string, info *LoXinfo, id string) {
+	loXEntryInfos(mc.exp, class, info, id)
+}
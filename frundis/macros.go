@@ -34,6 +34,9 @@ func processText(exp Exporter) {
 			// things are not perfect either)
 			fmt.Fprint(&ctx.buf, "\n")
 		}
+		if tl, ok := exp.(TextLocator); ok && !ctx.Inline {
+			tl.LocateText()
+		}
 		text := exp.RenderText(ctx.text)
 		if len(text) > 0 && hasBlankLine(text) {
 			ctx.Error("empty line")
@@ -103,7 +106,7 @@ func macroBd(exp Exporter) {
 	if tag != "" {
 		_, ok := ctx.Dtags[tag]
 		if !ok {
-			ctx.Error("invalid tag:", tag)
+			ctx.Error("invalid tag:", tag+didYouMean(tag, ctx.dtagNames()))
 		}
 	}
 	exp.BeginDisplayBlock(tag, id)
@@ -139,7 +142,7 @@ func macroBf(exp Exporter) {
 		bfinf.filterTag = tag
 		_, okGoFilter := ctx.Filters[tag]
 		if !okGoFilter {
-			ctx.Error("undefined filter tag:", tag)
+			ctx.Error("undefined filter tag:", tag+didYouMean(tag, ctx.filterNames()))
 			bfinf.ignore = true
 			return
 		}
@@ -148,8 +151,12 @@ func macroBf(exp Exporter) {
 		formats := strings.Split(ctx.InlinesToText(fmt), ",")
 		ctx.checkFormats(formats)
 		if ctx.notExportFormat(formats) {
-			bfinf.ignore = true
-			return
+			raw, ok := rawFormat(exp, formats)
+			if !ok {
+				bfinf.ignore = true
+				return
+			}
+			bfinf.rawFormat = raw
 		}
 	}
 	if ctx.parScope {
@@ -325,7 +332,7 @@ func macroBm(exp Exporter) {
 		tag = ctx.InlinesToText(t)
 		_, ok := ctx.Mtags[tag]
 		if !ok {
-			ctx.Error("invalid tag argument to `-t' option")
+			ctx.Error("invalid tag argument to `-t' option:", tag+didYouMean(tag, ctx.mtagNames()))
 		}
 	}
 	ctx.pushScope(&scope{kind: scopeInline, macro: "Bm", tag: tag, id: id, tagRequired: flags["r"]})
@@ -430,14 +437,18 @@ func macroEf(exp Exporter) {
 			if ok {
 				text = filter(ctx.rawText.String())
 			} else {
-				ctx.Error("invalid filter tag:", tag)
+				ctx.Error("invalid filter tag:", tag+didYouMean(tag, ctx.filterNames()))
 				text = ctx.rawText.String()
 			}
 		} else {
 			text = ctx.rawText.String()
 		}
 		w := ctx.W()
-		fmt.Fprint(w, text)
+		if raw := ctx.bfInfo.rawFormat; raw != "" {
+			exp.(RawRenderer).RawText(raw, text)
+		} else {
+			fmt.Fprint(w, text)
+		}
 		if ctx.parScope && !flags["ns"] {
 			ctx.WantsSpace = true
 		} else if !flags["ns"] {
@@ -610,11 +621,16 @@ func macroFt(exp Exporter) {
 	}
 	opts, flags, args := ctx.ParseOptions(specOptFt, ctx.Args)
 	format, okFmt := opts["f"]
+	var raw string
 	if okFmt {
 		formats := strings.Split(ctx.InlinesToText(format), ",")
 		ctx.checkFormats(formats)
 		if ctx.notExportFormat(formats) {
-			return
+			var ok bool
+			raw, ok = rawFormat(exp, formats)
+			if !ok {
+				return
+			}
 		}
 	}
 	tag, okTag := opts["t"]
@@ -633,12 +649,16 @@ func macroFt(exp Exporter) {
 		if okGoFilter {
 			text = goFilter(argsToText(exp, args))
 		} else {
-			ctx.Error("undefined filter tag:", tag)
+			ctx.Error("undefined filter tag:", tag+didYouMean(tag, ctx.filterNames()))
 			text = renderArgs(exp, args)
 		}
 	} else {
 		text = argsToText(exp, args)
 	}
+	if raw != "" {
+		exp.(RawRenderer).RawText(raw, text)
+		return
+	}
 	w := ctx.W()
 	fmt.Fprint(w, text)
 }
@@ -670,7 +690,7 @@ func macroIncludeFile(exp Exporter) {
 			beginPhrasingMacro(exp, flags["ns"])
 			ctx.WantsSpace = true
 		}
-		source, err := os.ReadFile(filename)
+		source, err := os.ReadFile(ctx.Path(filename))
 		if err != nil {
 			ctx.Error("as-is inclusion:", err)
 			return
@@ -682,7 +702,7 @@ func macroIncludeFile(exp Exporter) {
 				text = filter(string(source))
 			} else {
 				text = string(source)
-				ctx.Error("unknown tag:", tag)
+				ctx.Error("unknown tag:", tag+didYouMean(tag, ctx.filterNames()))
 			}
 		} else {
 			text = string(source)
@@ -1041,7 +1061,7 @@ func macroSm(exp Exporter) {
 		tag = ctx.InlinesToText(t)
 		_, ok := ctx.Mtags[tag]
 		if !ok {
-			ctx.Error("invalid tag argument to `-t' option")
+			ctx.Error("invalid tag argument to `-t' option:", tag+didYouMean(tag, ctx.mtagNames()))
 		}
 	}
 	exp.BeginMarkupBlock(tag, id)
@@ -1068,7 +1088,7 @@ func macroSx(exp Exporter) {
 	id := ctx.InlinesToText(args[0])
 	idinfo, ok := ctx.IDs[id]
 	if !ok {
-		ctx.Error("reference to unknown id:", id)
+		ctx.Error("reference to unknown id:", id+didYouMean(id, ctx.idNames()))
 	}
 	beginPhrasingMacro(exp, flags["ns"])
 	ctx.WantsSpace = true
@@ -1411,16 +1431,8 @@ func macroXset(exp Exporter, args [][]ast.Inline) {
 	}
 	param := ctx.InlinesToText(args[0])
 	var value string
-	switch param {
-	case "dmark", "document-author", "document-date", "document-title",
-		"epub-cover", "epub-css", "epub-metadata", "epub-subject", "epub-uuid", "epub-version", "epub-nav-landmarks",
-		"lang",
-		"latex-preamble", "latex-variant",
-		"mom-preamble",
-		"nbsp", "title-page",
-		"xhtml-bottom", "xhtml-css", "xhtml-index", "xhtml-favicon", "xhtml-go-up", "xhtml-top", "xhtml-version", "xhtml-chap-prefix", "xhtml-chap-custom-filenames", "xhtml-custom-ids":
-	default:
-		ctx.Error("unknown parameter:", param)
+	if !isParam(param) {
+		ctx.Error("unknown parameter:", param+didYouMean(param, ParamNames))
 	}
 	switch param {
 	case "document-author", "document-date", "document-title",
@@ -1451,20 +1463,26 @@ func macroHeaderProcess(exp Exporter) {
 		ctx.Error("arguments required")
 		return
 	}
-	numbered := !flags["nonum"]
 	closeUnclosedScopes(exp, scopeInline)
 	closeUnclosedScopes(exp, scopeBlock)
 	endParagraph(exp, ParBreakNormal)
 	ctx.Toc.updateHeadersCount(ctx.Macro, flags["nonum"])
 	title := processInlineMacros(exp, args)
-	ctx.IDX = ctx.InlinesToText(opts["id"])
-	if ctx.Macro == "Ch" || ctx.Macro == "Pt" {
-		ctx.ID = ctx.IDX
+	writeHeader(exp, ctx.Macro, !flags["nonum"], ctx.InlinesToText(opts["id"]), title)
+}
+
+// writeHeader writes a header with an already rendered title, after headers
+// count has been updated.
+func writeHeader(exp Exporter, macro string, numbered bool, id string, title string) {
+	ctx := exp.Context()
+	ctx.IDX = id
+	if macro == "Ch" || macro == "Pt" {
+		ctx.ID = id
 	}
-	exp.BeginHeader(ctx.Macro, numbered, title)
+	exp.BeginHeader(macro, numbered, title)
 	fmt.Fprint(ctx.W(), title)
 	closeUnclosedScopes(exp, scopeInline)
-	exp.EndHeader(ctx.Macro, numbered, title)
+	exp.EndHeader(macro, numbered, title)
 }
 
 func macroHeaderInfos(exp Exporter) {
@@ -1474,38 +1492,45 @@ func macroHeaderInfos(exp Exporter) {
 		// Error message while processing
 		return
 	}
-	ctx.Toc.updateHeadersCount(ctx.Macro, flags["nonum"])
-	switch ctx.Macro {
+	info := headerInfos(exp, ctx.Macro, flags["nonum"], ctx.InlinesToText(opts["id"]))
+	info.Title = processInlineMacros(exp, args)
+}
+
+// headerInfos updates headers count for a new header, registers its
+// identifier id, if any, and adds an entry to the table of contents. It
+// returns the entry, whose title is left to the caller.
+func headerInfos(exp Exporter, macro string, nonum bool, id string) *LoXinfo {
+	ctx := exp.Context()
+	ctx.Toc.updateHeadersCount(macro, nonum)
+	switch macro {
 	case "Pt":
 		ctx.Toc.HasPart = true
 	case "Ch":
 		ctx.Toc.HasChapter = true
 	}
-	id := ctx.InlinesToText(opts["id"])
 	ctx.IDX = id
-	if ctx.Macro == "Ch" || ctx.Macro == "Pt" {
+	if macro == "Ch" || macro == "Pt" {
 		ctx.ID = id
 	}
-	ref := exp.HeaderReference(ctx.Macro)
-	num := ctx.Toc.HeaderNum(ctx.Macro, flags["nonum"])
+	ref := exp.HeaderReference(macro)
+	num := ctx.Toc.HeaderNum(macro, nonum)
 	if id != "" {
 		ctx.storeID(id, IDInfo{Ref: ref, Name: num, Type: HeaderID})
 	}
-	title := processInlineMacros(exp, args)
 	info := &LoXinfo{
 		Count:     ctx.Toc.HeaderCount,
 		ID:        id,
 		Ref:       ref,
 		RefPrefix: "s",
-		Macro:     ctx.Macro,
-		Nonum:     flags["nonum"],
-		Title:     title,
+		Macro:     macro,
+		Nonum:     nonum,
 		Num:       num}
 	ctx.LoXstack["toc"] = append(ctx.LoXstack["toc"], info)
-	switch ctx.Macro {
+	switch macro {
 	case "Pt", "Ch":
 		ctx.LoXstack["nav"] = append(ctx.LoXstack["nav"], info)
 	}
+	return info
 }
 
 ////////////// Macro utilities ///////////////////////////////////////////
@@ -1607,7 +1632,10 @@ func beginPhrasingMacro(exp Exporter, nospace bool) {
 // of same name of Exporter interface, which works for inline markup in most
 // output formats.
 func BeginPhrasingMacroInParagraph(exp Exporter, nospace bool) {
-	ctx := exp.Context()
+	beginPhrasingMacroInParagraph(exp.Context(), nospace)
+}
+
+func beginPhrasingMacroInParagraph(ctx *Context, nospace bool) {
 	if ctx.WantsSpace && !nospace {
 		w := ctx.W()
 		if ctx.Inline {
@@ -1695,24 +1723,14 @@ func closeUnclosedScopes(exp Exporter, scope scopeKind) {
 				s := scopes[len(scopes)-1]
 				ctx.Macro = curMacro
 				warnUnclosedScope(exp, s)
-				switch s.macro {
-				case "Bl", "It":
-					ctx.Macro = "El"
-				default:
-					ctx.Macro = "Ed"
-				}
+				ctx.Macro = scopeEndMacro(s)
 				if s.tag != "" {
 					ctx.Args = append(ctx.Args,
 						[]ast.Inline{ast.Text("-t")}, []ast.Inline{ast.Text(s.tag)})
 				}
 				oquiet := ctx.quiet
 				ctx.quiet = true
-				switch s.macro {
-				case "Bl", "It":
-					macroEl(exp)
-				default:
-					macroEd(exp)
-				}
+				closeBlockScope(exp, s)
 				ctx.quiet = oquiet
 				ctx.Args = ctx.Args[:0]
 			}
@@ -1720,6 +1738,38 @@ func closeUnclosedScopes(exp Exporter, scope scopeKind) {
 	}
 }
 
+// scopeEndMacro returns the name of the macro closing block scope s.
+func scopeEndMacro(s *scope) string {
+	switch s.macro {
+	case "Bl", "It":
+		return "El"
+	case "Bd":
+		return "Ed"
+	}
+	return s.endMacro
+}
+
+// closeBlockScope closes block scope s, which should be the innermost one,
+// using its closing macro.
+func closeBlockScope(exp Exporter, s *scope) {
+	ctx := exp.Context()
+	switch s.macro {
+	case "Bl", "It":
+		macroEl(exp)
+	case "Bd":
+		macroEd(exp)
+	default:
+		n := len(ctx.scopes[scopeBlock])
+		if handler, ok := ctx.Macros[s.endMacro]; ok {
+			handler(exp)
+		}
+		if len(ctx.scopes[scopeBlock]) == n {
+			// ensure progress with a misbehaving handler
+			ctx.popScope(scopeBlock)
+		}
+	}
+}
+
 // closeUnclosedBlocks closes unclosed blocks of a different macro than the
 // given one.
 func closeUnclosedBlocks(exp Exporter, macro string) {
@@ -1760,30 +1810,18 @@ func closeUnclosedBlocks(exp Exporter, macro string) {
 		if s.tag != "" {
 			tag = " of type " + s.tag
 		}
-		switch s.macro {
-		case "Bl", "It":
-			msg := fmt.Sprintf("found %s while `.%s' macro%s %s isn't closed yet by a `.%s'",
-				curMacro, s.macro, tag, location, "El")
-			ctx.Error(msg)
-			ctx.Macro = "El"
-		default:
-			msg := fmt.Sprintf("found %s while `.%s' macro%s %s isn't closed yet by a `.%s'",
-				curMacro, s.macro, tag, location, "Ed")
-			ctx.Error(msg)
-			ctx.Macro = "Ed"
-		}
+		endMacro := scopeEndMacro(s)
+		msg := fmt.Sprintf("found %s while `.%s' macro%s %s isn't closed yet by a `.%s'",
+			curMacro, s.macro, tag, location, endMacro)
+		ctx.Error(msg)
+		ctx.Macro = endMacro
 		if s.tag != "" {
 			ctx.Args = append(ctx.Args,
 				[]ast.Inline{ast.Text("-t")}, []ast.Inline{ast.Text(s.tag)})
 		}
 		oquiet := ctx.quiet
 		ctx.quiet = true
-		switch s.macro {
-		case "Bl", "It":
-			macroEl(exp)
-		default:
-			macroEd(exp)
-		}
+		closeBlockScope(exp, s)
 		ctx.quiet = oquiet
 		ctx.Args = ctx.Args[:0]
 		ctx.Macro = curMacro
@@ -1823,6 +1861,7 @@ func processParagraph(exp Exporter) {
 	}
 	ctx.buf.Reset()
 	ctx.parScope = false
+	ctx.markParagraphEnd()
 }
 
 // warnUnclosedScope warns for an unclosed scope of some kind.
@@ -1839,6 +1878,8 @@ func warnUnclosedScope(exp Exporter, scope *scope) {
 		endmacro = "Ed"
 	case "#if":
 		endmacro = "#;"
+	default:
+		endmacro = scope.endMacro
 	}
 	location := ctx.scopeLocation(scope)
 	var tag string
@@ -1889,6 +1930,14 @@ func checkForUnclosedDe(exp Exporter) {
 	ctx.Errorf("found End Of File while `.#de' macro at line %d of file %s isn't closed by a `.#.'", ctx.uMacroDef.line, ctx.uMacroDef.file)
 }
 
+func checkForUnclosedFor(exp Exporter) {
+	ctx := exp.Context()
+	if ctx.forLoop == nil {
+		return
+	}
+	ctx.Errorf("found End Of File while `.#for' macro at line %d of file %s isn't closed by a `.#done'", ctx.forLoop.line, ctx.forLoop.file)
+}
+
 func scopeVerse(exp Exporter) bool {
 	ctx := exp.Context()
 	scopes, ok := ctx.scopes[scopeBlock]
@@ -40,7 +40,7 @@ scanOptions:
 		args = args[1:]
 		optionType, ok := spec[name]
 		if !ok {
-			ctx.Errorf("unrecognized option: -%s", name)
+			ctx.Errorf("unrecognized option: -%s%s", name, didYouMean("-"+name, optionNames(spec)))
 			continue scanOptions
 		}
 		if optionType == ArgOption {
@@ -84,8 +84,18 @@ var specOptBm = map[string]Option{
 	"ns": FlagOption,
 	"id": ArgOption}
 var specOptD = map[string]Option{}
-var specOptDef = map[string]Option{"f": ArgOption}
-var specOptDefVar = map[string]Option{"f": ArgOption}
+var specOptDef = map[string]Option{
+	"f":        ArgOption,
+	"default":  ArgOption,
+	"desc":     ArgOption,
+	"required": ArgOption}
+var specOptDefVar = map[string]Option{
+	"f":     ArgOption,
+	"inc":   FlagOption,
+	"dec":   FlagOption,
+	"reset": FlagOption,
+	"expr":  FlagOption,
+	"fmt":   ArgOption}
 var specOptEd = map[string]Option{"t": ArgOption}
 var specOptEl = map[string]Option{}
 var specOptEm = map[string]Option{
@@ -97,6 +107,7 @@ var specOptFt = map[string]Option{
 	"t":  ArgOption,
 	"f":  ArgOption,
 	"ns": FlagOption}
+var specOptFor = map[string]Option{"sep": ArgOption}
 var specOptIf = map[string]Option{
 	"eq":  ArgOption,
 	"f":   ArgOption,
@@ -153,3 +164,89 @@ var specOptXset = map[string]Option{"f": ArgOption}
 var specOptHeader = map[string]Option{
 	"id":    ArgOption,
 	"nonum": FlagOption}
+
+var builtinOptions = map[string]map[string]Option{
+	"Bd":     specOptBd,
+	"Bf":     specOptBf,
+	"Bl":     specOptBl,
+	"Bm":     specOptBm,
+	"Ch":     specOptHeader,
+	"D":      specOptD,
+	"Ed":     specOptEd,
+	"Ef":     specOptEf,
+	"El":     specOptEl,
+	"Em":     specOptEm,
+	"Ft":     specOptFt,
+	"If":     specOptIncludeFile,
+	"Im":     specOptIm,
+	"It":     specOptIt,
+	"Lk":     specOptLk,
+	"P":      specOptP,
+	"Pt":     specOptHeader,
+	"Sh":     specOptHeader,
+	"Sm":     specOptSm,
+	"Ss":     specOptHeader,
+	"Sx":     specOptSx,
+	"Ta":     specOptTa,
+	"Tc":     specOptTc,
+	"X dtag": specOptXdtag,
+	"X ftag": specOptXftag,
+	"X mtag": specOptXmtag,
+	"X set":  specOptXset,
+	"#de":    specOptDef,
+	"#dv":    specOptDefVar,
+	"#for":   specOptFor,
+	"#if":    specOptIf,
+	"#elif":  specOptIf,
+	"#run":   specOptRun,
+}
+
+// BuiltinOptions returns the option specification of builtin macro name, and
+// whether there is such a macro. Subcommands of the X macro are named like
+// "X mtag".
+func BuiltinOptions(name string) (map[string]Option, bool) {
+	spec, ok := builtinOptions[name]
+	return spec, ok
+}
+
+// ParamNames lists the parameters that can be set with "X set".
+var ParamNames = []string{
+	"dmark",
+	"document-author",
+	"document-date",
+	"document-title",
+	"epub-cover",
+	"epub-css",
+	"epub-metadata",
+	"epub-nav-landmarks",
+	"epub-subject",
+	"epub-uuid",
+	"epub-version",
+	"lang",
+	"latex-preamble",
+	"latex-variant",
+	"mom-preamble",
+	"nbsp",
+	"title-page",
+	"typst-preamble",
+	"xhtml-bottom",
+	"xhtml-chap-custom-filenames",
+	"xhtml-chap-prefix",
+	"xhtml-css",
+	"xhtml-custom-ids",
+	"xhtml-favicon",
+	"xhtml-go-up",
+	"xhtml-index",
+	"xhtml-top",
+	"xhtml-version",
+}
+
+// isParam reports whether name is a parameter that can be set with "X set".
+func isParam(name string) bool {
+	for _, p := range ParamNames {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
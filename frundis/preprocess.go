@@ -0,0 +1,174 @@
+// Preprocess-only mode
+
+package frundis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+)
+
+// preprocessor holds information for preprocess-only mode.
+type preprocessor struct {
+	file string // file of last written block
+	next int    // line following last written block, or 0 if unknown
+}
+
+// Preprocess writes to w the frundis source of file filename after
+// preprocessing: user macros are expanded, variables interpolated, false
+// #if branches removed and included frundis files inlined. Other macros are
+// left as-is. Output blocks are preceded by a `.\" file:line' comment giving
+// their origin, whenever they do not directly follow the previous block in
+// the same file. Comments for blocks from user macros also give the location
+// of the outermost macro call. Conditionals depending on the export format
+// use the format of the given exporter, which produces no output. As in
+// normal processing, an info pass first collects parameters set with `X set',
+// so that they can be used in #if expressions.
+func Preprocess(exp Exporter, filename string, w io.Writer) error {
+	exp.Init()
+	ctx := exp.Context()
+	err := processFile(exp, filename)
+	if err != nil {
+		return err
+	}
+	ctx.Reset()
+	ctx.preprocess = &preprocessor{}
+	ctx.Wout = bufio.NewWriter(w)
+	err = processFile(exp, filename)
+	if err != nil {
+		return err
+	}
+	if ctx.loc == nil {
+		ctx.loc = &location{curBlock: -1, curFile: filename}
+	}
+	ctx.Macro = "End Of File"
+	s := ctx.scopes[scopeIf]
+	if len(s) > 0 {
+		warnUnclosedScope(exp, s[len(s)-1])
+	}
+	checkForUnclosedDe(exp)
+	checkForUnclosedFor(exp)
+	return ctx.Wout.Flush()
+}
+
+// isPreprocessorMacro reports whether macro m is handled in preprocess-only
+// mode, instead of being written as-is.
+func (ctx *Context) isPreprocessorMacro(m *ast.Macro) bool {
+	switch m.Name {
+	case "#de", "#.", "#if", "#elif", "#else", "#;", "#dv", "#for", "#done":
+		return true
+	case "If":
+		_, flags, _ := ctx.ParseOptions(specOptIncludeFile, m.Args)
+		return !flags["as-is"]
+	}
+	return false
+}
+
+// preprocessBlock writes the current block, with variables interpolated.
+func (ctx *Context) preprocessBlock(b ast.Block) {
+	if m, ok := b.(*ast.Macro); ok && m.Name == "" {
+		// comment line
+		return
+	}
+	pp := ctx.preprocess
+	file, line := ctx.loc.curFile, b.GetLine()
+	expanded := ctx.uMacroCall.loc != nil
+	if expanded || file != pp.file || line != pp.next {
+		if expanded {
+			loc := ctx.uMacroCall.loc
+			call := loc.curBlocks[loc.curBlock].(*ast.Macro)
+			fmt.Fprintf(ctx.Wout, ".\\\" %s:%d, in .%s at %s:%d\n", file, line, call.Name, loc.curFile, call.Line)
+		} else {
+			fmt.Fprintf(ctx.Wout, ".\\\" %s:%d\n", file, line)
+		}
+	}
+	pp.file, pp.next = file, 0
+	switch b := b.(type) {
+	case *ast.Macro:
+		if !expanded && b.End.IsValid() {
+			pp.next = b.End.Line + 1
+		}
+		m := &ast.Macro{Name: b.Name, Line: b.Line, Args: make([][]ast.Inline, len(b.Args))}
+		for i, arg := range b.Args {
+			plain, protected := ctx.interpolate(arg)
+			if ctx.isPunctArg(plain) {
+				// delimiter argument: a \& escape would make it text
+				m.Args[i] = plain
+			} else {
+				m.Args[i] = protected
+			}
+		}
+		err := ast.Fprint(ctx.Wout, []ast.Block{m})
+		if err != nil {
+			ctx.Error(err)
+		}
+	case *ast.TextBlock:
+		if !expanded && b.End.IsValid() {
+			pp.next = b.End.Line + 1
+		}
+		_, text := ctx.interpolate(b.Text)
+		tb := &ast.TextBlock{Line: b.Line, Text: text}
+		err := ast.Fprint(ctx.Wout, []ast.Block{tb})
+		if err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// interpolate returns inline elements with variables replaced by their
+// values. Variable values are not subject to typographic processing (such as
+// French non-breaking spaces), so the protected result also has \& escapes
+// before the characters of values that would be processed otherwise.
+func (ctx *Context) interpolate(ins []ast.Inline) (plain, protected []ast.Inline) {
+	plain = make([]ast.Inline, 0, len(ins))
+	protected = make([]ast.Inline, 0, len(ins))
+	for _, in := range ins {
+		v, ok := in.(ast.VarEscape)
+		if !ok {
+			plain = append(plain, in)
+			protected = append(protected, in)
+			continue
+		}
+		text := ctx.inlineToText(v)
+		plain = appendValue(plain, text, false)
+		protected = appendValue(protected, text, true)
+	}
+	return plain, protected
+}
+
+// appendValue appends to res the inline elements representing variable
+// value text, escaping backslashes. If protect is true, characters subject to
+// typographic processing are protected with \& escapes: punctuation and
+// apostrophes are preceded by one, and opening guillemets followed by one.
+func appendValue(res []ast.Inline, text string, protect bool) []ast.Inline {
+	start := 0
+	for i, c := range text {
+		switch {
+		case c == '\\':
+			if i > start {
+				res = append(res, ast.Text(text[start:i]))
+			}
+			res = append(res, ast.Escape("e"))
+			start = i + 1
+		case !protect:
+		case strings.ContainsRune("!:;?\u00bb'", c):
+			if i > start {
+				res = append(res, ast.Text(text[start:i]))
+			}
+			res = append(res, ast.Escape("&"))
+			start = i
+		case c == '\u00ab':
+			next := i + utf8.RuneLen(c)
+			res = append(res, ast.Text(text[start:next]), ast.Escape("&"))
+			start = next
+		}
+	}
+	if start < len(text) {
+		res = append(res, ast.Text(text[start:]))
+	}
+	return res
+}
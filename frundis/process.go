@@ -3,6 +3,11 @@
 package frundis
 
 import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"time"
+
 	"codeberg.org/anaseto/gofrundis/ast"
 	"codeberg.org/anaseto/gofrundis/parser"
 )
@@ -10,21 +15,65 @@ import (
 // ProcessFrundisSource processes a frundis file with a given exporter. In
 // restricted mode, no #run nor shell filter are allowed.
 func ProcessFrundisSource(exp Exporter, filename string, unrestricted bool) error {
+	return processFrundisSource(context.Background(), exp, filename, &ProcessOptions{Unrestricted: unrestricted}, nil)
+}
+
+// ProcessOptions gathers processing options.
+type ProcessOptions struct {
+	Unrestricted  bool          // allow #run and shell filters
+	ExecTimeout   time.Duration // maximum duration of external commands (no limit if zero)
+	ExecMaxOutput int64         // maximum output size in bytes of external commands (no limit if zero)
+}
+
+// ProcessFrundisSourceContext is like ProcessFrundisSource, with processing
+// options opts. When cctx is done, running external commands are killed and
+// processing is aborted before next block, returning the error of cctx. The
+// output of an aborted processing is released as described in Aborter.
+func ProcessFrundisSourceContext(cctx context.Context, exp Exporter, filename string, opts *ProcessOptions) error {
+	return processFrundisSource(cctx, exp, filename, opts, nil)
+}
+
+// processFrundisSource is like ProcessFrundisSourceContext, with an optional
+// setup function called with the context after exporter initialization.
+func processFrundisSource(cctx context.Context, exp Exporter, filename string, opts *ProcessOptions, setup func(*Context)) (err error) {
 	exp.Init()
 	ctx := exp.Context()
-	ctx.Unrestricted = unrestricted
-	err := processFile(exp, filename)
+	ctx.Unrestricted = opts.Unrestricted
+	ctx.cctx = cctx
+	ctx.execTimeout = opts.ExecTimeout
+	ctx.execMaxOutput = opts.ExecMaxOutput
+	if setup != nil {
+		setup(ctx)
+	}
+	err = processFile(exp, filename)
 	if err != nil {
 		return err
 	}
+	if err := cctx.Err(); err != nil {
+		return err
+	}
 	err = exp.Reset()
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// release the output opened by Reset
+		if a, ok := exp.(Aborter); ok {
+			a.Abort()
+		} else {
+			exp.PostProcessing()
+		}
+	}()
 	err = processFile(exp, filename)
 	if err != nil {
 		return err
 	}
+	if err := cctx.Err(); err != nil {
+		return err
+	}
 	if ctx.loc == nil {
 		ctx.loc = &location{curBlock: -1, curFile: filename}
 	}
@@ -38,6 +87,7 @@ func ProcessFrundisSource(exp Exporter, filename string, unrestricted bool) erro
 	}
 	checkForUnclosedFormatBlock(exp)
 	checkForUnclosedDe(exp)
+	checkForUnclosedFor(exp)
 	exp.PostProcessing()
 	return nil
 }
@@ -48,8 +98,15 @@ func processFile(exp Exporter, filename string) error {
 	blocks, ok := ctx.files[filename]
 	if !ok {
 		var err error
-		p := parser.Parser{}
-		blocks, err = p.ParseFile(filename)
+		p := parser.Parser{Werror: ctx.Werror}
+		if src, ok := ctx.source(filename); ok {
+			p.Source = filename
+			blocks, err = p.ParseWithReader(bytes.NewReader(src))
+		} else if ctx.fileCache != nil {
+			blocks, err = ctx.fileCache.parse(filename, ctx.Werror)
+		} else {
+			blocks, err = p.ParseFile(filename)
+		}
 		if err != nil {
 			return err
 		}
@@ -62,9 +119,25 @@ func processFile(exp Exporter, filename string) error {
 	return nil
 }
 
+// source returns the contents set with SetSource for file filename, if any.
+func (ctx *Context) source(filename string) ([]byte, bool) {
+	if ctx.sources == nil {
+		return nil, false
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, false
+	}
+	src, ok := ctx.sources[abs]
+	return src, ok
+}
+
 func processBlocks(exp Exporter) {
 	ctx := exp.Context()
 	for i, b := range ctx.loc.curBlocks {
+		if ctx.canceled() {
+			return
+		}
 		ctx.loc.curBlock = i
 		switch b := b.(type) {
 		case *ast.Macro:
@@ -75,6 +148,7 @@ func processBlocks(exp Exporter) {
 			ctx.text = b.Text
 			ctx.line = b.Line
 		}
+		ctx.markSource()
 		processBlock(exp)
 	}
 }
@@ -90,6 +164,10 @@ func processBlock(exp Exporter) {
 				macroIfEnd(exp)
 			case "#if":
 				macroIfStart(exp)
+			case "#elif":
+				macroElif(exp)
+			case "#else":
+				macroElse(exp)
 			default:
 			}
 		}
@@ -115,6 +193,22 @@ func processBlock(exp Exporter) {
 		}
 		return
 	}
+	if ctx.forLoop != nil {
+		if b, ok := b.(*ast.Macro); ok {
+			switch b.Name {
+			case "#for":
+				ctx.forLoop.depth++
+			case "#done":
+				if ctx.forLoop.depth == 0 {
+					macroForEnd(exp)
+					return
+				}
+				ctx.forLoop.depth--
+			}
+		}
+		ctx.forLoop.blocks = append(ctx.forLoop.blocks, b)
+		return
+	}
 
 	switch b := b.(type) {
 	case *ast.Macro:
@@ -123,11 +217,15 @@ func processBlock(exp Exporter) {
 			processUserMacro(exp, m)
 			return
 		}
+		if ctx.preprocess != nil && !ctx.isPreprocessorMacro(b) {
+			ctx.preprocessBlock(b)
+			return
+		}
 		handler, ok := ctx.Macros[b.Name]
 		if ok {
 			if ctx.bfInfo != nil {
 				switch b.Name {
-				case "Ef", "#if", "#;":
+				case "Ef", "#if", "#elif", "#else", "#;", "#for", "#done":
 				default:
 					checkForUnclosedFormatBlock(exp)
 				}
@@ -135,68 +233,82 @@ func processBlock(exp Exporter) {
 			handler(exp)
 			ctx.PrevMacro = b.Name
 		} else if b.Name != "" && ctx.Process {
-			ctx.Error("unknown macro:", b.Name)
+			ctx.Error("unknown macro:", b.Name+didYouMean(b.Name, ctx.macroNames()))
 		}
 	case *ast.TextBlock:
+		if ctx.preprocess != nil {
+			ctx.preprocessBlock(b)
+			return
+		}
 		processText(exp)
 		ctx.PrevMacro = ""
 	}
 }
 
 // DefaultExporterMacros returns a mapping from macros to handling functions,
-// with the standard set of frundis macros.
+// with the standard set of frundis macros, as well as macros registered with
+// RegisterMacro.
 func DefaultExporterMacros() map[string]func(Exporter) {
-	return map[string]func(Exporter){
-		"Bd":   macroBd,
-		"Bf":   macroBf,
-		"Bl":   macroBl,
-		"Bm":   macroBm,
-		"Ch":   macroHeader,
-		"D":    macroD,
-		"Ed":   macroEd,
-		"Ef":   macroEf,
-		"El":   macroEl,
-		"Em":   macroEm,
-		"Ft":   macroFt,
-		"If":   macroIncludeFile,
-		"Im":   macroIm,
-		"It":   macroIt,
-		"Lk":   macroLk,
-		"P":    macroP,
-		"Pt":   macroHeader,
-		"Sh":   macroHeader,
-		"Sm":   macroSm,
-		"Ss":   macroHeader,
-		"Sx":   macroSx,
-		"Ta":   macroTa,
-		"Tc":   macroTc,
-		"X":    macroX,
-		"#de":  macroDefStart,
-		"#.":   macroDefEnd,
-		"#if":  macroIfStart,
-		"#;":   macroIfEnd,
-		"#dv":  macroDefVar,
-		"#run": macroRun}
+	return withRegisteredMacros(map[string]func(Exporter){
+		"Bd":    macroBd,
+		"Bf":    macroBf,
+		"Bl":    macroBl,
+		"Bm":    macroBm,
+		"Ch":    macroHeader,
+		"D":     macroD,
+		"Ed":    macroEd,
+		"Ef":    macroEf,
+		"El":    macroEl,
+		"Em":    macroEm,
+		"Ft":    macroFt,
+		"If":    macroIncludeFile,
+		"Im":    macroIm,
+		"It":    macroIt,
+		"Lk":    macroLk,
+		"P":     macroP,
+		"Pt":    macroHeader,
+		"Sh":    macroHeader,
+		"Sm":    macroSm,
+		"Ss":    macroHeader,
+		"Sx":    macroSx,
+		"Ta":    macroTa,
+		"Tc":    macroTc,
+		"X":     macroX,
+		"#de":   macroDefStart,
+		"#.":    macroDefEnd,
+		"#if":   macroIfStart,
+		"#elif": macroElif,
+		"#else": macroElse,
+		"#;":    macroIfEnd,
+		"#dv":   macroDefVar,
+		"#for":  macroForStart,
+		"#done": macroForEnd,
+		"#run":  macroRun})
 }
 
 // MinimalExporterMacros returns a mapping from macros to handling functions,
-// with only the following macros: Bd, Bf, Bm, Ed, Ef, Em, Ft, If, Sm, X.
+// with only the following macros: Bd, Bf, Bm, Ed, Ef, Em, Ft, If, Sm, X, as
+// well as macros registered with RegisterMacro.
 func MinimalExporterMacros() map[string]func(Exporter) {
-	return map[string]func(Exporter){
-		"Bd":   macroBd,
-		"Bf":   macroBf,
-		"Bm":   macroBm,
-		"Ed":   macroEd,
-		"Ef":   macroEf,
-		"Em":   macroEm,
-		"Ft":   macroFt,
-		"If":   macroIncludeFile,
-		"Sm":   macroSm,
-		"X":    macroX,
-		"#de":  macroDefStart,
-		"#.":   macroDefEnd,
-		"#if":  macroIfStart,
-		"#;":   macroIfEnd,
-		"#dv":  macroDefVar,
-		"#run": macroRun}
+	return withRegisteredMacros(map[string]func(Exporter){
+		"Bd":    macroBd,
+		"Bf":    macroBf,
+		"Bm":    macroBm,
+		"Ed":    macroEd,
+		"Ef":    macroEf,
+		"Em":    macroEm,
+		"Ft":    macroFt,
+		"If":    macroIncludeFile,
+		"Sm":    macroSm,
+		"X":     macroX,
+		"#de":   macroDefStart,
+		"#.":    macroDefEnd,
+		"#if":   macroIfStart,
+		"#elif": macroElif,
+		"#else": macroElse,
+		"#;":    macroIfEnd,
+		"#dv":   macroDefVar,
+		"#for":  macroForStart,
+		"#done": macroForEnd,
+		"#run":  macroRun})
 }
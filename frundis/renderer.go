@@ -0,0 +1,282 @@
+// Default Renderer implementation
+
+package frundis
+
+import (
+	"fmt"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+)
+
+// BaseRenderer provides default implementations of the Renderer methods,
+// that produce plain text without markup, as well as a Context method. It is
+// intended to be embedded in exporters, so that they only have to implement
+// the methods whose behavior differs, in addition to Init, Reset,
+// PostProcessing, Xdtag and Xmtag. The exporter should set the Ctx field in
+// its Init method.
+//
+// Features that not all exporters can handle are provided through optional
+// capability interfaces, such as RawRenderer, NoteRenderer or MathRenderer,
+// that exporters satisfy by implementing additional methods, and that are
+// checked with type assertions. This way, new capabilities do not break
+// existing exporters.
+type BaseRenderer struct {
+	Ctx       *Context // processing context
+	enumCount []int    // item counts of nested enumeration lists
+	rowCells  int      // number of cells in current table row
+}
+
+// Context returns processing context.
+func (r *BaseRenderer) Context() *Context {
+	return r.Ctx
+}
+
+// BeginDescList does nothing.
+func (r *BaseRenderer) BeginDescList(id string) {
+}
+
+// BeginDescValue does nothing.
+func (r *BaseRenderer) BeginDescValue() {
+}
+
+// BeginDialogue writes an em dash.
+func (r *BaseRenderer) BeginDialogue() {
+	fmt.Fprint(r.Ctx.W(), "—")
+}
+
+// BeginDisplayBlock does nothing.
+func (r *BaseRenderer) BeginDisplayBlock(tag string, id string) {
+}
+
+// BeginEnumItem writes the item number.
+func (r *BaseRenderer) BeginEnumItem() {
+	n := 1
+	if len(r.enumCount) > 0 {
+		r.enumCount[len(r.enumCount)-1]++
+		n = r.enumCount[len(r.enumCount)-1]
+	}
+	fmt.Fprintf(r.Ctx.W(), "%d. ", n)
+}
+
+// BeginEnumList starts item numbering.
+func (r *BaseRenderer) BeginEnumList(id string) {
+	r.enumCount = append(r.enumCount, 0)
+}
+
+// BeginHeader does nothing.
+func (r *BaseRenderer) BeginHeader(macro string, numbered bool, title string) {
+}
+
+// BeginItem writes a dash.
+func (r *BaseRenderer) BeginItem() {
+	fmt.Fprint(r.Ctx.W(), "- ")
+}
+
+// BeginItemList does nothing.
+func (r *BaseRenderer) BeginItemList(id string) {
+}
+
+// BeginMarkupBlock writes the beginning text of the markup tag, if any.
+func (r *BaseRenderer) BeginMarkupBlock(tag string, id string) {
+	if mtag, ok := r.Ctx.Mtags[tag]; ok {
+		fmt.Fprint(r.Ctx.W(), mtag.Begin)
+	}
+}
+
+// BeginParagraph does nothing.
+func (r *BaseRenderer) BeginParagraph() {
+}
+
+// BeginPhrasingMacroInParagraph writes a space or a newline if needed, like
+// the function of same name.
+func (r *BaseRenderer) BeginPhrasingMacroInParagraph(nospace bool) {
+	beginPhrasingMacroInParagraph(r.Ctx, nospace)
+}
+
+// BeginTable does nothing.
+func (r *BaseRenderer) BeginTable(tableinfo *TableData) {
+}
+
+// BeginTableCell separates cells with a tab.
+func (r *BaseRenderer) BeginTableCell() {
+	if r.rowCells > 0 {
+		fmt.Fprint(r.Ctx.W(), "\t")
+	}
+	r.rowCells++
+}
+
+// BeginTableRow starts a new row.
+func (r *BaseRenderer) BeginTableRow() {
+	r.rowCells = 0
+}
+
+// BeginVerse writes the poem title, if any.
+func (r *BaseRenderer) BeginVerse(title string, id string) {
+	if title != "" {
+		fmt.Fprint(r.Ctx.W(), title, "\n\n")
+	}
+}
+
+// BeginVerseLine does nothing.
+func (r *BaseRenderer) BeginVerseLine() {
+}
+
+// CheckParamAssignement accepts any parameter value.
+func (r *BaseRenderer) CheckParamAssignement(param string, value string) bool {
+	return true
+}
+
+// CrossReference writes the name of the reference.
+func (r *BaseRenderer) CrossReference(idf IDInfo, punct string) {
+	fmt.Fprint(r.Ctx.W(), idf.Name, punct)
+}
+
+// DescName writes the name on its own line.
+func (r *BaseRenderer) DescName(name string) {
+	fmt.Fprint(r.Ctx.W(), name, "\n")
+}
+
+// EndDescList does nothing.
+func (r *BaseRenderer) EndDescList() {
+}
+
+// EndDescValue ends the line.
+func (r *BaseRenderer) EndDescValue() {
+	fmt.Fprint(r.Ctx.W(), "\n")
+}
+
+// EndDisplayBlock does nothing.
+func (r *BaseRenderer) EndDisplayBlock(tag string) {
+}
+
+// EndEnumItem ends the line.
+func (r *BaseRenderer) EndEnumItem() {
+	fmt.Fprint(r.Ctx.W(), "\n")
+}
+
+// EndEnumList ends item numbering.
+func (r *BaseRenderer) EndEnumList() {
+	if len(r.enumCount) > 0 {
+		r.enumCount = r.enumCount[:len(r.enumCount)-1]
+	}
+}
+
+// EndHeader ends the header with an empty line.
+func (r *BaseRenderer) EndHeader(macro string, numbered bool, title string) {
+	fmt.Fprint(r.Ctx.W(), "\n\n")
+}
+
+// EndItem ends the line.
+func (r *BaseRenderer) EndItem() {
+	fmt.Fprint(r.Ctx.W(), "\n")
+}
+
+// EndItemList does nothing.
+func (r *BaseRenderer) EndItemList() {
+}
+
+// EndMarkupBlock writes the ending text of the markup tag, if any, and
+// punctuation.
+func (r *BaseRenderer) EndMarkupBlock(tag string, id string, punct string) {
+	w := r.Ctx.W()
+	if mtag, ok := r.Ctx.Mtags[tag]; ok {
+		fmt.Fprint(w, mtag.End)
+	}
+	fmt.Fprint(w, punct)
+}
+
+// EndParagraph ends the line, unless the break is forced or before a new
+// list item.
+func (r *BaseRenderer) EndParagraph(pbreak ParagraphBreak) {
+	switch pbreak {
+	case ParBreakForced, ParBreakItem:
+	default:
+		fmt.Fprint(r.Ctx.W(), "\n")
+	}
+}
+
+// EndStanza ends the line.
+func (r *BaseRenderer) EndStanza() {
+	fmt.Fprint(r.Ctx.W(), "\n")
+}
+
+// EndTable does nothing.
+func (r *BaseRenderer) EndTable(tableinfo *TableData) {
+}
+
+// EndTableCell does nothing.
+func (r *BaseRenderer) EndTableCell() {
+}
+
+// EndTableRow ends the line.
+func (r *BaseRenderer) EndTableRow() {
+	fmt.Fprint(r.Ctx.W(), "\n")
+}
+
+// EndVerse does nothing.
+func (r *BaseRenderer) EndVerse() {
+}
+
+// EndVerseLine ends the line.
+func (r *BaseRenderer) EndVerseLine() {
+	fmt.Fprint(r.Ctx.W(), "\n")
+}
+
+// FormatParagraph returns text unchanged.
+func (r *BaseRenderer) FormatParagraph(text []byte) []byte {
+	return text
+}
+
+// FigureImage writes the caption on its own line, if any.
+func (r *BaseRenderer) FigureImage(image string, caption string, link string, alt string) {
+	if caption != "" {
+		fmt.Fprint(r.Ctx.W(), caption, "\n")
+	}
+}
+
+// GenRef returns the empty string.
+func (r *BaseRenderer) GenRef(prefix string, id string, hasfile bool) string {
+	return ""
+}
+
+// HeaderReference returns the empty string.
+func (r *BaseRenderer) HeaderReference(macro string) string {
+	return ""
+}
+
+// InlineImage writes the alternate text.
+func (r *BaseRenderer) InlineImage(image string, link string, id string, punct string, alt string) {
+	fmt.Fprint(r.Ctx.W(), alt, punct)
+}
+
+// LkWithLabel writes the label.
+func (r *BaseRenderer) LkWithLabel(url string, label string, punct string) {
+	fmt.Fprint(r.Ctx.W(), label, punct)
+}
+
+// LkWithoutLabel writes the url.
+func (r *BaseRenderer) LkWithoutLabel(url string, punct string) {
+	fmt.Fprint(r.Ctx.W(), url, punct)
+}
+
+// ParagraphTitle writes the title on its own line.
+func (r *BaseRenderer) ParagraphTitle(title string) {
+	fmt.Fprint(r.Ctx.W(), title, "\n")
+}
+
+// RenderText returns the text, escaped with the "escape" filter, if any.
+func (r *BaseRenderer) RenderText(text []ast.Inline) string {
+	s := r.Ctx.InlinesToText(text)
+	if escape, ok := r.Ctx.Filters["escape"]; ok {
+		s = escape(s)
+	}
+	return s
+}
+
+// TableOfContents does nothing.
+func (r *BaseRenderer) TableOfContents(opts map[string][]ast.Inline, flags map[string]bool) {
+}
+
+// TableOfContentsInfos does nothing.
+func (r *BaseRenderer) TableOfContentsInfos(flags map[string]bool) {
+}
@@ -21,6 +21,9 @@ type scope struct {
 	kind        scopeKind
 	macro       string
 	inUserMacro bool
+	endMacro    string // macro closing a block scope started with MacroContext.BeginBlock
+	ifTaken     bool   // whether a branch of "#if" was taken
+	elseScope   *scope // location of "#else", if any
 }
 
 // pushScope adds a new scope
@@ -29,6 +32,13 @@ func (ctx *Context) pushScope(s *scope) {
 	if !ok {
 		st = []*scope{}
 	}
+	ctx.setScopeLocation(s)
+	st = append(st, s)
+	ctx.scopes[s.kind] = st
+}
+
+// setScopeLocation sets the location of s to the current block.
+func (ctx *Context) setScopeLocation(s *scope) {
 	if ctx.uMacroCall.loc != nil {
 		s.file = ctx.uMacroCall.loc.curFile
 		b := ctx.uMacroCall.loc.curBlocks[ctx.uMacroCall.loc.curBlock]
@@ -39,9 +49,6 @@ func (ctx *Context) pushScope(s *scope) {
 		b := ctx.block()
 		s.lnum = b.GetLine()
 	}
-
-	st = append(st, s)
-	ctx.scopes[s.kind] = st
 }
 
 // popScope pops a scope from specific tag
@@ -0,0 +1,168 @@
+// Source maps
+
+package frundis
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// SourceMap is an io.Writer that passes output through to an underlying
+// writer, recording the source file and line from which output lines were
+// generated. An exporter supporting source maps should use it as the
+// underlying writer of ctx.Wout and assign it to ctx.SourceMap.
+type SourceMap struct {
+	w       io.Writer
+	offset  int          // number of bytes written
+	line    int          // current output line
+	bol     bool         // whether at beginning of line
+	marks   []sourceMark // marks not yet reached by output
+	pending *sourceMark  // mark for the block ending the current paragraph
+	files   map[string]int
+	data    sourceMapData
+}
+
+// sourceMark marks the output offset from which output comes from a given
+// source location.
+type sourceMark struct {
+	offset int
+	file   string
+	line   int
+}
+
+// sourceMapData represents the JSON form of a source map. Each entry of
+// Lines is a triple [output line, index in Sources, source line], meaning
+// that output from that line on, until the next entry, comes from the given
+// source file and line.
+type sourceMapData struct {
+	Version int      `json:"version"`
+	Sources []string `json:"sources"`
+	Lines   [][3]int `json:"lines"`
+}
+
+// NewSourceMap returns a new source map writing output to w.
+func NewSourceMap(w io.Writer) *SourceMap {
+	return &SourceMap{
+		w:     w,
+		line:  1,
+		bol:   true,
+		files: make(map[string]int),
+		data:  sourceMapData{Version: 1, Sources: []string{}, Lines: [][3]int{}}}
+}
+
+// Write writes p to the underlying writer, resolving marks reached. A line is
+// mapped to the last mark reached at its beginning, so that a mark in the
+// middle of a line applies to the next one.
+func (sm *SourceMap) Write(p []byte) (int, error) {
+	n, err := sm.w.Write(p)
+	for i := 0; i < n; i++ {
+		if sm.bol {
+			sm.resolve(sm.offset + i)
+			sm.bol = false
+		}
+		if p[i] == '\n' {
+			sm.line++
+			sm.bol = true
+		}
+	}
+	sm.offset += n
+	return n, err
+}
+
+// resolve records marks for output at offset.
+func (sm *SourceMap) resolve(offset int) {
+	for len(sm.marks) > 0 && sm.marks[0].offset <= offset {
+		sm.record(sm.marks[0])
+		sm.marks = sm.marks[1:]
+	}
+}
+
+func (sm *SourceMap) record(m sourceMark) {
+	idx, ok := sm.files[m.file]
+	if !ok {
+		idx = len(sm.data.Sources)
+		sm.files[m.file] = idx
+		sm.data.Sources = append(sm.data.Sources, m.file)
+	}
+	entry := [3]int{sm.line, idx, m.line}
+	lines := sm.data.Lines
+	if len(lines) > 0 {
+		last := lines[len(lines)-1]
+		switch {
+		case last[0] == entry[0]:
+			lines[len(lines)-1] = entry
+			return
+		case last[1] == entry[1] && last[2] == entry[2]:
+			return
+		}
+	}
+	sm.data.Lines = append(lines, entry)
+}
+
+// mark records that output from offset on comes from source file and line.
+func (sm *SourceMap) mark(offset int, file string, line int) {
+	m := sourceMark{offset: offset, file: file, line: line}
+	if n := len(sm.marks); n > 0 && sm.marks[n-1].offset == offset {
+		sm.marks[n-1] = m
+		return
+	}
+	sm.marks = append(sm.marks, m)
+}
+
+// WriteFile writes the source map in JSON form to file filename.
+func (sm *SourceMap) WriteFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	err = enc.Encode(sm.data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// markSource records the source location of the current block in the
+// source map, if any. Blocks in user macros are mapped to the macro call,
+// and as-is text of Bf blocks to the Bf macro. Blocks processed while in a
+// paragraph do not produce output before the end of the paragraph, so only
+// the last one is kept, for the output following the paragraph.
+func (ctx *Context) markSource() {
+	sm := ctx.SourceMap
+	if sm == nil || !ctx.Process || ctx.uMacroCall.loc != nil || ctx.ifIgnoreDepth > 0 ||
+		ctx.uMacroDef != nil || ctx.forLoop != nil || ctx.bfInfo != nil {
+		return
+	}
+	m := sourceMark{file: ctx.loc.curFile, line: ctx.line}
+	if ctx.parScope {
+		sm.pending = &m
+		return
+	}
+	sm.mark(sm.offset+ctx.Wout.Buffered(), m.file, m.line)
+}
+
+// markParagraphEnd records the pending source location, if any, after the
+// output of a paragraph.
+func (ctx *Context) markParagraphEnd() {
+	sm := ctx.SourceMap
+	if sm == nil || sm.pending == nil {
+		return
+	}
+	sm.mark(sm.offset+ctx.Wout.Buffered(), sm.pending.file, sm.pending.line)
+	sm.pending = nil
+}
+
+// SourceLocation returns the source file and line of the current block.
+// Blocks in user macros are located at the outermost macro call.
+func (ctx *Context) SourceLocation() (string, int) {
+	if loc := ctx.uMacroCall.loc; loc != nil {
+		return loc.curFile, loc.curBlocks[loc.curBlock].GetLine()
+	}
+	if ctx.loc == nil {
+		return "", 0
+	}
+	return ctx.loc.curFile, ctx.line
+}
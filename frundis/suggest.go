@@ -0,0 +1,181 @@
+// "Did you mean" suggestions for misspelled names
+
+package frundis
+
+import (
+	"sort"
+	"strings"
+)
+
+// maxSuggestions is the maximum number of equally close names suggested:
+// if there are more, none is suggested.
+const maxSuggestions = 4
+
+// suggestion gathers the known names closest to a misspelled name.
+type suggestion struct {
+	name  string
+	dist  int      // best distance so far
+	names []string // names at best distance
+}
+
+// newSuggestion returns a suggestion for misspelled name.
+func newSuggestion(name string) *suggestion {
+	// allow roughly one edit every three characters
+	return &suggestion{name: name, dist: len([]rune(name))/3 + 1}
+}
+
+// add considers a known name as a candidate.
+func (s *suggestion) add(name string) {
+	if name == s.name || name == "" {
+		return
+	}
+	d := editDistance(strings.ToLower(s.name), strings.ToLower(name))
+	if d < editDistance(s.name, name) {
+		// case differences are cheaper than other edits
+		d++
+	}
+	switch {
+	case d < s.dist:
+		s.dist = d
+		s.names = []string{name}
+	case d == s.dist:
+		s.names = append(s.names, name)
+	}
+}
+
+// String returns a hint of the form " (did you mean `x'?)", or the empty
+// string if no close enough name was found.
+func (s *suggestion) String() string {
+	if len(s.names) == 0 || len(s.names) > maxSuggestions {
+		return ""
+	}
+	sort.Strings(s.names)
+	var b strings.Builder
+	b.WriteString(" (did you mean ")
+	for i, name := range s.names {
+		switch {
+		case i == 0:
+		case i == len(s.names)-1:
+			b.WriteString(" or ")
+		default:
+			b.WriteString(", ")
+		}
+		b.WriteString("`" + name + "'")
+	}
+	b.WriteString("?)")
+	return b.String()
+}
+
+// didYouMean returns a suggestion hint for misspelled name among known
+// names.
+func didYouMean(name string, known []string) string {
+	s := newSuggestion(name)
+	for _, k := range known {
+		s.add(k)
+	}
+	return s.String()
+}
+
+// editDistance returns the optimal string alignment distance between a and
+// b: the number of rune insertions, deletions, substitutions and
+// transpositions of adjacent runes needed to transform a into b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// three rows of the dynamic programming matrix
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+func min(n int, ns ...int) int {
+	for _, m := range ns {
+		if m < n {
+			n = m
+		}
+	}
+	return n
+}
+
+// macroNames returns the names of builtin and user macros.
+func (ctx *Context) macroNames() []string {
+	names := make([]string, 0, len(ctx.Macros)+len(ctx.uMacros))
+	for name := range ctx.Macros {
+		names = append(names, name)
+	}
+	for name := range ctx.uMacros {
+		names = append(names, name)
+	}
+	return names
+}
+
+// idNames returns the known identifiers.
+func (ctx *Context) idNames() []string {
+	names := make([]string, 0, len(ctx.IDs))
+	for name := range ctx.IDs {
+		names = append(names, name)
+	}
+	return names
+}
+
+// mtagNames returns the tags defined with "X mtag".
+func (ctx *Context) mtagNames() []string {
+	names := make([]string, 0, len(ctx.Mtags))
+	for name := range ctx.Mtags {
+		names = append(names, name)
+	}
+	return names
+}
+
+// dtagNames returns the tags defined with "X dtag".
+func (ctx *Context) dtagNames() []string {
+	names := make([]string, 0, len(ctx.Dtags))
+	for name := range ctx.Dtags {
+		names = append(names, name)
+	}
+	return names
+}
+
+// filterNames returns the filter tags, defined with "X ftag" or builtin.
+func (ctx *Context) filterNames() []string {
+	names := make([]string, 0, len(ctx.Filters))
+	for name := range ctx.Filters {
+		names = append(names, name)
+	}
+	return names
+}
+
+// varNames returns the names of variables defined with "#dv".
+func (ctx *Context) varNames() []string {
+	names := make([]string, 0, len(ctx.ivars))
+	for name := range ctx.ivars {
+		names = append(names, name)
+	}
+	return names
+}
+
+// optionNames returns the option names of a specification, with their dash.
+func optionNames(spec map[string]Option) []string {
+	names := make([]string, 0, len(spec))
+	for name := range spec {
+		names = append(names, "-"+name)
+	}
+	return names
+}
@@ -4,6 +4,7 @@ package frundis
 
 import (
 	"fmt"
+	"sort"
 
 	"codeberg.org/anaseto/gofrundis/ast"
 )
@@ -99,6 +100,16 @@ func (ctx *Context) argsSubstBlock(
 	return res
 }
 
+// withOption returns opts with option name set to value, allocating the map
+// if needed.
+func withOption(opts map[string][]ast.Inline, name string, value []ast.Inline) map[string][]ast.Inline {
+	if opts == nil {
+		opts = make(map[string][]ast.Inline)
+	}
+	opts[name] = value
+	return opts
+}
+
 func processUserMacro(exp Exporter, m *uMacroDefInfo) {
 	ctx := exp.Context()
 	// Do not allow too much depth
@@ -121,6 +132,20 @@ func processUserMacro(exp Exporter, m *uMacroDefInfo) {
 	if !m.list && len(args) > m.argsc && ctx.Process {
 		ctx.Error("too many arguments")
 	}
+	for _, name := range m.required {
+		if _, ok := opts[name]; !ok {
+			if ctx.Process {
+				ctx.Errorf("missing required option: -%s", name)
+			}
+			// avoid further missing named argument errors
+			opts = withOption(opts, name, []ast.Inline{})
+		}
+	}
+	for name, value := range m.defaults {
+		if _, ok := opts[name]; !ok {
+			opts = withOption(opts, name, value)
+		}
+	}
 	var blocks []ast.Block
 	if m.argsc > 0 || m.list || len(m.opts) > 0 {
 		// substitute $N arguments
@@ -153,3 +178,60 @@ func processUserMacro(exp Exporter, m *uMacroDefInfo) {
 	// process user macro blocks
 	processBlocks(exp)
 }
+
+// MacroInfo describes a user macro defined with "#de".
+type MacroInfo struct {
+	Name        string        // macro name
+	File        string        // file where macro is defined
+	Line        int           // line of definition
+	Description string        // one-line description (-desc option)
+	Arity       int           // number of positional arguments
+	Variadic    bool          // whether the macro accepts more arguments ($@)
+	Options     []MacroOption // named arguments and flags, sorted by name
+}
+
+// MacroOption describes a named argument or flag of a user macro.
+type MacroOption struct {
+	Name     string
+	Flag     bool   // whether the option is a flag
+	Required bool   // whether the option is required
+	Default  string // default value as frundis source, or empty if none
+}
+
+// ListMacros returns information about the user macros defined in file
+// filename, sorted by name. Definitions specific to some formats use the
+// format of the given exporter, which produces no output.
+func ListMacros(exp Exporter, filename string) ([]MacroInfo, error) {
+	exp.Init()
+	ctx := exp.Context()
+	err := processFile(exp, filename)
+	if err != nil {
+		return nil, err
+	}
+	macros := make([]MacroInfo, 0, len(ctx.uMacros))
+	for _, m := range ctx.uMacros {
+		mi := MacroInfo{
+			Name:        m.name,
+			File:        m.file,
+			Line:        m.line,
+			Description: m.desc,
+			Arity:       m.argsc,
+			Variadic:    m.list}
+		for name, opt := range m.opts {
+			mo := MacroOption{Name: name, Flag: opt == FlagOption}
+			for _, r := range m.required {
+				if r == name {
+					mo.Required = true
+				}
+			}
+			if d, ok := m.defaults[name]; ok {
+				mo.Default = ast.ArgString(d)
+			}
+			mi.Options = append(mi.Options, mo)
+		}
+		sort.Slice(mi.Options, func(i, j int) bool { return mi.Options[i].Name < mi.Options[j].Name })
+		macros = append(macros, mi)
+	}
+	sort.Slice(macros, func(i, j int) bool { return macros[i].Name < macros[j].Name })
+	return macros, nil
+}
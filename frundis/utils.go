@@ -1,7 +1,10 @@
 package frundis
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path"
@@ -51,36 +54,47 @@ func (ctx *Context) block() ast.Block {
 	return ctx.loc.curBlocks[ctx.loc.curBlock]
 }
 
-// isValidFormat checks whether format is a valid format.
+// isValidFormat checks whether format is a valid format or format family.
 func (ctx *Context) isValidFormat(format string) bool {
-	for _, f := range ctx.validFormats {
-		if format == f {
-			return true
-		}
-	}
-	return false
+	return isKnownFormat(format)
 }
 
 // checkFormats warns if a format from formats is unknown.
 func (ctx *Context) checkFormats(formats []string) {
 	for _, f := range formats {
 		if !ctx.isValidFormat(f) {
-			ctx.Error("invalid argument to -f option:", f)
+			ctx.Error("invalid argument to -f option:", f+didYouMean(f, formatNames()))
 		}
 	}
 }
 
 // notExportFormat tests whether none of the formats in formats is current
-// export format.
+// export format or its family.
 func (ctx *Context) notExportFormat(formats []string) bool {
+	family := FormatFamily(ctx.Format)
 	for _, f := range formats {
-		if f == ctx.Format {
+		if f == ctx.Format || family != "" && f == family {
 			return false
 		}
 	}
 	return true
 }
 
+// rawFormat returns the raw format under which text for one of formats should
+// be embedded, if exp is a RawRenderer accepting one of them.
+func rawFormat(exp Exporter, formats []string) (string, bool) {
+	rr, ok := exp.(RawRenderer)
+	if !ok {
+		return "", false
+	}
+	for _, f := range formats {
+		if raw, ok := rr.RawFormat(f); ok {
+			return raw, true
+		}
+	}
+	return "", false
+}
+
 // containsSpace checks wether a string contains any unicode space.
 func containsSpace(s string) bool {
 	for _, c := range s {
@@ -134,7 +148,7 @@ func (ctx *Context) inlineToText(elt ast.Inline) string {
 			if len(string(elt)) > 0 && string(elt)[0] == '$' {
 				res = os.Getenv(string(elt)[1:])
 			} else {
-				ctx.Error("unknown variable name:", string(elt))
+				ctx.Error("unknown variable name:", string(elt)+didYouMean(string(elt), ctx.varNames()))
 			}
 		}
 	case ast.Text:
@@ -179,16 +193,17 @@ func renderArgs(exp Exporter, args [][]ast.Inline) string {
 	return ctx.bufra.String()
 }
 
-// SearchIncFile returns the path to filename relative to the current directory
+// SearchIncFile returns the path to filename relative to the context directory
 // or the FRUNDISLIB environnment variable, and boolean true if such a file
 // exists. Otherwise it returns a false boolean.
 func SearchIncFile(exp Exporter, filename string) (string, bool) {
 	ctx := exp.Context()
-	if fi, err := os.Stat(filename); err == nil && fi.Mode().IsRegular() {
-		return filename, true
+	fpath := ctx.Path(filename)
+	if fi, err := os.Stat(fpath); err == nil && fi.Mode().IsRegular() {
+		return fpath, true
 	}
 	for _, dir := range ctx.frundisINC {
-		fpath := path.Join(dir, filename)
+		fpath := ctx.Path(path.Join(dir, filename))
 		fi, err := os.Stat(fpath)
 		if err == nil && fi.Mode().IsRegular() {
 			return fpath, true
@@ -224,6 +239,7 @@ func loXEntryInfos(exp Exporter, class string, loXinfo *LoXinfo, id string) {
 
 // storeId stores an id with reference string ref, and of type idtype.
 func (ctx *Context) storeID(id string, idinfo IDInfo) {
+	idinfo.ID = id
 	if _, ok := ctx.IDs[id]; ok {
 		q := ctx.quiet
 		ctx.quiet = false
@@ -235,17 +251,82 @@ func (ctx *Context) storeID(id string, idinfo IDInfo) {
 
 // getCommand returns a command from a list of arguments. If there is only one
 // argument, and it contains spaces, this argument is passed to the shell
-// as-is.
-func getCommand(args []string) *exec.Cmd {
+// as-is. The command is killed when cctx is done.
+func getCommand(cctx context.Context, args []string) *exec.Cmd {
 	var cmd *exec.Cmd
 	if len(args) == 1 && containsSpace(args[0]) {
-		cmd = exec.Command("/bin/sh", "-c", args[0])
+		cmd = exec.CommandContext(cctx, "/bin/sh", "-c", args[0])
 	} else {
-		cmd = exec.Command(args[0], args[1:]...)
+		cmd = exec.CommandContext(cctx, args[0], args[1:]...)
 	}
 	return cmd
 }
 
+// runCommand runs the command given by arguments args with standard input
+// stdin, and returns its standard output. The command is killed if processing
+// is canceled, if it runs for longer than the configured timeout, or if its
+// output exceeds the configured maximum size.
+func (ctx *Context) runCommand(args []string, stdin io.Reader) ([]byte, error) {
+	pctx := ctx.cctx
+	if pctx == nil {
+		pctx = context.Background()
+	}
+	var cctx context.Context
+	var cancel context.CancelFunc
+	if ctx.execTimeout > 0 {
+		cctx, cancel = context.WithTimeout(pctx, ctx.execTimeout)
+	} else {
+		cctx, cancel = context.WithCancel(pctx)
+	}
+	defer cancel()
+	cmd := getCommand(cctx, args)
+	cmd.Stdin = stdin
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-cctx.Done():
+			// unblock reading when output is kept open by
+			// subprocesses
+			stdout.Close()
+		case <-done:
+		}
+	}()
+	var r io.Reader = stdout
+	if ctx.execMaxOutput > 0 {
+		r = io.LimitReader(stdout, ctx.execMaxOutput+1)
+	}
+	var buf bytes.Buffer
+	_, rerr := buf.ReadFrom(r)
+	close(done)
+	exceeded := ctx.execMaxOutput > 0 && int64(buf.Len()) > ctx.execMaxOutput
+	if exceeded {
+		cancel()
+	}
+	err = cmd.Wait()
+	switch {
+	case exceeded:
+		return nil, fmt.Errorf("killed: output exceeds %d bytes", ctx.execMaxOutput)
+	case pctx.Err() != nil:
+		return nil, fmt.Errorf("killed: %v", pctx.Err())
+	case cctx.Err() == context.DeadlineExceeded:
+		return nil, fmt.Errorf("killed: timeout after %v", ctx.execTimeout)
+	case err != nil:
+		return nil, err
+	case rerr != nil:
+		return nil, rerr
+	}
+	return buf.Bytes(), nil
+}
+
 // shellFilter runs a filter on text using arguments args as the filtering
 // command.
 func shellFilter(exp Exporter, args []string, text string) string {
@@ -281,10 +362,7 @@ func shellFilter(exp Exporter, args []string, text string) string {
 		ctx.Errorf("shell command: %v: file seek: %v", args, err)
 		return ""
 	}
-	cmd := getCommand(args)
-	cmd.Stdin = file
-	cmd.Stderr = os.Stderr
-	bytes, err := cmd.Output()
+	bytes, err := ctx.runCommand(args, file)
 	if err != nil {
 		ctx.Errorf("shell command: %v: %v", args, err)
 		return ""
@@ -441,3 +519,14 @@ func checkPairs(ctx *Context, pairs []string) {
 		}
 	}
 }
+
+// AbortOutputFile closes an output file f and removes it, as its content is
+// partial. It does nothing if f is nil or the standard output. It is intended
+// for use in Abort methods of exporters.
+func AbortOutputFile(f *os.File) {
+	if f == nil || f == os.Stdout {
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+}
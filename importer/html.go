@@ -0,0 +1,688 @@
+package importer
+
+import (
+	"html"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// HTML reads an HTML or XHTML document from r and writes equivalent frundis
+// source to w. Documents produced by the xhtml exporter are converted back
+// into the frundis constructs that produced them.
+func HTML(r io.Reader, w io.Writer) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	root := parseHTML(string(data))
+	hc := &htmlConverter{doc: &document{ids: map[string]bool{}, literal: true}}
+	hc.convert(root)
+	return writeDocument(w, hc.doc)
+}
+
+// HTML tree.
+
+type htmlNode struct {
+	tag      string      // lowercase element name, empty for text nodes
+	attrs    [][2]string // attributes in document order
+	text     string      // text for text nodes
+	children []*htmlNode
+}
+
+func (n *htmlNode) attr(name string) string {
+	for _, a := range n.attrs {
+		if a[0] == name {
+			return a[1]
+		}
+	}
+	return ""
+}
+
+// class returns the first class of the element.
+func (n *htmlNode) class() string {
+	cl := strings.Fields(n.attr("class"))
+	if len(cl) == 0 {
+		return ""
+	}
+	return cl[0]
+}
+
+// pairs returns element attributes other than id and class as key/value
+// pairs.
+func (n *htmlNode) pairs() []string {
+	var pairs []string
+	for _, a := range n.attrs {
+		switch a[0] {
+		case "id", "class", "xmlns":
+		default:
+			pairs = append(pairs, a[0], a[1])
+		}
+	}
+	return pairs
+}
+
+// find returns the first descendant element with the given tag name.
+func (n *htmlNode) find(tag string) *htmlNode {
+	for _, c := range n.children {
+		if c.tag == tag {
+			return c
+		}
+		if d := c.find(tag); d != nil {
+			return d
+		}
+	}
+	return nil
+}
+
+// textContent returns the concatenated text of the node.
+func (n *htmlNode) textContent() string {
+	if n.tag == "" {
+		return n.text
+	}
+	var sb strings.Builder
+	for _, c := range n.children {
+		sb.WriteString(c.textContent())
+	}
+	return sb.String()
+}
+
+// Tokenizer.
+
+type htmlTokenKind int
+
+const (
+	htmlText htmlTokenKind = iota
+	htmlStartTag
+	htmlEndTag
+	htmlSelfClosingTag
+)
+
+type htmlToken struct {
+	kind  htmlTokenKind
+	tag   string
+	attrs [][2]string
+	text  string
+}
+
+var (
+	htmlTagName = regexp.MustCompile(`^</?([a-zA-Z][a-zA-Z0-9:-]*)`)
+	htmlAttr    = regexp.MustCompile(`^[\s/]*([^\s"'>/=]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+)
+
+// rawTextElements contain text that is not parsed as markup.
+var rawTextElements = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+
+// tokenizeHTML splits s into tokens. Comments, doctype declarations and
+// processing instructions are dropped. Entities are decoded.
+func tokenizeHTML(s string) []htmlToken {
+	var toks []htmlToken
+	var sb strings.Builder
+	flush := func() {
+		if sb.Len() > 0 {
+			toks = append(toks, htmlToken{kind: htmlText, text: html.UnescapeString(sb.String())})
+			sb.Reset()
+		}
+	}
+	for i := 0; i < len(s); {
+		if s[i] != '<' {
+			j := strings.IndexByte(s[i:], '<')
+			if j < 0 {
+				j = len(s) - i
+			}
+			sb.WriteString(s[i : i+j])
+			i += j
+			continue
+		}
+		rest := s[i:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest[4:], "-->")
+			if end < 0 {
+				i = len(s)
+			} else {
+				i += 4 + end + 3
+			}
+		case strings.HasPrefix(rest, "<![CDATA["):
+			end := strings.Index(rest, "]]>")
+			if end < 0 {
+				end = len(rest)
+			}
+			flush()
+			toks = append(toks, htmlToken{kind: htmlText, text: rest[9:end]})
+			i += end + 3
+		case strings.HasPrefix(rest, "<!") || strings.HasPrefix(rest, "<?"):
+			end := strings.IndexByte(rest, '>')
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			i += end + 1
+		default:
+			m := htmlTagName.FindStringSubmatch(rest)
+			if m == nil {
+				sb.WriteByte('<')
+				i++
+				break
+			}
+			flush()
+			tok := htmlToken{kind: htmlStartTag, tag: strings.ToLower(m[1])}
+			if rest[1] == '/' {
+				tok.kind = htmlEndTag
+			}
+			j := len(m[0])
+			for {
+				am := htmlAttr.FindStringSubmatch(rest[j:])
+				if am == nil {
+					break
+				}
+				val := am[2] + am[3] + am[4]
+				tok.attrs = append(tok.attrs, [2]string{strings.ToLower(am[1]), html.UnescapeString(val)})
+				j += len(am[0])
+			}
+			end := strings.IndexByte(rest[j:], '>')
+			if end < 0 {
+				end = len(rest) - j - 1
+			}
+			if strings.HasSuffix(strings.TrimSpace(rest[j:j+end]), "/") {
+				tok.kind = htmlSelfClosingTag
+			}
+			i += j + end + 1
+			toks = append(toks, tok)
+			if tok.kind == htmlStartTag && rawTextElements[tok.tag] {
+				k := strings.Index(strings.ToLower(s[i:]), "</"+tok.tag)
+				if k < 0 {
+					k = len(s) - i
+				}
+				text := s[i : i+k]
+				if tok.tag == "title" || tok.tag == "textarea" {
+					text = html.UnescapeString(text)
+				}
+				toks = append(toks, htmlToken{kind: htmlText, text: text})
+				i += k
+			}
+		}
+	}
+	flush()
+	return toks
+}
+
+// Tree construction.
+
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true}
+
+// pClosers are elements whose start tag implicitly closes an open paragraph.
+var pClosers = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "div": true, "dl": true,
+	"fieldset": true, "figure": true, "footer": true, "form": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true, "main": true,
+	"nav": true, "ol": true, "p": true, "pre": true, "section": true, "table": true, "ul": true}
+
+// parseHTML builds a tree from an HTML document. Missing end tags are
+// handled for the most common cases.
+func parseHTML(s string) *htmlNode {
+	root := &htmlNode{tag: "#root"}
+	stack := []*htmlNode{root}
+	// closeUntil closes elements up to the innermost element with one of
+	// the names in tags, unless one of the boundary elements comes first.
+	closeUntil := func(tags []string, boundaries ...string) {
+		for i := len(stack) - 1; i > 0; i-- {
+			for _, b := range boundaries {
+				if stack[i].tag == b {
+					return
+				}
+			}
+			for _, t := range tags {
+				if stack[i].tag == t {
+					stack = stack[:i]
+					return
+				}
+			}
+		}
+	}
+	for _, tok := range tokenizeHTML(s) {
+		cur := stack[len(stack)-1]
+		switch tok.kind {
+		case htmlText:
+			cur.children = append(cur.children, &htmlNode{text: tok.text})
+		case htmlStartTag, htmlSelfClosingTag:
+			switch {
+			case tok.tag == "li":
+				closeUntil([]string{"li"}, "ul", "ol")
+			case tok.tag == "dt" || tok.tag == "dd":
+				closeUntil([]string{"dt", "dd"}, "dl")
+			case tok.tag == "tr":
+				closeUntil([]string{"tr"}, "table")
+			case tok.tag == "td" || tok.tag == "th":
+				closeUntil([]string{"td", "th"}, "tr", "table")
+			}
+			if pClosers[tok.tag] {
+				closeUntil([]string{"p"}, "div", "li", "td", "th", "dd", "blockquote", "section", "article")
+			}
+			cur = stack[len(stack)-1]
+			n := &htmlNode{tag: tok.tag, attrs: tok.attrs}
+			cur.children = append(cur.children, n)
+			if tok.kind == htmlStartTag && !voidElements[tok.tag] {
+				stack = append(stack, n)
+			}
+		case htmlEndTag:
+			closeUntil([]string{tok.tag})
+		}
+	}
+	return root
+}
+
+// Conversion into the document model.
+
+type htmlConverter struct {
+	doc *document
+	toc bool // whether a table of contents was found
+}
+
+var headerNum = regexp.MustCompile(`^\s*[0-9IVXLCDM]+(?:\.[0-9]+)*\.?\s+`)
+
+func (hc *htmlConverter) convert(root *htmlNode) {
+	if h := root.find("html"); h != nil {
+		if lang := h.attr("lang"); lang != "" {
+			hc.setParam("lang", lang)
+		}
+	}
+	if t := root.find("title"); t != nil {
+		if title := strings.Join(fields(t.textContent()), " "); title != "" {
+			hc.setParam("document-title", title)
+		}
+	}
+	body := root.find("body")
+	if body == nil {
+		body = root
+	}
+	hc.collectIDs(body)
+	hc.doc.blocks = hc.blocks(body.children)
+}
+
+func (hc *htmlConverter) setParam(name, value string) {
+	for i, p := range hc.doc.params {
+		if p[0] == name {
+			hc.doc.params[i][1] = value
+			return
+		}
+	}
+	hc.doc.params = append(hc.doc.params, [2]string{name, value})
+}
+
+// autoID matches identifiers generated by the xhtml exporter.
+var autoID = regexp.MustCompile(`^(?:s|fig|tbl|poem)[0-9]+$`)
+
+// collectIDs records element identifiers that will be kept, so that links
+// to them can be written as cross-references.
+func (hc *htmlConverter) collectIDs(n *htmlNode) {
+	for _, c := range n.children {
+		if id := c.attr("id"); id != "" && !autoID.MatchString(id) {
+			hc.doc.ids[id] = true
+		}
+		hc.collectIDs(c)
+	}
+}
+
+// keptID returns the element identifier, unless it was generated.
+func keptID(n *htmlNode) string {
+	id := n.attr("id")
+	if autoID.MatchString(id) {
+		return ""
+	}
+	return id
+}
+
+var phrasingElements = map[string]bool{
+	"a": true, "abbr": true, "b": true, "bdi": true, "bdo": true, "big": true, "br": true,
+	"cite": true, "code": true, "data": true, "del": true, "dfn": true, "em": true, "font": true,
+	"i": true, "img": true, "ins": true, "kbd": true, "mark": true, "q": true, "s": true,
+	"samp": true, "small": true, "span": true, "strike": true, "strong": true, "sub": true,
+	"sup": true, "time": true, "tt": true, "u": true, "var": true, "wbr": true}
+
+var skippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "template": true, "noscript": true,
+	"title": true, "meta": true, "link": true, "hr": true, "button": true, "form": true,
+	"input": true, "select": true, "textarea": true}
+
+// blocks converts flow content. Phrasing content outside paragraphs is
+// gathered into paragraphs.
+func (hc *htmlConverter) blocks(nodes []*htmlNode) []block {
+	var blocks []block
+	var run []inline
+	flush := func() {
+		if len(normalize(run)) > 0 {
+			blocks = append(blocks, &para{text: run})
+		}
+		run = nil
+	}
+	for _, n := range nodes {
+		if n.tag == "" || phrasingElements[n.tag] {
+			run = append(run, hc.inline(n)...)
+			continue
+		}
+		if skippedElements[n.tag] {
+			continue
+		}
+		flush()
+		blocks = append(blocks, hc.block(n)...)
+	}
+	flush()
+	return blocks
+}
+
+func (hc *htmlConverter) block(n *htmlNode) []block {
+	class := n.class()
+	switch n.tag {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		return hc.header(n)
+	case "p":
+		if class == "paragraph" && len(n.children) > 0 && n.children[0].tag == "strong" {
+			title := hc.inlines(n.children[0].children)
+			return append([]block{&header{level: 4, text: title}}, &para{text: hc.inlines(n.children[1:])})
+		}
+		return []block{&para{text: hc.inlines(n.children)}}
+	case "ul", "ol":
+		l := &list{enum: n.tag == "ol", id: keptID(n)}
+		for _, c := range n.children {
+			if c.tag == "li" {
+				l.items = append(l.items, hc.blocks(c.children))
+			}
+		}
+		return []block{l}
+	case "dl":
+		return []block{hc.descList(n)}
+	case "table":
+		return []block{hc.table(n, nil)}
+	case "pre":
+		return []block{&codeBlock{text: strings.TrimSuffix(strings.TrimPrefix(n.textContent(), "\n"), "\n")}}
+	case "figure":
+		return []block{hc.figure(n)}
+	case "nav":
+		if !hc.toc {
+			hc.toc = true
+			return []block{&tocBlock{kind: "toc"}}
+		}
+		return nil
+	case "blockquote":
+		tag := class
+		if tag == "" {
+			tag = "quote"
+		}
+		return []block{&display{tag: tag, elem: n.tag, id: keptID(n), pairs: n.pairs(), blocks: hc.blocks(n.children)}}
+	}
+	switch class {
+	case "toc", "lof", "lot", "lop":
+		if class == "toc" {
+			if hc.toc {
+				return nil
+			}
+			hc.toc = true
+		}
+		return []block{&tocBlock{kind: class}}
+	case "figure":
+		return []block{hc.figure(n)}
+	case "table":
+		if t := n.find("table"); t != nil {
+			var title []inline
+			for _, c := range n.children {
+				if c.tag == "p" && c.class() == "table-title" {
+					title = hc.inlines(c.children)
+				}
+			}
+			return []block{hc.table(t, title)}
+		}
+	case "verse":
+		return []block{hc.verse(n)}
+	case "code":
+		if pre := n.find("pre"); pre != nil {
+			return hc.block(pre)
+		}
+	case "title", "author", "date":
+		if strings.HasPrefix(n.tag, "h") {
+			hc.setParam("title-page", "1")
+			hc.setParam("document-"+class, strings.Join(fields(n.textContent()), " "))
+			return nil
+		}
+	}
+	switch n.tag {
+	case "address", "article", "aside", "div", "footer", "header", "main", "section":
+		if class == "" {
+			if n.tag == "div" && len(n.attrs) == 0 && !hasHeader(n) {
+				return []block{&display{blocks: hc.blocks(n.children)}}
+			}
+			return hc.blocks(n.children)
+		}
+		return []block{&display{tag: class, elem: n.tag, id: keptID(n), pairs: n.pairs(), blocks: hc.blocks(n.children)}}
+	}
+	return hc.blocks(n.children)
+}
+
+// hasHeader reports whether n contains a heading element.
+func hasHeader(n *htmlNode) bool {
+	for _, c := range n.children {
+		switch c.tag {
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			return true
+		}
+		if hasHeader(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (hc *htmlConverter) header(n *htmlNode) []block {
+	class := n.class()
+	level := int(n.tag[1] - '0')
+	switch class {
+	case "title", "author", "date":
+		hc.setParam("title-page", "1")
+		hc.setParam("document-"+class, strings.Join(fields(n.textContent()), " "))
+		return nil
+	case "toc-title":
+		return nil
+	}
+	h := &header{id: keptID(n), text: hc.inlines(n.children)}
+	if h.id != "" {
+		hc.setParam("xhtml-custom-ids", "1")
+	}
+	switch class {
+	case "Pt", "Ch", "Sh", "Ss":
+		h.level = map[string]int{"Pt": 0, "Ch": 1, "Sh": 2, "Ss": 3}[class]
+		if len(h.text) > 0 {
+			if t, ok := h.text[0].(text); ok {
+				if loc := headerNum.FindStringIndex(string(t)); loc != nil {
+					h.text[0] = t[loc[1]:]
+				} else {
+					h.nonum = true
+				}
+			}
+		}
+	default:
+		h.level = level
+	}
+	return []block{h}
+}
+
+func (hc *htmlConverter) descList(n *htmlNode) block {
+	l := &descList{id: keptID(n)}
+	for _, c := range n.children {
+		switch c.tag {
+		case "dt":
+			l.items = append(l.items, descItem{term: hc.inlines(c.children)})
+		case "dd":
+			if len(l.items) == 0 {
+				l.items = append(l.items, descItem{})
+			}
+			item := &l.items[len(l.items)-1]
+			item.blocks = append(item.blocks, hc.blocks(c.children)...)
+		}
+	}
+	return l
+}
+
+func (hc *htmlConverter) table(n *htmlNode, title []inline) block {
+	t := &table{id: keptID(n), title: title}
+	var rows func(n *htmlNode)
+	rows = func(n *htmlNode) {
+		for _, c := range n.children {
+			switch c.tag {
+			case "thead", "tbody", "tfoot":
+				rows(c)
+			case "caption":
+				t.title = hc.inlines(c.children)
+			case "tr":
+				var row []tableCell
+				for _, cell := range c.children {
+					if cell.tag == "td" || cell.tag == "th" {
+						row = append(row, tableCell{
+							text: hc.flatten(hc.blocks(cell.children)),
+							par:  cell.find("p") != nil})
+					}
+				}
+				t.rows = append(t.rows, row)
+			}
+		}
+	}
+	rows(n)
+	return t
+}
+
+// flatten returns the inline content of blocks, separated by spaces.
+func (hc *htmlConverter) flatten(blocks []block) []inline {
+	var ins []inline
+	for _, b := range blocks {
+		switch b := b.(type) {
+		case *para:
+			if len(ins) > 0 {
+				ins = append(ins, text(" "))
+			}
+			ins = append(ins, b.text...)
+		case *header:
+			if len(ins) > 0 {
+				ins = append(ins, text(" "))
+			}
+			ins = append(ins, b.text...)
+		}
+	}
+	return ins
+}
+
+func (hc *htmlConverter) verse(n *htmlNode) block {
+	v := &verse{id: keptID(n)}
+	for _, c := range n.children {
+		switch {
+		case c.tag == "h4" || c.tag == "h3" || c.tag == "h5":
+			v.title = hc.inlines(c.children)
+			if id := c.attr("id"); id != "" && !autoID.MatchString(id) {
+				v.id = id
+			}
+		case c.tag == "p":
+			var stanza [][]inline
+			var line []inline
+			flushLine := func() {
+				if len(normalize(line)) > 0 {
+					stanza = append(stanza, line)
+				}
+				line = nil
+			}
+			for _, l := range c.children {
+				switch {
+				case l.tag == "span" && l.class() == "verse":
+					flushLine()
+					line = hc.inlines(l.children)
+					flushLine()
+				case l.tag == "br":
+					flushLine()
+				default:
+					line = append(line, hc.inline(l)...)
+				}
+			}
+			flushLine()
+			if len(stanza) > 0 {
+				v.stanzas = append(v.stanzas, stanza)
+			}
+		}
+	}
+	return v
+}
+
+func (hc *htmlConverter) figure(n *htmlNode) block {
+	f := &figure{}
+	if img := n.find("img"); img != nil {
+		f.src = img.attr("src")
+		f.alt = img.attr("alt")
+	}
+	for _, c := range n.children {
+		switch {
+		case c.tag == "a" && c.find("img") != nil:
+			f.link = c.attr("href")
+		case c.tag == "figcaption" || c.tag == "p" && c.class() == "caption":
+			f.caption = hc.inlines(c.children)
+		}
+	}
+	if len(f.caption) == 0 {
+		f.caption = []inline{text(f.alt)}
+	}
+	return f
+}
+
+// inlines converts phrasing content. Block elements found inside are
+// flattened.
+func (hc *htmlConverter) inlines(nodes []*htmlNode) []inline {
+	var ins []inline
+	for _, n := range nodes {
+		ins = append(ins, hc.inline(n)...)
+	}
+	return ins
+}
+
+// defaultMtags gives tags for phrasing elements without a class.
+// Plain emphasis has no tag.
+var defaultMtags = map[string]string{
+	"em": "", "i": "", "cite": "", "dfn": "", "var": "",
+	"strong": "strong", "b": "strong",
+	"code": "code", "kbd": "code", "samp": "code", "tt": "code"}
+
+func (hc *htmlConverter) inline(n *htmlNode) []inline {
+	switch n.tag {
+	case "":
+		return []inline{text(n.text)}
+	case "br":
+		return []inline{lineBreak{}}
+	case "img":
+		return []inline{&image{src: n.attr("src"), alt: n.attr("alt"), id: keptID(n)}}
+	case "a":
+		href := n.attr("href")
+		if href == "" {
+			return hc.inlines(n.children)
+		}
+		if len(n.children) == 1 && n.children[0].tag == "img" {
+			img := n.children[0]
+			return []inline{&image{src: img.attr("src"), alt: img.attr("alt"), link: href, id: keptID(img)}}
+		}
+		return []inline{&link{url: href, content: hc.inlines(n.children)}}
+	case "script", "style", "wbr":
+		return nil
+	}
+	content := hc.inlines(n.children)
+	if !phrasingElements[n.tag] {
+		return append(append([]inline{text(" ")}, content...), text(" "))
+	}
+	tag := n.class()
+	if tag == "" {
+		var ok bool
+		tag, ok = defaultMtags[n.tag]
+		if ok {
+			return []inline{&markup{tag: tag, elem: n.tag, id: keptID(n), content: content}}
+		}
+		switch n.tag {
+		case "span", "font", "abbr", "bdi", "bdo", "data", "time":
+			return content
+		}
+		tag = n.tag
+	}
+	return []inline{&markup{tag: tag, elem: n.tag, id: keptID(n), pairs: n.pairs(), content: content}}
+}
@@ -0,0 +1,91 @@
+package importer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"codeberg.org/anaseto/gofrundis/exporter/xhtml"
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+func TestHTML(t *testing.T) {
+	doGolden(t, "testdata/*.html", HTML)
+}
+
+// TestHTMLRoundTrip checks that importing xhtml output gives back source
+// producing the same output.
+func TestHTMLRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.html")
+	exportXHTML(t, "testdata/roundtrip.frundis", first)
+	in, err := os.Open(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	var src bytes.Buffer
+	err = HTML(in, &src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkParse(t, "roundtrip", src.String())
+	imported := filepath.Join(dir, "imported.frundis")
+	err = os.WriteFile(imported, src.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := filepath.Join(dir, "second.html")
+	exportXHTML(t, imported, second)
+	want, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collapseSpaces(string(got)) != collapseSpaces(string(want)) {
+		t.Errorf("round trip differs:\n%s\nimported source:\n%s", got, src.String())
+	}
+}
+
+func exportXHTML(t *testing.T, filename, output string) {
+	exp := xhtml.NewExporter(&xhtml.Options{
+		Format:       "xhtml",
+		OutputFile:   output,
+		AllInOneFile: true})
+	err := frundis.ProcessFrundisSource(exp, filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var spaces = regexp.MustCompile(`\s+`)
+
+func collapseSpaces(s string) string {
+	return strings.TrimSpace(spaces.ReplaceAllString(s, " "))
+}
+
+func TestTokenizeHTML(t *testing.T) {
+	toks := tokenizeHTML(`a<!-- c --><p class='x' id=y data-z="1 &amp; 2">b &lt;<br/></p>`)
+	kinds := []htmlTokenKind{htmlText, htmlStartTag, htmlText, htmlSelfClosingTag, htmlEndTag}
+	if len(toks) != len(kinds) {
+		t.Fatalf("got %d tokens, want %d: %v", len(toks), len(kinds), toks)
+	}
+	for i, k := range kinds {
+		if toks[i].kind != k {
+			t.Errorf("token %d: kind %d, want %d", i, toks[i].kind, k)
+		}
+	}
+	p := &htmlNode{attrs: toks[1].attrs}
+	if p.class() != "x" || p.attr("id") != "y" || p.attr("data-z") != "1 & 2" {
+		t.Errorf("bad attributes: %v", toks[1].attrs)
+	}
+	if toks[2].text != "b <" {
+		t.Errorf("bad text: %q", toks[2].text)
+	}
+}
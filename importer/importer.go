@@ -0,0 +1,826 @@
+// Package importer converts documents written in other markup languages into
+// frundis source.
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// document represents an imported document.
+type document struct {
+	params  [][2]string     // parameters set with X set
+	blocks  []block         // document body
+	ids     map[string]bool // identifiers defined in the document
+	literal bool            // whether apostrophes are literal (no typography)
+}
+
+// Document model shared by importers. Blocks and inlines are represented by
+// the following types.
+type (
+	block  interface{}
+	inline interface{}
+)
+
+type header struct {
+	level int // 0 for parts, 4 or more for paragraph titles
+	text  []inline
+	id    string
+	nonum bool
+}
+
+type para struct {
+	text []inline
+}
+
+type list struct {
+	enum  bool
+	id    string
+	items [][]block
+}
+
+type descList struct {
+	id    string
+	items []descItem
+}
+
+type descItem struct {
+	term   []inline
+	blocks []block
+}
+
+type table struct {
+	id    string
+	title []inline
+	rows  [][]tableCell
+}
+
+type tableCell struct {
+	text []inline
+	par  bool // whether the cell contains a paragraph
+}
+
+type verse struct {
+	id      string
+	title   []inline
+	stanzas [][][]inline // lines of each stanza
+}
+
+type codeBlock struct {
+	text string
+}
+
+type display struct {
+	tag    string // dtag name
+	elem   string // html element
+	id     string
+	pairs  []string
+	blocks []block
+}
+
+type figure struct {
+	src     string
+	caption []inline
+	alt     string
+	link    string
+}
+
+type tocBlock struct {
+	kind string // toc, lof, lot or lop
+}
+
+type text string
+
+type markup struct {
+	tag     string // mtag name (e.g. "em"), or empty for plain emphasis
+	elem    string // html element, if any
+	id      string
+	pairs   []string
+	content []inline
+}
+
+type link struct {
+	url     string
+	content []inline
+}
+
+type image struct {
+	src  string
+	alt  string
+	link string
+	id   string
+}
+
+type lineBreak struct{}
+
+// tagDecl describes a tag as found in the imported document.
+type tagDecl struct {
+	elem  string
+	pairs []string
+}
+
+// writer writes frundis source from a document model.
+type writer struct {
+	body  bytes.Buffer
+	doc   *document
+	mtags map[string]*tagDecl // used mtags
+	dtags map[string]*tagDecl // used dtags
+	code  bool                // whether code blocks are used
+	apos  bool                // whether to protect apostrophes from typography
+}
+
+// writeDocument writes frundis source for doc to w, preceded by the
+// necessary parameter and tag declarations.
+func writeDocument(w io.Writer, doc *document) error {
+	fw := &writer{doc: doc, mtags: map[string]*tagDecl{}, dtags: map[string]*tagDecl{}}
+	if doc.literal {
+		// English typographic rules apply by default
+		fw.apos = true
+		for _, p := range doc.params {
+			if p[0] == "lang" && p[1] != "en" {
+				fw.apos = false
+			}
+		}
+	}
+	fw.blocks(doc.blocks)
+	var out bytes.Buffer
+	fw.declarations(&out)
+	out.Write(fw.body.Bytes())
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// allFormats returns the comma-separated list of builtin export formats, for
+// which tags are declared. It does not depend on the exporters linked in, so
+// that output is the same for every program.
+func allFormats() string {
+	return strings.Join(frundis.BuiltinFormats(), ",")
+}
+
+// builtinTag describes rendering of some well-known tags: the html element
+// used by default, and declarations for other formats.
+type builtinTag struct {
+	elem  string
+	decls []string
+}
+
+var mtagBuiltins = map[string]builtinTag{
+	"em": {elem: "em"},
+	"strong": {elem: "strong", decls: []string{
+		".X mtag -f latex -t strong -c textbf",
+		".X mtag -f markdown -t strong -c **",
+		".X mtag -f mom -t strong -c B",
+		".X mtag -f typst -t strong -c strong"}},
+	"code": {elem: "code", decls: []string{
+		".X mtag -f latex -t code -c texttt",
+		".X mtag -f markdown -t code -c `",
+		".X mtag -f mom -t code -c CR"}},
+}
+
+var dtagBuiltins = map[string]builtinTag{
+	"quote": {elem: "blockquote", decls: []string{
+		".X dtag -f latex -t quote -c quote"}},
+}
+
+// codeMacros defines the code dtag and the Bcode and Ecode macros delimiting
+// code blocks. The {{all}} placeholder is replaced by the list of formats
+// returned by allFormats. Code is written raw for latex and markdown, and
+// with the escape filter for other formats.
+const codeMacros = `.X dtag -f {{all}} -t code
+.X dtag -f latex -t code -c verbatim
+.X dtag -f mom -t code -c CODE
+.X dtag -f xhtml,epub -t code -c div
+.#de Bcode
+.Bd -r -t code
+.Bf -f xhtml,epub
+<pre class="code">
+.Ef
+.#if -f latex
+.Bf -f latex
+.#elif -f markdown
+.Bf -f markdown
+` + "```" + `
+
+.#else
+.Bf -t escape
+.#;
+.#.
+.#de Ecode
+.#if -f markdown
+
+` + "```" + `
+.#;
+.Ef
+.Ft -f xhtml,epub </pre>
+.Ed -t code
+.#.
+`
+
+func (fw *writer) declarations(w io.Writer) {
+	for _, p := range fw.doc.params {
+		fmt.Fprintf(w, ".X set %s %s\n", p[0], quoteArg(p[1]))
+	}
+	writeTagDecls(w, "mtag", fw.mtags, mtagBuiltins)
+	writeTagDecls(w, "dtag", fw.dtags, dtagBuiltins)
+	if fw.code {
+		fmt.Fprint(w, strings.Replace(codeMacros, "{{all}}", allFormats(), 1))
+	}
+}
+
+func writeTagDecls(w io.Writer, kind string, used map[string]*tagDecl, builtins map[string]builtinTag) {
+	tags := make([]string, 0, len(used))
+	for tag := range used {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		decl := used[tag]
+		fmt.Fprintf(w, ".X %s -f %s -t %s\n", kind, allFormats(), escapeArg(tag, false))
+		b := builtins[tag]
+		for _, d := range b.decls {
+			fmt.Fprintln(w, d)
+		}
+		elem := decl.elem
+		if elem == "" {
+			elem = b.elem
+		}
+		if elem == "" {
+			if kind == "mtag" {
+				elem = "span"
+			} else {
+				elem = "div"
+			}
+		}
+		s := fmt.Sprintf(".X %s -f xhtml,epub -t %s -c %s", kind, escapeArg(tag, false), elem)
+		if len(decl.pairs) > 0 {
+			s += " -a " + escapeArg(pairsArg(decl.pairs), true)
+		}
+		fmt.Fprintln(w, s)
+	}
+}
+
+// pairsArg returns a -a option argument for key/value pairs.
+func pairsArg(pairs []string) string {
+	all := strings.Join(pairs, "")
+	sep := "|"
+	for _, c := range "|/:;#,!" {
+		if !strings.ContainsRune(all, c) {
+			sep = string(c)
+			break
+		}
+	}
+	return sep + strings.Join(pairs, sep)
+}
+
+// useTag records the use of a tag with a given html element and attributes.
+// The first use determines the declaration.
+func useTag(used map[string]*tagDecl, tag, elem string, pairs []string) {
+	if _, ok := used[tag]; !ok {
+		used[tag] = &tagDecl{elem: elem, pairs: pairs}
+	}
+}
+
+func (fw *writer) line(s string) {
+	fw.body.WriteString(s)
+	fw.body.WriteByte('\n')
+}
+
+func (fw *writer) blocks(blocks []block) {
+	prevPara := false
+	titled := false // whether previous block is a paragraph title
+	for _, b := range blocks {
+		isPara := false
+		switch b := b.(type) {
+		case *header:
+			fw.header(b)
+			if b.level >= 4 {
+				titled = true
+				continue
+			}
+		case *para:
+			if len(normalize(b.text)) == 0 {
+				continue
+			}
+			if prevPara && !titled {
+				fw.line(".P")
+			}
+			fw.inlines(b.text)
+			isPara = true
+		case *list:
+			fw.list(b)
+		case *descList:
+			fw.descList(b)
+		case *table:
+			fw.table(b)
+		case *verse:
+			fw.verse(b)
+		case *codeBlock:
+			fw.code = true
+			fw.line(".Bcode")
+			for _, l := range strings.Split(b.text, "\n") {
+				if l == "" {
+					l = `\&` // avoid empty lines
+				} else {
+					l = escapeTextLine(l)
+				}
+				fw.line(l)
+			}
+			fw.line(".Ecode")
+		case *display:
+			fw.display(b)
+		case *figure:
+			s := ".Im"
+			if b.alt != "" && b.alt != plainText(b.caption) {
+				s += " -alt " + quoteArg(b.alt)
+			}
+			if b.link != "" {
+				s += " -link " + escapeArg(b.link, false)
+			}
+			s += " " + escapeArg(b.src, false)
+			if caption := strings.Join(fields(plainText(b.caption)), " "); caption != "" {
+				s += " " + quoteArg(caption)
+			}
+			fw.line(s)
+		case *tocBlock:
+			fw.line(".Tc -" + b.kind)
+		}
+		prevPara = isPara
+		titled = false
+	}
+}
+
+func (fw *writer) header(h *header) {
+	var macro string
+	switch h.level {
+	case 0:
+		macro = ".Pt"
+	case 1:
+		macro = ".Ch"
+	case 2:
+		macro = ".Sh"
+	case 3:
+		macro = ".Ss"
+	default:
+		macro = ".P"
+	}
+	args := fw.args(h.text)
+	if args == "" {
+		return
+	}
+	if h.level < 4 {
+		macro += idOpt(h.id)
+		if h.nonum {
+			macro += " -nonum"
+		}
+	}
+	fw.line(macro + " " + args)
+}
+
+// argsOK reports whether inlines can be written as macro arguments without
+// losing markup: in arguments, Sm applies to all the following ones, so
+// markup can only be followed by other markup.
+func argsOK(ins []inline) bool {
+	ins = normalize(ins)
+	marked := false
+	for i, in := range ins {
+		switch in := in.(type) {
+		case text:
+			if !marked {
+				continue
+			}
+			if d, rest := splitDelim(string(in)); d != "" && i == len(ins)-1 && len(fields(rest)) == 0 {
+				continue
+			}
+			if len(fields(string(in))) > 0 {
+				return false
+			}
+		case *markup:
+			if _, ok := singleText(in.content); !ok {
+				return false
+			}
+			marked = true
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// args returns escaped macro arguments for inlines. Markup is kept when
+// possible.
+func (fw *writer) args(ins []inline) string {
+	if !argsOK(ins) {
+		return fw.protect(escapeArgs(fields(plainText(ins))))
+	}
+	ins = normalize(ins)
+	var args []string
+	for i, in := range ins {
+		switch in := in.(type) {
+		case text:
+			words := fields(string(in))
+			if len(words) == 0 {
+				continue
+			}
+			if i == len(ins)-1 && i > 0 {
+				// closing delimiter of last markup
+				args = append(args, escapeArg(words[0], true))
+				continue
+			}
+			args = append(args, fw.protect(escapeArgs(words)))
+		case *markup:
+			t, _ := singleText(in.content)
+			s := "Sm"
+			if in.tag != "" {
+				useTag(fw.mtags, in.tag, in.elem, in.pairs)
+				s += " -t " + escapeArg(in.tag, false)
+			}
+			if i > 0 {
+				if prev, ok := ins[i-1].(text); ok && !endsWithSpace(string(prev)) {
+					s += " -ns"
+				}
+			}
+			args = append(args, s, fw.protect(escapeArgs(fields(t))))
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+func idOpt(id string) string {
+	if id == "" {
+		return ""
+	}
+	return " -id " + escapeArg(id, false)
+}
+
+func (fw *writer) list(l *list) {
+	if l.enum {
+		fw.line(".Bl -t enum" + idOpt(l.id))
+	} else {
+		fw.line(".Bl" + idOpt(l.id))
+	}
+	for _, item := range l.items {
+		fw.line(".It")
+		fw.blocks(item)
+	}
+	fw.line(".El")
+}
+
+func (fw *writer) descList(l *descList) {
+	fw.line(".Bl -t desc" + idOpt(l.id))
+	for _, item := range l.items {
+		fw.line(".It " + fw.args(item.term))
+		fw.blocks(item.blocks)
+	}
+	fw.line(".El")
+}
+
+func (fw *writer) table(t *table) {
+	cols := 0
+	for _, row := range t.rows {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+	s := fmt.Sprintf(".Bl -t table -columns %d", cols) + idOpt(t.id)
+	if title := fw.args(t.title); title != "" {
+		s += " " + title
+	}
+	fw.line(s)
+	for _, row := range t.rows {
+		for i, cell := range row {
+			macro := ".Ta"
+			if i == 0 {
+				macro = ".It"
+			}
+			if args := fw.args(cell.text); !cell.par && args != "" && argsOK(cell.text) {
+				fw.line(macro + " " + args)
+				continue
+			}
+			fw.line(macro)
+			fw.inlines(cell.text)
+		}
+	}
+	fw.line(".El")
+}
+
+func (fw *writer) verse(v *verse) {
+	s := ".Bl -t verse" + idOpt(v.id)
+	if title := fw.args(v.title); title != "" {
+		s += " " + title
+	}
+	fw.line(s)
+	for i, stanza := range v.stanzas {
+		if i > 0 {
+			fw.line(".P")
+		}
+		for _, l := range stanza {
+			fw.line(".It")
+			fw.inlines(l)
+		}
+	}
+	fw.line(".El")
+}
+
+func (fw *writer) display(d *display) {
+	if d.tag == "" {
+		fw.line(".Bd" + idOpt(d.id))
+		fw.blocks(d.blocks)
+		fw.line(".Ed")
+		return
+	}
+	useTag(fw.dtags, d.tag, d.elem, d.pairs)
+	fw.line(".Bd -t " + escapeArg(d.tag, false) + idOpt(d.id))
+	fw.blocks(d.blocks)
+	fw.line(".Ed")
+}
+
+// inlines writes paragraph content made of text lines and phrasing macros.
+func (fw *writer) inlines(ins []inline) {
+	ins = normalize(ins)
+	glued := false // whether current item is glued to previous text
+	for i := 0; i < len(ins); i++ {
+		var next string
+		if i+1 < len(ins) {
+			if t, ok := ins[i+1].(text); ok {
+				next = string(t)
+			}
+		}
+		ns := nsFlag(i > 0 && glued)
+		delim, rest := splitDelim(next)
+		switch in := ins[i].(type) {
+		case text:
+			s := string(in)
+			fw.text(s)
+			glued = !endsWithSpace(s)
+			continue
+		case *markup:
+			opts := idOpt(in.id)
+			if in.tag != "" {
+				useTag(fw.mtags, in.tag, in.elem, in.pairs)
+				opts = " -t " + escapeArg(in.tag, false) + opts
+			}
+			if t, ok := singleText(in.content); ok && (rest == "" || startsWithSpace(rest)) {
+				fw.line(".Sm" + ns + opts + " " + fw.protect(escapeArgs(fields(t))) + delimArg(delim))
+			} else {
+				fw.line(".Bm" + ns + opts)
+				fw.inlines(in.content)
+				em := ".Em"
+				if rest != "" && !startsWithSpace(rest) {
+					em += " -ns"
+				}
+				fw.line(em + delimArg(delim))
+			}
+		case *link:
+			label := fw.args(in.content)
+			if id := strings.TrimPrefix(in.url, "#"); id != in.url && fw.doc.ids[id] {
+				s := ".Sx" + ns + " " + escapeArg(id, false)
+				if label != "" {
+					s += " " + label
+				}
+				fw.line(s + delimArg(delim))
+				break
+			}
+			s := ".Lk" + ns + " " + escapeArg(in.url, false)
+			if label != "" && strings.Join(fields(plainText(in.content)), " ") != in.url {
+				s += " " + label
+			}
+			fw.line(s + delimArg(delim))
+		case *image:
+			s := ".Im" + ns
+			if in.alt != "" {
+				s += " -alt " + quoteArg(in.alt)
+			}
+			if in.link != "" {
+				s += " -link " + escapeArg(in.link, false)
+			}
+			s += idOpt(in.id)
+			fw.line(s + " " + escapeArg(in.src, false) + delimArg(delim))
+		}
+		if delim != "" {
+			ins[i+1] = text(rest)
+		}
+		glued = false
+	}
+}
+
+// text writes text lines, wrapping long lines.
+func (fw *writer) text(s string) {
+	words := fields(s)
+	var sb strings.Builder
+	for _, w := range words {
+		if sb.Len() > 0 && sb.Len()+len(w) >= 72 {
+			fw.line(fw.protect(escapeTextLine(sb.String())))
+			sb.Reset()
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(w)
+	}
+	if sb.Len() > 0 {
+		fw.line(fw.protect(escapeTextLine(sb.String())))
+	}
+}
+
+// protect escapes apostrophes in escaped text, so that they are not
+// converted by typographic rules.
+func (fw *writer) protect(s string) string {
+	if !fw.apos {
+		return s
+	}
+	return strings.Replace(s, "'", `\&'`, -1)
+}
+
+// normalize merges adjacent text, turns line breaks into spaces, and drops
+// empty markup.
+func normalize(ins []inline) []inline {
+	var res []inline
+	for _, in := range ins {
+		switch in := in.(type) {
+		case lineBreak:
+			res = appendText(res, " ")
+		case text:
+			res = appendText(res, in)
+		case *markup:
+			if len(normalize(in.content)) == 0 {
+				continue
+			}
+			res = append(res, in)
+		default:
+			res = append(res, in)
+		}
+	}
+	if len(res) == 1 {
+		if t, ok := res[0].(text); ok && len(fields(string(t))) == 0 {
+			return nil
+		}
+	}
+	return res
+}
+
+func appendText(ins []inline, t text) []inline {
+	if len(ins) > 0 {
+		if prev, ok := ins[len(ins)-1].(text); ok {
+			ins[len(ins)-1] = prev + t
+			return ins
+		}
+	}
+	return append(ins, t)
+}
+
+// singleText returns the text of content, if it is only made of text.
+func singleText(content []inline) (string, bool) {
+	content = normalize(content)
+	if len(content) != 1 {
+		return "", false
+	}
+	t, ok := content[0].(text)
+	if !ok {
+		return "", false
+	}
+	return string(t), true
+}
+
+// plainText returns the text of inlines without any markup.
+func plainText(ins []inline) string {
+	var sb strings.Builder
+	for _, in := range ins {
+		switch in := in.(type) {
+		case text:
+			sb.WriteString(string(in))
+		case lineBreak:
+			sb.WriteByte(' ')
+		case *markup:
+			sb.WriteString(plainText(in.content))
+		case *link:
+			sb.WriteString(plainText(in.content))
+		case *image:
+			sb.WriteString(in.alt)
+		}
+	}
+	return sb.String()
+}
+
+// isBreakingSpace reports whether r is a space separating words. Non-breaking
+// spaces are not.
+func isBreakingSpace(r rune) bool {
+	return unicode.IsSpace(r) && r != '\u00a0' && r != '\u202f'
+}
+
+// fields splits s around breaking spaces.
+func fields(s string) []string {
+	return strings.FieldsFunc(s, isBreakingSpace)
+}
+
+// splitDelim returns the punctuation delimiter at the start of s, if any,
+// as well as the remaining text.
+func splitDelim(s string) (string, string) {
+	i := strings.IndexFunc(s, isBreakingSpace)
+	if i < 0 {
+		i = len(s)
+	}
+	if i == 0 {
+		return "", s
+	}
+	for _, c := range s[:i] {
+		if !unicode.IsPunct(c) {
+			return "", s
+		}
+	}
+	return s[:i], s[i:]
+}
+
+func nsFlag(ns bool) string {
+	if ns {
+		return " -ns"
+	}
+	return ""
+}
+
+func delimArg(delim string) string {
+	if delim == "" {
+		return ""
+	}
+	return " " + escapeArg(delim, true)
+}
+
+func startsWithSpace(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return s == "" || isBreakingSpace(r)
+}
+
+func endsWithSpace(s string) bool {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return s == "" || isBreakingSpace(r)
+}
+
+var escaper = strings.NewReplacer(`\`, `\e`)
+
+// escapeTextLine escapes a frundis text line.
+func escapeTextLine(s string) string {
+	s = escaper.Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// escapeArgs escapes words for use as macro arguments. A last argument made
+// only of punctuation is escaped so that it is not taken as a delimiter.
+func escapeArgs(words []string) string {
+	args := make([]string, len(words))
+	for i, w := range words {
+		args[i] = escapeArg(w, false)
+		if i == len(words)-1 {
+			if d, _ := splitDelim(w); d != "" && !strings.HasPrefix(args[i], `\&`) && !strings.HasPrefix(args[i], `"`) {
+				args[i] = `\&` + args[i]
+			}
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// escapeArg escapes a single word for use as macro argument. Delimiters are
+// not protected against punctuation interpretation.
+func escapeArg(w string, delim bool) string {
+	if strings.ContainsAny(w, "\" \t") {
+		if delim {
+			return quote(escaper.Replace(w))
+		}
+		return quoteArg(w)
+	}
+	w = strings.Replace(escaper.Replace(w), "\u00a0", `\~`, -1)
+	if delim {
+		return w
+	}
+	switch {
+	case w == "Sm" || w == "Bm" || w == "Em":
+		w = `\&` + w
+	case strings.HasPrefix(w, "-"):
+		w = `\&` + w
+	}
+	return w
+}
+
+// quoteArg returns a quoted macro argument. The argument is protected
+// against interpretation as an option or a delimiter.
+func quoteArg(s string) string {
+	d, _ := splitDelim(s)
+	s = escaper.Replace(s)
+	if strings.HasPrefix(s, "-") || d != "" && d == s {
+		s = `\&` + s
+	}
+	return quote(s)
+}
+
+// quote quotes an already escaped argument.
+func quote(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
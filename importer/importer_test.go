@@ -0,0 +1,59 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "codeberg.org/anaseto/gofrundis/exporter/docbook"
+	_ "codeberg.org/anaseto/gofrundis/exporter/events"
+	_ "codeberg.org/anaseto/gofrundis/exporter/fb2"
+	_ "codeberg.org/anaseto/gofrundis/exporter/latex"
+	_ "codeberg.org/anaseto/gofrundis/exporter/markdown"
+	_ "codeberg.org/anaseto/gofrundis/exporter/mom"
+	_ "codeberg.org/anaseto/gofrundis/exporter/pandoc"
+	_ "codeberg.org/anaseto/gofrundis/exporter/tei"
+	_ "codeberg.org/anaseto/gofrundis/exporter/typst"
+	_ "codeberg.org/anaseto/gofrundis/exporter/xhtml"
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// TestAllFormats checks that imported documents can be exported to every
+// builtin format without diagnostics, except those about missing image
+// files and the EPUB title, which depend on the original document.
+func TestAllFormats(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.frundis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f, "roundtrip.frundis") {
+			// not an imported document
+			continue
+		}
+		dir := t.TempDir()
+		var exps []frundis.Exporter
+		for _, format := range frundis.BuiltinFormats() {
+			exp, err := frundis.NewFormatExporter(&frundis.FormatOptions{
+				Format:       format,
+				OutputFile:   filepath.Join(dir, format),
+				AllInOneFile: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			exps = append(exps, exp)
+		}
+		var diags bytes.Buffer
+		err := frundis.ProcessFrundisSourceConcurrently(context.Background(), exps, f, &frundis.ProcessOptions{}, &diags)
+		if err != nil {
+			t.Errorf("%s: %v", f, err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(diags.String()), "\n") {
+			if line != "" && !strings.Contains(line, "image") && !strings.Contains(line, "document-title") {
+				t.Errorf("%s: diagnostic: %s", f, line)
+			}
+		}
+	}
+}
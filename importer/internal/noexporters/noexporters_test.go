@@ -0,0 +1,44 @@
+// Package noexporters_test checks the importer in a test binary without any
+// exporter package linked in, as in a program using only package importer.
+package noexporters_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"codeberg.org/anaseto/gofrundis/frundis"
+	"codeberg.org/anaseto/gofrundis/importer"
+)
+
+func TestMarkdown(t *testing.T) {
+	if formats := frundis.Formats(); len(formats) != 0 {
+		t.Fatalf("registered formats: %v", formats)
+	}
+	files, err := filepath.Glob("../../testdata/*.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		in, err := os.Open(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		err = importer.Markdown(in, &out)
+		in.Close()
+		if err != nil {
+			t.Errorf("%s: %v", f, err)
+			continue
+		}
+		golden := f[:len(f)-len(".md")] + ".frundis"
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != string(want) {
+			t.Errorf("%s: output differs from %s:\n%s", f, golden, out.String())
+		}
+	}
+}
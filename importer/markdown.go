@@ -0,0 +1,661 @@
+package importer
+
+import (
+	"bufio"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Markdown reads CommonMark text (with GitHub pipe tables) from r and writes
+// equivalent frundis source to w. Raw HTML is kept as text.
+func Markdown(r io.Reader, w io.Writer) error {
+	var lines []string
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		lines = append(lines, strings.Replace(s.Text(), "\t", "    ", -1))
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	md := &mdParser{refs: map[string]string{}}
+	lines = md.collectRefs(lines)
+	return writeDocument(w, &document{blocks: md.blocks(lines)})
+}
+
+// mdParser parses markdown.
+type mdParser struct {
+	refs map[string]string // link reference definitions
+}
+
+var (
+	mdATX       = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	mdFence     = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})")
+	mdHR        = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	mdQuote     = regexp.MustCompile(`^ {0,3}> ?`)
+	mdItem      = regexp.MustCompile(`^( {0,3})([-+*]|[0-9]{1,9}[.)])( +|$)`)
+	mdSetext1   = regexp.MustCompile(`^ {0,3}=+[ \t]*$`)
+	mdSetext2   = regexp.MustCompile(`^ {0,3}-+[ \t]*$`)
+	mdTableSep  = regexp.MustCompile(`^ *\|? *:?-+:? *(\| *:?-+:? *)*\|? *$`)
+	mdRefDef    = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:[ \t]*<?([^ \t>]+)>?(?:[ \t]+(?:"[^"]*"|'[^']*'|\([^)]*\)))?[ \t]*$`)
+	mdAutolink  = regexp.MustCompile(`^<([a-zA-Z][a-zA-Z0-9+.-]{1,31}:[^ <>]*|[a-zA-Z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9.-]+)>`)
+	mdLinkTitle = regexp.MustCompile(`^[ \t\n]+(?:"(?:\\.|[^"])*"|'(?:\\.|[^'])*'|\((?:\\.|[^)])*\))`)
+)
+
+func isBlank(s string) bool {
+	return strings.TrimSpace(s) == ""
+}
+
+func indentation(s string) int {
+	return len(s) - len(strings.TrimLeft(s, " "))
+}
+
+// collectRefs records link reference definitions and removes them from
+// lines.
+func (md *mdParser) collectRefs(lines []string) []string {
+	var res []string
+	inFence := false
+	for _, l := range lines {
+		if mdFence.MatchString(strings.TrimLeft(l, " >")) {
+			inFence = !inFence
+		}
+		if !inFence {
+			if m := mdRefDef.FindStringSubmatch(l); m != nil {
+				label := normalizeLabel(m[1])
+				if _, ok := md.refs[label]; !ok {
+					md.refs[label] = m[2]
+				}
+				continue
+			}
+		}
+		res = append(res, l)
+	}
+	return res
+}
+
+func normalizeLabel(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// interrupts reports whether line l starts a block that interrupts a
+// paragraph.
+func interrupts(l string) bool {
+	if mdATX.MatchString(l) || mdFence.MatchString(l) || mdHR.MatchString(l) || mdQuote.MatchString(l) {
+		return true
+	}
+	if m := mdItem.FindStringSubmatch(l); m != nil && m[3] != "" {
+		return m[2] == "-" || m[2] == "+" || m[2] == "*" || strings.HasPrefix(m[2], "1")
+	}
+	return false
+}
+
+// blocks parses block structure.
+func (md *mdParser) blocks(lines []string) []block {
+	var blocks []block
+	for i := 0; i < len(lines); {
+		l := lines[i]
+		switch {
+		case isBlank(l):
+			i++
+		case mdATX.MatchString(l):
+			m := mdATX.FindStringSubmatch(l)
+			blocks = append(blocks, &header{level: len(m[1]), text: md.inlines(m[2])})
+			i++
+		case mdFence.MatchString(l):
+			var cb *codeBlock
+			cb, i = md.fencedCode(lines, i)
+			blocks = append(blocks, cb)
+		case indentation(l) >= 4:
+			var code []string
+			for ; i < len(lines) && (isBlank(lines[i]) || indentation(lines[i]) >= 4); i++ {
+				if isBlank(lines[i]) {
+					code = append(code, "")
+				} else {
+					code = append(code, lines[i][4:])
+				}
+			}
+			for len(code) > 0 && code[len(code)-1] == "" {
+				code = code[:len(code)-1]
+			}
+			blocks = append(blocks, &codeBlock{text: strings.Join(code, "\n")})
+		case mdHR.MatchString(l):
+			i++
+		case mdQuote.MatchString(l):
+			var qlines []string
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				if loc := mdQuote.FindStringIndex(lines[i]); loc != nil {
+					qlines = append(qlines, lines[i][loc[1]:])
+				} else if len(qlines) > 0 && !interrupts(lines[i]) {
+					qlines = append(qlines, lines[i]) // lazy continuation
+				} else {
+					break
+				}
+			}
+			blocks = append(blocks, &display{tag: "quote", elem: "blockquote", blocks: md.blocks(qlines)})
+		case mdItem.MatchString(l):
+			var ls *list
+			ls, i = md.list(lines, i)
+			blocks = append(blocks, ls)
+		case i+1 < len(lines) && strings.Contains(l, "|") && mdTableSep.MatchString(lines[i+1]) &&
+			len(splitRow(l)) == len(splitRow(lines[i+1])):
+			var t *table
+			t, i = md.table(lines, i)
+			blocks = append(blocks, t)
+		default:
+			var b block
+			b, i = md.paragraph(lines, i)
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+func (md *mdParser) fencedCode(lines []string, i int) (*codeBlock, int) {
+	m := mdFence.FindStringSubmatch(lines[i])
+	indent, fence := len(m[1]), m[2]
+	var code []string
+	for i++; i < len(lines); i++ {
+		l := lines[i]
+		t := strings.TrimLeft(l, " ")
+		if indentation(l) < 4 && strings.HasPrefix(t, fence) && strings.Trim(t, fence[:1]+" ") == "" {
+			i++
+			break
+		}
+		n := indentation(l)
+		if n > indent {
+			n = indent
+		}
+		code = append(code, l[n:])
+	}
+	return &codeBlock{text: strings.Join(code, "\n")}, i
+}
+
+func (md *mdParser) paragraph(lines []string, i int) (block, int) {
+	var plines []string
+	for ; i < len(lines); i++ {
+		l := lines[i]
+		if isBlank(l) {
+			break
+		}
+		if len(plines) > 0 {
+			if mdSetext1.MatchString(l) || mdSetext2.MatchString(l) {
+				level := 1
+				if mdSetext2.MatchString(l) {
+					level = 2
+				}
+				return &header{level: level, text: md.inlines(strings.Join(plines, "\n"))}, i + 1
+			}
+			if interrupts(l) {
+				break
+			}
+		}
+		plines = append(plines, strings.TrimLeft(l, " "))
+	}
+	ins := md.inlines(strings.TrimRight(strings.Join(plines, "\n"), " "))
+	if len(ins) == 1 {
+		if img, ok := ins[0].(*image); ok {
+			return &figure{src: img.src, caption: []inline{text(img.alt)}, alt: img.alt}, i
+		}
+	}
+	return &para{text: ins}, i
+}
+
+func (md *mdParser) list(lines []string, i int) (*list, int) {
+	m := mdItem.FindStringSubmatch(lines[i])
+	marker := m[2]
+	enum := !strings.ContainsAny(marker, "-+*")
+	kind := marker[len(marker)-1:]
+	ls := &list{enum: enum}
+	for i < len(lines) {
+		m := mdItem.FindStringSubmatch(lines[i])
+		if m == nil || m[2][len(m[2])-1:] != kind {
+			break
+		}
+		content := len(m[0])
+		if m[3] == "" || len(m[3]) > 4 {
+			// blank or indented code item: content starts after one space
+			content = len(m[1]) + len(m[2]) + 1
+		}
+		first := ""
+		if len(lines[i]) > content {
+			first = lines[i][content:]
+		}
+		ilines := []string{first}
+		i++
+		for ; i < len(lines); i++ {
+			l := lines[i]
+			if isBlank(l) {
+				// a blank line belongs to the item only if the item continues
+				j := i + 1
+				for j < len(lines) && isBlank(lines[j]) {
+					j++
+				}
+				if j < len(lines) && indentation(lines[j]) >= content {
+					ilines = append(ilines, "")
+					continue
+				}
+				break
+			}
+			if indentation(l) >= content {
+				ilines = append(ilines, l[content:])
+				continue
+			}
+			if !isBlank(ilines[len(ilines)-1]) && !interrupts(l) && !mdItem.MatchString(l) {
+				ilines = append(ilines, l) // lazy continuation
+				continue
+			}
+			break
+		}
+		ls.items = append(ls.items, md.blocks(ilines))
+		for i < len(lines) && isBlank(lines[i]) {
+			i++
+		}
+	}
+	return ls, i
+}
+
+// splitRow splits a pipe table row into cells.
+func splitRow(l string) []string {
+	l = strings.TrimSpace(l)
+	l = strings.TrimPrefix(l, "|")
+	if strings.HasSuffix(l, "|") && !strings.HasSuffix(l, `\|`) {
+		l = l[:len(l)-1]
+	}
+	var cells []string
+	var sb strings.Builder
+	for i := 0; i < len(l); i++ {
+		switch {
+		case l[i] == '\\' && i+1 < len(l) && l[i+1] == '|':
+			sb.WriteByte('|')
+			i++
+		case l[i] == '|':
+			cells = append(cells, strings.TrimSpace(sb.String()))
+			sb.Reset()
+		default:
+			sb.WriteByte(l[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(sb.String()))
+}
+
+func (md *mdParser) table(lines []string, i int) (*table, int) {
+	cols := len(splitRow(lines[i]))
+	t := &table{}
+	addRow := func(l string) {
+		cells := splitRow(l)
+		row := make([]tableCell, cols)
+		for j := range row {
+			if j < len(cells) {
+				row[j].text = md.inlines(cells[j])
+			}
+		}
+		t.rows = append(t.rows, row)
+	}
+	addRow(lines[i])
+	for i += 2; i < len(lines) && !isBlank(lines[i]) && !interrupts(lines[i]); i++ {
+		addRow(lines[i])
+	}
+	return t, i
+}
+
+// Inline parsing. The text is first split into nodes: text, delimiter runs
+// of '*' and '_', and already parsed inlines. Emphasis is then resolved
+// following the CommonMark delimiter algorithm.
+
+type mdNode struct {
+	in       inline // parsed inline, or nil for text and delimiters
+	text     string
+	delim    byte // '*' or '_' for delimiter runs
+	n        int  // remaining delimiter count
+	orig     int  // original delimiter count
+	canOpen  bool
+	canClose bool
+}
+
+func (md *mdParser) inlines(s string) []inline {
+	nodes := md.tokenize(s)
+	nodes = resolveEmphasis(nodes)
+	var res []inline
+	for _, n := range nodes {
+		switch {
+		case n.in != nil:
+			res = append(res, n.in)
+		case n.delim != 0:
+			res = append(res, text(strings.Repeat(string(n.delim), n.n)))
+		default:
+			res = append(res, text(n.text))
+		}
+	}
+	return mergeText(res)
+}
+
+func mergeText(ins []inline) []inline {
+	var res []inline
+	for _, in := range ins {
+		if t, ok := in.(text); ok {
+			res = appendText(res, t)
+			continue
+		}
+		res = append(res, in)
+	}
+	return res
+}
+
+func isASCIIPunct(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
+
+func (md *mdParser) tokenize(s string) []*mdNode {
+	var nodes []*mdNode
+	var sb strings.Builder
+	flush := func() {
+		if sb.Len() > 0 {
+			nodes = append(nodes, &mdNode{text: html.UnescapeString(sb.String())})
+			sb.Reset()
+		}
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			flush()
+			nodes = append(nodes, &mdNode{text: s[i+1 : i+2]})
+			i += 2
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			flush()
+			nodes = append(nodes, &mdNode{in: lineBreak{}})
+			i += 2
+		case c == '\n':
+			t := sb.String()
+			if strings.HasSuffix(t, "  ") {
+				sb.Reset()
+				sb.WriteString(strings.TrimRight(t, " "))
+				flush()
+				nodes = append(nodes, &mdNode{in: lineBreak{}})
+			} else {
+				sb.WriteByte('\n')
+			}
+			i++
+		case c == '`':
+			n := runLength(s[i:], '`')
+			end := strings.Index(s[i+n:], strings.Repeat("`", n))
+			for end >= 0 && i+n+end+n < len(s) && s[i+n+end+n] == '`' {
+				// closing run must have exactly n backticks
+				k := i + n + end
+				k += runLength(s[k:], '`')
+				e := strings.Index(s[k:], strings.Repeat("`", n))
+				if e < 0 {
+					end = -1
+					break
+				}
+				end = k - i - n + e
+			}
+			if end < 0 {
+				sb.WriteString(s[i : i+n])
+				i += n
+				break
+			}
+			flush()
+			code := strings.Replace(s[i+n:i+n+end], "\n", " ", -1)
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+				code = code[1 : len(code)-1]
+			}
+			nodes = append(nodes, &mdNode{in: &markup{tag: "code", content: []inline{text(code)}}})
+			i += n + end + n
+		case c == '*' || c == '_':
+			n := runLength(s[i:], c)
+			flush()
+			nodes = append(nodes, delimNode(s, i, n))
+			i += n
+		case c == '<':
+			if m := mdAutolink.FindStringSubmatch(s[i:]); m != nil {
+				flush()
+				url := m[1]
+				if !strings.Contains(url, ":") {
+					url = "mailto:" + url
+				}
+				nodes = append(nodes, &mdNode{in: &link{url: url, content: []inline{text(m[1])}}})
+				i += len(m[0])
+				break
+			}
+			sb.WriteByte(c)
+			i++
+		case c == '!' && i+1 < len(s) && s[i+1] == '[' || c == '[':
+			start := i
+			if c == '!' {
+				start++
+			}
+			in, n := md.link(s[start:], c == '!')
+			if in == nil {
+				sb.WriteByte(c)
+				i++
+				break
+			}
+			flush()
+			nodes = append(nodes, &mdNode{in: in})
+			i = start + n
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+	return nodes
+}
+
+func runLength(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+// delimNode returns a delimiter run node for the run of n characters at
+// position i in s.
+func delimNode(s string, i, n int) *mdNode {
+	c := s[i]
+	before, after := ' ', ' '
+	if i > 0 {
+		before, _ = utf8.DecodeLastRuneInString(s[:i])
+	}
+	if i+n < len(s) {
+		after, _ = utf8.DecodeRuneInString(s[i+n:])
+	}
+	isP := func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) }
+	left := !unicode.IsSpace(after) && (!isP(after) || unicode.IsSpace(before) || isP(before))
+	right := !unicode.IsSpace(before) && (!isP(before) || unicode.IsSpace(after) || isP(after))
+	node := &mdNode{delim: c, n: n, orig: n}
+	if c == '*' {
+		node.canOpen, node.canClose = left, right
+	} else {
+		node.canOpen = left && (!right || isP(before))
+		node.canClose = right && (!left || isP(after))
+	}
+	return node
+}
+
+// link parses a link or image starting with '[' at the start of s. It
+// returns the inline and the number of bytes consumed, or nil.
+func (md *mdParser) link(s string, isImage bool) (inline, int) {
+	depth := 0
+	end := -1
+loop:
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '`':
+			n := runLength(s[i:], '`')
+			if e := strings.Index(s[i+n:], strings.Repeat("`", n)); e >= 0 {
+				i += n + e + n - 1
+			}
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				end = i
+				break loop
+			}
+		}
+	}
+	if end < 0 {
+		return nil, 0
+	}
+	label := s[1:end]
+	rest := s[end+1:]
+	var url string
+	n := end + 1
+	switch {
+	case strings.HasPrefix(rest, "("):
+		u, k, ok := linkDestination(rest)
+		if !ok {
+			return nil, 0
+		}
+		url, n = u, n+k
+	case strings.HasPrefix(rest, "["):
+		e := strings.IndexByte(rest, ']')
+		if e < 0 {
+			return nil, 0
+		}
+		ref := rest[1:e]
+		if ref == "" {
+			ref = label
+		}
+		u, ok := md.refs[normalizeLabel(ref)]
+		if !ok {
+			return nil, 0
+		}
+		url, n = u, n+e+1
+	default:
+		u, ok := md.refs[normalizeLabel(label)]
+		if !ok {
+			return nil, 0
+		}
+		url = u
+	}
+	if isImage {
+		return &image{src: url, alt: plainText(md.inlines(label))}, n
+	}
+	return &link{url: url, content: md.inlines(label)}, n
+}
+
+// linkDestination parses "(url "title")" at the start of s.
+func linkDestination(s string) (string, int, bool) {
+	i := 1
+	for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+		i++
+	}
+	var url string
+	if i < len(s) && s[i] == '<' {
+		e := strings.IndexByte(s[i:], '>')
+		if e < 0 {
+			return "", 0, false
+		}
+		url = s[i+1 : i+e]
+		i += e + 1
+	} else {
+		start := i
+		depth := 0
+		for ; i < len(s); i++ {
+			c := s[i]
+			if c == '\\' && i+1 < len(s) {
+				i++
+				continue
+			}
+			if c == ' ' || c == '\n' || c < 0x20 {
+				break
+			}
+			if c == '(' {
+				depth++
+			}
+			if c == ')' {
+				if depth == 0 {
+					break
+				}
+				depth--
+			}
+		}
+		url = unescapeBackslashes(s[start:i])
+	}
+	if m := mdLinkTitle.FindString(s[i:]); m != "" {
+		i += len(m)
+	}
+	for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+		i++
+	}
+	if i >= len(s) || s[i] != ')' {
+		return "", 0, false
+	}
+	return html.UnescapeString(url), i + 1, true
+}
+
+func unescapeBackslashes(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// resolveEmphasis turns matching delimiter runs into emphasis.
+func resolveEmphasis(nodes []*mdNode) []*mdNode {
+	for ci := 0; ci < len(nodes); ci++ {
+		closer := nodes[ci]
+		if closer.delim == 0 || !closer.canClose || closer.n == 0 {
+			continue
+		}
+		oi := -1
+		for j := ci - 1; j >= 0; j-- {
+			o := nodes[j]
+			if o.delim != closer.delim || !o.canOpen || o.n == 0 {
+				continue
+			}
+			if (o.canClose || closer.canOpen) && (o.orig+closer.orig)%3 == 0 &&
+				!(o.orig%3 == 0 && closer.orig%3 == 0) {
+				continue
+			}
+			oi = j
+			break
+		}
+		if oi < 0 {
+			continue
+		}
+		opener := nodes[oi]
+		use, tag := 1, "em"
+		if opener.n >= 2 && closer.n >= 2 {
+			use, tag = 2, "strong"
+		}
+		opener.n -= use
+		closer.n -= use
+		var content []inline
+		for _, n := range nodes[oi+1 : ci] {
+			switch {
+			case n.in != nil:
+				content = append(content, n.in)
+			case n.delim != 0:
+				if n.n > 0 {
+					content = append(content, text(strings.Repeat(string(n.delim), n.n)))
+				}
+			default:
+				content = append(content, text(n.text))
+			}
+		}
+		em := &mdNode{in: &markup{tag: tag, content: mergeText(content)}}
+		rest := append([]*mdNode{em}, nodes[ci:]...)
+		nodes = append(nodes[:oi+1], rest...)
+		ci = oi + 2 // closer position
+		if closer.n > 0 {
+			ci-- // process the same closer again
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,92 @@
+package importer
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"codeberg.org/anaseto/gofrundis/parser"
+)
+
+func TestMarkdown(t *testing.T) {
+	doGolden(t, "testdata/*.md", Markdown)
+}
+
+// doGolden converts files matching pattern and compares the result with the
+// frundis file of same base name.
+func doGolden(t *testing.T, pattern string, convert func(io.Reader, io.Writer) error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		in, err := os.Open(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		err = convert(in, &out)
+		in.Close()
+		if err != nil {
+			t.Errorf("%s: %v", f, err)
+			continue
+		}
+		golden := strings.TrimSuffix(f, filepath.Ext(f)) + ".frundis"
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != string(want) {
+			t.Errorf("%s: output differs from %s:\n%s", f, golden, out.String())
+		}
+		checkParse(t, f, out.String())
+	}
+}
+
+// checkParse checks that the frundis source parses without warnings.
+func checkParse(t *testing.T, name string, source string) {
+	var werr bytes.Buffer
+	p := &parser.Parser{Source: name, Werror: &werr}
+	_, err := p.ParseString(source)
+	if err != nil {
+		t.Errorf("%s: parse error: %v", name, err)
+	}
+	if werr.Len() > 0 {
+		t.Errorf("%s: parse warnings: %s", name, werr.String())
+	}
+}
+
+func TestEscapeArgs(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  string
+	}{
+		{[]string{"a", "b"}, "a b"},
+		{[]string{"Sm", "-t", "x"}, `\&Sm \&-t x`},
+		{[]string{`a\b`}, `a\eb`},
+		{[]string{`say "hi"`}, `"say ""hi"""`},
+		{[]string{"a", "!"}, `a \&!`},
+	}
+	for _, test := range tests {
+		if got := escapeArgs(test.words); got != test.want {
+			t.Errorf("escapeArgs(%q) = %s, want %s", test.words, got, test.want)
+		}
+	}
+}
+
+func TestEscapeTextLine(t *testing.T) {
+	tests := []struct{ line, want string }{
+		{"text", "text"},
+		{".Sm x", `\&.Sm x`},
+		{"'quote", `\&'quote`},
+		{`back\slash`, `back\eslash`},
+	}
+	for _, test := range tests {
+		if got := escapeTextLine(test.line); got != test.want {
+			t.Errorf("escapeTextLine(%q) = %s, want %s", test.line, got, test.want)
+		}
+	}
+}
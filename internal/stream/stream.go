@@ -0,0 +1,104 @@
+// Package stream implements marked up document streams, as written by
+// exporters that record rendering instead of producing output. A stream is
+// made of text and markers. A marker starts with Open, followed by a kind and
+// optional arguments separated by Sep, and ends with End. Those control
+// characters are removed from text and arguments.
+package stream
+
+import "strings"
+
+// Control characters delimiting markers.
+const (
+	Open = "\x1c"
+	Sep  = "\x1f"
+	End  = "\x1d"
+)
+
+// Close is a marker closing the last node opened by a marker, for streams
+// representing trees.
+const Close = Open + "/" + End
+
+var sanitizer = strings.NewReplacer(Open, "", Sep, "", End, "")
+
+// Sanitize removes stream control characters from text.
+func Sanitize(text string) string {
+	return sanitizer.Replace(text)
+}
+
+// Marker returns a marker of a given kind, with optional arguments.
+func Marker(kind string, args ...string) string {
+	var sb strings.Builder
+	sb.WriteString(Open)
+	sb.WriteString(kind)
+	for _, arg := range args {
+		sb.WriteString(Sep)
+		sb.WriteString(Sanitize(arg))
+	}
+	sb.WriteString(End)
+	return sb.String()
+}
+
+// Node represents a marker, or some text if its kind is empty. Children are
+// only filled by Parse.
+type Node struct {
+	Kind     string
+	Args     []string
+	Children []*Node
+	Text     string
+}
+
+// Arg returns the i-th argument of the node, or an empty string.
+func (n *Node) Arg(i int) string {
+	if i < len(n.Args) {
+		return n.Args[i]
+	}
+	return ""
+}
+
+// Split returns the list of text and marker nodes of a stream, in order.
+func Split(s string) []*Node {
+	var nodes []*Node
+	for len(s) > 0 {
+		i := strings.Index(s, Open)
+		if i < 0 {
+			i = len(s)
+		}
+		if i > 0 {
+			nodes = append(nodes, &Node{Text: s[:i]})
+			s = s[i:]
+			continue
+		}
+		j := strings.Index(s, End)
+		if j < 0 {
+			// should not happen
+			break
+		}
+		fields := strings.Split(s[len(Open):j], Sep)
+		s = s[j+len(End):]
+		nodes = append(nodes, &Node{Kind: fields[0], Args: fields[1:]})
+	}
+	return nodes
+}
+
+// Parse builds the node tree of a stream: nodes following a marker are its
+// children, until the corresponding Close marker. The root node has kind
+// "root".
+func Parse(s string) *Node {
+	root := &Node{Kind: "root"}
+	stack := []*Node{root}
+	for _, n := range Split(s) {
+		cur := stack[len(stack)-1]
+		switch n.Kind {
+		case "":
+			cur.Children = append(cur.Children, n)
+		case "/":
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		default:
+			cur.Children = append(cur.Children, n)
+			stack = append(stack, n)
+		}
+	}
+	return root
+}
@@ -0,0 +1,205 @@
+package lsp
+
+import (
+	"sort"
+	"strings"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// completion returns completion items at a given position: macro names at
+// the start of a macro line, option names, X subcommands and parameter
+// names, tags for -t options, and identifiers for Sx.
+func (s *Server) completion(params *textDocumentPositionParams) []completionItem {
+	file := absPath(uriToPath(params.TextDocument.URI))
+	idx, t := s.documentIndex(file)
+	pos := params.Position
+	line := t.line(pos.Line)
+	prefix := line[:byteOffset(line, pos.Character)]
+	if !strings.HasPrefix(prefix, ".") || strings.Contains(prefix, `\"`) {
+		return []completionItem{}
+	}
+	fields := strings.Fields(prefix[1:])
+	trailing := strings.HasSuffix(prefix, " ") || strings.HasSuffix(prefix, "\t")
+	var word string
+	if !trailing && len(fields) > 0 {
+		word = fields[len(fields)-1]
+		fields = fields[:len(fields)-1]
+	}
+	start := position{Line: pos.Line, Character: utf16Len(prefix[:len(prefix)-len(word)])}
+	c := &completer{word: word, rng: lspRange{Start: start, End: pos}}
+	if len(fields) == 0 {
+		if trailing {
+			return c.items
+		}
+		c.macros(idx)
+		return c.items
+	}
+	name, args := fields[0], fields[1:]
+	k := len(args) // index of the argument being completed
+	ma := parseMacroArgs(name, append(args, word))
+	switch {
+	case name == "X" && k == 0:
+		c.add(kindKeyword, "", "dtag", "ftag", "mtag", "set")
+	case k < ma.rest && strings.HasPrefix(word, "-") && ma.opts[word[1:]] == k:
+		c.options(ma.spec)
+	case k < ma.rest && k > 0 && strings.HasPrefix(args[k-1], "-"):
+		c.optionValue(idx, ma.spec, args[k-1][1:])
+	case k == ma.rest && ma.spec == "Sx":
+		c.addDefs(kindReference, "identifier", idx.ids)
+	case k == ma.rest && ma.spec == "X set":
+		params := make(map[string]bool, len(frundis.ParamNames))
+		for _, p := range frundis.ParamNames {
+			params[p] = true
+		}
+		for p := range idx.params {
+			params[p] = true
+		}
+		c.add(kindVariable, "parameter", sortedKeys(params)...)
+	}
+	return c.items
+}
+
+// completer gathers completion items matching a word.
+type completer struct {
+	word  string
+	rng   lspRange
+	items []completionItem
+}
+
+func (c *completer) add(kind int, detail string, labels ...string) {
+	for _, l := range labels {
+		if !strings.HasPrefix(l, c.word) {
+			continue
+		}
+		c.items = append(c.items, completionItem{
+			Label:    l,
+			Kind:     kind,
+			Detail:   detail,
+			TextEdit: &textEdit{Range: c.rng, NewText: l}})
+	}
+}
+
+func (c *completer) addDefs(kind int, detail string, defs map[string][]definition) {
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.add(kind, detail, names...)
+}
+
+// macros adds builtin and user macro names.
+func (c *completer) macros(idx *index) {
+	builtins := frundis.DefaultExporterMacros()
+	names := make(map[string]bool, len(builtins))
+	for name := range builtins {
+		names[name] = true
+	}
+	c.add(kindFunction, "macro", sortedKeys(names)...)
+	user := make(map[string][]definition)
+	for name, defs := range idx.macros {
+		if !names[name] {
+			user[name] = defs
+		}
+	}
+	c.addDefs(kindFunction, "user macro", user)
+}
+
+// options adds option names of builtin macro spec.
+func (c *completer) options(spec string) {
+	opts, ok := frundis.BuiltinOptions(spec)
+	if !ok {
+		return
+	}
+	names := make(map[string]bool, len(opts))
+	for name := range opts {
+		names["-"+name] = true
+	}
+	c.add(kindField, "option", sortedKeys(names)...)
+}
+
+// optionValue adds values for option opt of builtin macro spec.
+func (c *completer) optionValue(idx *index, spec, opt string) {
+	if opt != "t" {
+		return
+	}
+	switch spec {
+	case "Sm", "Bm", "Em", "X mtag":
+		c.addDefs(kindEnumMember, "markup tag", idx.mtags)
+	case "Bd", "Ed", "X dtag":
+		c.addDefs(kindEnumMember, "display tag", idx.dtags)
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// definition returns the locations of the definitions of the element at a
+// given position: identifiers of Sx, tags of -t options, user macros and
+// included files.
+func (s *Server) definition(params *textDocumentPositionParams) []location {
+	file := absPath(uriToPath(params.TextDocument.URI))
+	idx, t := s.documentIndex(file)
+	off := t.offset(params.Position)
+	var m *ast.Macro
+	for _, b := range idx.files[file] {
+		if b, ok := b.(*ast.Macro); ok && b.Pos.Offset <= off && off <= b.End.Offset {
+			m = b
+			break
+		}
+	}
+	if m == nil {
+		return nil
+	}
+	texts := map[string]text{file: t}
+	var defs []definition
+	if off <= m.Pos.Offset+1+len(m.Name) {
+		defs = idx.macros[m.Name]
+	}
+	args := argsText(m)
+	ma := parseMacroArgs(m.Name, args)
+	k := -1
+	for i, r := range m.ArgRanges {
+		if r.Pos.Offset <= off && off <= r.End.Offset {
+			k = i
+			break
+		}
+	}
+	switch {
+	case k < 0:
+	case k == ma.opts["t"] && k > ma.start:
+		switch ma.spec {
+		case "Sm", "Bm", "Em", "X mtag":
+			defs = idx.mtags[args[k]]
+		case "Bd", "Ed", "X dtag":
+			defs = idx.dtags[args[k]]
+		}
+	case k == ma.opts["id"] && k > ma.start, k == ma.rest && ma.spec == "Sx":
+		defs = idx.ids[args[k]]
+	case k == ma.rest && ma.spec == "If":
+		if _, ok := ma.opts["as-is"]; ok {
+			break
+		}
+		if inc, ok := searchIncFile(args[k], s.workspaceRoot(), s.source(s.snapshot())); ok {
+			defs = []definition{{file: inc, rng: ast.Range{}}}
+		}
+	}
+	locs := make([]location, 0, len(defs))
+	for _, def := range defs {
+		if !def.rng.Pos.IsValid() {
+			// start of file
+			locs = append(locs, location{URI: pathToURI(def.file)})
+			continue
+		}
+		locs = append(locs, lspLocation(def, texts))
+	}
+	return locs
+}
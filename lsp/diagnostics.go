@@ -0,0 +1,144 @@
+package lsp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"codeberg.org/anaseto/gofrundis/frundis"
+	"codeberg.org/anaseto/gofrundis/token"
+)
+
+// checker wraps an exporter to capture error messages and use the contents
+// of open documents instead of files on disk.
+type checker struct {
+	frundis.Exporter
+	dir     string // directory for relative file names
+	werror  io.Writer
+	sources map[string]string
+}
+
+func (c *checker) Init() {
+	c.Exporter.Init()
+	ctx := c.Context()
+	ctx.Werror = c.werror
+	ctx.Dir = c.dir
+	for f, src := range c.sources {
+		ctx.SetSource(f, []byte(src))
+	}
+}
+
+// scheduleDiagnostics schedules an update of diagnostics, after a delay
+// restarted on each call.
+func (s *Server) scheduleDiagnostics() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(s.opts.Delay, s.publishDiagnostics)
+}
+
+// publishDiagnostics processes open documents and publishes diagnostics for
+// them and the files they include.
+func (s *Server) publishDiagnostics() {
+	s.checkMu.Lock()
+	defer s.checkMu.Unlock()
+	docs := s.snapshot()
+	roots, _ := s.roots(docs)
+	diags := make(map[string][]diagnostic)
+	for f := range docs {
+		diags[f] = []diagnostic{}
+	}
+	texts := make(map[string]text)
+	for f, src := range docs {
+		texts[f] = newText(src)
+	}
+	for _, root := range roots {
+		var buf bytes.Buffer
+		s.check(root, docs, &buf)
+		for _, msg := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+			if msg == "" {
+				continue
+			}
+			file, d := parseMessage(msg, root, texts)
+			diags[file] = append(diags[file], d)
+		}
+	}
+	s.mu.Lock()
+	for f := range s.published {
+		if _, ok := diags[f]; !ok {
+			diags[f] = []diagnostic{}
+		}
+	}
+	s.published = make(map[string]bool)
+	for f, d := range diags {
+		if len(d) > 0 {
+			s.published[f] = true
+		}
+	}
+	s.mu.Unlock()
+	for f, d := range diags {
+		s.notify("textDocument/publishDiagnostics",
+			publishDiagnosticsParams{URI: pathToURI(f), Diagnostics: d})
+	}
+}
+
+// check runs the info and process passes on file root, writing error
+// messages to w.
+func (s *Server) check(root string, docs map[string]string, w io.Writer) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Fprintf(w, "frundis: %s: internal error: %v\n", root, err)
+		}
+	}()
+	exp, err := s.opts.NewExporter(s.exportFormat())
+	if err != nil {
+		fmt.Fprintf(w, "frundis: %s: %v\n", root, err)
+		return
+	}
+	c := &checker{Exporter: exp, dir: s.workspaceRoot(), werror: w, sources: docs}
+	err = frundis.ProcessFrundisSource(c, root, false)
+	if err != nil {
+		fmt.Fprintf(w, "frundis: %s: %v\n", root, strings.TrimSpace(err.Error()))
+	}
+}
+
+// messageRx matches frundis error messages, either of the form
+// "frundis: file:line:message" or "frundis:file:line:col: message".
+var messageRx = regexp.MustCompile(`^frundis: ?(.+?):(\d+):(?:(\d+):)?\s*(.*)$`)
+
+// parseMessage returns the file and diagnostic corresponding to an error
+// message. Messages without location are reported at the start of file
+// root.
+func parseMessage(msg, root string, texts map[string]text) (string, diagnostic) {
+	d := diagnostic{Severity: severityError, Source: "frundis"}
+	m := messageRx.FindStringSubmatch(msg)
+	if m == nil {
+		d.Message = strings.TrimPrefix(strings.TrimPrefix(msg, "frundis:"), " ")
+		d.Message = strings.TrimSpace(strings.TrimPrefix(d.Message, root+":"))
+		d.Range = lspRange{}
+		return root, d
+	}
+	file := absPath(m[1])
+	t, ok := texts[file]
+	if !ok {
+		t = readText(file)
+		texts[file] = t
+	}
+	line, _ := strconv.Atoi(m[2])
+	if line < 1 {
+		line = 1
+	}
+	d.Range = t.lineRange(line - 1)
+	if m[3] != "" {
+		col, _ := strconv.Atoi(m[3])
+		d.Range.Start = t.position(token.Pos{Line: line, Col: col})
+	}
+	d.Message = m[4]
+	return file, d
+}
@@ -0,0 +1,180 @@
+package lsp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/frundis"
+	"codeberg.org/anaseto/gofrundis/parser"
+)
+
+// definition represents the source location of a definition.
+type definition struct {
+	file string
+	rng  ast.Range
+}
+
+// index gathers definitions found in a document and in the files it includes.
+type index struct {
+	dir    string                  // directory of relative included file names
+	files  map[string][]ast.Block  // parsed files by absolute name
+	macros map[string][]definition // user macros (#de)
+	mtags  map[string][]definition // tags defined with X mtag
+	dtags  map[string][]definition // tags defined with X dtag
+	ids    map[string][]definition // identifiers given with -id
+	params map[string]bool         // parameters set with X set
+}
+
+// sourceFunc returns the contents of a file.
+type sourceFunc func(path string) ([]byte, error)
+
+// newIndex builds the index of root files, following If inclusions, with
+// relative included file names searched from directory dir.
+func newIndex(roots []string, dir string, source sourceFunc) *index {
+	idx := &index{
+		dir:    dir,
+		files:  make(map[string][]ast.Block),
+		macros: make(map[string][]definition),
+		mtags:  make(map[string][]definition),
+		dtags:  make(map[string][]definition),
+		ids:    make(map[string][]definition),
+		params: make(map[string]bool)}
+	for _, root := range roots {
+		idx.addFile(absPath(root), source)
+	}
+	return idx
+}
+
+func (idx *index) addFile(file string, source sourceFunc) {
+	if _, ok := idx.files[file]; ok {
+		return
+	}
+	src, err := source(file)
+	if err != nil {
+		return
+	}
+	p := &parser.Parser{Source: file, Werror: io.Discard}
+	blocks, _ := p.ParseWithReader(bytes.NewReader(src))
+	idx.files[file] = blocks
+	for _, b := range blocks {
+		m, ok := b.(*ast.Macro)
+		if !ok {
+			continue
+		}
+		args := argsText(m)
+		ma := parseMacroArgs(m.Name, args)
+		def := func(i int) definition {
+			return definition{file: file, rng: m.ArgRanges[i]}
+		}
+		if i, ok := ma.opts["id"]; ok && i < len(args) {
+			idx.ids[args[i]] = append(idx.ids[args[i]], def(i))
+		}
+		switch ma.spec {
+		case "#de":
+			if ma.rest < len(args) {
+				name := args[ma.rest]
+				idx.macros[name] = append(idx.macros[name], def(ma.rest))
+			}
+		case "X mtag", "X dtag":
+			i, ok := ma.opts["t"]
+			if !ok || i >= len(args) {
+				break
+			}
+			tags := idx.mtags
+			if ma.spec == "X dtag" {
+				tags = idx.dtags
+			}
+			tags[args[i]] = append(tags[args[i]], def(i))
+		case "X set":
+			if ma.rest < len(args) {
+				idx.params[args[ma.rest]] = true
+			}
+		case "If":
+			if _, ok := ma.opts["as-is"]; ok || ma.rest >= len(args) {
+				break
+			}
+			if inc, ok := searchIncFile(args[ma.rest], idx.dir, source); ok {
+				idx.addFile(inc, source)
+			}
+		}
+	}
+}
+
+// searchIncFile returns the absolute name of an included frundis file,
+// searching as frundis does in directory root (the current directory if
+// empty) and then in the directories listed in FRUNDISLIB.
+func searchIncFile(filename string, root string, source sourceFunc) (string, bool) {
+	dirs := []string{""}
+	if lib, ok := os.LookupEnv("FRUNDISLIB"); ok {
+		dirs = append(dirs, strings.Split(lib, ":")...)
+	}
+	for _, dir := range dirs {
+		file := filepath.Join(dir, filename)
+		if root != "" && !filepath.IsAbs(file) {
+			file = filepath.Join(root, file)
+		}
+		file = absPath(file)
+		if _, err := source(file); err == nil {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+// argsText returns the text of the arguments of a macro, ignoring escapes
+// other than \e and \~.
+func argsText(m *ast.Macro) []string {
+	args := make([]string, len(m.Args))
+	for i, arg := range m.Args {
+		var sb strings.Builder
+		for _, in := range arg {
+			switch in := in.(type) {
+			case ast.Text:
+				sb.WriteString(string(in))
+			case ast.Escape:
+				sb.WriteString(in.ToText())
+			}
+		}
+		args[i] = sb.String()
+	}
+	return args
+}
+
+// macroArgs describes the arguments of a macro line.
+type macroArgs struct {
+	spec  string         // name of the option specification (e.g. "X mtag")
+	start int            // index of the first argument that may be an option
+	opts  map[string]int // index of option values (or of flags)
+	rest  int            // index of the first non-option argument
+}
+
+// parseMacroArgs finds the options in the arguments of macro name, in the
+// same way as frundis does.
+func parseMacroArgs(name string, args []string) macroArgs {
+	ma := macroArgs{spec: name, opts: make(map[string]int)}
+	if name == "X" && len(args) > 0 {
+		ma.spec += " " + args[0]
+		ma.start = 1
+	}
+	spec, _ := frundis.BuiltinOptions(ma.spec)
+	i := ma.start
+	for i < len(args) && strings.HasPrefix(args[i], "-") {
+		opt := args[i][1:]
+		if spec[opt] == frundis.ArgOption {
+			ma.opts[opt] = i + 1
+			i += 2
+			continue
+		}
+		ma.opts[opt] = i
+		i++
+	}
+	if i > len(args) {
+		i = len(args)
+	}
+	ma.rest = i
+	return ma
+}
@@ -0,0 +1,351 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/anaseto/gofrundis/exporter/latex"
+	"codeberg.org/anaseto/gofrundis/exporter/markdown"
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+const mainSource = `.X mtag -t em -f markdown -b <em>
+.#de mymac
+.Sm -t em \$1
+.#.
+.Sh -id intro Intro
+.If inc.frundis
+.Sx intro
+.Sm -t em foo
+.mymac bar
+.Sx other
+.Bd -t unknown
+`
+
+// client is a test client for a language server.
+type client struct {
+	t      *testing.T
+	w      io.Writer
+	id     int
+	msgs   chan map[string]json.RawMessage
+	diags  map[string][]diagnostic
+	closed chan error
+}
+
+func newClient(t *testing.T, s *Server) *client {
+	sr, cw := io.Pipe()
+	cr, sw := io.Pipe()
+	c := &client{
+		t:      t,
+		w:      cw,
+		msgs:   make(chan map[string]json.RawMessage, 100),
+		diags:  make(map[string][]diagnostic),
+		closed: make(chan error, 1)}
+	go func() {
+		err := s.Serve(sr, sw)
+		sw.Close()
+		c.closed <- err
+	}()
+	go func() {
+		br := bufio.NewReader(cr)
+		for {
+			body, err := readMessage(br)
+			if err != nil {
+				close(c.msgs)
+				return
+			}
+			var msg map[string]json.RawMessage
+			if err := json.Unmarshal(body, &msg); err != nil {
+				t.Errorf("bad message: %s", body)
+				continue
+			}
+			c.msgs <- msg
+		}
+	}()
+	return c
+}
+
+func (c *client) write(msg map[string]interface{}) {
+	msg["jsonrpc"] = "2.0"
+	if err := writeMessage(c.w, msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.write(map[string]interface{}{"method": method, "params": params})
+}
+
+// call sends a request and decodes its result into v, recording
+// diagnostics received meanwhile.
+func (c *client) call(method string, params interface{}, v interface{}) {
+	c.t.Helper()
+	c.id++
+	c.write(map[string]interface{}{"id": c.id, "method": method, "params": params})
+	for {
+		msg := c.next()
+		if _, ok := msg["method"]; ok {
+			continue
+		}
+		var id int
+		json.Unmarshal(msg["id"], &id)
+		if id != c.id {
+			c.t.Fatalf("got response for id %d, want %d", id, c.id)
+		}
+		if e, ok := msg["error"]; ok {
+			c.t.Fatalf("%s: error: %s", method, e)
+		}
+		if err := json.Unmarshal(msg["result"], v); err != nil {
+			c.t.Fatalf("%s: bad result: %v", method, err)
+		}
+		return
+	}
+}
+
+// next returns the next message, recording diagnostics.
+func (c *client) next() map[string]json.RawMessage {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatal("server closed connection")
+		}
+		var method string
+		json.Unmarshal(msg["method"], &method)
+		if method == "textDocument/publishDiagnostics" {
+			var params publishDiagnosticsParams
+			json.Unmarshal(msg["params"], &params)
+			c.diags[params.URI] = params.Diagnostics
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timeout waiting for server message")
+	}
+	return nil
+}
+
+// waitDiagnostics waits for diagnostics for uri.
+func (c *client) waitDiagnostics(uri string) []diagnostic {
+	c.t.Helper()
+	for {
+		if d, ok := c.diags[uri]; ok {
+			return d
+		}
+		c.next()
+	}
+}
+
+func TestServer(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mainFile := filepath.Join(dir, "main.frundis")
+	incFile := filepath.Join(dir, "inc.frundis")
+	err = os.WriteFile(incFile, []byte(".P\n.Sh -id other Other\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the main file is only known from the editor buffer
+	s := NewServer(&Options{
+		NewExporter: func(format string) (frundis.Exporter, error) {
+			return markdown.NewExporter(&markdown.Options{OutputFile: os.DevNull}), nil
+		},
+		Delay: 10 * time.Millisecond})
+	c := newClient(t, s)
+	var init map[string]interface{}
+	c.call("initialize", map[string]interface{}{"rootUri": pathToURI(dir)}, &init)
+	if _, ok := init["capabilities"]; !ok {
+		t.Errorf("no capabilities in initialize result: %v", init)
+	}
+	c.notify("initialized", struct{}{})
+	if cwd, err := os.Getwd(); err != nil || cwd != wd {
+		t.Errorf("working directory changed to %s", cwd)
+	}
+	uri := pathToURI(mainFile)
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "frundis", "version": 1, "text": mainSource}})
+
+	diags := c.waitDiagnostics(uri)
+	if len(diags) == 0 {
+		t.Errorf("no diagnostics for unclosed display")
+	}
+	for _, d := range diags {
+		if !strings.Contains(d.Message, "Bd") {
+			t.Errorf("unexpected diagnostic: %+v", d)
+		}
+	}
+
+	complete := func(line, char int) []string {
+		t.Helper()
+		var items []completionItem
+		c.call("textDocument/completion", textDocumentPositionParams{
+			TextDocument: textDocumentIdentifier{URI: uri},
+			Position:     position{Line: line, Character: char}}, &items)
+		labels := make([]string, len(items))
+		for i, it := range items {
+			labels[i] = it.Label
+		}
+		sort.Strings(labels)
+		return labels
+	}
+	completionTests := []struct {
+		line, char int
+		want       []string
+	}{
+		{8, 3, []string{"mymac"}},                // .my|mac
+		{7, 2, []string{"Sh", "Sm", "Ss", "Sx"}}, // .S|m
+		{7, 9, []string{"em"}},                   // .Sm -t |em
+		{6, 4, []string{"intro", "other"}},       // .Sx |intro
+		{0, 3, []string{"dtag", "ftag", "mtag", "set"}},
+		{4, 5, []string{"-id", "-nonum"}}, // .Sh -|id
+	}
+	for _, ct := range completionTests {
+		got := complete(ct.line, ct.char)
+		if !equalStrings(got, ct.want) {
+			t.Errorf("completion at %d:%d: got %v, want %v", ct.line, ct.char, got, ct.want)
+		}
+	}
+
+	define := func(line, char int) []location {
+		t.Helper()
+		var locs []location
+		c.call("textDocument/definition", textDocumentPositionParams{
+			TextDocument: textDocumentIdentifier{URI: uri},
+			Position:     position{Line: line, Character: char}}, &locs)
+		return locs
+	}
+	definitionTests := []struct {
+		line, char int
+		file       string
+		want       lspRange
+	}{
+		{6, 5, mainFile, lspRange{position{4, 8}, position{4, 13}}},  // .Sx intro
+		{9, 5, incFile, lspRange{position{1, 8}, position{1, 13}}},   // .Sx other
+		{7, 8, mainFile, lspRange{position{0, 11}, position{0, 13}}}, // .Sm -t em
+		{8, 2, mainFile, lspRange{position{1, 5}, position{1, 10}}},  // .mymac
+		{5, 6, incFile, lspRange{}},                                  // .If inc.frundis
+	}
+	for _, dt := range definitionTests {
+		locs := define(dt.line, dt.char)
+		if len(locs) != 1 {
+			t.Errorf("definition at %d:%d: got %v", dt.line, dt.char, locs)
+			continue
+		}
+		want := location{URI: pathToURI(dt.file), Range: dt.want}
+		if locs[0] != want {
+			t.Errorf("definition at %d:%d: got %v, want %v", dt.line, dt.char, locs[0], want)
+		}
+	}
+	if locs := define(2, 0); len(locs) != 0 {
+		t.Errorf("definition of builtin macro: got %v", locs)
+	}
+
+	// fixing the error clears diagnostics
+	delete(c.diags, uri)
+	fixed := mainSource[:len(mainSource)-len(".Bd -t unknown\n")]
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []map[string]string{{"text": fixed}}})
+	if diags := c.waitDiagnostics(uri); len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %+v", diags)
+	}
+
+	var null interface{}
+	c.call("shutdown", nil, &null)
+	c.notify("exit", nil)
+	select {
+	case err := <-c.closed:
+		if err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("server did not exit")
+	}
+}
+
+func TestImageDiagnostics(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "image.png"), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(&Options{
+		NewExporter: func(format string) (frundis.Exporter, error) {
+			return latex.NewExporter(&latex.Options{OutputFile: os.DevNull}), nil
+		},
+		Format: "latex",
+		Delay:  10 * time.Millisecond})
+	c := newClient(t, s)
+	var init map[string]interface{}
+	c.call("initialize", map[string]interface{}{"rootUri": pathToURI(dir)}, &init)
+	c.notify("initialized", struct{}{})
+	// images are looked for relative to the workspace root, and not to the
+	// working directory
+	uri := pathToURI(filepath.Join(dir, "main.frundis"))
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "frundis", "version": 1,
+			"text": ".Im image.png\n.Im missing.png\n"}})
+	diags := c.waitDiagnostics(uri)
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, "missing.png") || diags[0].Range.Start.Line != 1 {
+		t.Errorf("unexpected diagnostics: %+v", diags)
+	}
+
+	var null interface{}
+	c.call("shutdown", nil, &null)
+	c.notify("exit", nil)
+	select {
+	case err := <-c.closed:
+		if err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("server did not exit")
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	texts := map[string]text{"/doc.frundis": newText("first\nsécond line\n")}
+	tests := []struct {
+		msg, file, text string
+		rng             lspRange
+	}{
+		{"frundis: /doc.frundis:2:Sm: unknown tag", "/doc.frundis", "Sm: unknown tag",
+			lspRange{position{1, 0}, position{1, 11}}},
+		{"frundis:/doc.frundis:2:3: unexpected EOF", "/doc.frundis", "unexpected EOF",
+			lspRange{position{1, 2}, position{1, 11}}},
+		{"frundis: /doc.frundis: no such file", "/doc.frundis", "no such file", lspRange{}},
+	}
+	for _, test := range tests {
+		file, d := parseMessage(test.msg, "/doc.frundis", texts)
+		if file != test.file || d.Message != test.text || d.Range != test.rng {
+			t.Errorf("%q: got %s %q %v", test.msg, file, d.Message, d.Range)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
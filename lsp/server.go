@@ -0,0 +1,324 @@
+// Package lsp implements a language server for frundis documents, speaking
+// the Language Server Protocol over JSON-RPC.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"codeberg.org/anaseto/gofrundis/frundis"
+)
+
+// Options gathers language server options.
+type Options struct {
+	// NewExporter returns an exporter for the given format, used for
+	// diagnostics. The exporter should not write output anywhere.
+	NewExporter func(format string) (frundis.Exporter, error)
+	// Format is the export format used for diagnostics (default
+	// "xhtml"). Clients can override it with the "format"
+	// initialization option.
+	Format string
+	// Delay is the delay before updating diagnostics after a change
+	// (default 300ms).
+	Delay time.Duration
+}
+
+// Server is a language server for frundis documents.
+type Server struct {
+	opts *Options // opts.Format is protected by mu
+
+	wmu sync.Mutex // protects w
+	w   io.Writer
+
+	mu        sync.Mutex        // protects the fields below
+	docs      map[string]string // open documents contents by absolute file name
+	root      string            // workspace root directory (if any)
+	published map[string]bool   // files with published diagnostics
+	timer     *time.Timer       // diagnostics timer
+	shutdown  bool
+
+	checkMu sync.Mutex // serializes diagnostics passes
+}
+
+// NewServer returns a new language server.
+func NewServer(opts *Options) *Server {
+	if opts.Format == "" {
+		opts.Format = "xhtml"
+	}
+	if opts.Delay == 0 {
+		opts.Delay = 300 * time.Millisecond
+	}
+	return &Server{
+		opts:      opts,
+		docs:      make(map[string]string),
+		published: make(map[string]bool)}
+}
+
+// Serve reads requests from r and writes responses and notifications to w,
+// until the client sends the exit notification or r is closed.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.w = w
+	br := bufio.NewReader(r)
+	for {
+		body, err := readMessage(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.replyError(nil, codeParseError, err.Error())
+			continue
+		}
+		if req.Method == "exit" {
+			s.mu.Lock()
+			if s.timer != nil {
+				s.timer.Stop()
+			}
+			s.mu.Unlock()
+			return nil
+		}
+		s.handle(&req)
+	}
+}
+
+// handle handles a request or a notification.
+func (s *Server) handle(req *request) {
+	s.mu.Lock()
+	shutdown := s.shutdown
+	s.mu.Unlock()
+	if shutdown {
+		if req.ID != nil {
+			s.replyError(req.ID, codeInvalidRequest, "server is shutting down")
+		}
+		return
+	}
+	var result interface{}
+	var err error
+	switch req.Method {
+	case "initialize":
+		result, err = s.initialize(req.Params)
+	case "initialized", "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration":
+	case "shutdown":
+		s.mu.Lock()
+		s.shutdown = true
+		s.mu.Unlock()
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err = json.Unmarshal(req.Params, &params); err == nil {
+			s.setDocument(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params didChangeParams
+		err = json.Unmarshal(req.Params, &params)
+		if err == nil && len(params.ContentChanges) > 0 {
+			text := params.ContentChanges[len(params.ContentChanges)-1].Text
+			s.setDocument(params.TextDocument.URI, text)
+		}
+	case "textDocument/didSave":
+		s.scheduleDiagnostics()
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err = json.Unmarshal(req.Params, &params); err == nil {
+			s.mu.Lock()
+			delete(s.docs, absPath(uriToPath(params.TextDocument.URI)))
+			s.mu.Unlock()
+			s.scheduleDiagnostics()
+		}
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err = json.Unmarshal(req.Params, &params); err == nil {
+			result = s.completion(&params)
+		}
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err = json.Unmarshal(req.Params, &params); err == nil {
+			result = s.definition(&params)
+		}
+	default:
+		if req.ID != nil {
+			s.replyError(req.ID, codeMethodNotFound, "method not found: "+req.Method)
+		}
+		return
+	}
+	if req.ID == nil {
+		// notification
+		return
+	}
+	if err != nil {
+		s.replyError(req.ID, codeInvalidParams, err.Error())
+		return
+	}
+	s.send(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+}
+
+func (s *Server) initialize(raw json.RawMessage) (interface{}, error) {
+	var params initializeParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, err
+	}
+	root := params.RootPath
+	if params.RootURI != "" {
+		root = uriToPath(params.RootURI)
+	}
+	// relative file names in If macros are relative to the
+	// workspace root, as if frundis was run from there
+	s.mu.Lock()
+	s.root = root
+	if f := params.InitializationOptions.Format; f != "" {
+		s.opts.Format = f
+	}
+	s.mu.Unlock()
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync": map[string]interface{}{
+				"openClose": true,
+				"change":    1, // full document sync
+				"save":      true},
+			"completionProvider": map[string]interface{}{
+				"triggerCharacters": []string{".", "-", " "}},
+			"definitionProvider": true},
+		"serverInfo": map[string]string{"name": "frundis"}}, nil
+}
+
+func (s *Server) replyError(id json.RawMessage, code int, msg string) {
+	s.send(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"error":   responseError{Code: code, Message: msg}})
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	s.send(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+func (s *Server) send(v interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	writeMessage(s.w, v)
+}
+
+// setDocument records the contents of an open document and schedules
+// diagnostics.
+func (s *Server) setDocument(uri, text string) {
+	s.mu.Lock()
+	s.docs[absPath(uriToPath(uri))] = text
+	s.mu.Unlock()
+	s.scheduleDiagnostics()
+}
+
+// source returns the contents of a file, using open documents contents when
+// available.
+func (s *Server) source(docs map[string]string) sourceFunc {
+	return func(path string) ([]byte, error) {
+		if text, ok := docs[absPath(path)]; ok {
+			return []byte(text), nil
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil, errors.New("not a regular file")
+		}
+		return os.ReadFile(path)
+	}
+}
+
+// workspaceRoot returns the workspace root directory, or an empty string if
+// there is none.
+func (s *Server) workspaceRoot() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.root
+}
+
+// exportFormat returns the export format used for diagnostics.
+func (s *Server) exportFormat() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opts.Format
+}
+
+// snapshot returns a copy of the open documents.
+func (s *Server) snapshot() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs := make(map[string]string, len(s.docs))
+	for f, text := range s.docs {
+		docs[f] = text
+	}
+	return docs
+}
+
+// roots returns the open documents that are not included by other open
+// documents, and their indexes.
+func (s *Server) roots(docs map[string]string) ([]string, map[string]*index) {
+	source := s.source(docs)
+	indexes := make(map[string]*index, len(docs))
+	for f := range docs {
+		indexes[f] = newIndex([]string{f}, s.workspaceRoot(), source)
+	}
+	var roots []string
+	for f := range docs {
+		included := false
+		for g, idx := range indexes {
+			if _, ok := idx.files[f]; ok && g != f {
+				if _, ok := indexes[f].files[g]; !ok {
+					included = true
+					break
+				}
+			}
+		}
+		if !included {
+			roots = append(roots, f)
+		}
+	}
+	sort.Strings(roots)
+	return roots, indexes
+}
+
+// documentIndex returns the index to use for document file: the one of the
+// open documents including it, or its own.
+func (s *Server) documentIndex(file string) (*index, text) {
+	docs := s.snapshot()
+	roots, indexes := s.roots(docs)
+	var including []string
+	for _, r := range roots {
+		if _, ok := indexes[r].files[file]; ok {
+			including = append(including, r)
+		}
+	}
+	if len(including) == 0 {
+		including = []string{file}
+	}
+	var t text
+	if src, ok := docs[file]; ok {
+		t = newText(src)
+	} else {
+		t = readText(file)
+	}
+	return newIndex(including, s.workspaceRoot(), s.source(docs)), t
+}
+
+// lspLocation returns the LSP location of a definition.
+func lspLocation(def definition, texts map[string]text) location {
+	t, ok := texts[def.file]
+	if !ok {
+		t = readText(def.file)
+		texts[def.file] = t
+	}
+	return location{
+		URI: pathToURI(def.file),
+		Range: lspRange{
+			Start: t.position(def.rng.Pos),
+			End:   t.position(def.rng.End)}}
+}
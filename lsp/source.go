@@ -0,0 +1,119 @@
+package lsp
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"codeberg.org/anaseto/gofrundis/token"
+)
+
+// Helpers for conversions between file names and URIs, and between frundis
+// positions (lines and columns in characters) and LSP positions (lines and
+// UTF-16 code units).
+
+// uriToPath returns the file name corresponding to a file URI.
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// pathToURI returns the file URI corresponding to a file name.
+func pathToURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String()
+}
+
+// absPath returns an absolute version of path, or path itself.
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+// text represents the contents of a source file split into lines.
+type text []string
+
+func newText(s string) text {
+	return strings.Split(s, "\n")
+}
+
+// line returns line i, counting from 0, or the empty string.
+func (t text) line(i int) string {
+	if i < 0 || i >= len(t) {
+		return ""
+	}
+	return t[i]
+}
+
+// position converts a frundis position into a LSP one.
+func (t text) position(pos token.Pos) position {
+	line := t.line(pos.Line - 1)
+	n := 0
+	for i := range line {
+		if n == pos.Col-1 {
+			return position{Line: pos.Line - 1, Character: utf16Len(line[:i])}
+		}
+		n++
+	}
+	return position{Line: pos.Line - 1, Character: utf16Len(line)}
+}
+
+// lineRange returns the range of a whole line, counting from 0.
+func (t text) lineRange(i int) lspRange {
+	return lspRange{
+		Start: position{Line: i},
+		End:   position{Line: i, Character: utf16Len(t.line(i))}}
+}
+
+// byteOffset returns the byte offset of a LSP position in a line.
+func byteOffset(line string, char int) int {
+	n := 0
+	for i, r := range line {
+		if n >= char {
+			return i
+		}
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return len(line)
+}
+
+// offset returns the byte offset in the source of a LSP position.
+func (t text) offset(pos position) int {
+	off := 0
+	for i := 0; i < pos.Line && i < len(t); i++ {
+		off += len(t[i]) + 1
+	}
+	return off + byteOffset(t.line(pos.Line), pos.Character)
+}
+
+func utf16Len(s string) int {
+	if !utf8.ValidString(s) {
+		return len(s)
+	}
+	return len(utf16.Encode([]rune(s)))
+}
+
+// readText returns the text of file path, or nil.
+func readText(path string) text {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return newText(string(src))
+}
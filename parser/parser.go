@@ -15,12 +15,14 @@ import (
 
 // Parser gathers state data for parsing.
 type Parser struct {
-	Source string    // for error messages location information (e.g. filename)
-	Werror io.Writer // where non-fatal scanning error messages go (default os.Stderr)
-	line   int
-	lit    string
-	scan   *scanner.Scanner
-	tok    token.Token
+	Source   string    // for error messages location information (e.g. filename)
+	Werror   io.Writer // where non-fatal scanning error messages go (default os.Stderr)
+	Comments bool      // keep comments in the AST
+	line     int
+	lit      string
+	pending  ast.Block // block to return before parsing more
+	scan     *scanner.Scanner
+	tok      token.Token
 }
 
 // ParseWithReader parses a frundis source from a reader and returns a list of
@@ -75,6 +77,11 @@ func (p *Parser) initialize() error {
 
 // Returns next block from parser
 func (p *Parser) parseBlock() (ast.Block, error) {
+	if p.pending != nil {
+		b := p.pending
+		p.pending = nil
+		return b, nil
+	}
 	var b ast.Block
 	var err error
 	switch p.tok {
@@ -94,9 +101,11 @@ func (p *Parser) parseBlock() (ast.Block, error) {
 func (p *Parser) parseText() (*ast.TextBlock, error) {
 	// p.tok != token.MACRO_NAME && p.tok != token.EOF
 	b := []ast.Inline{}
-	line := p.line
+	tb := &ast.TextBlock{Line: p.line}
+	tb.Pos, tb.End = p.scan.Range()
 parse:
 	for {
+		n := len(b)
 		switch p.tok {
 		case token.TEXT:
 			if p.lit != "" {
@@ -113,25 +122,47 @@ parse:
 			b = append(b, ast.NamedArgEscape(p.lit))
 		case token.NFESCAPE:
 			b = append(b, ast.NamedFlagEscape(p.lit))
-		case token.COMMENT, token.ILLEGAL:
+		case token.COMMENT:
+			if p.Comments {
+				c := &ast.Comment{Text: p.lit, Line: p.line}
+				c.Pos, c.End = p.scan.Range()
+				b = append(b, c)
+			}
+		case token.ILLEGAL:
 		case token.MACRO_NAME, token.EOF:
 			break parse
 		default:
 			return nil, fmt.Errorf("parser.parseText:unexpected token:%#v", p.tok)
 		}
+		_, end := p.scan.Range()
+		if len(b) > n {
+			start, _ := p.scan.Range()
+			tb.TextRanges = append(tb.TextRanges, ast.Range{Pos: start, End: end})
+		}
+		if end.Offset > tb.End.Offset {
+			tb.End = end
+		}
 		var err error
 		p.tok, p.line, p.lit, err = p.scan.Scan()
 		if err != nil {
 			return nil, err
 		}
 	}
-	return &ast.TextBlock{Text: b, Line: line}, nil
+	tb.Text = b
+	return tb, nil
 }
 
-func (p *Parser) parseMacro() (*ast.Macro, error) {
+func (p *Parser) parseMacro() (ast.Block, error) {
 	// p.tok == token.MACRO_NAME
 	m := ast.Macro{Name: p.lit, Line: p.line}
+	m.Pos, m.End = p.scan.Range()
 	a := []ast.Inline{}
+	var arg ast.Range // current argument range
+	endArg := func() {
+		m.Args = append(m.Args, a)
+		m.ArgRanges = append(m.ArgRanges, arg)
+		arg = ast.Range{}
+	}
 parse:
 	for {
 		var err error
@@ -140,6 +171,19 @@ parse:
 			return nil, err
 		}
 		switch p.tok {
+		case token.TEXT, token.ESCAPE, token.IESCAPE, token.AESCAPE, token.NAESCAPE, token.NFESCAPE:
+			start, end := p.scan.Range()
+			if !arg.Pos.IsValid() {
+				arg.Pos = start
+			}
+			if end.Offset > start.Offset || !arg.End.IsValid() {
+				arg.End = end
+			}
+			if end.Offset > m.End.Offset {
+				m.End = end
+			}
+		}
+		switch p.tok {
 		case token.TEXT:
 			if p.lit != "" {
 				a = append(a, ast.Text(p.lit))
@@ -157,7 +201,22 @@ parse:
 			a = append(a, ast.NamedFlagEscape(p.lit))
 		case token.COMMENT, token.MACRO_END, token.EOF:
 			if len(a) > 0 {
-				m.Args = append(m.Args, a)
+				endArg()
+			}
+			if p.tok == token.COMMENT && p.Comments {
+				c := &ast.Comment{Text: p.lit, Line: p.line}
+				c.Pos, c.End = p.scan.Range()
+				if m.Name == "" && len(m.Args) == 0 {
+					// .\" comment line
+					c.Pos = m.Pos
+					p.tok, p.line, p.lit, err = p.scan.Scan()
+					if err != nil {
+						return nil, err
+					}
+					return c, nil
+				}
+				c.Trailing = true
+				p.pending = c
 			}
 			if p.tok != token.EOF {
 				p.tok, p.line, p.lit, err = p.scan.Scan()
@@ -168,7 +227,7 @@ parse:
 			break parse
 		case token.EXTEND_LINE, token.ILLEGAL:
 		case token.ARG_END:
-			m.Args = append(m.Args, a)
+			endArg()
 			a = []ast.Inline{}
 		default:
 			return nil, fmt.Errorf("parser.parseMacro:unexpected token:%#v", p.tok)
@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"testing"
+
+	"codeberg.org/anaseto/gofrundis/ast"
+	"codeberg.org/anaseto/gofrundis/token"
+)
+
+func TestPositions(t *testing.T) {
+	src := ".Sh  \"é t\" x\\&y \\\" c\ntext é\nmore\n.\\\" line\n.P\n"
+	p := &Parser{Comments: true}
+	blocks, err := p.ParseString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 5 {
+		t.Fatalf("got %d blocks, want 5: %#v", len(blocks), blocks)
+	}
+	pos := func(line, col, offset int) token.Pos {
+		return token.Pos{Line: line, Col: col, Offset: offset}
+	}
+	m := blocks[0].(*ast.Macro)
+	checkRange(t, "macro", m.Range, pos(1, 1, 0), pos(1, 16, 16))
+	if len(m.ArgRanges) != 2 {
+		t.Fatalf("got %d argument ranges, want 2", len(m.ArgRanges))
+	}
+	checkRange(t, "quoted argument", m.ArgRanges[0], pos(1, 6, 5), pos(1, 11, 11))
+	checkRange(t, "argument with escape", m.ArgRanges[1], pos(1, 12, 12), pos(1, 16, 16))
+	c := blocks[1].(*ast.Comment)
+	if !c.Trailing || c.Text != " c" {
+		t.Errorf("bad trailing comment: %#v", c)
+	}
+	checkRange(t, "trailing comment", c.Range, pos(1, 17, 17), pos(1, 21, 21))
+	tb := blocks[2].(*ast.TextBlock)
+	checkRange(t, "text block", tb.Range, pos(2, 1, 22), pos(3, 5, 34))
+	if len(tb.TextRanges) != len(tb.Text) {
+		t.Errorf("got %d text ranges for %d inlines", len(tb.TextRanges), len(tb.Text))
+	}
+	c = blocks[3].(*ast.Comment)
+	if c.Trailing || c.Text != " line" {
+		t.Errorf("bad comment: %#v", c)
+	}
+	checkRange(t, "comment line", c.Range, pos(4, 1, 35), pos(4, 9, 43))
+	checkRange(t, "last macro", blocks[4].(*ast.Macro).Range, pos(5, 1, 44), pos(5, 3, 46))
+}
+
+func checkRange(t *testing.T, what string, r ast.Range, start, end token.Pos) {
+	if r.Pos != start || r.End != end {
+		t.Errorf("%s: got range %v-%v, want %v-%v", what, r.Pos, r.End, start, end)
+	}
+}
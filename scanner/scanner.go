@@ -22,7 +22,11 @@ type Scanner struct {
 	ch      rune         // current character
 	col     int          // current column number
 	line    int          // current line number
+	offset  int          // current byte offset
+	size    int          // current character size in bytes
 	prevcol int          // previous line last column number
+	start   token.Pos    // last token start position
+	end     token.Pos    // last token end position
 	state   scannerState // scanner state (e.g. expecting text block, argument, etc.)
 }
 
@@ -71,6 +75,23 @@ func (s *Scanner) Init() {
 	}
 }
 
+// pos returns the position of the current character.
+func (s *Scanner) pos() token.Pos {
+	switch {
+	case s.ch == '\n':
+		return token.Pos{Line: s.line - 1, Col: s.prevcol + 1, Offset: s.offset}
+	case s.ch < 0:
+		return token.Pos{Line: s.line, Col: s.col + 1, Offset: s.offset}
+	}
+	return token.Pos{Line: s.line, Col: s.col, Offset: s.offset}
+}
+
+// Range returns the start and end (excluded) positions of the last scanned
+// token. Comments and text blocks end before their final newline.
+func (s *Scanner) Range() (start, end token.Pos) {
+	return s.start, s.end
+}
+
 func (s *Scanner) error(msg string) {
 	line := s.line
 	col := s.col
@@ -211,13 +232,17 @@ scanAgain:
 		return token.TEXT, s.buf.String()
 	default:
 		if s.ch == '\n' {
+			nl := s.pos()
 			s.next()
 			if s.ch == '.' {
+				s.end = nl
 				s.state = scanMacroName
 				return token.TEXT, s.buf.String()
 			}
 			if s.ch >= 0 {
 				s.buf.WriteRune('\n')
+			} else {
+				s.end = nl
 			}
 			goto scanAgain
 		}
@@ -334,11 +359,14 @@ func (s *Scanner) scanComment() (token.Token, string) {
 		s.buf.WriteRune(s.ch)
 		s.next()
 	}
+	s.end = s.pos()
 	if s.state != scanTextBlock {
 		if s.ch == '\n' {
 			s.next()
 		}
-		s.state = scanBlockStart
+		if s.ch >= 0 {
+			s.state = scanBlockStart
+		}
 	}
 	return token.COMMENT, s.buf.String()
 }
@@ -361,6 +389,8 @@ func (s *Scanner) Scan() (tok token.Token, line int, lit string, err error) {
 		s.next()
 	}
 	line = s.line
+	s.start = s.pos()
+	s.end = token.Pos{}
 scanAgain:
 	switch s.state {
 	case scanBlockStart:
@@ -377,6 +407,7 @@ scanAgain:
 		if s.state == scanCommentLine {
 			break
 		}
+		s.end = s.pos()
 		s.skipWhiteSpace()
 		if s.state != scanBlockStart && s.state != scanEnd {
 			s.state = scanNewArg
@@ -399,12 +430,17 @@ scanAgain:
 		err = errors.New(fmt.Sprint("scanner.Scan:unhandled state:", s.state))
 	}
 
+	if !s.end.IsValid() {
+		s.end = s.pos()
+	}
 	//fmt.Fprintf(os.Stderr, "%s,«%s»\n", tok, lit)
 	return
 }
 
 func (s *Scanner) next() {
-	r, _, err := s.bReader.ReadRune()
+	s.offset += s.size
+	r, size, err := s.bReader.ReadRune()
+	s.size = size
 	if err != nil {
 		s.ch = -1 // end of file
 		s.state = scanEnd
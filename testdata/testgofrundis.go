@@ -51,7 +51,7 @@ func doFragments() error {
 			continue
 		}
 		fullPath := path.Join("data", f)
-		for _, format := range []string{"latex", "mom", "xhtml", "markdown"} {
+		for _, format := range []string{"latex", "mom", "xhtml", "markdown", "fb2", "typst", "docbook", "tei", "pandoc-json", "events"} {
 			err := doFile(fullPath, format, false)
 			if err != nil {
 				return err
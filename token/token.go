@@ -51,3 +51,15 @@ func (t Token) String() string {
 	}
 	return ""
 }
+
+// Pos represents a position in a source file.
+type Pos struct {
+	Line   int // line number, starting at 1
+	Col    int // column number in characters, starting at 1
+	Offset int // byte offset, starting at 0
+}
+
+// IsValid reports whether the position is valid.
+func (pos Pos) IsValid() bool {
+	return pos.Line > 0
+}